@@ -12,6 +12,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
 	"github.com/okx/go-wallet-sdk/util"
 )
@@ -21,6 +22,7 @@ type TransactionBuilder struct {
 	outputs   []Output
 	netParams *chaincfg.Params
 	tx        *wire.MsgTx
+	runestone *runes.Runestone
 }
 
 type Input struct {
@@ -33,8 +35,9 @@ type Input struct {
 }
 
 type Output struct {
-	address string
-	amount  int64
+	address  string
+	amount   int64
+	pkScript []byte
 }
 
 func NewTxBuild(version int32, netParams *chaincfg.Params) *TransactionBuilder {
@@ -67,6 +70,24 @@ func (build *TransactionBuilder) AddOutput(address string, amount int64) {
 	build.outputs = append(build.outputs, output)
 }
 
+// AddRunestone adds the OP_RETURN output of runestone, its edicts and
+// pointer refer to the outputs by their index in the order added.
+func (build *TransactionBuilder) AddRunestone(runestone *runes.Runestone) error {
+	if build.runestone != nil {
+		return errors.New("only one runestone per transaction")
+	}
+	pkScript, err := runestone.Encipher()
+	if err != nil {
+		return err
+	}
+	if err = runes.CheckStandardScript(pkScript); err != nil {
+		return err
+	}
+	build.runestone = runestone
+	build.outputs = append(build.outputs, Output{pkScript: pkScript})
+	return nil
+}
+
 func (build *TransactionBuilder) Build(sign bool) (*wire.MsgTx, []*wire.TxOut, error) {
 	if len(build.inputs) == 0 || len(build.outputs) == 0 {
 		return nil, nil, errors.New("invalid inputs or outputs")
@@ -113,13 +134,21 @@ func (build *TransactionBuilder) Build(sign bool) (*wire.MsgTx, []*wire.TxOut, e
 
 	for i := 0; i < len(build.outputs); i++ {
 		output := build.outputs[i]
-		pkScript, err := AddrToPkScript(output.address, build.netParams)
-		if err != nil {
-			return nil, nil, err
+		pkScript := output.pkScript
+		if pkScript == nil {
+			var err error
+			if pkScript, err = AddrToPkScript(output.address, build.netParams); err != nil {
+				return nil, nil, err
+			}
 		}
 		txOut := wire.NewTxOut(output.amount, pkScript)
 		tx.TxOut = append(tx.TxOut, txOut)
 	}
+	if build.runestone != nil {
+		if err := build.runestone.CheckOutputs(len(tx.TxOut)); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	if sign {
 		if err := Sign(tx, privateKeys, prevOutFetcher); err != nil {
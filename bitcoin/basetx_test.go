@@ -1,6 +1,8 @@
 package bitcoin
 
 import (
+	"encoding/hex"
+	"math/big"
 	"testing"
 
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -8,8 +10,10 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // support for single private key address formats (legacy/segwit_nested/segwit_native/taproot_keypath)
@@ -184,3 +188,26 @@ func TestGenerateAddress(t *testing.T) {
 
 }
 */
+
+func TestBuildRunestoneTx(t *testing.T) {
+	txBuild := NewTxBuild(2, &chaincfg.TestNet3Params)
+	txBuild.AddInput2("c44a7f98434e5e875a573339f77d36022c79c525771fa88c72fa53f3a55eeaf7", 1, "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", 10000)
+	txBuild.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 546)
+	err := txBuild.AddRunestone(&runes.Runestone{Edicts: []*runes.Edict{
+		{Id: runes.RuneId{Block: 840000, Tx: 3}, Amount: big.NewInt(100), Output: 0},
+	}})
+	require.Nil(t, err)
+	tx, _, err := txBuild.Build(true)
+	require.Nil(t, err)
+	require.Len(t, tx.TxOut, 2)
+	assert.Equal(t, "6a5d0700c0a233036400", hex.EncodeToString(tx.TxOut[1].PkScript))
+	assert.Equal(t, int64(0), tx.TxOut[1].Value)
+
+	txBuild = NewTxBuild(2, &chaincfg.TestNet3Params)
+	txBuild.AddInput2("c44a7f98434e5e875a573339f77d36022c79c525771fa88c72fa53f3a55eeaf7", 1, "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", 10000)
+	require.Nil(t, txBuild.AddRunestone(&runes.Runestone{Edicts: []*runes.Edict{
+		{Id: runes.RuneId{Block: 840000, Tx: 3}, Amount: big.NewInt(100), Output: 2},
+	}}))
+	_, _, err = txBuild.Build(false)
+	assert.NotNil(t, err)
+}
@@ -49,64 +49,27 @@ func NewTapRootAddressWithScriptWithPubKey(serializedPubKey []byte, script []byt
 	return address.String()
 }
 
-func CreateInscriptionScript(privateKey *btcec.PrivateKey, contentType string, body []byte) ([]byte, error) {
-	inscriptionBuilder := txscript.NewScriptBuilder().
-		AddData(schnorr.SerializePubKey(privateKey.PubKey())).
-		AddOp(txscript.OP_CHECKSIG).
-		AddOp(txscript.OP_FALSE).
-		AddOp(txscript.OP_IF).
-		AddData([]byte("ord")).
-		AddOp(txscript.OP_DATA_1).
-		AddOp(txscript.OP_DATA_1).
-		// text/plain;charset=utf-8
-		AddData([]byte(contentType)).
-		AddOp(txscript.OP_0)
+// CreateInscriptionScript builds the reveal tapscript, fields optionally carries
+// the extra ord envelope tags.
+func CreateInscriptionScript(privateKey *btcec.PrivateKey, contentType string, body []byte, fields ...*EnvelopeFields) ([]byte, error) {
+	return CreateInscriptionScriptWithPubKey(schnorr.SerializePubKey(privateKey.PubKey()), contentType, body, fields...)
+}
 
-	maxChunkSize := 520
-	bodySize := len(body)
-	for i := 0; i < bodySize; i += maxChunkSize {
-		end := i + maxChunkSize
-		if end > bodySize {
-			end = bodySize
-		}
-		inscriptionBuilder.AddFullData(body[i:end])
+func CreateInscriptionScriptWithPubKey(publicKey []byte, contentType string, body []byte, fields ...*EnvelopeFields) ([]byte, error) {
+	var envelopeFields *EnvelopeFields
+	if len(fields) > 0 {
+		envelopeFields = fields[0]
 	}
-	inscriptionScript, err := inscriptionBuilder.Script()
+	envelope, err := CreateEnvelopeScript(contentType, body, envelopeFields)
 	if err != nil {
 		return nil, err
 	}
-	// to skip txscript.MaxScriptSize 10000
-	inscriptionScript = append(inscriptionScript, txscript.OP_ENDIF)
-	return inscriptionScript, nil
-}
-
-func CreateInscriptionScriptWithPubKey(publicKey []byte, contentType string, body []byte) ([]byte, error) {
-	inscriptionBuilder := txscript.NewScriptBuilder().
+	inscriptionScript, err := txscript.NewScriptBuilder().
 		AddData(publicKey).
 		AddOp(txscript.OP_CHECKSIG).
-		AddOp(txscript.OP_FALSE).
-		AddOp(txscript.OP_IF).
-		AddData([]byte("ord")).
-		AddOp(txscript.OP_DATA_1).
-		AddOp(txscript.OP_DATA_1).
-		// text/plain;charset=utf-8
-		AddData([]byte(contentType)).
-		AddOp(txscript.OP_0)
-
-	maxChunkSize := 520
-	bodySize := len(body)
-	for i := 0; i < bodySize; i += maxChunkSize {
-		end := i + maxChunkSize
-		if end > bodySize {
-			end = bodySize
-		}
-		inscriptionBuilder.AddFullData(body[i:end])
-	}
-	inscriptionScript, err := inscriptionBuilder.Script()
+		Script()
 	if err != nil {
 		return nil, err
 	}
-	// to skip txscript.MaxScriptSize 10000
-	inscriptionScript = append(inscriptionScript, txscript.OP_ENDIF)
-	return inscriptionScript, nil
+	return append(inscriptionScript, envelope...), nil
 }
@@ -0,0 +1,231 @@
+package brc20
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// ord envelope tags, odd tags are optional and may be ignored by indexers,
+// unrecognized even tags make the inscription unbound.
+const (
+	TagBody            = 0
+	TagContentType     = 1
+	TagPointer         = 2
+	TagParent          = 3
+	TagMetadata        = 5
+	TagMetaprotocol    = 7
+	TagContentEncoding = 9
+	TagDelegate        = 11
+	TagRune            = 13
+	TagNote            = 15
+	TagUnbound         = 66
+	TagNop             = 255
+
+	MaxChunkSize = 520
+)
+
+const ProtocolId = "ord"
+
+// EnvelopeFields holds the optional ord envelope fields written next to the
+// content type and body.
+type EnvelopeFields struct {
+	// Pointer is the sat offset in the reveal outputs the inscription is
+	// bound to, zero is the default and is not written.
+	Pointer uint64 `json:"pointer"`
+	// Parent is the inscription id (<txid>i<index>) of the parent.
+	Parent string `json:"parent"`
+	// Metadata is CBOR encoded and chunked at MaxChunkSize.
+	Metadata        []byte `json:"metadata"`
+	Metaprotocol    string `json:"metaprotocol"`
+	ContentEncoding string `json:"contentEncoding"`
+	Delegate        string `json:"delegate"`
+	// Rune is the commitment of the rune etched by the reveal, it is
+	// written as is, see runes.Commitment.
+	Rune []byte `json:"rune"`
+}
+
+// IsKnownTag reports whether the tag is one the envelope writer and parser
+// understand.
+func IsKnownTag(tag int) bool {
+	switch tag {
+	case TagContentType, TagPointer, TagParent, TagMetadata, TagMetaprotocol,
+		TagContentEncoding, TagDelegate, TagRune, TagNote, TagNop:
+		return true
+	}
+	return false
+}
+
+// IsChunkedTag reports whether repeated values of the tag are concatenated
+// instead of being treated as duplicates.
+func IsChunkedTag(tag int) bool {
+	return tag == TagMetadata
+}
+
+// AppendPush appends a data push that never uses the small integer opcodes,
+// OP_1..OP_16 pushes make an inscription cursed.
+func AppendPush(script []byte, data []byte) []byte {
+	dataLen := len(data)
+	if dataLen < txscript.OP_PUSHDATA1 {
+		script = append(script, byte((txscript.OP_DATA_1-1)+dataLen))
+	} else if dataLen <= 0xff {
+		script = append(script, txscript.OP_PUSHDATA1, byte(dataLen))
+	} else if dataLen <= 0xffff {
+		buf := make([]byte, 2)
+		binary.LittleEndian.PutUint16(buf, uint16(dataLen))
+		script = append(script, txscript.OP_PUSHDATA2)
+		script = append(script, buf...)
+	} else {
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, uint32(dataLen))
+		script = append(script, txscript.OP_PUSHDATA4)
+		script = append(script, buf...)
+	}
+	return append(script, data...)
+}
+
+func appendField(script []byte, tag byte, value []byte) []byte {
+	script = AppendPush(script, []byte{tag})
+	return AppendPush(script, value)
+}
+
+func appendChunkedField(script []byte, tag byte, value []byte) []byte {
+	for i := 0; i < len(value); i += MaxChunkSize {
+		end := i + MaxChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		script = appendField(script, tag, value[i:end])
+	}
+	return script
+}
+
+// EncodePointer encodes a pointer as little endian with trailing zeros trimmed.
+func EncodePointer(pointer uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, pointer)
+	end := len(buf)
+	for end > 0 && buf[end-1] == 0 {
+		end--
+	}
+	return buf[:end]
+}
+
+// DecodePointer is the inverse of EncodePointer, values longer than 8 bytes
+// with non-zero trailing bytes are invalid.
+func DecodePointer(value []byte) (uint64, bool) {
+	for i := 8; i < len(value); i++ {
+		if value[i] != 0 {
+			return 0, false
+		}
+	}
+	buf := make([]byte, 8)
+	copy(buf, value)
+	return binary.LittleEndian.Uint64(buf), true
+}
+
+// ParseInscriptionId splits an inscription id of the form <txid>i<index>.
+func ParseInscriptionId(id string) (*chainhash.Hash, uint32, error) {
+	sep := strings.LastIndexByte(id, 'i')
+	if sep != chainhash.MaxHashStringSize {
+		return nil, 0, fmt.Errorf("invalid inscription id %q", id)
+	}
+	txHash, err := chainhash.NewHashFromStr(id[:sep])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid inscription id %q: %w", id, err)
+	}
+	index, err := strconv.ParseUint(id[sep+1:], 10, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid inscription id %q: %w", id, err)
+	}
+	return txHash, uint32(index), nil
+}
+
+// EncodeInscriptionId encodes an inscription id as the parent and delegate
+// tag value: the txid in internal byte order followed by the little endian
+// index with trailing zeros trimmed.
+func EncodeInscriptionId(id string) ([]byte, error) {
+	txHash, index, err := ParseInscriptionId(id)
+	if err != nil {
+		return nil, err
+	}
+	value := append([]byte{}, txHash[:]...)
+	return append(value, EncodePointer(uint64(index))...), nil
+}
+
+// DecodeInscriptionId is the inverse of EncodeInscriptionId.
+func DecodeInscriptionId(value []byte) (string, error) {
+	if len(value) < chainhash.HashSize || len(value) > chainhash.HashSize+4 {
+		return "", errors.New("invalid inscription id length")
+	}
+	txHash, err := chainhash.NewHash(value[:chainhash.HashSize])
+	if err != nil {
+		return "", err
+	}
+	index, _ := DecodePointer(value[chainhash.HashSize:])
+	return fmt.Sprintf("%si%d", txHash.String(), index), nil
+}
+
+// CreateEnvelopeScript builds the OP_FALSE OP_IF "ord" ... OP_ENDIF envelope,
+// the body and metadata are chunked at MaxChunkSize.
+func CreateEnvelopeScript(contentType string, body []byte, fields *EnvelopeFields) ([]byte, error) {
+	if fields == nil {
+		fields = &EnvelopeFields{}
+	}
+	script := []byte{txscript.OP_FALSE, txscript.OP_IF}
+	script = AppendPush(script, []byte(ProtocolId))
+
+	// the content type is always written unless the inscription only
+	// delegates its content
+	if contentType != "" || fields.Delegate == "" {
+		script = appendField(script, TagContentType, []byte(contentType))
+	}
+	if fields.ContentEncoding != "" {
+		script = appendField(script, TagContentEncoding, []byte(fields.ContentEncoding))
+	}
+	if fields.Metaprotocol != "" {
+		script = appendField(script, TagMetaprotocol, []byte(fields.Metaprotocol))
+	}
+	if fields.Parent != "" {
+		parent, err := EncodeInscriptionId(fields.Parent)
+		if err != nil {
+			return nil, err
+		}
+		script = appendField(script, TagParent, parent)
+	}
+	if fields.Delegate != "" {
+		delegate, err := EncodeInscriptionId(fields.Delegate)
+		if err != nil {
+			return nil, err
+		}
+		script = appendField(script, TagDelegate, delegate)
+	}
+	if fields.Pointer > 0 {
+		script = appendField(script, TagPointer, EncodePointer(fields.Pointer))
+	}
+	if len(fields.Metadata) > 0 {
+		script = appendChunkedField(script, TagMetadata, fields.Metadata)
+	}
+	if len(fields.Rune) > 0 {
+		script = appendField(script, TagRune, fields.Rune)
+	}
+
+	if len(body) > 0 || fields.Delegate == "" {
+		script = append(script, txscript.OP_0)
+		for i := 0; i < len(body); i += MaxChunkSize {
+			end := i + MaxChunkSize
+			if end > len(body) {
+				end = len(body)
+			}
+			script = AppendPush(script, body[i:end])
+		}
+	}
+	// built by hand to skip txscript.MaxScriptSize 10000, tapscript has no
+	// script size limit
+	return append(script, txscript.OP_ENDIF), nil
+}
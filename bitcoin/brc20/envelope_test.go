@@ -0,0 +1,66 @@
+package brc20
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeInscriptionId(t *testing.T) {
+	id := "1f64c4fd19d8fda2b7b4e5b2e5c2f1ba37ff1cfe4d8a0f23c8d2b6d1e1d1c1b1i1"
+	value, err := EncodeInscriptionId(id)
+	require.Nil(t, err)
+	assert.Len(t, value, 33)
+	assert.Equal(t, byte(0xb1), value[0])
+	decoded, err := DecodeInscriptionId(value)
+	require.Nil(t, err)
+	assert.Equal(t, id, decoded)
+
+	value, err = EncodeInscriptionId("1f64c4fd19d8fda2b7b4e5b2e5c2f1ba37ff1cfe4d8a0f23c8d2b6d1e1d1c1b1i0")
+	require.Nil(t, err)
+	assert.Len(t, value, 32)
+
+	_, err = EncodeInscriptionId("1f64c4fd19d8fda2b7b4e5b2e5c2f1ba37ff1cfe4d8a0f23c8d2b6d1e1d1c1b1")
+	assert.NotNil(t, err)
+}
+
+func TestCreateEnvelopeScript(t *testing.T) {
+	script, err := CreateEnvelopeScript("text/plain;charset=utf-8", []byte("1"), &EnvelopeFields{
+		Pointer:         5,
+		Metaprotocol:    "brc-20",
+		ContentEncoding: "br",
+		Parent:          "1f64c4fd19d8fda2b7b4e5b2e5c2f1ba37ff1cfe4d8a0f23c8d2b6d1e1d1c1b1i0",
+		Metadata:        bytes.Repeat([]byte{0xa0}, MaxChunkSize+1),
+	})
+	require.Nil(t, err)
+
+	// the single byte pointer and body must not be pushed as OP_5/OP_1
+	assert.True(t, bytes.Contains(script, []byte{txscript.OP_DATA_1, TagPointer, txscript.OP_DATA_1, 0x05}))
+	assert.True(t, bytes.HasSuffix(script, []byte{txscript.OP_0, txscript.OP_DATA_1, '1', txscript.OP_ENDIF}))
+
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	var pushes [][]byte
+	for tokenizer.Next() {
+		if tokenizer.Opcode() <= txscript.OP_PUSHDATA4 {
+			pushes = append(pushes, tokenizer.Data())
+		}
+	}
+	require.Nil(t, tokenizer.Err())
+	metadataChunks := 0
+	for i := 2; i+1 < len(pushes); i += 2 {
+		if len(pushes[i]) == 1 && pushes[i][0] == TagMetadata {
+			metadataChunks++
+		}
+	}
+	assert.Equal(t, 2, metadataChunks)
+
+	script, err = CreateEnvelopeScript("", nil, &EnvelopeFields{
+		Delegate: "1f64c4fd19d8fda2b7b4e5b2e5c2f1ba37ff1cfe4d8a0f23c8d2b6d1e1d1c1b1i0",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "0063036f7264010b20b1c1d1e1d1b6d2c8230f8a4dfe1cff37baf1c2e5b2e5b4b7a2fdd819fdc4641f68", hex.EncodeToString(script))
+}
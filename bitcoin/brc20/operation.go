@@ -0,0 +1,281 @@
+package brc20
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	Protocol = "brc-20"
+
+	OpDeploy   = "deploy"
+	OpMint     = "mint"
+	OpTransfer = "transfer"
+
+	// MaxDecimals is the default and the highest dec of a deploy.
+	MaxDecimals = 18
+	// SelfMintTickLength is the byte length of tickers only their deployer
+	// (the parent inscription) can mint, they must be deployed with
+	// "self_mint":"true".
+	SelfMintTickLength = 5
+	TickLength         = 4
+)
+
+// maxAmount is the uint64 ceiling indexers put on max, lim and amt, scaled
+// by 10^MaxDecimals.
+var maxAmount = new(big.Int).Mul(new(big.Int).SetUint64(^uint64(0)), new(big.Int).Exp(big.NewInt(10), big.NewInt(MaxDecimals), nil))
+
+// Operation is a brc-20 inscription body, every value is a string as the
+// indexers require.
+type Operation struct {
+	P        string `json:"p"`
+	Op       string `json:"op"`
+	Tick     string `json:"tick"`
+	Max      string `json:"max,omitempty"`
+	Lim      string `json:"lim,omitempty"`
+	Dec      string `json:"dec,omitempty"`
+	SelfMint string `json:"self_mint,omitempty"`
+	Amt      string `json:"amt,omitempty"`
+}
+
+// NewDeploy returns the body of a deploy, lim and dec are optional.
+func NewDeploy(tick, max, lim, dec string, selfMint bool) ([]byte, error) {
+	op := &Operation{P: Protocol, Op: OpDeploy, Tick: tick, Max: max, Lim: lim, Dec: dec}
+	if selfMint {
+		op.SelfMint = "true"
+	}
+	return op.body()
+}
+
+func NewMint(tick, amt string) ([]byte, error) {
+	return (&Operation{P: Protocol, Op: OpMint, Tick: tick, Amt: amt}).body()
+}
+
+func NewTransfer(tick, amt string) ([]byte, error) {
+	return (&Operation{P: Protocol, Op: OpTransfer, Tick: tick, Amt: amt}).body()
+}
+
+func (op *Operation) body() ([]byte, error) {
+	if err := op.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(op)
+}
+
+// ParseOperation reads a brc-20 body. It returns nil without error when the
+// body is not meant as brc-20, i.e. is not a JSON object whose "p" is
+// brc-20 in any case, nor mentions brc-20 at all.
+func ParseOperation(contentType string, body []byte) (*Operation, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// indexers drop a malformed brc-20 body, e.g. with a trailing comma
+		if strings.Contains(strings.ToLower(string(body)), Protocol) {
+			return nil, fmt.Errorf("brc-20 body is not a JSON object: %w", err)
+		}
+		return nil, nil
+	}
+	p, _ := fields["p"].(string)
+	if !strings.EqualFold(strings.TrimSpace(p), Protocol) {
+		return nil, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "text/plain" && mediaType != "application/json") {
+		return nil, fmt.Errorf("brc-20 content type must be text/plain or application/json, got %q", contentType)
+	}
+	// keys are case sensitive for indexers, unlike json.Unmarshal into a struct
+	op := &Operation{}
+	for key, value := range map[string]*string{
+		"p": &op.P, "op": &op.Op, "tick": &op.Tick, "max": &op.Max, "lim": &op.Lim,
+		"dec": &op.Dec, "self_mint": &op.SelfMint, "amt": &op.Amt,
+	} {
+		if field, ok := fields[key]; ok {
+			if *value, ok = field.(string); !ok {
+				return nil, fmt.Errorf("brc-20 %s must be a string", key)
+			}
+		}
+	}
+	if err = op.Validate(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// ValidateBody rejects the brc-20 bodies indexers would ignore, other
+// inscriptions pass.
+func ValidateBody(contentType string, body []byte) error {
+	_, err := ParseOperation(contentType, body)
+	return err
+}
+
+// Validate checks op against the indexer rules. Amounts of mints and
+// transfers can only be checked up to MaxDecimals here, see CheckMint and
+// CheckTransfer for the ticker's own dec and lim.
+func (op *Operation) Validate() error {
+	if op.P != Protocol {
+		return fmt.Errorf("p must be %q, got %q", Protocol, op.P)
+	}
+	tickLength := len([]byte(op.Tick))
+	if tickLength != TickLength && tickLength != SelfMintTickLength {
+		return fmt.Errorf("tick must be %d or %d bytes, %q is %d", TickLength, SelfMintTickLength, op.Tick, tickLength)
+	}
+
+	switch op.Op {
+	case OpDeploy:
+		if op.Amt != "" {
+			return errors.New("deploy does not take amt")
+		}
+		selfMint := op.SelfMint == "true"
+		if op.SelfMint != "" && !selfMint {
+			return fmt.Errorf("self_mint must be \"true\", got %q", op.SelfMint)
+		}
+		if tickLength == SelfMintTickLength && !selfMint {
+			return fmt.Errorf("%d byte tick %q must be deployed with self_mint", SelfMintTickLength, op.Tick)
+		}
+		if tickLength == TickLength && selfMint {
+			return fmt.Errorf("self_mint needs a %d byte tick", SelfMintTickLength)
+		}
+
+		dec, err := op.decimals()
+		if err != nil {
+			return err
+		}
+		max, err := parseAmount(op.Max, dec)
+		if err != nil {
+			return fmt.Errorf("max: %w", err)
+		}
+		// a self mint max of 0 means uint64 max
+		if max.Sign() == 0 && !selfMint {
+			return errors.New("max must be positive")
+		}
+		if op.Lim != "" {
+			lim, err := parseAmount(op.Lim, dec)
+			if err != nil {
+				return fmt.Errorf("lim: %w", err)
+			}
+			if lim.Sign() == 0 && !selfMint {
+				return errors.New("lim must be positive")
+			}
+			if max.Sign() > 0 && lim.Cmp(max) > 0 {
+				return fmt.Errorf("lim %s is above max %s", op.Lim, op.Max)
+			}
+		}
+	case OpMint, OpTransfer:
+		if op.Max != "" || op.Lim != "" || op.Dec != "" || op.SelfMint != "" {
+			return fmt.Errorf("%s only takes tick and amt", op.Op)
+		}
+		if err := CheckAmount(op.Amt, MaxDecimals); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown brc-20 op %q", op.Op)
+	}
+	return nil
+}
+
+// CheckMint checks a mint against the deploy of its ticker: amt must respect
+// the ticker dec and lim.
+func (op *Operation) CheckMint(deploy *Operation) error {
+	dec, err := op.checkDeploy(OpMint, deploy)
+	if err != nil {
+		return err
+	}
+	limValue := deploy.Lim
+	if limValue == "" {
+		limValue = deploy.Max
+	}
+	amt, _ := parseAmount(op.Amt, dec)
+	lim, err := parseAmount(limValue, dec)
+	if err != nil {
+		return err
+	}
+	if lim.Sign() > 0 && amt.Cmp(lim) > 0 {
+		return fmt.Errorf("amt %s is above the mint limit %s", op.Amt, limValue)
+	}
+	return nil
+}
+
+// CheckTransfer checks a transfer against the deploy of its ticker: amt must
+// respect the ticker dec, indexers drop a transfer with more decimals.
+func (op *Operation) CheckTransfer(deploy *Operation) error {
+	_, err := op.checkDeploy(OpTransfer, deploy)
+	return err
+}
+
+// checkDeploy checks that op is a name op of the ticker of deploy with an amt
+// of at most the ticker dec decimals, it returns the ticker dec.
+func (op *Operation) checkDeploy(name string, deploy *Operation) (int, error) {
+	if op.Op != name || deploy.Op != OpDeploy {
+		return 0, fmt.Errorf("not a %s and its deploy", name)
+	}
+	if !strings.EqualFold(op.Tick, deploy.Tick) {
+		return 0, fmt.Errorf("%s of %q checked against the deploy of %q", name, op.Tick, deploy.Tick)
+	}
+	dec, err := deploy.decimals()
+	if err != nil {
+		return 0, err
+	}
+	if err = CheckAmount(op.Amt, dec); err != nil {
+		return 0, err
+	}
+	return dec, nil
+}
+
+// CheckAmount checks a positive amount with at most dec decimals.
+func CheckAmount(amt string, dec int) error {
+	value, err := parseAmount(amt, dec)
+	if err != nil {
+		return fmt.Errorf("amt: %w", err)
+	}
+	if value.Sign() == 0 {
+		return errors.New("amt must be positive")
+	}
+	return nil
+}
+
+func (op *Operation) decimals() (int, error) {
+	if op.Dec == "" {
+		return MaxDecimals, nil
+	}
+	for _, c := range op.Dec {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("dec must be an integer, got %q", op.Dec)
+		}
+	}
+	dec, err := strconv.Atoi(op.Dec)
+	if err != nil || dec > MaxDecimals {
+		return 0, fmt.Errorf("dec must be at most %d, got %q", MaxDecimals, op.Dec)
+	}
+	return dec, nil
+}
+
+// parseAmount parses a plain decimal string, digits with at most one inner
+// dot, into its value scaled by 10^MaxDecimals.
+func parseAmount(value string, dec int) (*big.Int, error) {
+	if value == "" {
+		return nil, errors.New("missing value")
+	}
+	integer, fraction, hasDot := strings.Cut(value, ".")
+	if integer == "" || (hasDot && fraction == "") {
+		return nil, fmt.Errorf("%q is not a decimal number", value)
+	}
+	for _, c := range integer + fraction {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("%q is not a decimal number", value)
+		}
+	}
+	if len(fraction) > dec {
+		return nil, fmt.Errorf("%q has more than %d decimals", value, dec)
+	}
+
+	scaled, _ := new(big.Int).SetString(integer+fraction+strings.Repeat("0", MaxDecimals-len(fraction)), 10)
+	if scaled.Cmp(maxAmount) > 0 {
+		return nil, fmt.Errorf("%q is above the uint64 limit", value)
+	}
+	return scaled, nil
+}
@@ -0,0 +1,112 @@
+package brc20
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildOperation(t *testing.T) {
+	body, err := NewDeploy("ordi", "21000000", "1000", "", false)
+	require.Nil(t, err)
+	assert.Equal(t, `{"p":"brc-20","op":"deploy","tick":"ordi","max":"21000000","lim":"1000"}`, string(body))
+
+	body, err = NewDeploy("pizza", "0", "", "8", true)
+	require.Nil(t, err)
+	assert.Equal(t, `{"p":"brc-20","op":"deploy","tick":"pizza","max":"0","dec":"8","self_mint":"true"}`, string(body))
+
+	body, err = NewTransfer("mpct", "10")
+	require.Nil(t, err)
+	assert.Equal(t, `{"p":"brc-20","op":"transfer","tick":"mpct","amt":"10"}`, string(body))
+
+	_, err = NewDeploy("pizza", "100", "", "", false)
+	assert.NotNil(t, err)
+	_, err = NewDeploy("ordi", "100", "", "", true)
+	assert.NotNil(t, err)
+	_, err = NewDeploy("ordi", "100", "101", "", false)
+	assert.NotNil(t, err)
+	_, err = NewDeploy("ordi", "100.5", "", "0", false)
+	assert.NotNil(t, err)
+	_, err = NewDeploy("ordi", "100", "", "19", false)
+	assert.NotNil(t, err)
+	_, err = NewMint("ordi", "0")
+	assert.NotNil(t, err)
+	_, err = NewMint("toolong", "1")
+	assert.NotNil(t, err)
+}
+
+func TestParseOperation(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		valid       bool
+	}{
+		{"transfer", "text/plain;charset=utf-8", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":"10"}`, true},
+		{"json", "application/json", `{"p":"brc-20","op":"mint","tick":"mpct","amt":"0.000000000000000001"}`, true},
+		{"unicode tick", "text/plain", `{"p":"brc-20","op":"mint","tick":"\ud83d\udd25","amt":"1"}`, true},
+		{"not brc-20", "image/png", `{"p":"sns","op":"reg","name":"a.sats"}`, true},
+		{"not json", "text/plain", `hello`, true},
+		{"trailing comma", "text/plain", `{"p":"brc-20","op":"mint","tick":"ordi","amt":"1",}`, false},
+		{"upper case malformed", "text/plain", `{"p":"BRC-20","op":"mint","tick":"ordi","amt":"1"`, false},
+		{"array", "application/json", `[{"p":"brc-20","op":"mint","tick":"ordi","amt":"1"}]`, false},
+		{"content type", "text/html", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":"10"}`, false},
+		{"upper case p", "text/plain", `{"p":"BRC-20","op":"transfer","tick":"mpct","amt":"10"}`, false},
+		{"number amt", "text/plain", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":10}`, false},
+		{"upper case key", "text/plain", `{"p":"brc-20","op":"transfer","TICK":"mpct","amt":"10"}`, false},
+		{"3 byte tick", "text/plain", `{"p":"brc-20","op":"transfer","tick":"mpc","amt":"10"}`, false},
+		{"leading dot", "text/plain", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":".5"}`, false},
+		{"trailing dot", "text/plain", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":"5."}`, false},
+		{"exponent", "text/plain", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":"1e3"}`, false},
+		{"sign", "text/plain", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":"+1"}`, false},
+		{"19 decimals", "text/plain", `{"p":"brc-20","op":"transfer","tick":"mpct","amt":"0.0000000000000000001"}`, false},
+		{"above uint64", "text/plain", `{"p":"brc-20","op":"deploy","tick":"mpct","max":"18446744073709551616"}`, false},
+		{"uint64", "text/plain", `{"p":"brc-20","op":"deploy","tick":"mpct","max":"18446744073709551615"}`, true},
+		{"unknown op", "text/plain", `{"p":"brc-20","op":"burn","tick":"mpct","amt":"10"}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateBody(test.contentType, []byte(test.body))
+			if test.valid {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckMint(t *testing.T) {
+	deploy, err := ParseOperation("text/plain", []byte(`{"p":"brc-20","op":"deploy","tick":"ordi","max":"21000000","lim":"1000","dec":"2"}`))
+	require.Nil(t, err)
+
+	mint := &Operation{P: Protocol, Op: OpMint, Tick: "ORDI", Amt: "1000"}
+	assert.Nil(t, mint.CheckMint(deploy))
+	mint.Amt = "1000.01"
+	assert.NotNil(t, mint.CheckMint(deploy))
+	mint.Amt = "0.001"
+	assert.NotNil(t, mint.CheckMint(deploy))
+	mint.Tick = "sats"
+	mint.Amt = "1"
+	assert.NotNil(t, mint.CheckMint(deploy))
+}
+
+func TestCheckTransfer(t *testing.T) {
+	deploy, err := ParseOperation("text/plain", []byte(`{"p":"brc-20","op":"deploy","tick":"ordi","max":"21000000","lim":"1000","dec":"2"}`))
+	require.Nil(t, err)
+
+	// transfers are not bound by lim
+	transfer := &Operation{P: Protocol, Op: OpTransfer, Tick: "ORDI", Amt: "5000.25"}
+	assert.Nil(t, transfer.Validate())
+	assert.Nil(t, transfer.CheckTransfer(deploy))
+	// valid up to MaxDecimals, but more decimals than the ticker dec
+	transfer.Amt = "1.001"
+	assert.Nil(t, transfer.Validate())
+	assert.ErrorContains(t, transfer.CheckTransfer(deploy), "more than 2 decimals")
+	transfer.Amt = "1"
+	transfer.Tick = "sats"
+	assert.NotNil(t, transfer.CheckTransfer(deploy))
+	mint := &Operation{P: Protocol, Op: OpMint, Tick: "ordi", Amt: "1"}
+	assert.NotNil(t, mint.CheckTransfer(deploy))
+}
@@ -40,6 +40,7 @@ type Input struct {
 type Inscription struct {
 	contentType string
 	body        []byte
+	fields      *EnvelopeFields
 }
 
 type Output struct {
@@ -68,6 +69,10 @@ func NewInscription(contentType string, body []byte) *Inscription {
 	return &Inscription{contentType: contentType, body: body}
 }
 
+func NewInscriptionWithFields(contentType string, body []byte, fields *EnvelopeFields) *Inscription {
+	return &Inscription{contentType: contentType, body: body, fields: fields}
+}
+
 func (build *TransactionBuilder) AddInput(txId string, vOut uint32, privateKeyHex string, address string, value string, inscription *Inscription) {
 	input := Input{txId: txId, vOut: vOut, privateKeyHex: privateKeyHex, address: address, value: value, inscription: inscription}
 	build.inputs = append(build.inputs, input)
@@ -227,7 +232,7 @@ func (build *TransactionBuilder) Build() (string, error) {
 		// the address is taproot address
 		if isTaproot {
 			//create taproot script
-			inscriptionScript, err := CreateInscriptionScript(prvKey, input.inscription.contentType, input.inscription.body)
+			inscriptionScript, err := CreateInscriptionScript(prvKey, input.inscription.contentType, input.inscription.body, input.inscription.fields)
 			if err != nil {
 				return "", err
 			}
@@ -350,7 +355,7 @@ func (build *TransactionBuilder) CalculateHash(pubKeyHex string) (string, error)
 		if isTaproot {
 			pubKeyBytes, _ := hex.DecodeString(pubKeyHex)
 			// taproot address
-			inscriptionScript, err := CreateInscriptionScriptWithPubKey(pubKeyBytes, input.inscription.contentType, input.inscription.body)
+			inscriptionScript, err := CreateInscriptionScriptWithPubKey(pubKeyBytes, input.inscription.contentType, input.inscription.body, input.inscription.fields)
 			if err != nil {
 				return "", err
 			}
@@ -425,7 +430,7 @@ func (build *TransactionBuilder) BuildWithSig(signatureHex, pubKeyHex string) (s
 				return "", err
 			}
 			// taproot address
-			inscriptionScript, err := CreateInscriptionScriptWithPubKey(pubKeyBytes, input.inscription.contentType, input.inscription.body)
+			inscriptionScript, err := CreateInscriptionScriptWithPubKey(pubKeyBytes, input.inscription.contentType, input.inscription.body, input.inscription.fields)
 			if err != nil {
 				return "", err
 			}
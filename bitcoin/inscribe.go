@@ -12,13 +12,52 @@ import (
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/brc20"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
 )
 
 type InscriptionData struct {
-	ContentType string `json:"contentType"`
-	Body        []byte `json:"body"`
-	RevealAddr  string `json:"revealAddr"`
+	ContentType     string `json:"contentType"`
+	Body            []byte `json:"body"`
+	RevealAddr      string `json:"revealAddr"`
+	Pointer         uint64 `json:"pointer"`
+	Parent          string `json:"parent"`
+	Metadata        []byte `json:"metadata"`
+	Metaprotocol    string `json:"metaprotocol"`
+	ContentEncoding string `json:"contentEncoding"`
+	Delegate        string `json:"delegate"`
+	// Postage is the value of the reveal output holding the inscription,
+	// revealOutValue when zero.
+	Postage int64 `json:"postage"`
+	// Runestone is revealed along with the inscription, the rune of its
+	// etching is committed to in the envelope and the reveal waits for
+	// runes.CommitConfirmations of the commit tx.
+	Runestone *runes.Runestone `json:"runestone"`
+	// PremineAddr receives the premine of the etching, RevealAddr when empty.
+	PremineAddr string `json:"premineAddr"`
+	// EtchingOnly reveals the Runestone etching without an inscription, the
+	// tapscript commits to the rune outside of any envelope. ContentType,
+	// Body and the envelope fields are ignored.
+	EtchingOnly bool `json:"etchingOnly"`
+	// RecoveryLeaf commits to a bare recovery leaf next to the inscription,
+	// so recoverCommit can sweep the commit output by script path without
+	// inscribing. It changes the commit address and adds 32 bytes to the
+	// reveal witness. In a batch, one such inscription gives the leaf to
+	// every commit output.
+	RecoveryLeaf bool `json:"recoveryLeaf"`
+}
+
+func (data *InscriptionData) envelopeFields() *brc20.EnvelopeFields {
+	return &brc20.EnvelopeFields{
+		Pointer:         data.Pointer,
+		Parent:          data.Parent,
+		Metadata:        data.Metadata,
+		Metaprotocol:    data.Metaprotocol,
+		ContentEncoding: data.ContentEncoding,
+		Delegate:        data.Delegate,
+		Rune:            data.runeCommitment(),
+	}
 }
 
 type PrevOutput struct {
@@ -27,6 +66,13 @@ type PrevOutput struct {
 	Amount     int64  `json:"amount"`
 	Address    string `json:"address"`
 	PrivateKey string `json:"privateKey"`
+	// InscriptionOffsets and SatRanges mark the sats of the output the
+	// builders must not burn or merge, see CheckSatFlow.
+	InscriptionOffsets []int64     `json:"inscriptionOffsets,omitempty"`
+	SatRanges          []*SatRange `json:"satRanges,omitempty"`
+	// NonWitnessUtxo is the hex tx of the output, Brc20CommitPSBT needs it
+	// for a P2PKH one.
+	NonWitnessUtxo string `json:"nonWitnessUtxo,omitempty"`
 }
 
 type InscriptionRequest struct {
@@ -37,6 +83,14 @@ type InscriptionRequest struct {
 	RevealOutValue         int64             `json:"revealOutValue"`
 	ChangeAddress          string            `json:"changeAddress"`
 	MinChangeValue         int64             `json:"minChangeValue"`
+	// BatchMode inscribes all the data through one commit output per reveal,
+	// see BatchModeSeparateOutputs and BatchModeSameSat.
+	BatchMode string `json:"batchMode"`
+	// Parent reveals the inscriptions as children of an inscription owned
+	// by the caller, they are batched into a single reveal.
+	Parent *ParentInscription `json:"parent"`
+	// RevealOptions adds funding inputs and change outputs to the reveals.
+	RevealOptions *RevealOptions `json:"revealOptions"`
 }
 
 type InscriptionTxCtxData struct {
@@ -46,6 +100,23 @@ type InscriptionTxCtxData struct {
 	CommitTxAddressPkScript []byte
 	ControlBlockWitness     []byte
 	RevealTxPrevOutput      *wire.TxOut
+	// RevealTxOutputs replaces the single revealOutValue output for batch
+	// reveals carrying several envelopes.
+	RevealTxOutputs []*wire.TxOut
+	// ParentOutPoint is spent by the reveal at input 0 and returned to
+	// ParentPrevOutput.PkScript at output 0.
+	ParentOutPoint   *wire.OutPoint
+	ParentPrevOutput *wire.TxOut
+	ParentPrivateKey *btcec.PrivateKey
+	// RevealFundingOutPoint is spent by the reveal right after the commit
+	// input and pays its fee, what is left goes to RevealChangePkScript.
+	RevealFundingOutPoint   *wire.OutPoint
+	RevealFundingPrevOutput *wire.TxOut
+	RevealFundingPrivateKey *btcec.PrivateKey
+	RevealChangePkScript    []byte
+	// CommitConfirmations is the relative lock of the commit input, an
+	// etching reveal needs its commitment that deep.
+	CommitConfirmations int64
 }
 
 type InscriptionBuilder struct {
@@ -58,6 +129,7 @@ type InscriptionBuilder struct {
 	CommitTx                  *wire.MsgTx
 	MustCommitTxFee           int64
 	MustRevealTxFees          []int64
+	CommitConfirmations       []int64
 	CommitAddrs               []string
 	CommitTxFee               int64
 	RevealTxFees              []int64
@@ -69,6 +141,9 @@ type InscribeTxs struct {
 	CommitTxFee  int64    `json:"commitTxFee"`
 	RevealTxFees []int64  `json:"revealTxFees"`
 	CommitAddrs  []string `json:"commitAddrs"`
+	// CommitConfirmations is by reveal the confirmations of the commit tx
+	// it waits for, the reveals of etchings can't be broadcast before.
+	CommitConfirmations []int64 `json:"commitConfirmations,omitempty"`
 }
 
 type Brc20InscriptionParseResult struct {
@@ -89,6 +164,73 @@ type Brc20CtxData struct {
 
 	RevealTxOutPkScript []byte `json:"revealTxOutPkScript"`
 	RevealTxOutValue    int64  `json:"revealTxOutValue"`
+
+	RevealTxOutputs      []*RevealTxOut   `json:"revealTxOutputs,omitempty"`
+	Parent               *RevealTxPrevOut `json:"parent,omitempty"`
+	Funding              *RevealTxPrevOut `json:"funding,omitempty"`
+	RevealChangePkScript []byte           `json:"revealChangePkScript,omitempty"`
+	// CommitConfirmations is the minimum depth of the commit tx for the
+	// reveal to be mined, enforced by the sequence of the commit input.
+	CommitConfirmations int64 `json:"commitConfirmations,omitempty"`
+}
+
+type RevealTxPrevOut struct {
+	TxId     string `json:"txId"`
+	VOut     uint32 `json:"vOut"`
+	PkScript []byte `json:"pkScript"`
+	Value    int64  `json:"value"`
+}
+
+type RevealTxOut struct {
+	PkScript []byte `json:"pkScript"`
+	Value    int64  `json:"value"`
+}
+
+func (data *Brc20CtxData) inscriptionTxCtxData() (*InscriptionTxCtxData, error) {
+	ctxData := &InscriptionTxCtxData{
+		InscriptionScript:       data.InscriptionScript,
+		CommitTxAddress:         data.CommitTxAddress,
+		CommitTxAddressPkScript: data.CommitTxOutPkScript,
+		ControlBlockWitness:     data.ControlBlockWitness,
+		CommitConfirmations:     data.CommitConfirmations,
+		RevealTxPrevOutput: &wire.TxOut{
+			PkScript: data.CommitTxOutPkScript,
+			Value:    data.CommitTxOutValue,
+		},
+	}
+	for _, out := range data.RevealTxOutputs {
+		ctxData.RevealTxOutputs = append(ctxData.RevealTxOutputs, wire.NewTxOut(out.Value, out.PkScript))
+	}
+	if data.Parent != nil {
+		txHash, err := chainhash.NewHashFromStr(data.Parent.TxId)
+		if err != nil {
+			return nil, err
+		}
+		ctxData.ParentOutPoint = wire.NewOutPoint(txHash, data.Parent.VOut)
+		ctxData.ParentPrevOutput = wire.NewTxOut(data.Parent.Value, data.Parent.PkScript)
+	}
+	if data.Funding != nil {
+		txHash, err := chainhash.NewHashFromStr(data.Funding.TxId)
+		if err != nil {
+			return nil, err
+		}
+		ctxData.RevealFundingOutPoint = wire.NewOutPoint(txHash, data.Funding.VOut)
+		ctxData.RevealFundingPrevOutput = wire.NewTxOut(data.Funding.Value, data.Funding.PkScript)
+		ctxData.RevealChangePkScript = data.RevealChangePkScript
+	}
+	return ctxData, nil
+}
+
+func toInscriptionTxCtxDataList(ctxDataList []*Brc20CtxData) ([]*InscriptionTxCtxData, error) {
+	inscriptionTxCtxDataList := make([]*InscriptionTxCtxData, len(ctxDataList))
+	for i := range ctxDataList {
+		inscriptionTxCtxData, err := ctxDataList[i].inscriptionTxCtxData()
+		if err != nil {
+			return nil, err
+		}
+		inscriptionTxCtxDataList[i] = inscriptionTxCtxData
+	}
+	return inscriptionTxCtxDataList, nil
 }
 
 const (
@@ -126,11 +268,16 @@ func (builder *InscriptionBuilder) initTool(network *chaincfg.Params, request *I
 	if request.RevealOutValue > 0 {
 		revealOutValue = request.RevealOutValue
 	}
-	minChangeValue := DefaultMinChangeValue
+	minChangeValue := GetDustLimit(network)
 	if request.MinChangeValue > 0 {
 		minChangeValue = request.MinChangeValue
 	}
 
+	// the commit outputs are sized for the reveals, inscribed sats would be
+	// revealed along with the new inscriptions
+	if HasProtectedSats(request.CommitTxPrevOutputList) {
+		return errors.New("commit inputs must not carry inscriptions")
+	}
 	privateKeyWif, err := btcutil.DecodeWIF(request.CommitTxPrevOutputList[0].PrivateKey)
 	if err != nil {
 		return err
@@ -139,14 +286,23 @@ func (builder *InscriptionBuilder) initTool(network *chaincfg.Params, request *I
 	pubKey := privateKey.PubKey()
 
 	inscriptionTxCtxDataList := make([]*InscriptionTxCtxData, len(request.InscriptionDataList))
-	for i := 0; i < len(request.InscriptionDataList); i++ {
-
-		inscriptionTxCtxData, err := newInscriptionTxCtxData(network, &request.InscriptionDataList[i], privateKey, pubKey)
-		if err != nil {
+	if NeedsBatchTxCtxData(request.InscriptionDataList, request.BatchMode, request.Parent, request.RevealOptions) {
+		if inscriptionTxCtxDataList, err = newBatchTxCtxDataList(network, request.InscriptionDataList, request.BatchMode, revealOutValue, request.Parent, privateKey, pubKey); err != nil {
 			return err
 		}
-		inscriptionTxCtxDataList[i] = inscriptionTxCtxData
-		destinations[i] = request.InscriptionDataList[i].RevealAddr
+		if err = request.RevealOptions.apply(network, inscriptionTxCtxDataList); err != nil {
+			return err
+		}
+	} else {
+		for i := 0; i < len(request.InscriptionDataList); i++ {
+
+			inscriptionTxCtxData, err := newInscriptionTxCtxData(network, &request.InscriptionDataList[i], privateKey, pubKey)
+			if err != nil {
+				return err
+			}
+			inscriptionTxCtxDataList[i] = inscriptionTxCtxData
+			destinations[i] = request.InscriptionDataList[i].RevealAddr
+		}
 	}
 	revealTxs, totalRevealPrevOutputValue, err := builder.BuildEmptyRevealTx(destinations, inscriptionTxCtxDataList, revealOutValue, request.RevealFeeRate)
 	if err != nil {
@@ -199,6 +355,14 @@ func (builder *InscriptionBuilder) initTool(network *chaincfg.Params, request *I
 		return err
 	}
 
+	if err = builder.SignRevealTxParent(revealTxs, revealTxPrevOutputFetcher, inscriptionTxCtxDataList); err != nil {
+		return err
+	}
+
+	if err = builder.SignRevealTxFunding(revealTxs, revealTxPrevOutputFetcher, inscriptionTxCtxDataList); err != nil {
+		return err
+	}
+
 	if err = CheckRevealTx(revealTxs); err != nil {
 		return err
 	}
@@ -215,7 +379,7 @@ func (builder *InscriptionBuilder) PreProcess(network *chaincfg.Params, inscript
 	if argRevealOutValue > 0 {
 		revealOutValue = argRevealOutValue
 	}
-	minChangeValue := DefaultMinChangeValue
+	minChangeValue := GetDustLimit(network)
 	if argMinChangeValue > 0 {
 		minChangeValue = argMinChangeValue
 	}
@@ -236,6 +400,10 @@ func (builder *InscriptionBuilder) PreProcess(network *chaincfg.Params, inscript
 		destinations[i] = inscriptionDataList[i].RevealAddr
 	}
 
+	return builder.parseInscriptionTxCtxData(destinations, inscriptionTxCtxDataList, revealOutValue, minChangeValue, revealFeeRate)
+}
+
+func (builder *InscriptionBuilder) parseInscriptionTxCtxData(destinations []string, inscriptionTxCtxDataList []*InscriptionTxCtxData, revealOutValue int64, minChangeValue int64, revealFeeRate int64) (*Brc20InscriptionParseResult, error) {
 	revealTxs, totalRevealPrevOutputValue, err := builder.BuildEmptyRevealTx(destinations, inscriptionTxCtxDataList, revealOutValue, revealFeeRate)
 	if err != nil {
 		return nil, err
@@ -252,8 +420,31 @@ func (builder *InscriptionBuilder) PreProcess(network *chaincfg.Params, inscript
 			InscriptionScript:   inscriptionTxCtxDataList[i].InscriptionScript,
 			ControlBlockWitness: inscriptionTxCtxDataList[i].ControlBlockWitness,
 
-			RevealTxOutPkScript: revealTxs[i].TxOut[0].PkScript,
-			RevealTxOutValue:    revealTxs[i].TxOut[0].Value,
+			// the parent input and output come first and shift both
+			RevealTxOutPkScript: revealTxs[i].TxOut[revealCommitInputIndex(inscriptionTxCtxDataList[i])].PkScript,
+			RevealTxOutValue:    revealTxs[i].TxOut[revealCommitInputIndex(inscriptionTxCtxDataList[i])].Value,
+
+			CommitConfirmations: inscriptionTxCtxDataList[i].CommitConfirmations,
+		}
+		for _, out := range inscriptionTxCtxDataList[i].RevealTxOutputs {
+			data.RevealTxOutputs = append(data.RevealTxOutputs, &RevealTxOut{PkScript: out.PkScript, Value: out.Value})
+		}
+		if parent := inscriptionTxCtxDataList[i].ParentOutPoint; parent != nil {
+			data.Parent = &RevealTxPrevOut{
+				TxId:     parent.Hash.String(),
+				VOut:     parent.Index,
+				PkScript: inscriptionTxCtxDataList[i].ParentPrevOutput.PkScript,
+				Value:    inscriptionTxCtxDataList[i].ParentPrevOutput.Value,
+			}
+		}
+		if funding := inscriptionTxCtxDataList[i].RevealFundingOutPoint; funding != nil {
+			data.Funding = &RevealTxPrevOut{
+				TxId:     funding.Hash.String(),
+				VOut:     funding.Index,
+				PkScript: inscriptionTxCtxDataList[i].RevealFundingPrevOutput.PkScript,
+				Value:    inscriptionTxCtxDataList[i].RevealFundingPrevOutput.Value,
+			}
+			data.RevealChangePkScript = inscriptionTxCtxDataList[i].RevealChangePkScript
 		}
 
 		ctxDataList[i] = data
@@ -271,46 +462,40 @@ func (builder *InscriptionBuilder) PreProcess(network *chaincfg.Params, inscript
 }
 
 func newInscriptionTxCtxData(network *chaincfg.Params, inscriptionData *InscriptionData, privateKey *btcec.PrivateKey, pubKey *btcec.PublicKey) (*InscriptionTxCtxData, error) {
+	if err := checkBrc20Blessed(inscriptionData, 0, true); err != nil {
+		return nil, err
+	}
 
-	inscriptionBuilder := txscript.NewScriptBuilder().
-		AddData(schnorr.SerializePubKey(pubKey)).
-		AddOp(txscript.OP_CHECKSIG).
-		AddOp(txscript.OP_FALSE).
-		AddOp(txscript.OP_IF).
-		AddData([]byte("ord")).
-		AddOp(txscript.OP_DATA_1).
-		AddOp(txscript.OP_DATA_1).
-		AddData([]byte(inscriptionData.ContentType)).
-		AddOp(txscript.OP_0)
-	maxChunkSize := 520
-	// use taproot to skip txscript.MaxScriptSize 10000
-	bodySize := len(inscriptionData.Body)
-	for i := 0; i < bodySize; i += maxChunkSize {
-		end := i + maxChunkSize
-		if end > bodySize {
-			end = bodySize
-		}
-
-		inscriptionBuilder.AddFullData(inscriptionData.Body[i:end])
-	}
-	inscriptionScript, err := inscriptionBuilder.Script()
+	inscriptionScript, err := brc20.CreateInscriptionScriptWithPubKey(schnorr.SerializePubKey(pubKey),
+		inscriptionData.ContentType, inscriptionData.Body, inscriptionData.envelopeFields())
 	if err != nil {
 		return nil, err
 	}
-	inscriptionScript = append(inscriptionScript, txscript.OP_ENDIF)
 
-	proof := &txscript.TapscriptProof{
-		TapLeaf:  txscript.NewBaseTapLeaf(schnorr.SerializePubKey(pubKey)),
-		RootNode: txscript.NewBaseTapLeaf(inscriptionScript),
+	return newTapscriptTxCtxData(network, inscriptionScript, inscriptionData.RecoveryLeaf, privateKey, pubKey)
+}
+
+// newTapscriptTxCtxData commits to the tapscript, and to a bare recovery leaf
+// when withRecoveryLeaf is set. The commit output is spent through the
+// tapscript by the reveal tx and through the recovery leaf by recoverCommit.
+func newTapscriptTxCtxData(network *chaincfg.Params, inscriptionScript []byte, withRecoveryLeaf bool, privateKey *btcec.PrivateKey, pubKey *btcec.PublicKey) (*InscriptionTxCtxData, error) {
+	leaves := []txscript.TapLeaf{txscript.NewBaseTapLeaf(inscriptionScript)}
+	if withRecoveryLeaf {
+		recoveryLeaf, err := newRecoveryTapLeaf(schnorr.SerializePubKey(pubKey))
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, recoveryLeaf)
 	}
+	tree := txscript.AssembleTaprootScriptTree(leaves...)
 
-	controlBlock := proof.ToControlBlock(pubKey)
+	controlBlock := tree.LeafMerkleProofs[0].ToControlBlock(pubKey)
 	controlBlockWitness, err := controlBlock.ToBytes()
 	if err != nil {
 		return nil, err
 	}
 
-	tapHash := proof.RootNode.TapHash()
+	tapHash := tree.RootNode.TapHash()
 	commitTxAddress, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootOutputKey(pubKey, tapHash[:])), network)
 	if err != nil {
 		return nil, err
@@ -329,18 +514,56 @@ func newInscriptionTxCtxData(network *chaincfg.Params, inscriptionData *Inscript
 	}, nil
 }
 
+// newRecoveryTapLeaf is the <key> OP_CHECKSIG leaf committed to next to the
+// inscription, its witness carries no envelope.
+func newRecoveryTapLeaf(xOnlyPubKey []byte) (txscript.TapLeaf, error) {
+	script, err := txscript.NewScriptBuilder().AddData(xOnlyPubKey).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		return txscript.TapLeaf{}, err
+	}
+	return txscript.NewBaseTapLeaf(script), nil
+}
+
 func (builder *InscriptionBuilder) BuildEmptyRevealTx(destination []string, inscriptionTxCtxDataList []*InscriptionTxCtxData, revealOutValue, revealFeeRate int64) ([]*wire.MsgTx, int64, error) {
-	addTxInTxOutIntoRevealTx := func(tx *wire.MsgTx, index int) error {
+	addTxInTxOutIntoRevealTx := func(tx *wire.MsgTx, index int) (int64, error) {
+		if parent := inscriptionTxCtxDataList[index]; parent.ParentOutPoint != nil {
+			in := wire.NewTxIn(parent.ParentOutPoint, nil, nil)
+			in.Sequence = DefaultSequenceNum
+			tx.AddTxIn(in)
+			tx.AddTxOut(wire.NewTxOut(parent.ParentPrevOutput.Value, parent.ParentPrevOutput.PkScript))
+		}
+		sequence, err := revealCommitSequence(inscriptionTxCtxDataList[index])
+		if err != nil {
+			return 0, err
+		}
 		in := wire.NewTxIn(&wire.OutPoint{Index: uint32(index)}, nil, nil)
-		in.Sequence = DefaultSequenceNum
+		in.Sequence = sequence
 		tx.AddTxIn(in)
+		if outputs := inscriptionTxCtxDataList[index].RevealTxOutputs; len(outputs) > 0 {
+			outValue := int64(0)
+			for _, out := range outputs {
+				tx.AddTxOut(wire.NewTxOut(out.Value, out.PkScript))
+				outValue += out.Value
+			}
+			// the funding input follows the commit input and the change the
+			// inscription outputs, the inscribed sats come first
+			if funding := inscriptionTxCtxDataList[index]; funding.RevealFundingOutPoint != nil {
+				in := wire.NewTxIn(funding.RevealFundingOutPoint, nil, nil)
+				in.Sequence = DefaultSequenceNum
+				tx.AddTxIn(in)
+				if funding.RevealChangePkScript != nil {
+					tx.AddTxOut(wire.NewTxOut(0, funding.RevealChangePkScript))
+				}
+			}
+			return outValue, nil
+		}
 		scriptPubKey, err := AddrToPkScript(destination[index], builder.Network)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		out := wire.NewTxOut(revealOutValue, scriptPubKey)
 		tx.AddTxOut(out)
-		return nil
+		return revealOutValue, nil
 	}
 
 	totalPrevOutputValue := int64(0)
@@ -348,32 +571,93 @@ func (builder *InscriptionBuilder) BuildEmptyRevealTx(destination []string, insc
 	revealTx := make([]*wire.MsgTx, total)
 	mustRevealTxFees := make([]int64, total)
 	commitAddrs := make([]string, total)
+	var commitConfirmations []int64
 	for i := 0; i < total; i++ {
 		tx := wire.NewMsgTx(DefaultTxVersion)
-		err := addTxInTxOutIntoRevealTx(tx, i)
+		outValue, err := addTxInTxOutIntoRevealTx(tx, i)
 		if err != nil {
 			return revealTx, 0, err
 		}
-		prevOutputValue := revealOutValue + int64(tx.SerializeSize())*revealFeeRate
-		emptySignature := make([]byte, 64)
-		emptyControlBlockWitness := make([]byte, 33)
-		fee := (int64(wire.TxWitness{emptySignature, inscriptionTxCtxDataList[i].InscriptionScript, emptyControlBlockWitness}.SerializeSize()+2+3) / 4) * revealFeeRate
-		prevOutputValue += fee
+		fee := estimateRevealFee(tx, inscriptionTxCtxDataList[i], revealFeeRate)
+		prevOutputValue := outValue + fee
+		if inscriptionTxCtxDataList[i].RevealFundingOutPoint != nil {
+			prevOutputValue, fee = builder.fundRevealTx(tx, inscriptionTxCtxDataList[i], outValue, revealFeeRate)
+		}
 		inscriptionTxCtxDataList[i].RevealTxPrevOutput = &wire.TxOut{
 			PkScript: inscriptionTxCtxDataList[i].CommitTxAddressPkScript,
 			Value:    prevOutputValue,
 		}
+		if err = checkRevealSatFlow(tx, inscriptionTxCtxDataList[i]); err != nil {
+			return revealTx, 0, fmt.Errorf("reveal(index %d): %w", i, err)
+		}
+		if weight := estimateRevealTxWeight(tx, inscriptionTxCtxDataList[i]); weight > MaxStandardTxWeight {
+			return revealTx, 0, fmt.Errorf("reveal(index %d) transaction weight greater than %d (MAX_STANDARD_TX_WEIGHT): %d", i, MaxStandardTxWeight, weight)
+		}
 		totalPrevOutputValue += prevOutputValue
 		revealTx[i] = tx
-		mustRevealTxFees[i] = int64(tx.SerializeSize())*revealFeeRate + fee
+		mustRevealTxFees[i] = fee
 		commitAddrs[i] = inscriptionTxCtxDataList[i].CommitTxAddress
+		if confirmations := inscriptionTxCtxDataList[i].CommitConfirmations; confirmations > 0 {
+			if commitConfirmations == nil {
+				commitConfirmations = make([]int64, total)
+			}
+			commitConfirmations[i] = confirmations
+		}
 	}
 	builder.MustRevealTxFees = mustRevealTxFees
+	builder.CommitConfirmations = commitConfirmations
 	builder.CommitAddrs = commitAddrs
 
 	return revealTx, totalPrevOutputValue, nil
 }
 
+// estimateRevealFee is the fee of tx once signed at revealFeeRate.
+func estimateRevealFee(tx *wire.MsgTx, ctxData *InscriptionTxCtxData, revealFeeRate int64) int64 {
+	fillDummyRevealWitness(tx, ctxData)
+	defer clearRevealWitness(tx)
+	return GetTxVirtualSize(btcutil.NewTx(tx)) * revealFeeRate
+}
+
+// estimateRevealTxWeight is the weight of tx once signed.
+func estimateRevealTxWeight(tx *wire.MsgTx, ctxData *InscriptionTxCtxData) int64 {
+	fillDummyRevealWitness(tx, ctxData)
+	defer clearRevealWitness(tx)
+	return GetTransactionWeight(btcutil.NewTx(tx))
+}
+
+// fillDummyRevealWitness fills the reveal inputs with signature sized
+// witnesses, every envelope field is part of the inscription script.
+func fillDummyRevealWitness(tx *wire.MsgTx, ctxData *InscriptionTxCtxData) {
+	controlBlockWitness := ctxData.ControlBlockWitness
+	if len(controlBlockWitness) == 0 {
+		controlBlockWitness = make([]byte, 33)
+	}
+	commitIn := tx.TxIn[revealCommitInputIndex(ctxData)]
+	commitIn.Witness = wire.TxWitness{make([]byte, 64), ctxData.InscriptionScript, controlBlockWitness}
+	if ctxData.ParentOutPoint != nil {
+		tx.TxIn[0].SignatureScript, tx.TxIn[0].Witness = dummySignature(ctxData.ParentPrevOutput.PkScript)
+	}
+	if ctxData.RevealFundingOutPoint != nil {
+		fundingIn := tx.TxIn[revealCommitInputIndex(ctxData)+1]
+		fundingIn.SignatureScript, fundingIn.Witness = dummySignature(ctxData.RevealFundingPrevOutput.PkScript)
+	}
+}
+
+func clearRevealWitness(tx *wire.MsgTx) {
+	for _, in := range tx.TxIn {
+		in.SignatureScript, in.Witness = nil, nil
+	}
+}
+
+// revealCommitInputIndex is the reveal input spending the commit output, the
+// parent, when there is one, comes first.
+func revealCommitInputIndex(ctxData *InscriptionTxCtxData) int {
+	if ctxData.ParentOutPoint != nil {
+		return 1
+	}
+	return 0
+}
+
 func (builder *InscriptionBuilder) ParseCommitTxPrevOutput(commitTxPrevOutputList []*PrevOutput) (*txscript.MultiPrevOutFetcher, *wire.MsgTx, btcutil.Amount, error) {
 	tx := wire.NewMsgTx(DefaultTxVersion)
 	commitTxPrevOutputFetcher := txscript.NewMultiPrevOutFetcher(nil)
@@ -450,12 +734,18 @@ func (builder *InscriptionBuilder) FillRevealTx(revealTxs []*wire.MsgTx, commitT
 			Hash:  commitTxhash,
 			Index: uint32(i),
 		}, inscriptionTxCtxDataList[i].RevealTxPrevOutput)
-		revealTxs[i].TxIn[0].PreviousOutPoint.Hash = commitTxhash
+		if inscriptionTxCtxDataList[i].ParentOutPoint != nil {
+			revealTxPrevOutputFetcher.AddPrevOut(*inscriptionTxCtxDataList[i].ParentOutPoint, inscriptionTxCtxDataList[i].ParentPrevOutput)
+		}
+		if inscriptionTxCtxDataList[i].RevealFundingOutPoint != nil {
+			revealTxPrevOutputFetcher.AddPrevOut(*inscriptionTxCtxDataList[i].RevealFundingOutPoint, inscriptionTxCtxDataList[i].RevealFundingPrevOutput)
+		}
+		revealTxs[i].TxIn[revealCommitInputIndex(inscriptionTxCtxDataList[i])].PreviousOutPoint.Hash = commitTxhash
 	}
 	for i := range inscriptionTxCtxDataList {
 		revealTx := revealTxs[i]
 		witnessArray, err := txscript.CalcTapscriptSignaturehash(txscript.NewTxSigHashes(revealTx, revealTxPrevOutputFetcher),
-			txscript.SigHashDefault, revealTx, 0, revealTxPrevOutputFetcher, txscript.NewBaseTapLeaf(inscriptionTxCtxDataList[i].InscriptionScript))
+			txscript.SigHashDefault, revealTx, revealCommitInputIndex(inscriptionTxCtxDataList[i]), revealTxPrevOutputFetcher, txscript.NewBaseTapLeaf(inscriptionTxCtxDataList[i].InscriptionScript))
 		if err != nil {
 			return revealTxPrevOutputFetcher, witnessList, err
 		}
@@ -466,8 +756,8 @@ func (builder *InscriptionBuilder) FillRevealTx(revealTxs []*wire.MsgTx, commitT
 }
 
 func (builder *InscriptionBuilder) SignRevealTx(revealTxs []*wire.MsgTx, witnessList [][]byte, inscriptionTxCtxDataList []*InscriptionTxCtxData) error {
-	for _, tx := range revealTxs {
-		tx.TxIn[0].Witness = wire.TxWitness{}
+	for i, tx := range revealTxs {
+		tx.TxIn[revealCommitInputIndex(inscriptionTxCtxDataList[i])].Witness = wire.TxWitness{}
 	}
 
 	for i := range inscriptionTxCtxDataList {
@@ -477,14 +767,14 @@ func (builder *InscriptionBuilder) SignRevealTx(revealTxs []*wire.MsgTx, witness
 			return err
 		}
 		witness := wire.TxWitness{signature.Serialize(), inscriptionTxCtxDataList[i].InscriptionScript, inscriptionTxCtxDataList[i].ControlBlockWitness}
-		revealTxs[i].TxIn[0].Witness = witness
+		revealTxs[i].TxIn[revealCommitInputIndex(inscriptionTxCtxDataList[i])].Witness = witness
 	}
 
 	return nil
 }
 func (builder *InscriptionBuilder) SignRevealTx2(revealTxs []*wire.MsgTx, signature string, inscriptionTxCtxDataList []*InscriptionTxCtxData) error {
-	for _, tx := range revealTxs {
-		tx.TxIn[0].Witness = wire.TxWitness{}
+	for i, tx := range revealTxs {
+		tx.TxIn[revealCommitInputIndex(inscriptionTxCtxDataList[i])].Witness = wire.TxWitness{}
 	}
 
 	for i := range inscriptionTxCtxDataList {
@@ -493,7 +783,7 @@ func (builder *InscriptionBuilder) SignRevealTx2(revealTxs []*wire.MsgTx, signat
 			return err
 		}
 		witness := wire.TxWitness{signatureBytes, inscriptionTxCtxDataList[i].InscriptionScript, inscriptionTxCtxDataList[i].ControlBlockWitness}
-		revealTxs[i].TxIn[0].Witness = witness
+		revealTxs[i].TxIn[revealCommitInputIndex(inscriptionTxCtxDataList[i])].Witness = witness
 	}
 
 	return nil
@@ -512,42 +802,51 @@ func CheckRevealTx(revealTxs []*wire.MsgTx) error {
 }
 
 func Sign(tx *wire.MsgTx, privateKeys []*btcec.PrivateKey, prevOutFetcher *txscript.MultiPrevOutFetcher) error {
-	for i, in := range tx.TxIn {
-		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+	for i := range tx.TxIn {
 		txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
-		privKey := privateKeys[i]
-		if txscript.IsPayToTaproot(prevOut.PkScript) {
-			witness, err := txscript.TaprootWitnessSignature(tx, txSigHashes, i, prevOut.Value, prevOut.PkScript, txscript.SigHashDefault, privKey)
-			if err != nil {
-				return err
-			}
-			in.Witness = witness
-		} else if txscript.IsPayToPubKeyHash(prevOut.PkScript) {
-			sigScript, err := txscript.SignatureScript(tx, i, prevOut.PkScript, txscript.SigHashAll, privKey, true)
-			if err != nil {
-				return err
-			}
-			in.SignatureScript = sigScript
-		} else {
-			pubKeyBytes := privKey.PubKey().SerializeCompressed()
-			script, err := PayToPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
-			if err != nil {
-				return err
-			}
-			amount := prevOut.Value
-			witness, err := txscript.WitnessSignature(tx, txSigHashes, i, amount, script, txscript.SigHashAll, privKey, true)
+		if err := signTxInput(tx, i, privateKeys[i], prevOutFetcher, txSigHashes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// signTxInput signs input i through the key path of its previous output.
+func signTxInput(tx *wire.MsgTx, i int, privKey *btcec.PrivateKey, prevOutFetcher *txscript.MultiPrevOutFetcher, txSigHashes *txscript.TxSigHashes) error {
+	in := tx.TxIn[i]
+	prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+	if txscript.IsPayToTaproot(prevOut.PkScript) {
+		witness, err := txscript.TaprootWitnessSignature(tx, txSigHashes, i, prevOut.Value, prevOut.PkScript, txscript.SigHashDefault, privKey)
+		if err != nil {
+			return err
+		}
+		in.Witness = witness
+	} else if txscript.IsPayToPubKeyHash(prevOut.PkScript) {
+		sigScript, err := txscript.SignatureScript(tx, i, prevOut.PkScript, txscript.SigHashAll, privKey, true)
+		if err != nil {
+			return err
+		}
+		in.SignatureScript = sigScript
+	} else {
+		pubKeyBytes := privKey.PubKey().SerializeCompressed()
+		script, err := PayToPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
+		if err != nil {
+			return err
+		}
+		amount := prevOut.Value
+		witness, err := txscript.WitnessSignature(tx, txSigHashes, i, amount, script, txscript.SigHashAll, privKey, true)
+		if err != nil {
+			return err
+		}
+		in.Witness = witness
+
+		if txscript.IsPayToScriptHash(prevOut.PkScript) {
+			redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
 			if err != nil {
 				return err
 			}
-			in.Witness = witness
-
-			if txscript.IsPayToScriptHash(prevOut.PkScript) {
-				redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
-				if err != nil {
-					return err
-				}
-				in.SignatureScript = append([]byte{byte(len(redeemScript))}, redeemScript...)
-			}
+			in.SignatureScript = append([]byte{byte(len(redeemScript))}, redeemScript...)
 		}
 	}
 
@@ -594,11 +893,13 @@ func CalculateRevealTxFee(revealTxs []*wire.MsgTx, revealTxPrevOutputFetcher *tx
 	revealTxFees := make([]int64, 0)
 	for _, tx := range revealTxs {
 		revealTxFee := int64(0)
-		for i, in := range tx.TxIn {
+		for _, in := range tx.TxIn {
 			revealTxFee += revealTxPrevOutputFetcher.FetchPrevOutput(in.PreviousOutPoint).Value
-			revealTxFee -= tx.TxOut[i].Value
-			revealTxFees = append(revealTxFees, revealTxFee)
 		}
+		for _, out := range tx.TxOut {
+			revealTxFee -= out.Value
+		}
+		revealTxFees = append(revealTxFees, revealTxFee)
 	}
 	return revealTxFees
 }
@@ -612,6 +913,8 @@ func Inscribe(network *chaincfg.Params, request *InscriptionRequest) (*InscribeT
 			CommitTxFee:  tool.MustCommitTxFee,
 			RevealTxFees: tool.MustRevealTxFees,
 			CommitAddrs:  tool.CommitAddrs,
+
+			CommitConfirmations: tool.CommitConfirmations,
 		}, nil
 	}
 
@@ -634,6 +937,8 @@ func Inscribe(network *chaincfg.Params, request *InscriptionRequest) (*InscribeT
 		CommitTxFee:  tool.CommitTxFee,
 		RevealTxFees: tool.RevealTxFees,
 		CommitAddrs:  tool.CommitAddrs,
+
+		CommitConfirmations: tool.CommitConfirmations,
 	}, nil
 }
 
@@ -0,0 +1,306 @@
+package bitcoin
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/brc20"
+)
+
+const (
+	// BatchModeSeparateOutputs gives every inscription of a reveal its own
+	// output, the envelopes point at the first sat of their output.
+	BatchModeSeparateOutputs = "separate-outputs"
+	// BatchModeSameSat inscribes every envelope of a reveal on the first sat
+	// of a single output.
+	BatchModeSameSat = "same-sat"
+)
+
+// batchInscriptionScript writes all the envelopes behind a single
+// <pubkey> OP_CHECKSIG, so one commit output reveals them all.
+func batchInscriptionScript(pubKey *btcec.PublicKey, inscriptionDataList []InscriptionData) ([]byte, error) {
+	script, err := brc20.CreateInscriptionScriptWithPubKey(schnorr.SerializePubKey(pubKey),
+		inscriptionDataList[0].ContentType, inscriptionDataList[0].Body, inscriptionDataList[0].envelopeFields())
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(inscriptionDataList); i++ {
+		envelope, err := brc20.CreateEnvelopeScript(inscriptionDataList[i].ContentType, inscriptionDataList[i].Body, inscriptionDataList[i].envelopeFields())
+		if err != nil {
+			return nil, err
+		}
+		script = append(script, envelope...)
+	}
+	return script, nil
+}
+
+// estimateRevealWeight is the weight of a reveal spending a tapscript of
+// scriptLen bytes proven by a control block of controlBlockLen bytes, and the
+// parent when not nil, into outputs. A funding input and its change are left
+// out, BuildEmptyRevealTx checks the final weight.
+func estimateRevealWeight(scriptLen, controlBlockLen int, outputs []*wire.TxOut, parent *wire.TxOut) int64 {
+	inputs := 1
+	if parent != nil {
+		inputs++
+		outputs = append([]*wire.TxOut{parent}, outputs...)
+	}
+	baseSize := 4 + 4 + wire.VarIntSerializeSize(uint64(inputs)) + 41 + wire.VarIntSerializeSize(uint64(len(outputs)))
+	for _, out := range outputs {
+		baseSize += out.SerializeSize()
+	}
+	witnessSize := wire.VarIntSerializeSize(3) +
+		1 + 64 +
+		wire.VarIntSerializeSize(uint64(scriptLen)) + scriptLen +
+		wire.VarIntSerializeSize(uint64(controlBlockLen)) + controlBlockLen
+	if parent != nil {
+		sigScript, witness := dummySignature(parent.PkScript)
+		baseSize += 41 + len(sigScript)
+		witnessSize += witness.SerializeSize()
+	}
+	return int64(baseSize*WitnessScaleFactor + 2 + witnessSize)
+}
+
+// newBatchTxCtxDataList splits inscriptionDataList into as few reveals as
+// MaxStandardTxWeight allows, each reveal spends one commit output whose
+// tapscript carries all its envelopes. Pointers are assigned by the batch
+// mode and override the ones of inscriptionDataList, an empty mode is
+// BatchModeSeparateOutputs. An empty mode without a parent is no batch at
+// all: one reveal per inscription, keeping its pointer.
+//
+// With a parent every inscription gets the parent tag and the parent UTXO is
+// spent and returned at output 0 of the reveal, so all the children have to
+// fit in one reveal.
+//
+// A brc-20 body is only taken where the reveal inscribes it blessed, first in
+// its reveal without pointer and without parent, see checkBrc20Blessed.
+//
+// An inscription carrying a runestone is revealed alone, its postage output
+// followed by the premine and runestone outputs, see etchingOutputs. An
+// EtchingOnly one gets the same outputs from a tapscript without envelope,
+// see etchingScript.
+func newBatchTxCtxDataList(network *chaincfg.Params, inscriptionDataList []InscriptionData, batchMode string, revealOutValue int64, parent *ParentInscription, privateKey *btcec.PrivateKey, pubKey *btcec.PublicKey) ([]*InscriptionTxCtxData, error) {
+	unbatched := batchMode == "" && parent == nil
+	if batchMode == "" {
+		batchMode = BatchModeSeparateOutputs
+	}
+	if batchMode != BatchModeSeparateOutputs && batchMode != BatchModeSameSat {
+		return nil, fmt.Errorf("unknown batch mode %q", batchMode)
+	}
+	if len(inscriptionDataList) == 0 {
+		return nil, fmt.Errorf("no inscription data")
+	}
+	if err := checkEtchings(inscriptionDataList, parent); err != nil {
+		return nil, err
+	}
+
+	var parentOutPoint *wire.OutPoint
+	var parentPrevOutput *wire.TxOut
+	var parentPrivateKey *btcec.PrivateKey
+	pointerBase := int64(0)
+	if parent != nil {
+		var err error
+		if parentOutPoint, parentPrevOutput, parentPrivateKey, err = parent.prevOutput(network); err != nil {
+			return nil, err
+		}
+		// the children sats follow the parent output
+		pointerBase = parentPrevOutput.Value
+	}
+
+	pkScripts := make([][]byte, len(inscriptionDataList))
+	postages := make([]int64, len(inscriptionDataList))
+	for i := range inscriptionDataList {
+		pkScript, err := AddrToPkScript(inscriptionDataList[i].RevealAddr, network)
+		if err != nil {
+			return nil, err
+		}
+		pkScripts[i] = pkScript
+		postages[i] = revealOutValue
+		if inscriptionDataList[i].Postage > 0 {
+			postages[i] = inscriptionDataList[i].Postage
+		}
+		if postages[i] < GetDustLimit(network) {
+			return nil, fmt.Errorf("inscription %d: postage %d below the dust limit", i, postages[i])
+		}
+	}
+
+	envelopeSize := func(data InscriptionData) (int, error) {
+		if data.EtchingOnly {
+			script, err := etchingScript(pubKey, &data)
+			if err != nil {
+				return 0, err
+			}
+			return len(script) - (1 + 32 + 1), nil
+		}
+		envelope, err := brc20.CreateEnvelopeScript(data.ContentType, data.Body, data.envelopeFields())
+		if err != nil {
+			return 0, err
+		}
+		return len(envelope), nil
+	}
+
+	withRecoveryLeaf := false
+	for i := range inscriptionDataList {
+		withRecoveryLeaf = withRecoveryLeaf || inscriptionDataList[i].RecoveryLeaf
+	}
+	// the control block only depends on the depth of the tapscript leaf
+	probe, err := newTapscriptTxCtxData(network, nil, withRecoveryLeaf, nil, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	controlBlockLen := len(probe.ControlBlockWitness)
+
+	var ctxDataList []*InscriptionTxCtxData
+	var batch []InscriptionData
+	var outputs []*wire.TxOut
+	outValue := int64(0)
+	commitConfirmations := int64(0)
+	// <32 byte pubkey> OP_CHECKSIG
+	scriptLen := 1 + 32 + 1
+
+	flush := func() error {
+		var script []byte
+		var err error
+		if batch[0].EtchingOnly {
+			// an etching is revealed alone
+			script, err = etchingScript(pubKey, &batch[0])
+		} else {
+			script, err = batchInscriptionScript(pubKey, batch)
+		}
+		if err != nil {
+			return err
+		}
+		ctxData, err := newTapscriptTxCtxData(network, script, withRecoveryLeaf, privateKey, pubKey)
+		if err != nil {
+			return err
+		}
+		ctxData.RevealTxOutputs = outputs
+		ctxData.ParentOutPoint = parentOutPoint
+		ctxData.ParentPrevOutput = parentPrevOutput
+		ctxData.ParentPrivateKey = parentPrivateKey
+		ctxData.CommitConfirmations = commitConfirmations
+		ctxDataList = append(ctxDataList, ctxData)
+		batch, outputs, outValue, scriptLen, commitConfirmations = nil, nil, 0, 1+32+1, 0
+		return nil
+	}
+
+	for i := 0; i < len(inscriptionDataList); i++ {
+		data := inscriptionDataList[i]
+		if parent != nil {
+			data.Parent = parent.InscriptionId
+		}
+		if data.Runestone != nil && len(batch) > 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		var output *wire.TxOut
+		switch {
+		case unbatched:
+			output = wire.NewTxOut(postages[i], pkScripts[i])
+		case batchMode == BatchModeSeparateOutputs:
+			data.Pointer = uint64(pointerBase + outValue)
+			output = wire.NewTxOut(postages[i], pkScripts[i])
+		case batchMode == BatchModeSameSat:
+			// the sat is sent with the postage of the first inscription
+			data.Pointer = uint64(pointerBase)
+			if len(batch) == 0 {
+				output = wire.NewTxOut(postages[i], pkScripts[i])
+			} else if data.RevealAddr != batch[0].RevealAddr {
+				return nil, fmt.Errorf("inscription %d: same-sat batch reveals to %s, got %s", i, batch[0].RevealAddr, data.RevealAddr)
+			}
+		}
+
+		newOutputs := []*wire.TxOut{output}
+		confirmations := int64(0)
+		if data.Runestone != nil {
+			firstIndex := 0
+			if parent != nil {
+				firstIndex = 1
+			}
+			var err error
+			if newOutputs, confirmations, err = etchingOutputs(network, &data, output, revealOutValue, firstIndex); err != nil {
+				return nil, fmt.Errorf("inscription %d: %w", i, err)
+			}
+		}
+
+		size, err := envelopeSize(data)
+		if err != nil {
+			return nil, err
+		}
+		candidateOutputs := outputs
+		if output != nil {
+			candidateOutputs = append(outputs[:len(outputs):len(outputs)], newOutputs...)
+		}
+		if estimateRevealWeight(scriptLen+size, controlBlockLen, candidateOutputs, parentPrevOutput) > MaxStandardTxWeight {
+			if len(batch) == 0 {
+				return nil, fmt.Errorf("inscription %d: reveal transaction weight greater than %d (MAX_STANDARD_TX_WEIGHT)", i, MaxStandardTxWeight)
+			}
+			if parent != nil {
+				return nil, fmt.Errorf("inscription %d: children of %s do not fit in one reveal", i, parent.InscriptionId)
+			}
+			if err = flush(); err != nil {
+				return nil, err
+			}
+			// the inscription opens the next batch, its pointer changes
+			i--
+			continue
+		}
+
+		if err = checkBrc20Blessed(&data, len(batch), parent == nil); err != nil {
+			return nil, fmt.Errorf("inscription %d: %w", i, err)
+		}
+		batch = append(batch, data)
+		outputs = candidateOutputs
+		if output != nil {
+			for _, out := range newOutputs {
+				outValue += out.Value
+			}
+		}
+		scriptLen += size
+		commitConfirmations = confirmations
+		if unbatched || data.Runestone != nil {
+			if err = flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(batch) == 0 {
+		return ctxDataList, nil
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return ctxDataList, nil
+}
+
+// PreProcessBatch is PreProcess for batch mode, every ctx data of the result
+// is one commit output and one reveal carrying several inscriptions.
+// revealOptions is optional.
+func (builder *InscriptionBuilder) PreProcessBatch(network *chaincfg.Params, inscriptionDataList []InscriptionData, batchMode string, parent *ParentInscription, revealOptions *RevealOptions, argRevealOutValue int64, argMinChangeValue int64, revealFeeRate int64, pubKey []byte) (*Brc20InscriptionParseResult, error) {
+	revealOutValue := DefaultRevealOutValue
+	if argRevealOutValue > 0 {
+		revealOutValue = argRevealOutValue
+	}
+	minChangeValue := GetDustLimit(network)
+	if argMinChangeValue > 0 {
+		minChangeValue = argMinChangeValue
+	}
+
+	pk, err := btcec.ParsePubKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	inscriptionTxCtxDataList, err := newBatchTxCtxDataList(network, inscriptionDataList, batchMode, revealOutValue, parent, nil, pk)
+	if err != nil {
+		return nil, err
+	}
+	if err = revealOptions.apply(network, inscriptionTxCtxDataList); err != nil {
+		return nil, err
+	}
+
+	return builder.parseInscriptionTxCtxData(nil, inscriptionTxCtxDataList, revealOutValue, minChangeValue, revealFeeRate)
+}
@@ -0,0 +1,171 @@
+package bitcoin
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInscribeBatch(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	address := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+
+	var inscriptionDataList []InscriptionData
+	for i := 0; i < 3; i++ {
+		inscriptionDataList = append(inscriptionDataList, InscriptionData{
+			ContentType: "text/plain;charset=utf-8",
+			Body:        []byte(fmt.Sprintf("inscription %d", i+1)),
+			RevealAddr:  address,
+		})
+	}
+	request := &InscriptionRequest{
+		CommitTxPrevOutputList: []*PrevOutput{{
+			TxId:       "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+			VOut:       0,
+			Amount:     100000,
+			Address:    address,
+			PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		}},
+		CommitFeeRate:       2,
+		RevealFeeRate:       2,
+		InscriptionDataList: inscriptionDataList,
+		ChangeAddress:       address,
+	}
+
+	t.Run(BatchModeSeparateOutputs, func(t *testing.T) {
+		request.BatchMode = BatchModeSeparateOutputs
+		txs, err := Inscribe(network, request)
+		require.Nil(t, err)
+		require.Len(t, txs.RevealTxs, 1)
+
+		commitTx, err := NewTxFromHex(txs.CommitTx)
+		require.Nil(t, err)
+		// one commit output and the change
+		assert.Len(t, commitTx.TxOut, 2)
+
+		revealTx, err := NewTxFromHex(txs.RevealTxs[0])
+		require.Nil(t, err)
+		require.Len(t, revealTx.TxOut, 3)
+		inscriptions, err := ParseInscriptions(revealTx, []int64{commitTx.TxOut[0].Value})
+		require.Nil(t, err)
+		require.Len(t, inscriptions, 3)
+		for i, inscription := range inscriptions {
+			assert.Equal(t, inscriptionDataList[i].Body, inscription.Body)
+			assert.Equal(t, int64(i)*DefaultRevealOutValue, inscription.Offset)
+		}
+		assert.Nil(t, inscriptions[0].Pointer)
+		assert.Equal(t, commitTx.TxOut[0].Value-3*DefaultRevealOutValue, txs.RevealTxFees[0])
+	})
+
+	t.Run(BatchModeSameSat, func(t *testing.T) {
+		request.BatchMode = BatchModeSameSat
+		txs, err := Inscribe(network, request)
+		require.Nil(t, err)
+		require.Len(t, txs.RevealTxs, 1)
+
+		revealTx, err := NewTxFromHex(txs.RevealTxs[0])
+		require.Nil(t, err)
+		require.Len(t, revealTx.TxOut, 1)
+		inscriptions, err := ParseInscriptions(revealTx, nil)
+		require.Nil(t, err)
+		require.Len(t, inscriptions, 3)
+		for _, inscription := range inscriptions {
+			assert.Nil(t, inscription.Pointer)
+			assert.Equal(t, int64(0), inscription.Offset)
+		}
+	})
+
+	t.Run("brc-20", func(t *testing.T) {
+		// indexers skip the cursed envelopes past the first one of the input
+		request.BatchMode = BatchModeSameSat
+		request.InscriptionDataList = append([]InscriptionData{}, inscriptionDataList...)
+		request.InscriptionDataList[0].Body = []byte(`{"p":"brc-20","op":"transfer","tick":"xcvb","amt":"1"}`)
+		_, err := Inscribe(network, request)
+		assert.Nil(t, err)
+		request.InscriptionDataList[1].Body = request.InscriptionDataList[0].Body
+		_, err = Inscribe(network, request)
+		assert.ErrorContains(t, err, "inscription 1: brc-20 body would be cursed")
+
+		// so are the ones with a pointer
+		request.InscriptionDataList = []InscriptionData{request.InscriptionDataList[0]}
+		request.InscriptionDataList[0].Pointer = 1
+		request.BatchMode = ""
+		_, err = Inscribe(network, request)
+		assert.ErrorContains(t, err, "cursed")
+		request.InscriptionDataList = inscriptionDataList
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		request.BatchMode = "all-in-one"
+		_, err := Inscribe(network, request)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestBatchSplitByWeight(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKeyWif, err := btcutil.DecodeWIF("cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22")
+	require.Nil(t, err)
+
+	var inscriptionDataList []InscriptionData
+	for i := 0; i < 5; i++ {
+		inscriptionDataList = append(inscriptionDataList, InscriptionData{
+			ContentType: "application/octet-stream",
+			Body:        bytes.Repeat([]byte{byte(i)}, 150000),
+			RevealAddr:  "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		})
+	}
+
+	ctxDataList, err := newBatchTxCtxDataList(network, inscriptionDataList, BatchModeSeparateOutputs, DefaultRevealOutValue, nil, privateKeyWif.PrivKey, privateKeyWif.PrivKey.PubKey())
+	require.Nil(t, err)
+	require.Len(t, ctxDataList, 3)
+	assert.Len(t, ctxDataList[0].RevealTxOutputs, 2)
+	assert.Len(t, ctxDataList[1].RevealTxOutputs, 2)
+	assert.Len(t, ctxDataList[2].RevealTxOutputs, 1)
+
+	builder := &InscriptionBuilder{Network: network}
+	revealTxs, _, err := builder.BuildEmptyRevealTx(nil, ctxDataList, DefaultRevealOutValue, 1)
+	require.Nil(t, err)
+	_, witnessList, err := builder.FillRevealTx(revealTxs, revealTxs[0].TxIn[0].PreviousOutPoint.Hash, ctxDataList)
+	require.Nil(t, err)
+	require.Nil(t, builder.SignRevealTx(revealTxs, witnessList, ctxDataList))
+	require.Nil(t, CheckRevealTx(revealTxs))
+	for i, tx := range revealTxs {
+		// the estimate sizes the control block of the signed reveal
+		weight := estimateRevealWeight(len(ctxDataList[i].InscriptionScript), len(ctxDataList[i].ControlBlockWitness), ctxDataList[i].RevealTxOutputs, nil)
+		assert.Equal(t, GetTransactionWeight(btcutil.NewTx(tx)), weight)
+	}
+
+	// every batch restarts its pointers at its own first output
+	inscriptions, err := ParseInscriptions(revealTxs[1], nil)
+	require.Nil(t, err)
+	require.Len(t, inscriptions, 2)
+	assert.Nil(t, inscriptions[0].Pointer)
+	require.NotNil(t, inscriptions[1].Pointer)
+	assert.Equal(t, uint64(DefaultRevealOutValue), *inscriptions[1].Pointer)
+
+	// a funding input and its change are only weighed on the built reveal
+	fundingPkScript, err := AddrToPkScript("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", network)
+	require.Nil(t, err)
+	full := *ctxDataList[0]
+	weight := GetTransactionWeight(btcutil.NewTx(revealTxs[0]))
+	full.InscriptionScript = append(bytes.Repeat([]byte{txscript.OP_NOP}, int(MaxStandardTxWeight-weight-100)), full.InscriptionScript...)
+	_, _, err = builder.BuildEmptyRevealTx(nil, []*InscriptionTxCtxData{&full}, DefaultRevealOutValue, 1)
+	require.Nil(t, err)
+	full.RevealFundingOutPoint = &wire.OutPoint{Index: 1}
+	full.RevealFundingPrevOutput = wire.NewTxOut(100000, fundingPkScript)
+	full.RevealChangePkScript = fundingPkScript
+	_, _, err = builder.BuildEmptyRevealTx(nil, []*InscriptionTxCtxData{&full}, DefaultRevealOutValue, 1)
+	assert.ErrorContains(t, err, "MAX_STANDARD_TX_WEIGHT")
+
+	inscriptionDataList[0].Body = bytes.Repeat([]byte{0}, MaxStandardTxWeight)
+	_, err = newBatchTxCtxDataList(network, inscriptionDataList, BatchModeSeparateOutputs, DefaultRevealOutValue, nil, privateKeyWif.PrivKey, privateKeyWif.PrivKey.PubKey())
+	assert.NotNil(t, err)
+}
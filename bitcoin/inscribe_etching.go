@@ -0,0 +1,129 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// runeCommitment is the commitment of the rune etched along with data, nil
+// when there is none or the rune name is invalid, etchingOutputs reports it.
+func (data *InscriptionData) runeCommitment() []byte {
+	if data.Runestone == nil || data.Runestone.Etching == nil || data.Runestone.Etching.Rune == "" {
+		return nil
+	}
+	value, _, err := runes.ParseSpacedRune(data.Runestone.Etching.Rune)
+	if err != nil {
+		return nil
+	}
+	return runes.Commitment(value)
+}
+
+// etchingScript is the tapscript of an EtchingOnly reveal,
+// <pubkey> OP_CHECKSIG <commitment> OP_DROP: the rune commitment is a data
+// push of the tapscript as the runes protocol requires, never an OP_N the
+// indexer would not read as one, but there is no envelope for ord to read
+// an inscription from.
+func etchingScript(pubKey *btcec.PublicKey, data *InscriptionData) ([]byte, error) {
+	commitment := data.runeCommitment()
+	if commitment == nil {
+		return nil, errors.New("an etching only reveal needs the rune name of an etching")
+	}
+	return txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(pubKey)).
+		AddOp(txscript.OP_CHECKSIG).
+		AddFullData(commitment).
+		AddOp(txscript.OP_DROP).
+		Script()
+}
+
+// etchingOutputs are the reveal outputs of data carrying a runestone: the
+// inscription postage, the premine output when there is a premine and the
+// runestone. firstIndex is the index of the postage output in the reveal,
+// the runestone pointer defaults to the premine output. It also returns the
+// confirmations the commit output needs before the reveal, zero when the
+// runestone commits to no rune.
+func etchingOutputs(network *chaincfg.Params, data *InscriptionData, postage *wire.TxOut, revealOutValue int64, firstIndex int) ([]*wire.TxOut, int64, error) {
+	runestone := *data.Runestone
+	outputs := []*wire.TxOut{postage}
+
+	if etching := runestone.Etching; etching != nil && etching.Premine != nil && etching.Premine.Sign() > 0 {
+		premineAddr := data.PremineAddr
+		if premineAddr == "" {
+			premineAddr = data.RevealAddr
+		}
+		pkScript, err := AddrToPkScript(premineAddr, network)
+		if err != nil {
+			return nil, 0, err
+		}
+		outputs = append(outputs, wire.NewTxOut(revealOutValue, pkScript))
+		if runestone.Pointer == nil {
+			pointer := uint32(firstIndex + 1)
+			runestone.Pointer = &pointer
+		}
+	}
+
+	script, err := runestone.Encipher()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = runes.CheckStandardScript(script); err != nil {
+		return nil, 0, err
+	}
+	outputs = append(outputs, wire.NewTxOut(0, script))
+	if err = runestone.CheckOutputs(firstIndex + len(outputs)); err != nil {
+		return nil, 0, err
+	}
+
+	if data.runeCommitment() == nil {
+		return outputs, 0, nil
+	}
+	return outputs, runes.CommitConfirmations, nil
+}
+
+// revealCommitSequence is the sequence of the reveal input spending the
+// commit output. An etching commitment must be CommitConfirmations deep, a
+// relative lock keeps the reveal out of the blocks before.
+func revealCommitSequence(ctxData *InscriptionTxCtxData) (uint32, error) {
+	if ctxData.CommitConfirmations <= 0 {
+		return DefaultSequenceNum, nil
+	}
+	if ctxData.CommitConfirmations-1 > wire.SequenceLockTimeMask {
+		return 0, fmt.Errorf("commit confirmations %d above the relative lock time limit", ctxData.CommitConfirmations)
+	}
+	// the reveal may be mined CommitConfirmations-1 blocks after the commit
+	return uint32(ctxData.CommitConfirmations - 1), nil
+}
+
+// checkEtchings refuses the inscription data lists the reveal layout can't
+// hold: a runestone is revealed in a reveal of its own, so it can't be
+// batched with the children of a parent. An EtchingOnly reveal needs a rune
+// to commit to and has no inscription to be a child.
+func checkEtchings(inscriptionDataList []InscriptionData, parent *ParentInscription) error {
+	for i := range inscriptionDataList {
+		if inscriptionDataList[i].EtchingOnly {
+			if inscriptionDataList[i].runeCommitment() == nil {
+				return fmt.Errorf("inscription %d: an etching only reveal needs the rune name of an etching", i)
+			}
+			if parent != nil {
+				return fmt.Errorf("inscription %d: an etching only reveal can't be a child of %s", i, parent.InscriptionId)
+			}
+		}
+		if inscriptionDataList[i].Runestone == nil {
+			continue
+		}
+		if parent != nil && len(inscriptionDataList) > 1 {
+			return fmt.Errorf("inscription %d: a runestone is revealed alone, it can't be batched with the children of %s", i, parent.InscriptionId)
+		}
+		if inscriptionDataList[i].Runestone.Etching == nil && inscriptionDataList[i].Runestone.Mint == nil && len(inscriptionDataList[i].Runestone.Edicts) == 0 {
+			return fmt.Errorf("inscription %d: empty runestone", i)
+		}
+	}
+	return nil
+}
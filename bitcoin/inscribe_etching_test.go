@@ -0,0 +1,216 @@
+package bitcoin
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInscribeEtching(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	address := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	premineAddr := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	divisibility := uint8(2)
+	etchingData := InscriptionData{
+		ContentType: "text/plain",
+		Body:        []byte("uncommon goods"),
+		RevealAddr:  address,
+		Runestone: &runes.Runestone{Etching: &runes.Etching{
+			Divisibility: &divisibility,
+			Premine:      big.NewInt(100000),
+			Rune:         "UNCOMMON•GOODS",
+			Symbol:       "⧉",
+		}},
+		PremineAddr: premineAddr,
+	}
+	value, _, err := runes.ParseSpacedRune("UNCOMMON•GOODS")
+	require.Nil(t, err)
+
+	t.Run("local", func(t *testing.T) {
+		txs, err := Inscribe(network, &InscriptionRequest{
+			CommitTxPrevOutputList: []*PrevOutput{{
+				TxId:       "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+				Amount:     100000,
+				Address:    address,
+				PrivateKey: privateKey,
+			}},
+			CommitFeeRate: 2,
+			RevealFeeRate: 2,
+			InscriptionDataList: []InscriptionData{
+				{ContentType: "text/plain", Body: []byte("plain"), RevealAddr: address},
+				etchingData,
+			},
+			ChangeAddress: address,
+		})
+		require.Nil(t, err)
+		require.Len(t, txs.RevealTxs, 2)
+		assert.Equal(t, []int64{0, runes.CommitConfirmations}, txs.CommitConfirmations)
+
+		commitTx, err := NewTxFromHex(txs.CommitTx)
+		require.Nil(t, err)
+		revealTx, err := NewTxFromHex(txs.RevealTxs[1])
+		require.Nil(t, err)
+		require.Len(t, revealTx.TxIn, 1)
+		require.Len(t, revealTx.TxOut, 3)
+		assert.Equal(t, uint32(runes.CommitConfirmations-1), revealTx.TxIn[0].Sequence)
+		premineScript, err := AddrToPkScript(premineAddr, network)
+		require.Nil(t, err)
+		assert.Equal(t, premineScript, revealTx.TxOut[1].PkScript)
+
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[0].PreviousOutPoint, commitTx.TxOut[1])
+		verifyRevealTx(t, revealTx, prevOutFetcher)
+
+		inscriptions, err := ParseInscriptions(revealTx, []int64{commitTx.TxOut[1].Value})
+		require.Nil(t, err)
+		require.Len(t, inscriptions, 1)
+		assert.Equal(t, runes.Commitment(value), inscriptions[0].Rune)
+		assert.Equal(t, int64(0), inscriptions[0].Offset)
+
+		artifact := runes.Decipher(revealTx)
+		require.NotNil(t, artifact)
+		require.False(t, artifact.IsCenotaph())
+		assert.Equal(t, "UNCOMMON•GOODS", artifact.Runestone.Etching.Rune)
+		require.NotNil(t, artifact.Runestone.Pointer)
+		assert.Equal(t, uint32(1), *artifact.Runestone.Pointer)
+		allocation := runes.Allocate(revealTx, nil, nil)
+		assert.Equal(t, "100000", allocation.Outputs[1][runes.RuneId{}].String())
+
+		plainTx, err := NewTxFromHex(txs.RevealTxs[0])
+		require.Nil(t, err)
+		assert.Equal(t, uint32(DefaultSequenceNum), plainTx.TxIn[0].Sequence)
+	})
+
+	t.Run("external", func(t *testing.T) {
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		pubKey := privateKeyWif.PrivKey.PubKey().SerializeCompressed()
+
+		builder := &InscriptionBuilder{Network: network}
+		parseResult, err := builder.PreProcessBatch(network, []InscriptionData{etchingData}, "", nil, nil, 0, 0, 2, pubKey)
+		require.Nil(t, err)
+		require.Len(t, parseResult.CtxDataList, 1)
+		assert.Equal(t, int64(runes.CommitConfirmations), parseResult.CtxDataList[0].CommitConfirmations)
+
+		commitTxHash := chainhash.HashH([]byte("commit"))
+		revealTxsHex, witnessList, _, err := BuildBrc20RevealTx(network, commitTxHash, parseResult.CtxDataList, nil, 2, 0)
+		require.Nil(t, err)
+		signedRevealTxsHex, err := SignBrc20RevealTx(network, revealTxsHex, witnessList, parseResult.CtxDataList, privateKey)
+		require.Nil(t, err)
+
+		revealTx, err := NewTxFromHex(signedRevealTxsHex[0])
+		require.Nil(t, err)
+		assert.Equal(t, uint32(runes.CommitConfirmations-1), revealTx.TxIn[0].Sequence)
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(wire.OutPoint{Hash: commitTxHash}, wire.NewTxOut(parseResult.CtxDataList[0].CommitTxOutValue, parseResult.CtxDataList[0].CommitTxOutPkScript))
+		verifyRevealTx(t, revealTx, prevOutFetcher)
+	})
+
+	t.Run("etching only", func(t *testing.T) {
+		data := InscriptionData{
+			RevealAddr:  address,
+			Runestone:   etchingData.Runestone,
+			PremineAddr: premineAddr,
+			EtchingOnly: true,
+		}
+		txs, err := Inscribe(network, &InscriptionRequest{
+			CommitTxPrevOutputList: []*PrevOutput{{
+				TxId:       "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+				Amount:     100000,
+				Address:    address,
+				PrivateKey: privateKey,
+			}},
+			CommitFeeRate:       2,
+			RevealFeeRate:       2,
+			InscriptionDataList: []InscriptionData{data},
+			ChangeAddress:       address,
+		})
+		require.Nil(t, err)
+		require.Len(t, txs.RevealTxs, 1)
+		assert.Equal(t, []int64{runes.CommitConfirmations}, txs.CommitConfirmations)
+
+		commitTx, err := NewTxFromHex(txs.CommitTx)
+		require.Nil(t, err)
+		revealTx, err := NewTxFromHex(txs.RevealTxs[0])
+		require.Nil(t, err)
+		require.Len(t, revealTx.TxOut, 3)
+		assert.Equal(t, uint32(runes.CommitConfirmations-1), revealTx.TxIn[0].Sequence)
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[0].PreviousOutPoint, commitTx.TxOut[0])
+		verifyRevealTx(t, revealTx, prevOutFetcher)
+
+		// the tapscript pushes the commitment, outside of any envelope
+		tapscript := revealTx.TxIn[0].Witness[1]
+		assert.Contains(t, string(tapscript), string(runes.Commitment(value)))
+		inscriptions, err := ParseInscriptions(revealTx, []int64{commitTx.TxOut[0].Value})
+		require.Nil(t, err)
+		assert.Empty(t, inscriptions)
+
+		artifact := runes.Decipher(revealTx)
+		require.NotNil(t, artifact)
+		require.False(t, artifact.IsCenotaph())
+		assert.Equal(t, "UNCOMMON•GOODS", artifact.Runestone.Etching.Rune)
+
+		// no rune to commit to
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		unnamed := data
+		unnamed.Runestone = &runes.Runestone{Etching: &runes.Etching{}}
+		_, err = newBatchTxCtxDataList(network, []InscriptionData{unnamed}, "", DefaultRevealOutValue, nil, nil, privateKeyWif.PrivKey.PubKey())
+		assert.NotNil(t, err)
+	})
+
+	t.Run("reserved rune", func(t *testing.T) {
+		data := InscriptionData{
+			ContentType: "text/plain",
+			Body:        []byte("reserved"),
+			RevealAddr:  address,
+			Runestone:   &runes.Runestone{Etching: &runes.Etching{}},
+		}
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		ctxDataList, err := newBatchTxCtxDataList(network, []InscriptionData{data}, "", DefaultRevealOutValue, nil, nil, privateKeyWif.PrivKey.PubKey())
+		require.Nil(t, err)
+		require.Len(t, ctxDataList, 1)
+		assert.Equal(t, int64(0), ctxDataList[0].CommitConfirmations)
+		// no premine, the postage and the runestone
+		assert.Len(t, ctxDataList[0].RevealTxOutputs, 2)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		pubKey := privateKeyWif.PrivKey.PubKey()
+
+		reserved := etchingData
+		reserved.Runestone = &runes.Runestone{Etching: &runes.Etching{Rune: "AAAAAAAAAAAAAAAAAAAAAAAAAAA"}}
+		_, err = newBatchTxCtxDataList(network, []InscriptionData{reserved}, "", DefaultRevealOutValue, nil, nil, pubKey)
+		assert.NotNil(t, err)
+
+		parent := &ParentInscription{
+			InscriptionId: "e0b7c3a2e0cd7de6ffc1a3ec5ad2dbd4c0c4f1a6e8a5e4b5c2b5d4e1f2a3b4c5i0",
+			PrevOutput: PrevOutput{
+				TxId:    "e0b7c3a2e0cd7de6ffc1a3ec5ad2dbd4c0c4f1a6e8a5e4b5c2b5d4e1f2a3b4c5",
+				Amount:  10000,
+				Address: address,
+			},
+		}
+		child := InscriptionData{ContentType: "text/plain", Body: []byte("child"), RevealAddr: address}
+		_, err = newBatchTxCtxDataList(network, []InscriptionData{child, etchingData}, "", DefaultRevealOutValue, parent, nil, pubKey)
+		assert.NotNil(t, err)
+
+		empty := etchingData
+		empty.Runestone = &runes.Runestone{}
+		_, err = newBatchTxCtxDataList(network, []InscriptionData{empty}, "", DefaultRevealOutValue, nil, nil, pubKey)
+		assert.NotNil(t, err)
+	})
+}
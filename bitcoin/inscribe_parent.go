@@ -0,0 +1,192 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/brc20"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// ParentInscription is the inscription children are revealed under. The UTXO
+// holding it is spent by the reveal and paid back to Address, in full, at
+// output 0, so the parent sat never leaves its owner. PrivateKey is only
+// needed when the reveal is signed locally.
+type ParentInscription struct {
+	InscriptionId string `json:"inscriptionId"`
+	PrevOutput
+}
+
+func (parent *ParentInscription) prevOutput(network *chaincfg.Params) (*wire.OutPoint, *wire.TxOut, *btcec.PrivateKey, error) {
+	if _, _, err := brc20.ParseInscriptionId(parent.InscriptionId); err != nil {
+		return nil, nil, nil, err
+	}
+	txHash, err := chainhash.NewHashFromStr(parent.TxId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pkScript, err := AddrToPkScript(parent.Address, network)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if parent.Amount <= 0 {
+		return nil, nil, nil, errors.New("parent amount must be positive")
+	}
+	var privateKey *btcec.PrivateKey
+	if parent.PrivateKey != "" {
+		privateKeyWif, err := btcutil.DecodeWIF(parent.PrivateKey)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		privateKey = privateKeyWif.PrivKey
+	}
+	return wire.NewOutPoint(txHash, parent.VOut), wire.NewTxOut(parent.Amount, pkScript), privateKey, nil
+}
+
+// SignRevealTxParent signs the parent input of the reveals spending one, the
+// commit input is signed by SignRevealTx.
+func (builder *InscriptionBuilder) SignRevealTxParent(revealTxs []*wire.MsgTx, revealTxPrevOutputFetcher *txscript.MultiPrevOutFetcher, inscriptionTxCtxDataList []*InscriptionTxCtxData) error {
+	for i := range inscriptionTxCtxDataList {
+		if inscriptionTxCtxDataList[i].ParentOutPoint == nil {
+			continue
+		}
+		if inscriptionTxCtxDataList[i].ParentPrivateKey == nil {
+			return errors.New("missing parent private key")
+		}
+		txSigHashes := txscript.NewTxSigHashes(revealTxs[i], revealTxPrevOutputFetcher)
+		if err := signTxInput(revealTxs[i], 0, inscriptionTxCtxDataList[i].ParentPrivateKey, revealTxPrevOutputFetcher, txSigHashes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildBrc20ChildRevealTx is BuildBrc20RevealTx for reveals that may spend a
+// parent or a funding output, it returns per reveal the message hashes to
+// sign keyed by input index: the parent and funding key path inputs and the
+// script path commit input. parentPubKey and fundingPubKey are only needed
+// for outputs held by segwit v0 addresses.
+func BuildBrc20ChildRevealTx(network *chaincfg.Params, commitTxHash chainhash.Hash, ctxDataList []*Brc20CtxData,
+	revealFeeRate int64, parentPubKey []byte, fundingPubKey []byte) ([]string, []map[int]string, []int64, error) {
+	tool := &InscriptionBuilder{
+		Network: network,
+	}
+
+	inscriptionTxCtxDataList, err := toInscriptionTxCtxDataList(ctxDataList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i := range inscriptionTxCtxDataList {
+		if inscriptionTxCtxDataList[i].RevealTxOutputs == nil {
+			return nil, nil, nil, errors.New("child reveal needs batch ctx data")
+		}
+	}
+
+	revealTxs, _, err := tool.BuildEmptyRevealTx(nil, inscriptionTxCtxDataList, 0, revealFeeRate)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	revealTxPrevOutputFetcher, witnessList, err := tool.FillRevealTx(revealTxs, commitTxHash, inscriptionTxCtxDataList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	revealTxsHex := make([]string, len(revealTxs))
+	messageHashMaps := make([]map[int]string, len(revealTxs))
+	for i, tx := range revealTxs {
+		commitIndex := revealCommitInputIndex(inscriptionTxCtxDataList[i])
+		messageHashMap := map[int]string{
+			commitIndex: hexutil.Encode(witnessList[i]),
+		}
+		txSigHashes := txscript.NewTxSigHashes(tx, revealTxPrevOutputFetcher)
+		if inscriptionTxCtxDataList[i].ParentOutPoint != nil {
+			messageHash, err := calcInputMessageHash(tx, 0, revealTxPrevOutputFetcher, txSigHashes, parentPubKey)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			messageHashMap[0] = messageHash
+		}
+		if inscriptionTxCtxDataList[i].RevealFundingOutPoint != nil {
+			messageHash, err := calcInputMessageHash(tx, commitIndex+1, revealTxPrevOutputFetcher, txSigHashes, fundingPubKey)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			messageHashMap[commitIndex+1] = messageHash
+		}
+		messageHashMaps[i] = messageHashMap
+
+		if revealTxsHex[i], err = GetTxHex(tx); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return revealTxsHex, messageHashMaps, CalculateRevealTxFee(revealTxs, revealTxPrevOutputFetcher), nil
+}
+
+// SignBrc20ChildRevealTx injects the signatures of the hashes returned by
+// BuildBrc20ChildRevealTx, keyed by input index.
+func SignBrc20ChildRevealTx(network *chaincfg.Params, revealTxsHex []string, signatureMaps []map[int]string, ctxDataList []*Brc20CtxData, parentPubKey string, fundingPubKey string) ([]string, error) {
+	if len(revealTxsHex) != len(ctxDataList) || len(signatureMaps) != len(ctxDataList) {
+		return nil, errors.New("reveal txs, signatures and ctx data do not match")
+	}
+
+	inscriptionTxCtxDataList, err := toInscriptionTxCtxDataList(ctxDataList)
+	if err != nil {
+		return nil, err
+	}
+
+	signedRevealTxsHex := make([]string, len(revealTxsHex))
+	for i, txHex := range revealTxsHex {
+		tx, err := NewTxFromHex(txHex)
+		if err != nil {
+			return nil, err
+		}
+		ctxData := inscriptionTxCtxDataList[i]
+		commitIndex := revealCommitInputIndex(ctxData)
+		inputCount := commitIndex + 1
+		if ctxData.RevealFundingOutPoint != nil {
+			inputCount++
+		}
+		if len(tx.TxIn) != inputCount {
+			return nil, errors.New("reveal tx does not match its ctx data")
+		}
+
+		signature, err := hex.DecodeString(signatureMaps[i][commitIndex])
+		if err != nil {
+			return nil, err
+		}
+		tx.TxIn[commitIndex].Witness = wire.TxWitness{signature, ctxData.InscriptionScript, ctxData.ControlBlockWitness}
+
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(tx.TxIn[commitIndex].PreviousOutPoint, ctxData.RevealTxPrevOutput)
+		if ctxData.ParentOutPoint != nil {
+			prevOutFetcher.AddPrevOut(*ctxData.ParentOutPoint, ctxData.ParentPrevOutput)
+		}
+		if ctxData.RevealFundingOutPoint != nil {
+			prevOutFetcher.AddPrevOut(*ctxData.RevealFundingOutPoint, ctxData.RevealFundingPrevOutput)
+		}
+		txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+		if ctxData.ParentOutPoint != nil {
+			if err = signInputBySignature(tx, 0, prevOutFetcher, txSigHashes, signatureMaps[i][0], parentPubKey); err != nil {
+				return nil, err
+			}
+		}
+		if ctxData.RevealFundingOutPoint != nil {
+			if err = signInputBySignature(tx, commitIndex+1, prevOutFetcher, txSigHashes, signatureMaps[i][commitIndex+1], fundingPubKey); err != nil {
+				return nil, err
+			}
+		}
+
+		if signedRevealTxsHex[i], err = GetTxHex(tx); err != nil {
+			return nil, err
+		}
+	}
+	return signedRevealTxsHex, nil
+}
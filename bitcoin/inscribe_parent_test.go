@@ -0,0 +1,142 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func verifyRevealTx(t *testing.T, tx *wire.MsgTx, prevOutFetcher *txscript.MultiPrevOutFetcher) {
+	txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	for i, in := range tx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+		require.NotNil(t, prevOut)
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, txSigHashes, prevOut.Value, prevOutFetcher)
+		require.Nil(t, err)
+		require.Nil(t, vm.Execute(), "input %d", i)
+	}
+}
+
+func TestInscribeChildren(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	address := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	parentId := "e0b7c3a2e0cd7de6ffc1a3ec5ad2dbd4c0c4f1a6e8a5e4b5c2b5d4e1f2a3b4c5i0"
+	parent := &ParentInscription{
+		InscriptionId: parentId,
+		PrevOutput: PrevOutput{
+			TxId:       "e0b7c3a2e0cd7de6ffc1a3ec5ad2dbd4c0c4f1a6e8a5e4b5c2b5d4e1f2a3b4c5",
+			VOut:       0,
+			Amount:     10000,
+			Address:    address,
+			PrivateKey: privateKey,
+		},
+	}
+	inscriptionDataList := []InscriptionData{
+		{ContentType: "text/plain", Body: []byte("child 1"), RevealAddr: address},
+		{ContentType: "text/plain", Body: []byte("child 2"), RevealAddr: address},
+	}
+
+	t.Run("local", func(t *testing.T) {
+		txs, err := Inscribe(network, &InscriptionRequest{
+			CommitTxPrevOutputList: []*PrevOutput{{
+				TxId:       "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+				Amount:     100000,
+				Address:    address,
+				PrivateKey: privateKey,
+			}},
+			CommitFeeRate:       2,
+			RevealFeeRate:       2,
+			InscriptionDataList: inscriptionDataList,
+			ChangeAddress:       address,
+			Parent:              parent,
+		})
+		require.Nil(t, err)
+		require.Len(t, txs.RevealTxs, 1)
+
+		commitTx, err := NewTxFromHex(txs.CommitTx)
+		require.Nil(t, err)
+		revealTx, err := NewTxFromHex(txs.RevealTxs[0])
+		require.Nil(t, err)
+		require.Len(t, revealTx.TxIn, 2)
+		require.Len(t, revealTx.TxOut, 3)
+		assert.Equal(t, parent.TxId, revealTx.TxIn[0].PreviousOutPoint.Hash.String())
+		assert.Equal(t, parent.Amount, revealTx.TxOut[0].Value)
+
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[0].PreviousOutPoint, wire.NewTxOut(parent.Amount, revealTx.TxOut[0].PkScript))
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[1].PreviousOutPoint, commitTx.TxOut[0])
+		verifyRevealTx(t, revealTx, prevOutFetcher)
+
+		inscriptions, err := ParseInscriptions(revealTx, []int64{parent.Amount, commitTx.TxOut[0].Value})
+		require.Nil(t, err)
+		require.Len(t, inscriptions, 2)
+		for i, inscription := range inscriptions {
+			assert.Equal(t, []string{parentId}, inscription.Parents)
+			assert.Equal(t, parent.Amount+int64(i)*DefaultRevealOutValue, inscription.Offset)
+		}
+	})
+
+	t.Run("external", func(t *testing.T) {
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		pubKey := privateKeyWif.PrivKey.PubKey().SerializeCompressed()
+
+		builder := &InscriptionBuilder{Network: network}
+		parseResult, err := builder.PreProcessBatch(network, inscriptionDataList, "", parent, nil, 0, 0, 2, pubKey)
+		require.Nil(t, err)
+		require.Len(t, parseResult.CtxDataList, 1)
+		require.NotNil(t, parseResult.CtxDataList[0].Parent)
+
+		commitTxHash := chainhash.HashH([]byte("commit"))
+		revealTxsHex, messageHashMaps, revealTxFees, err := BuildBrc20ChildRevealTx(network, commitTxHash, parseResult.CtxDataList, 2, pubKey, nil)
+		require.Nil(t, err)
+		require.Len(t, messageHashMaps[0], 2)
+		assert.Equal(t, parseResult.CtxDataList[0].CommitTxOutValue-2*DefaultRevealOutValue, revealTxFees[0])
+
+		sign := func(messageHash string, tweak bool) string {
+			hash, err := hexutil.Decode(messageHash)
+			require.Nil(t, err)
+			key := privateKeyWif.PrivKey
+			if tweak {
+				key = txscript.TweakTaprootPrivKey(*key, nil)
+			}
+			signature, err := schnorr.Sign(key, hash)
+			require.Nil(t, err)
+			return hex.EncodeToString(signature.Serialize())
+		}
+		signatureMap := map[int]string{
+			0: sign(messageHashMaps[0][0], true),
+			1: sign(messageHashMaps[0][1], false),
+		}
+		signedRevealTxsHex, err := SignBrc20ChildRevealTx(network, revealTxsHex, []map[int]string{signatureMap}, parseResult.CtxDataList, "", "")
+		require.Nil(t, err)
+
+		revealTx, err := NewTxFromHex(signedRevealTxsHex[0])
+		require.Nil(t, err)
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[0].PreviousOutPoint, wire.NewTxOut(parent.Amount, revealTx.TxOut[0].PkScript))
+		prevOutFetcher.AddPrevOut(wire.OutPoint{Hash: commitTxHash}, wire.NewTxOut(parseResult.CtxDataList[0].CommitTxOutValue, parseResult.CtxDataList[0].CommitTxOutPkScript))
+		verifyRevealTx(t, revealTx, prevOutFetcher)
+	})
+
+	t.Run("too many children", func(t *testing.T) {
+		var children []InscriptionData
+		for i := 0; i < 3; i++ {
+			children = append(children, InscriptionData{ContentType: "text/plain", Body: make([]byte, 150000), RevealAddr: address})
+		}
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		_, err = newBatchTxCtxDataList(network, children, BatchModeSeparateOutputs, DefaultRevealOutValue, parent, nil, privateKeyWif.PrivKey.PubKey())
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,369 @@
+package bitcoin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/brc20"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+const (
+	CurseDuplicateField        = "duplicate-field"
+	CurseIncompleteField       = "incomplete-field"
+	CurseNotInFirstInput       = "not-in-first-input"
+	CurseNotAtOffsetZero       = "not-at-offset-zero"
+	CursePointer               = "pointer"
+	CurseReinscription         = "reinscription"
+	CursePushnum               = "pushnum"
+	CurseStutter               = "stutter"
+	CurseUnrecognizedEvenField = "unrecognized-even-field"
+)
+
+// ParsedInscription is an ord envelope read back from a reveal transaction.
+type ParsedInscription struct {
+	Id string `json:"id"`
+	// Input is the index of the input whose tapscript carries the envelope,
+	// Envelope is the index of the envelope inside that tapscript.
+	Input    int `json:"input"`
+	Envelope int `json:"envelope"`
+	// Offset is the sat offset, counted over all inputs, the inscription is
+	// bound to. It is only meaningful when the input values are known.
+	Offset int64 `json:"offset"`
+
+	ContentType     string   `json:"contentType"`
+	ContentEncoding string   `json:"contentEncoding"`
+	Body            []byte   `json:"body"`
+	Pointer         *uint64  `json:"pointer,omitempty"`
+	Parents         []string `json:"parents"`
+	Delegate        string   `json:"delegate"`
+	Metadata        []byte   `json:"metadata"`
+	Metaprotocol    string   `json:"metaprotocol"`
+	Rune            []byte   `json:"rune"`
+
+	DuplicateField        bool `json:"duplicateField"`
+	IncompleteField       bool `json:"incompleteField"`
+	UnrecognizedEvenField bool `json:"unrecognizedEvenField"`
+	Pushnum               bool `json:"pushnum"`
+	Stutter               bool `json:"stutter"`
+
+	Curses  []string `json:"curses"`
+	Cursed  bool     `json:"cursed"`
+	Unbound bool     `json:"unbound"`
+
+	// hasPointer tells the pointer field is present, Pointer is only set
+	// when it decodes
+	hasPointer bool
+}
+
+type rawEnvelope struct {
+	input    int
+	envelope int
+	payload  [][]byte
+	pushnum  bool
+	stutter  bool
+}
+
+// tapscriptFromWitness returns the leaf script of a script path spend, or nil
+// for key path spends and non taproot witnesses.
+func tapscriptFromWitness(witness wire.TxWitness) []byte {
+	if len(witness) >= 2 {
+		last := witness[len(witness)-1]
+		if len(last) > 0 && last[0] == txscript.TaprootAnnexTag {
+			witness = witness[:len(witness)-1]
+		}
+	}
+	if len(witness) < 2 {
+		return nil
+	}
+	return witness[len(witness)-2]
+}
+
+type scriptInstruction struct {
+	opcode byte
+	data   []byte
+}
+
+func (instruction *scriptInstruction) isEmptyPush() bool {
+	return instruction.opcode == txscript.OP_0
+}
+
+func (instruction *scriptInstruction) isPush() bool {
+	return instruction.opcode <= txscript.OP_PUSHDATA4
+}
+
+// parseEnvelopes scans a tapscript for OP_FALSE OP_IF "ord" ... OP_ENDIF
+// envelopes following the ord rules. The scan stops at an instruction that
+// fails to parse, the envelopes closed before it are kept.
+func parseEnvelopes(tapscript []byte, input int) []*rawEnvelope {
+	var instructions []*scriptInstruction
+	tokenizer := txscript.MakeScriptTokenizer(0, tapscript)
+	for tokenizer.Next() {
+		instructions = append(instructions, &scriptInstruction{opcode: tokenizer.Opcode(), data: tokenizer.Data()})
+	}
+
+	var envelopes []*rawEnvelope
+	stuttered := false
+	for i := 0; i < len(instructions); i++ {
+		if !instructions[i].isEmptyPush() {
+			continue
+		}
+		next, stutter, envelope := readEnvelope(instructions, i+1, input, len(envelopes), stuttered)
+		if envelope != nil {
+			envelopes = append(envelopes, envelope)
+		} else {
+			stuttered = stutter
+		}
+		i = next - 1
+	}
+	return envelopes
+}
+
+// readEnvelope reads an envelope whose OP_FALSE precedes instructions[i]. It
+// returns the index to continue scanning from and, when no envelope was found,
+// whether the scan stopped on another OP_FALSE (a stutter).
+func readEnvelope(instructions []*scriptInstruction, i int, input, index int, stutter bool) (int, bool, *rawEnvelope) {
+	peekEmpty := func(i int) bool {
+		return i < len(instructions) && instructions[i].isEmptyPush()
+	}
+	if i >= len(instructions) || instructions[i].opcode != txscript.OP_IF {
+		return i, peekEmpty(i), nil
+	}
+	i++
+	if i >= len(instructions) || !instructions[i].isPush() || !bytes.Equal(instructions[i].data, []byte(brc20.ProtocolId)) {
+		return i, peekEmpty(i), nil
+	}
+	i++
+
+	envelope := &rawEnvelope{input: input, envelope: index, stutter: stutter}
+	for ; i < len(instructions); i++ {
+		op := instructions[i].opcode
+		switch {
+		case op == txscript.OP_ENDIF:
+			return i + 1, false, envelope
+		case op == txscript.OP_1NEGATE:
+			envelope.pushnum = true
+			envelope.payload = append(envelope.payload, []byte{0x81})
+		case op >= txscript.OP_1 && op <= txscript.OP_16:
+			envelope.pushnum = true
+			envelope.payload = append(envelope.payload, []byte{op - txscript.OP_1 + 1})
+		case op <= txscript.OP_PUSHDATA4:
+			envelope.payload = append(envelope.payload, instructions[i].data)
+		default:
+			return i + 1, false, nil
+		}
+	}
+	return i, false, nil
+}
+
+func (envelope *rawEnvelope) parse() *ParsedInscription {
+	inscription := &ParsedInscription{
+		Input:    envelope.input,
+		Envelope: envelope.envelope,
+		Pushnum:  envelope.pushnum,
+		Stutter:  envelope.stutter,
+	}
+
+	bodyIndex := -1
+	for i := 0; i < len(envelope.payload); i += 2 {
+		if len(envelope.payload[i]) == 0 {
+			bodyIndex = i
+			break
+		}
+	}
+	fieldsEnd := len(envelope.payload)
+	if bodyIndex >= 0 {
+		fieldsEnd = bodyIndex
+		for _, chunk := range envelope.payload[bodyIndex+1:] {
+			inscription.Body = append(inscription.Body, chunk...)
+		}
+	}
+
+	var tags []string
+	fields := make(map[string][][]byte)
+	for i := 0; i < fieldsEnd; i += 2 {
+		if i+1 >= fieldsEnd {
+			inscription.IncompleteField = true
+			break
+		}
+		tag := string(envelope.payload[i])
+		if _, ok := fields[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		fields[tag] = append(fields[tag], envelope.payload[i+1])
+	}
+
+	take := func(tag int) []byte {
+		values := fields[string([]byte{byte(tag)})]
+		if len(values) == 0 {
+			return nil
+		}
+		return values[0]
+	}
+	if value := take(brc20.TagContentType); value != nil {
+		inscription.ContentType = string(value)
+	}
+	if value := take(brc20.TagContentEncoding); value != nil {
+		inscription.ContentEncoding = string(value)
+	}
+	if value := take(brc20.TagMetaprotocol); value != nil {
+		inscription.Metaprotocol = string(value)
+	}
+	_, inscription.hasPointer = fields[string([]byte{byte(brc20.TagPointer)})]
+	if value := take(brc20.TagPointer); value != nil {
+		if pointer, ok := brc20.DecodePointer(value); ok {
+			inscription.Pointer = &pointer
+		}
+	}
+	if value := take(brc20.TagDelegate); value != nil {
+		if id, err := brc20.DecodeInscriptionId(value); err == nil {
+			inscription.Delegate = id
+		}
+	}
+	if value := take(brc20.TagRune); value != nil {
+		inscription.Rune = value
+	}
+	for _, value := range fields[string([]byte{brc20.TagParent})] {
+		if id, err := brc20.DecodeInscriptionId(value); err == nil {
+			inscription.Parents = append(inscription.Parents, id)
+		}
+	}
+	for _, value := range fields[string([]byte{brc20.TagMetadata})] {
+		inscription.Metadata = append(inscription.Metadata, value...)
+	}
+
+	for _, tag := range tags {
+		values := fields[tag]
+		known := len(tag) == 1 && brc20.IsKnownTag(int(tag[0]))
+		if len(values) > 1 && (!known || (!brc20.IsChunkedTag(int(tag[0])) && tag[0] != brc20.TagParent)) {
+			inscription.DuplicateField = true
+		}
+		if !known && len(tag) > 0 && tag[0]%2 == 0 {
+			inscription.UnrecognizedEvenField = true
+		}
+	}
+
+	return inscription
+}
+
+// ParseInscriptions reads every envelope of every input of tx. inputValues
+// is optional, when given it is used to locate the inscribed sats. The
+// reinscription curse is left to ParseInscriptionsOnInscribedSats.
+func ParseInscriptions(tx *wire.MsgTx, inputValues []int64) ([]*ParsedInscription, error) {
+	return ParseInscriptionsOnInscribedSats(tx, inputValues, nil)
+}
+
+// ParseInscriptionsOnInscribedSats is ParseInscriptions also cursing
+// reinscriptions. inscribedOffsets are, for each input, the offsets of its
+// sats already inscribed as PrevOutput.InscriptionOffsets, their
+// inscriptions taken as blessed, it needs the input values.
+func ParseInscriptionsOnInscribedSats(tx *wire.MsgTx, inputValues []int64, inscribedOffsets [][]int64) ([]*ParsedInscription, error) {
+	if inputValues != nil && len(inputValues) != len(tx.TxIn) {
+		return nil, fmt.Errorf("got %d input values for %d inputs", len(inputValues), len(tx.TxIn))
+	}
+	if inscribedOffsets != nil && (inputValues == nil || len(inscribedOffsets) != len(tx.TxIn)) {
+		return nil, errors.New("inscribed offsets need the values of all the inputs")
+	}
+	totalOutputValue := int64(0)
+	for _, out := range tx.TxOut {
+		totalOutputValue += out.Value
+	}
+
+	// inscribedSats counts the inscriptions of each sat, and tells if the
+	// first one is cursed, reinscribing a cursed inscription being blessed
+	type inscribedSat struct {
+		count  int
+		cursed bool
+	}
+	inscribedSats := make(map[int64]*inscribedSat)
+	inputStart := int64(0)
+	for i := range inscribedOffsets {
+		for _, offset := range inscribedOffsets[i] {
+			if sat := inscribedSats[inputStart+offset]; sat != nil {
+				sat.count++
+			} else {
+				inscribedSats[inputStart+offset] = &inscribedSat{count: 1}
+			}
+		}
+		inputStart += inputValues[i]
+	}
+
+	txHash := tx.TxHash()
+	inscriptions := make([]*ParsedInscription, 0)
+	inputStart = 0
+	for i, in := range tx.TxIn {
+		tapscript := tapscriptFromWitness(in.Witness)
+		if tapscript != nil {
+			for _, envelope := range parseEnvelopes(tapscript, i) {
+				inscription := envelope.parse()
+				inscription.Id = fmt.Sprintf("%si%d", txHash.String(), len(inscriptions))
+				inscription.Offset = inputStart
+				if inscription.Pointer != nil && int64(*inscription.Pointer) < totalOutputValue {
+					inscription.Offset = int64(*inscription.Pointer)
+				}
+
+				if inscription.UnrecognizedEvenField {
+					inscription.Curses = append(inscription.Curses, CurseUnrecognizedEvenField)
+				}
+				if inscription.DuplicateField {
+					inscription.Curses = append(inscription.Curses, CurseDuplicateField)
+				}
+				if inscription.IncompleteField {
+					inscription.Curses = append(inscription.Curses, CurseIncompleteField)
+				}
+				if i != 0 {
+					inscription.Curses = append(inscription.Curses, CurseNotInFirstInput)
+				}
+				// ord's offset is the index of the envelope in its input
+				if envelope.envelope != 0 {
+					inscription.Curses = append(inscription.Curses, CurseNotAtOffsetZero)
+				}
+				// an undecodable pointer still curses
+				if inscription.hasPointer {
+					inscription.Curses = append(inscription.Curses, CursePointer)
+				}
+				if inscription.Pushnum {
+					inscription.Curses = append(inscription.Curses, CursePushnum)
+				}
+				if inscription.Stutter {
+					inscription.Curses = append(inscription.Curses, CurseStutter)
+				}
+				if inscribedOffsets != nil {
+					sat := inscribedSats[inscription.Offset]
+					if sat != nil && (sat.count > 1 || !sat.cursed) {
+						inscription.Curses = append(inscription.Curses, CurseReinscription)
+					}
+					if sat == nil {
+						inscribedSats[inscription.Offset] = &inscribedSat{count: 1, cursed: len(inscription.Curses) > 0}
+					} else {
+						sat.count++
+					}
+				}
+				inscription.Cursed = len(inscription.Curses) > 0
+				inscription.Unbound = inscription.UnrecognizedEvenField ||
+					(inputValues != nil && inputValues[i] == 0)
+
+				inscriptions = append(inscriptions, inscription)
+			}
+		}
+		if inputValues != nil {
+			inputStart += inputValues[i]
+		}
+	}
+	return inscriptions, nil
+}
+
+// DecodeInscriptions parses the inscriptions of a raw transaction,
+// inscribedOffsets is optional, see ParseInscriptionsOnInscribedSats.
+func DecodeInscriptions(txHex string, inputValues []int64, inscribedOffsets [][]int64) ([]*ParsedInscription, error) {
+	tx, err := NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	if len(tx.TxIn) == 0 {
+		return nil, errors.New("transaction has no inputs")
+	}
+	return ParseInscriptionsOnInscribedSats(tx, inputValues, inscribedOffsets)
+}
@@ -0,0 +1,124 @@
+package bitcoin
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/brc20"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInscriptions(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKeyWif, err := btcutil.DecodeWIF("cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22")
+	require.Nil(t, err)
+
+	parent := "1f64c4fd19d8fda2b7b4e5b2e5c2f1ba37ff1cfe4d8a0f23c8d2b6d1e1d1c1b1i0"
+	ctxData, err := newInscriptionTxCtxData(network, &InscriptionData{
+		ContentType:  "text/plain;charset=utf-8",
+		Body:         []byte(`{"p":"sns","op":"reg","name":"xcvb.sats"}`),
+		Parent:       parent,
+		Pointer:      600,
+		Metaprotocol: "sns",
+	}, privateKeyWif.PrivKey, privateKeyWif.PrivKey.PubKey())
+	require.Nil(t, err)
+
+	// a second input carrying two envelopes, the second one uses OP_1 for
+	// its body and an unknown even tag
+	first, err := brc20.CreateEnvelopeScript("text/plain", []byte("a"), nil)
+	require.Nil(t, err)
+	second := []byte{txscript.OP_FALSE, txscript.OP_IF, txscript.OP_DATA_3, 'o', 'r', 'd',
+		txscript.OP_DATA_1, 20, txscript.OP_DATA_1, 'x', txscript.OP_0, txscript.OP_1, txscript.OP_ENDIF}
+	script := append(append([]byte{txscript.OP_TRUE}, first...), second...)
+
+	tx := wire.NewMsgTx(DefaultTxVersion)
+	tx.AddTxIn(&wire.TxIn{Witness: wire.TxWitness{make([]byte, 64), ctxData.InscriptionScript, ctxData.ControlBlockWitness}})
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 1}, Witness: wire.TxWitness{script, ctxData.ControlBlockWitness}})
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 2}, Witness: wire.TxWitness{make([]byte, 64)}})
+	tx.AddTxOut(wire.NewTxOut(1000, ctxData.CommitTxAddressPkScript))
+
+	inscriptions, err := ParseInscriptions(tx, []int64{1000, 546, 546})
+	require.Nil(t, err)
+	require.Len(t, inscriptions, 3)
+
+	txHash := tx.TxHash()
+	assert.Equal(t, txHash.String()+"i0", inscriptions[0].Id)
+	assert.Equal(t, "text/plain;charset=utf-8", inscriptions[0].ContentType)
+	assert.Equal(t, []string{parent}, inscriptions[0].Parents)
+	assert.Equal(t, "sns", inscriptions[0].Metaprotocol)
+	require.NotNil(t, inscriptions[0].Pointer)
+	assert.Equal(t, uint64(600), *inscriptions[0].Pointer)
+	assert.Equal(t, int64(600), inscriptions[0].Offset)
+	assert.Equal(t, []string{CursePointer}, inscriptions[0].Curses)
+	assert.True(t, inscriptions[0].Cursed)
+
+	assert.Equal(t, txHash.String()+"i1", inscriptions[1].Id)
+	assert.Equal(t, []byte("a"), inscriptions[1].Body)
+	assert.Equal(t, []string{CurseNotInFirstInput}, inscriptions[1].Curses)
+	assert.False(t, inscriptions[1].Unbound)
+
+	assert.Equal(t, 1, inscriptions[2].Envelope)
+	assert.Equal(t, []byte{1}, inscriptions[2].Body)
+	assert.True(t, inscriptions[2].Pushnum)
+	assert.True(t, inscriptions[2].UnrecognizedEvenField)
+	assert.True(t, inscriptions[2].Unbound)
+	assert.Equal(t, []string{CurseUnrecognizedEvenField, CurseNotInFirstInput, CurseNotAtOffsetZero, CursePushnum}, inscriptions[2].Curses)
+
+	// the pointer of the first inscription targets an inscribed sat, the
+	// third one lands on the sat of the second, which is cursed
+	inscriptions, err = ParseInscriptionsOnInscribedSats(tx, []int64{1000, 546, 546}, [][]int64{{600}, nil, nil})
+	require.Nil(t, err)
+	assert.Equal(t, []string{CursePointer, CurseReinscription}, inscriptions[0].Curses)
+	assert.Equal(t, []string{CurseNotInFirstInput}, inscriptions[1].Curses)
+	assert.NotContains(t, inscriptions[2].Curses, CurseReinscription)
+
+	_, err = ParseInscriptionsOnInscribedSats(tx, nil, [][]int64{{600}, nil, nil})
+	assert.NotNil(t, err)
+}
+
+func TestParseInscriptionsOrdParity(t *testing.T) {
+	envelope := func(fields ...byte) []byte {
+		script := []byte{txscript.OP_FALSE, txscript.OP_IF, txscript.OP_DATA_3, 'o', 'r', 'd'}
+		return append(append(script, fields...), txscript.OP_ENDIF)
+	}
+	controlBlock := make([]byte, 33)
+	parse := func(tapscript []byte) []*ParsedInscription {
+		tx := wire.NewMsgTx(DefaultTxVersion)
+		tx.AddTxIn(&wire.TxIn{Witness: wire.TxWitness{make([]byte, 64), tapscript, controlBlock}})
+		tx.AddTxOut(wire.NewTxOut(1000, nil))
+		inscriptions, err := ParseInscriptions(tx, nil)
+		require.Nil(t, err)
+		return inscriptions
+	}
+
+	t.Run("tokenizer error", func(t *testing.T) {
+		// a push running past the end of the script stops the scan, the
+		// envelope closed before it is kept and the open one is not
+		script := envelope(txscript.OP_0, txscript.OP_DATA_1, 'a')
+		script = append(script, txscript.OP_FALSE, txscript.OP_IF, txscript.OP_DATA_3, 'o', 'r', 'd', txscript.OP_0, txscript.OP_PUSHDATA1, 5, 'b')
+		inscriptions := parse(script)
+		require.Len(t, inscriptions, 1)
+		assert.Equal(t, []byte("a"), inscriptions[0].Body)
+		assert.Empty(t, inscriptions[0].Curses)
+	})
+
+	t.Run("undecodable pointer", func(t *testing.T) {
+		// a pointer wider than 8 bytes with a non zero byte past the 8th
+		pointer := []byte{txscript.OP_DATA_1, brc20.TagPointer, txscript.OP_DATA_9, 1, 0, 0, 0, 0, 0, 0, 0, 1}
+		inscriptions := parse(envelope(append(pointer, txscript.OP_0, txscript.OP_DATA_1, 'a')...))
+		require.Len(t, inscriptions, 1)
+		assert.Nil(t, inscriptions[0].Pointer)
+		assert.Equal(t, int64(0), inscriptions[0].Offset)
+		assert.Equal(t, []string{CursePointer}, inscriptions[0].Curses)
+
+		// so does an empty one
+		inscriptions = parse(envelope(txscript.OP_DATA_1, brc20.TagPointer, txscript.OP_0, txscript.OP_0, txscript.OP_DATA_1, 'a'))
+		require.Len(t, inscriptions, 1)
+		assert.Nil(t, inscriptions[0].Pointer)
+		assert.Equal(t, []string{CursePointer}, inscriptions[0].Curses)
+	})
+}
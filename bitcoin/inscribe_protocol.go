@@ -1,14 +1,43 @@
 package bitcoin
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/brc20"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
 )
 
+// ValidateBrc20InscriptionDataList rejects the brc-20 bodies indexers would
+// ignore, see brc20.ParseOperation. It is meant to run before
+// PrepareBrc20CommitTx, inscriptions of other protocols pass.
+func ValidateBrc20InscriptionDataList(inscriptionDataList []InscriptionData) error {
+	for i := range inscriptionDataList {
+		if err := brc20.ValidateBody(inscriptionDataList[i].ContentType, inscriptionDataList[i].Body); err != nil {
+			return fmt.Errorf("inscription %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// checkBrc20Blessed rejects a brc-20 body the reveal would inscribe cursed,
+// indexers ignore it: past the first envelope of its input, with a pointer or
+// out of the first reveal input.
+func checkBrc20Blessed(data *InscriptionData, envelopeIndex int, firstInput bool) error {
+	if envelopeIndex == 0 && data.Pointer == 0 && firstInput {
+		return nil
+	}
+	if op, err := brc20.ParseOperation(data.ContentType, data.Body); err != nil || op == nil {
+		return err
+	}
+	return errors.New("brc-20 body would be cursed, only the first envelope of the first reveal input without pointer is indexed")
+}
+
 func PrepareBrc20CommitTx(network *chaincfg.Params, inscriptionDataList []InscriptionData, commitTxPrevOutputList []*PrevOutput,
 	revealOutValue int64, minChangeValue int64, revealFeeRate int64, changeAddress string, pubKey []byte) (*Brc20InscriptionParseResult, string, btcutil.Amount, error) {
 	tool := &InscriptionBuilder{
@@ -26,26 +55,38 @@ func PrepareBrc20CommitTx(network *chaincfg.Params, inscriptionDataList []Inscri
 		return parseResult, txHex, totalSenderAmount, err
 	}
 
-	if _, tx, totalSenderAmount, err = tool.ParseCommitTxPrevOutput(commitTxPrevOutputList); err != nil {
+	if tx, totalSenderAmount, err = tool.prepareCommitTx(parseResult, commitTxPrevOutputList, changeAddress); err != nil {
 		return parseResult, txHex, totalSenderAmount, err
 	}
 
-	inscriptionTxCtxDataList := make([]*InscriptionTxCtxData, len(inscriptionDataList))
-	for i := 0; i < len(inscriptionDataList); i++ {
-		inscriptionTxCtxData := &InscriptionTxCtxData{
-			InscriptionScript:       parseResult.CtxDataList[i].InscriptionScript,
-			CommitTxAddress:         parseResult.CtxDataList[i].CommitTxAddress,
-			CommitTxAddressPkScript: parseResult.CtxDataList[i].CommitTxOutPkScript,
-			ControlBlockWitness:     parseResult.CtxDataList[i].ControlBlockWitness,
-			RevealTxPrevOutput: &wire.TxOut{
-				PkScript: parseResult.CtxDataList[i].CommitTxOutPkScript,
-				Value:    parseResult.CtxDataList[i].CommitTxOutValue,
-			},
-		}
-		inscriptionTxCtxDataList[i] = inscriptionTxCtxData
+	if txHex, err = GetTxHex(tx); err != nil {
+		return parseResult, txHex, totalSenderAmount, err
 	}
 
-	if err = tool.FillCommitTxOutput(tx, inscriptionTxCtxDataList, changeAddress); err != nil {
+	return parseResult, txHex, totalSenderAmount, nil
+}
+
+// PrepareBrc20BatchCommitTx is PrepareBrc20CommitTx with the inscriptions
+// batched into as few reveals as possible, see PreProcessBatch. parent is
+// optional and reveals the inscriptions as its children, revealOptions is
+// optional too.
+func PrepareBrc20BatchCommitTx(network *chaincfg.Params, inscriptionDataList []InscriptionData, commitTxPrevOutputList []*PrevOutput, batchMode string, parent *ParentInscription, revealOptions *RevealOptions,
+	revealOutValue int64, minChangeValue int64, revealFeeRate int64, changeAddress string, pubKey []byte) (*Brc20InscriptionParseResult, string, btcutil.Amount, error) {
+	tool := &InscriptionBuilder{
+		Network: network,
+	}
+
+	var err error
+	var parseResult *Brc20InscriptionParseResult
+	var tx *wire.MsgTx
+	var txHex string
+	totalSenderAmount := btcutil.Amount(0)
+
+	if parseResult, err = tool.PreProcessBatch(network, inscriptionDataList, batchMode, parent, revealOptions, revealOutValue, minChangeValue, revealFeeRate, pubKey); err != nil {
+		return parseResult, txHex, totalSenderAmount, err
+	}
+
+	if tx, totalSenderAmount, err = tool.prepareCommitTx(parseResult, commitTxPrevOutputList, changeAddress); err != nil {
 		return parseResult, txHex, totalSenderAmount, err
 	}
 
@@ -56,6 +97,27 @@ func PrepareBrc20CommitTx(network *chaincfg.Params, inscriptionDataList []Inscri
 	return parseResult, txHex, totalSenderAmount, nil
 }
 
+func (builder *InscriptionBuilder) prepareCommitTx(parseResult *Brc20InscriptionParseResult, commitTxPrevOutputList []*PrevOutput, changeAddress string) (*wire.MsgTx, btcutil.Amount, error) {
+	if HasProtectedSats(commitTxPrevOutputList) {
+		return nil, 0, errors.New("commit inputs must not carry inscriptions")
+	}
+	_, tx, totalSenderAmount, err := builder.ParseCommitTxPrevOutput(commitTxPrevOutputList)
+	if err != nil {
+		return nil, totalSenderAmount, err
+	}
+
+	inscriptionTxCtxDataList, err := toInscriptionTxCtxDataList(parseResult.CtxDataList)
+	if err != nil {
+		return nil, totalSenderAmount, err
+	}
+
+	if err = builder.FillCommitTxOutput(tx, inscriptionTxCtxDataList, changeAddress); err != nil {
+		return nil, totalSenderAmount, err
+	}
+
+	return tx, totalSenderAmount, nil
+}
+
 func SignBrc20CommitTx(network *chaincfg.Params, txHex string, commitTxPrevOutputList []*PrevOutput, commitTxPrivateKeyListWif []string) (string, error) {
 	tool := &InscriptionBuilder{
 		Network: network,
@@ -135,7 +197,27 @@ func BuildBrc20CommitTx(network *chaincfg.Params, inscriptionDataList []Inscript
 		return nil, "", 0, err
 	}
 
+	return completeBrc20CommitTx(network, parseResult, txPreparedHex, totalSenderAmount, commitTxPrevOutputList, commitFeeRate, commitTxPrivateKeyListWif)
+}
+
+// BuildBrc20BatchCommitTx is BuildBrc20CommitTx for batch mode.
+func BuildBrc20BatchCommitTx(network *chaincfg.Params, inscriptionDataList []InscriptionData, commitTxPrevOutputList []*PrevOutput, batchMode string, parent *ParentInscription, revealOptions *RevealOptions,
+	revealOutValue int64, minChangeValue int64, commitFeeRate int64, revealFeeRate int64, changeAddress string,
+	pubKey []byte, commitTxPrivateKeyListWif []string) (*Brc20InscriptionParseResult, string, int64, error) {
+
+	parseResult, txPreparedHex, totalSenderAmount, err := PrepareBrc20BatchCommitTx(network, inscriptionDataList, commitTxPrevOutputList, batchMode, parent, revealOptions, revealOutValue, minChangeValue, revealFeeRate, changeAddress, pubKey)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return completeBrc20CommitTx(network, parseResult, txPreparedHex, totalSenderAmount, commitTxPrevOutputList, commitFeeRate, commitTxPrivateKeyListWif)
+}
+
+func completeBrc20CommitTx(network *chaincfg.Params, parseResult *Brc20InscriptionParseResult, txPreparedHex string, totalSenderAmount btcutil.Amount,
+	commitTxPrevOutputList []*PrevOutput, commitFeeRate int64, commitTxPrivateKeyListWif []string) (*Brc20InscriptionParseResult, string, int64, error) {
+
 	// 2.pre sign commit tx
+	var err error
 	var txForEstimateHex string
 	txForEstimateHex, err = SignBrc20CommitTx(network, txPreparedHex, commitTxPrevOutputList, commitTxPrivateKeyListWif)
 	if err != nil {
@@ -158,26 +240,15 @@ func BuildBrc20RevealTx(network *chaincfg.Params, commitTxHash chainhash.Hash, c
 		Network: network,
 	}
 
-	inscriptionTxCtxDataList := make([]*InscriptionTxCtxData, len(ctxDataList))
-	for i := 0; i < len(ctxDataList); i++ {
-
-		inscriptionTxCtxData := &InscriptionTxCtxData{
-			InscriptionScript:       ctxDataList[i].InscriptionScript,
-			CommitTxAddress:         ctxDataList[i].CommitTxAddress,
-			CommitTxAddressPkScript: ctxDataList[i].CommitTxOutPkScript,
-			ControlBlockWitness:     ctxDataList[i].ControlBlockWitness,
-			RevealTxPrevOutput: &wire.TxOut{
-				PkScript: ctxDataList[i].CommitTxOutPkScript,
-				Value:    ctxDataList[i].CommitTxOutValue,
-			},
-		}
-		inscriptionTxCtxDataList[i] = inscriptionTxCtxData
-	}
-
 	var witnessList [][]byte
 	var revealTxFees []int64
 	reavealTxsHex := make([]string, 0)
 
+	inscriptionTxCtxDataList, err := toInscriptionTxCtxDataList(ctxDataList)
+	if err != nil {
+		return reavealTxsHex, witnessList, revealTxFees, err
+	}
+
 	revealTxs, _, err := tool.BuildEmptyRevealTx(revealAddrs, inscriptionTxCtxDataList, revealOutValue, revealFeeRate)
 	if err != nil {
 		return reavealTxsHex, witnessList, revealTxFees, err
@@ -224,21 +295,12 @@ func SignBrc20RevealTx(network *chaincfg.Params, revealTxsHex []string, witnessL
 		revealTxs[i] = tx
 	}
 
-	inscriptionTxCtxDataList := make([]*InscriptionTxCtxData, len(ctxDataList))
-	for i := 0; i < len(ctxDataList); i++ {
-
-		inscriptionTxCtxData := &InscriptionTxCtxData{
-			PrivateKey:              privateKeyWif.PrivKey,
-			InscriptionScript:       ctxDataList[i].InscriptionScript,
-			CommitTxAddress:         ctxDataList[i].CommitTxAddress,
-			CommitTxAddressPkScript: ctxDataList[i].CommitTxOutPkScript,
-			ControlBlockWitness:     ctxDataList[i].ControlBlockWitness,
-			RevealTxPrevOutput: &wire.TxOut{
-				PkScript: ctxDataList[i].CommitTxOutPkScript,
-				Value:    ctxDataList[i].CommitTxOutValue,
-			},
-		}
-		inscriptionTxCtxDataList[i] = inscriptionTxCtxData
+	inscriptionTxCtxDataList, err := toInscriptionTxCtxDataList(ctxDataList)
+	if err != nil {
+		return signedRevealTxsHex, err
+	}
+	for i := range inscriptionTxCtxDataList {
+		inscriptionTxCtxDataList[i].PrivateKey = privateKeyWif.PrivKey
 	}
 
 	if err = tool.SignRevealTx(revealTxs, witnessList, inscriptionTxCtxDataList); err != nil {
@@ -272,20 +334,9 @@ func SignBrc20RevealTx2(network *chaincfg.Params, revealTxsHex []string, signatu
 		revealTxs[i] = tx
 	}
 
-	inscriptionTxCtxDataList := make([]*InscriptionTxCtxData, len(ctxDataList))
-	for i := 0; i < len(ctxDataList); i++ {
-
-		inscriptionTxCtxData := &InscriptionTxCtxData{
-			InscriptionScript:       ctxDataList[i].InscriptionScript,
-			CommitTxAddress:         ctxDataList[i].CommitTxAddress,
-			CommitTxAddressPkScript: ctxDataList[i].CommitTxOutPkScript,
-			ControlBlockWitness:     ctxDataList[i].ControlBlockWitness,
-			RevealTxPrevOutput: &wire.TxOut{
-				PkScript: ctxDataList[i].CommitTxOutPkScript,
-				Value:    ctxDataList[i].CommitTxOutValue,
-			},
-		}
-		inscriptionTxCtxDataList[i] = inscriptionTxCtxData
+	inscriptionTxCtxDataList, err := toInscriptionTxCtxDataList(ctxDataList)
+	if err != nil {
+		return signedRevealTxsHex, err
 	}
 
 	if err := tool.SignRevealTx2(revealTxs, signature, inscriptionTxCtxDataList); err != nil {
@@ -0,0 +1,278 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Brc20CommitPSBT is the commit tx of PrepareBrc20CommitTx or
+// BuildBrc20CommitTx as a v0 psbt, for signers only taking psbts. Inputs
+// get the utxo of their prev output, outputs and inputs the derivations
+// of the keys owning them. A P2PKH prev output needs its NonWitnessUtxo.
+// The tx of PrepareBrc20CommitTx, whose change is zero until
+// AdjustBrc20CommitTx, is refused as any tx with a dust output.
+func Brc20CommitPSBT(network *chaincfg.Params, txHex string, commitTxPrevOutputList []*PrevOutput, derivations []*PSBTBip32Derivation) (*EncodedPSBT, error) {
+	tx, err := newUnsignedTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkCommitTxDust(network, tx); err != nil {
+		return nil, err
+	}
+	if len(commitTxPrevOutputList) != len(tx.TxIn) {
+		return nil, errors.New("commit tx prev outputs do not match its inputs")
+	}
+	p, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	for i, prevOutput := range commitTxPrevOutputList {
+		outPoint := tx.TxIn[i].PreviousOutPoint
+		if prevOutput == nil || outPoint.Hash.String() != prevOutput.TxId || outPoint.Index != prevOutput.VOut {
+			return nil, fmt.Errorf("input %d: prev output is not %s", i, outPoint)
+		}
+		pkScript, err := AddrToPkScript(prevOutput.Address, network)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		update := &PSBTInputUpdate{
+			Index:          i,
+			Address:        prevOutput.Address,
+			Amount:         prevOutput.Amount,
+			NonWitnessUtxo: prevOutput.NonWitnessUtxo,
+		}
+		if update.Bip32Derivation, err = ownedDerivations(pkScript, nil, derivations); err != nil {
+			return nil, err
+		}
+		if err = updatePSBTInput(network, updater, update); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	if err = updateOwnedPSBTOutputs(updater, derivations); err != nil {
+		return nil, err
+	}
+	if err = checkPSBTUtxos(p); err != nil {
+		return nil, err
+	}
+	return EncodePSBT(p)
+}
+
+// Brc20RevealPSBTs are the reveal txs of BuildBrc20RevealTx or
+// BuildBrc20ChildRevealTx as v0 psbts, one per ctx data. The commit input
+// carries the inscription leaf and its control block, so that a BIP371
+// signer signs it by script path, the parent and funding inputs their
+// utxo. Derivations are added where their key owns the input or output.
+func Brc20RevealPSBTs(network *chaincfg.Params, revealTxsHex []string, ctxDataList []*Brc20CtxData, derivations []*PSBTBip32Derivation) ([]*EncodedPSBT, error) {
+	if len(revealTxsHex) != len(ctxDataList) {
+		return nil, errors.New("reveal txs do not match the ctx data list")
+	}
+	psbts := make([]*EncodedPSBT, len(revealTxsHex))
+	for i := range revealTxsHex {
+		encoded, err := brc20RevealPSBT(network, revealTxsHex[i], ctxDataList[i], derivations)
+		if err != nil {
+			return nil, fmt.Errorf("reveal %d: %w", i, err)
+		}
+		psbts[i] = encoded
+	}
+	return psbts, nil
+}
+
+func brc20RevealPSBT(network *chaincfg.Params, txHex string, data *Brc20CtxData, derivations []*PSBTBip32Derivation) (*EncodedPSBT, error) {
+	if data == nil {
+		return nil, errors.New("missing ctx data")
+	}
+	tx, err := newUnsignedTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	ctxData, err := data.inscriptionTxCtxData()
+	if err != nil {
+		return nil, err
+	}
+	p, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	commitIndex := revealCommitInputIndex(ctxData)
+	for i, in := range tx.TxIn {
+		update := &PSBTInputUpdate{Index: i}
+		var prevOut *wire.TxOut
+		var leafScript []byte
+		if i == commitIndex {
+			prevOut, leafScript = ctxData.RevealTxPrevOutput, ctxData.InscriptionScript
+			update.TaprootLeafScript = hex.EncodeToString(ctxData.InscriptionScript)
+			update.TaprootControlBlock = hex.EncodeToString(ctxData.ControlBlockWitness)
+		} else if ctxData.ParentOutPoint != nil && in.PreviousOutPoint == *ctxData.ParentOutPoint {
+			prevOut = ctxData.ParentPrevOutput
+		} else if ctxData.RevealFundingOutPoint != nil && in.PreviousOutPoint == *ctxData.RevealFundingOutPoint {
+			prevOut = ctxData.RevealFundingPrevOutput
+		} else {
+			return nil, fmt.Errorf("input %d is not in the ctx data", i)
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(prevOut.PkScript, network)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		if len(addrs) != 1 {
+			return nil, fmt.Errorf("input %d: unsupported prev output script", i)
+		}
+		update.Address, update.Amount = addrs[0].EncodeAddress(), prevOut.Value
+		if update.Bip32Derivation, err = ownedDerivations(prevOut.PkScript, leafScript, derivations); err != nil {
+			return nil, err
+		}
+		if err = updatePSBTInput(network, updater, update); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	if err = updateOwnedPSBTOutputs(updater, derivations); err != nil {
+		return nil, err
+	}
+	if err = checkPSBTUtxos(p); err != nil {
+		return nil, err
+	}
+	return EncodePSBT(p)
+}
+
+// ImportBrc20CommitPSBT finalizes the signed commit psbt into the hex tx
+// SignBrc20CommitTx would have built, refusing dust outputs as
+// Brc20CommitPSBT does.
+func ImportBrc20CommitPSBT(network *chaincfg.Params, encoded string) (string, error) {
+	p, err := DecodePSBT(encoded)
+	if err != nil {
+		return "", err
+	}
+	if err = checkCommitTxDust(network, p.UnsignedTx); err != nil {
+		return "", err
+	}
+	return extractSignedPSBT(encoded)
+}
+
+// checkCommitTxDust refuses the outputs of tx below the dust limit, but
+// for OP_RETURN ones.
+func checkCommitTxDust(network *chaincfg.Params, tx *wire.MsgTx) error {
+	for i, out := range tx.TxOut {
+		if len(out.PkScript) > 0 && out.PkScript[0] == txscript.OP_RETURN {
+			continue
+		}
+		if out.Value < GetDustLimit(network) {
+			return fmt.Errorf("output %d: value %d below the dust limit, adjust the commit tx first", i, out.Value)
+		}
+	}
+	return nil
+}
+
+// ImportBrc20RevealPSBTs finalizes the signed reveal psbts into the hex
+// txs, checked as CheckBrc20RevealTx does.
+func ImportBrc20RevealPSBTs(psbts []string) ([]string, error) {
+	revealTxsHex := make([]string, len(psbts))
+	for i, encoded := range psbts {
+		txHex, err := extractSignedPSBT(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("reveal %d: %w", i, err)
+		}
+		revealTxsHex[i] = txHex
+	}
+	if err := CheckBrc20RevealTx(revealTxsHex); err != nil {
+		return nil, err
+	}
+	return revealTxsHex, nil
+}
+
+func extractSignedPSBT(encoded string) (string, error) {
+	finalized, err := FinalizePSBT(encoded)
+	if err != nil {
+		return "", err
+	}
+	if !finalized.Complete {
+		return "", errors.New("psbt is missing signatures")
+	}
+	return ExtractPSBT(finalized.Psbt)
+}
+
+// newUnsignedTxFromHex decodes a tx dropping the signatures, the commit tx
+// of BuildBrc20CommitTx being signed with a placeholder key.
+func newUnsignedTxFromHex(txHex string) (*wire.MsgTx, error) {
+	tx, err := NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range tx.TxIn {
+		in.SignatureScript, in.Witness = nil, nil
+	}
+	return tx, nil
+}
+
+// ownedDerivations are the derivations whose key owns pkScript, or is one
+// of the keys of leafScript.
+func ownedDerivations(pkScript []byte, leafScript []byte, derivations []*PSBTBip32Derivation) ([]*PSBTBip32Derivation, error) {
+	var owned []*PSBTBip32Derivation
+	for _, derivation := range derivations {
+		pubKey, _, err := parsePSBTBip32Derivation(derivation)
+		if err != nil {
+			return nil, err
+		}
+		if pubKeyOwnsPkScript(pubKey, pkScript) || len(leafScript) > 0 && tapscriptHasKey(leafScript, pubKey[1:]) {
+			owned = append(owned, derivation)
+		}
+	}
+	return owned, nil
+}
+
+// pubKeyOwnsPkScript tells if pkScript is one of the single key scripts
+// of pubKey: P2PKH, P2WPKH, P2SH-P2WPKH or BIP86 taproot.
+func pubKeyOwnsPkScript(pubKey []byte, pkScript []byte) bool {
+	pubKeyHash := btcutil.Hash160(pubKey)
+	switch {
+	case txscript.IsPayToPubKeyHash(pkScript):
+		return bytes.Equal(pkScript[3:23], pubKeyHash)
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		return bytes.Equal(pkScript[2:22], pubKeyHash)
+	case txscript.IsPayToScriptHash(pkScript):
+		redeemScript, err := PayToWitnessPubKeyHashScript(pubKeyHash)
+		return err == nil && bytes.Equal(pkScript[2:22], btcutil.Hash160(redeemScript))
+	case txscript.IsPayToTaproot(pkScript):
+		return checkTaprootOutputKey(pkScript, pubKey[1:], nil) == nil
+	}
+	return false
+}
+
+func updateOwnedPSBTOutputs(updater *psbt.Updater, derivations []*PSBTBip32Derivation) error {
+	for i, out := range updater.Upsbt.UnsignedTx.TxOut {
+		owned, err := ownedDerivations(out.PkScript, nil, derivations)
+		if err != nil {
+			return err
+		}
+		if len(owned) == 0 {
+			continue
+		}
+		if err = updatePSBTOutput(updater, &PSBTOutputUpdate{Index: i, Bip32Derivation: owned}); err != nil {
+			return fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func checkPSBTUtxos(p *psbt.Packet) error {
+	for i := range p.Inputs {
+		if p.Inputs[i].WitnessUtxo == nil && p.Inputs[i].NonWitnessUtxo == nil {
+			return fmt.Errorf("input %d: missing utxo, a legacy input needs its nonWitnessUtxo", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrc20CommitRevealPSBT(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	wif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	pubKey := wif.PrivKey.PubKey().SerializeCompressed()
+	derivations := []*PSBTBip32Derivation{{
+		PublicKey:         hex.EncodeToString(pubKey),
+		MasterFingerprint: 0x12345678,
+		DerivationPath:    "m/86'/1'/0'/0/0",
+	}}
+
+	inscriptionDataList := []InscriptionData{{
+		ContentType: "text/plain;charset=utf-8",
+		Body:        []byte(`{"p":"brc-20","op":"mint","tick":"xcvb","amt":"100"}`),
+		RevealAddr:  "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+	}, {
+		ContentType: "text/plain;charset=utf-8",
+		Body:        []byte(`{"p":"brc-20","op":"mint","tick":"xcvb","amt":"10"}`),
+		RevealAddr:  "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}}
+
+	// the P2PKH input needs the tx it spends
+	legacyPkScript, err := AddrToPkScript("mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", network)
+	require.Nil(t, err)
+	legacyPrevTx := wire.NewMsgTx(DefaultTxVersion)
+	legacyPrevTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	legacyPrevTx.AddTxOut(wire.NewTxOut(546, legacyPkScript))
+	legacyPrevTxHex, err := GetTxHex(legacyPrevTx)
+	require.Nil(t, err)
+
+	commitTxPrevOutputList := []*PrevOutput{{
+		TxId:    "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+		VOut:    0,
+		Amount:  546,
+		Address: "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc",
+	}, {
+		TxId:    "22c8a4869f2aa9ee5994959c0978106130290cda53f6e933a8dda2dcb82508d4",
+		VOut:    0,
+		Amount:  546,
+		Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}, {
+		TxId:           legacyPrevTx.TxHash().String(),
+		VOut:           0,
+		Amount:         546,
+		Address:        "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE",
+		NonWitnessUtxo: legacyPrevTxHex,
+	}, {
+		TxId:    "aa09fa48dda0e2b7de1843c3db8d3f2d7f2cbe0f83331a125b06516a348abd26",
+		VOut:    4,
+		Amount:  1142196,
+		Address: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+	}}
+	placeholderKeys := []string{privateKey, privateKey, privateKey, privateKey}
+	changeAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+
+	// the prepared commit tx has a zero change until adjusted
+	_, preparedTxHex, _, err := PrepareBrc20CommitTx(network, inscriptionDataList, commitTxPrevOutputList, 546, 546, 2, changeAddress, pubKey)
+	require.Nil(t, err)
+	_, err = Brc20CommitPSBT(network, preparedTxHex, commitTxPrevOutputList, derivations)
+	assert.NotNil(t, err)
+	preparedTx, err := newUnsignedTxFromHex(preparedTxHex)
+	require.Nil(t, err)
+	preparedPSBT, err := psbt.NewFromUnsignedTx(preparedTx)
+	require.Nil(t, err)
+	encodedPrepared, err := EncodePSBT(preparedPSBT)
+	require.Nil(t, err)
+	_, err = ImportBrc20CommitPSBT(network, encodedPrepared.Psbt)
+	assert.ErrorContains(t, err, "dust")
+
+	parseResult, commitTxHex, _, err := BuildBrc20CommitTx(network, inscriptionDataList, commitTxPrevOutputList, 546, 546, 2, 2, changeAddress, pubKey, placeholderKeys)
+	require.Nil(t, err)
+
+	// commit
+	commitPSBT, err := Brc20CommitPSBT(network, commitTxHex, commitTxPrevOutputList, derivations)
+	require.Nil(t, err)
+	p, err := DecodePSBT(commitPSBT.Psbt)
+	require.Nil(t, err)
+	assert.NotNil(t, p.Inputs[0].WitnessUtxo)
+	assert.NotNil(t, p.Inputs[0].RedeemScript)
+	assert.Len(t, p.Inputs[0].Bip32Derivation, 1)
+	assert.NotNil(t, p.Inputs[1].WitnessUtxo)
+	assert.Len(t, p.Inputs[1].Bip32Derivation, 1)
+	assert.Nil(t, p.Inputs[2].WitnessUtxo)
+	assert.NotNil(t, p.Inputs[2].NonWitnessUtxo)
+	assert.Equal(t, pubKey[1:], p.Inputs[3].TaprootInternalKey)
+	assert.Len(t, p.Inputs[3].TaprootBip32Derivation, 1)
+	changeOutput := p.Outputs[len(p.Outputs)-1]
+	assert.Equal(t, pubKey[1:], changeOutput.TaprootInternalKey)
+	for _, output := range p.Outputs[:len(p.Outputs)-1] {
+		assert.Empty(t, output.TaprootBip32Derivation)
+	}
+
+	signedCommit, err := SignPSBT(&SignPSBTRequest{Psbt: commitPSBT.Psbt, PrivateKeys: []string{privateKey}})
+	require.Nil(t, err)
+	signedCommitTxHex, err := ImportBrc20CommitPSBT(network, signedCommit.Psbt)
+	require.Nil(t, err)
+	commitTx, err := NewTxFromHex(signedCommitTxHex)
+	require.Nil(t, err)
+	unsignedCommitTx, err := NewTxFromHex(commitTxHex)
+	require.Nil(t, err)
+	assert.Equal(t, unsignedCommitTx.TxOut, commitTx.TxOut)
+	builder := &InscriptionBuilder{Network: network}
+	commitPrevOutFetcher, _, _, err := builder.ParseCommitTxPrevOutput(commitTxPrevOutputList)
+	require.Nil(t, err)
+	verifyRevealTx(t, commitTx, commitPrevOutFetcher)
+
+	// reveal
+	revealAddrs := []string{inscriptionDataList[0].RevealAddr, inscriptionDataList[1].RevealAddr}
+	revealTxsHex, _, _, err := BuildBrc20RevealTx(network, commitTx.TxHash(), parseResult.CtxDataList, revealAddrs, 2, parseResult.RevealOutValue)
+	require.Nil(t, err)
+	revealPSBTs, err := Brc20RevealPSBTs(network, revealTxsHex, parseResult.CtxDataList, derivations)
+	require.Nil(t, err)
+	require.Len(t, revealPSBTs, len(revealTxsHex))
+
+	signedRevealPSBTs := make([]string, len(revealPSBTs))
+	for i, revealPSBT := range revealPSBTs {
+		p, err = DecodePSBT(revealPSBT.Psbt)
+		require.Nil(t, err)
+		in := p.Inputs[0]
+		require.Len(t, in.TaprootLeafScript, 1)
+		assert.Equal(t, parseResult.CtxDataList[i].InscriptionScript, in.TaprootLeafScript[0].Script)
+		assert.Equal(t, parseResult.CtxDataList[i].ControlBlockWitness, in.TaprootLeafScript[0].ControlBlock)
+		assert.Equal(t, parseResult.CtxDataList[i].CommitTxOutValue, in.WitnessUtxo.Value)
+		require.Len(t, in.TaprootBip32Derivation, 1)
+		assert.Len(t, in.TaprootBip32Derivation[0].LeafHashes, 1)
+
+		signed, err := SignPSBT(&SignPSBTRequest{Psbt: revealPSBT.Psbt, PrivateKeys: []string{privateKey}})
+		require.Nil(t, err)
+		signedRevealPSBTs[i] = signed.Psbt
+	}
+	signedRevealTxsHex, err := ImportBrc20RevealPSBTs(signedRevealPSBTs)
+	require.Nil(t, err)
+	for i, txHex := range signedRevealTxsHex {
+		tx, err := NewTxFromHex(txHex)
+		require.Nil(t, err)
+		unsignedTx, err := NewTxFromHex(revealTxsHex[i])
+		require.Nil(t, err)
+		assert.Equal(t, unsignedTx.TxOut, tx.TxOut)
+		assert.Len(t, tx.TxIn[0].Witness, 3)
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(tx.TxIn[0].PreviousOutPoint, commitTx.TxOut[tx.TxIn[0].PreviousOutPoint.Index])
+		verifyRevealTx(t, tx, prevOutFetcher)
+	}
+
+	// an unsigned psbt is not imported
+	_, err = ImportBrc20RevealPSBTs([]string{revealPSBTs[0].Psbt})
+	assert.NotNil(t, err)
+}
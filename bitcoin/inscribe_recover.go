@@ -0,0 +1,306 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// RecoverCommitOutput is a commit output whose reveal was never broadcast,
+// CtxData is the one returned when the commit tx was built.
+type RecoverCommitOutput struct {
+	TxId    string        `json:"txId"`
+	VOut    uint32        `json:"vOut"`
+	CtxData *Brc20CtxData `json:"ctxData"`
+}
+
+type RecoverCommitTx struct {
+	TxHex string `json:"txHex"`
+	Fee   int64  `json:"fee"`
+	// MessageHashMap is keyed by input index.
+	MessageHashMap map[int]string `json:"messageHashMap"`
+	// MerkleRootMap is the tapscript root a key path signer tweaks its key
+	// with (BIP341 taproot_tweak_seckey), keyed by input index. It is empty
+	// for script path spends, which are signed with the untweaked key.
+	MerkleRootMap map[int]string `json:"merkleRootMap"`
+}
+
+type recoverInput struct {
+	prevOutput *wire.TxOut
+	merkleRoot []byte
+	scriptPath bool
+	// tapLeaf and controlBlock are the leaf of the commit tree spent by the
+	// script path: the recovery leaf, which only checks the key signature,
+	// or the inscription leaf of a commit tree without one.
+	tapLeaf      txscript.TapLeaf
+	controlBlock []byte
+}
+
+func newRecoverInput(ctxData *Brc20CtxData, scriptPath bool) (*recoverInput, error) {
+	controlBlock, err := txscript.ParseControlBlock(ctxData.ControlBlockWitness)
+	if err != nil {
+		return nil, err
+	}
+	merkleRoot := controlBlock.RootHash(ctxData.InscriptionScript)
+	outputKey := txscript.ComputeTaprootOutputKey(controlBlock.InternalKey, merkleRoot)
+	expectedPkScript, err := txscript.PayToTaprootScript(outputKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(expectedPkScript, ctxData.CommitTxOutPkScript) {
+		return nil, errors.New("ctx data does not match the commit address")
+	}
+
+	// the key path is only usable when the leaf key is also the internal
+	// key, i.e. the same signer holds both
+	leafKey := ctxData.InscriptionScript
+	if len(leafKey) < 33 || leafKey[0] != txscript.OP_DATA_32 ||
+		!bytes.Equal(leafKey[1:33], schnorr.SerializePubKey(controlBlock.InternalKey)) {
+		scriptPath = true
+	}
+	input := &recoverInput{
+		prevOutput: wire.NewTxOut(ctxData.CommitTxOutValue, ctxData.CommitTxOutPkScript),
+		merkleRoot: merkleRoot,
+		scriptPath: scriptPath,
+	}
+	if !scriptPath {
+		return input, nil
+	}
+
+	// a commit tree holding the inscription leaf only is swept through it,
+	// the sweep then inscribes at its first output like the reveal would
+	if len(controlBlock.InclusionProof) == 0 {
+		input.tapLeaf = txscript.NewBaseTapLeaf(ctxData.InscriptionScript)
+		input.controlBlock = ctxData.ControlBlockWitness
+		return input, nil
+	}
+
+	// the recovery leaf is the sibling of the inscription one
+	if input.tapLeaf, err = newRecoveryTapLeaf(schnorr.SerializePubKey(controlBlock.InternalKey)); err != nil {
+		return nil, err
+	}
+	inscriptionLeafHash := txscript.NewBaseTapLeaf(ctxData.InscriptionScript).TapHash()
+	recoveryControlBlock := txscript.ControlBlock{
+		InternalKey:     controlBlock.InternalKey,
+		OutputKeyYIsOdd: controlBlock.OutputKeyYIsOdd,
+		LeafVersion:     txscript.BaseLeafVersion,
+		InclusionProof:  inscriptionLeafHash[:],
+	}
+	if len(controlBlock.InclusionProof) != chainhash.HashSize || !bytes.Equal(recoveryControlBlock.RootHash(input.tapLeaf.Script), merkleRoot) {
+		return nil, errors.New("commit output has no recovery leaf")
+	}
+	if input.controlBlock, err = recoveryControlBlock.ToBytes(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+// newRecoverCommitTx builds the unsigned sweep of outputs to recoverAddress.
+//
+// The key path spends like any other taproot output once the key is tweaked
+// with the commit tree root, it is used unless scriptPath is set or the
+// internal key is not the leaf key. The script path is for signers that
+// can't tweak: it spends the recovery leaf, so the sweep carries no
+// inscription, or the inscription leaf when the commit tree has no recovery
+// leaf, so the sweep inscribes to recoverAddress.
+func newRecoverCommitTx(network *chaincfg.Params, outputs []*RecoverCommitOutput, recoverAddress string, feeRate int64, scriptPath bool) (*wire.MsgTx, *txscript.MultiPrevOutFetcher, []*recoverInput, error) {
+	if len(outputs) == 0 {
+		return nil, nil, nil, errors.New("no commit output to recover")
+	}
+	pkScript, err := AddrToPkScript(recoverAddress, network)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	tx := wire.NewMsgTx(DefaultTxVersion)
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	inputs := make([]*recoverInput, len(outputs))
+	totalValue := int64(0)
+	for i, output := range outputs {
+		if output.CtxData == nil {
+			return nil, nil, nil, fmt.Errorf("commit output %d: missing ctx data", i)
+		}
+		txHash, err := chainhash.NewHashFromStr(output.TxId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		input, err := newRecoverInput(output.CtxData, scriptPath)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("commit output %d: %w", i, err)
+		}
+
+		outPoint := wire.NewOutPoint(txHash, output.VOut)
+		prevOutFetcher.AddPrevOut(*outPoint, input.prevOutput)
+		in := wire.NewTxIn(outPoint, nil, nil)
+		in.Sequence = DefaultSequenceNum
+		if input.scriptPath {
+			in.Witness = wire.TxWitness{make([]byte, 64), input.tapLeaf.Script, input.controlBlock}
+		} else {
+			in.Witness = wire.TxWitness{make([]byte, 64)}
+		}
+		tx.AddTxIn(in)
+
+		inputs[i] = input
+		totalValue += input.prevOutput.Value
+	}
+	tx.AddTxOut(wire.NewTxOut(0, pkScript))
+
+	fee := GetTxVirtualSize(btcutil.NewTx(tx)) * feeRate
+	for _, in := range tx.TxIn {
+		in.Witness = nil
+	}
+	if totalValue-fee < GetDustLimit(network) {
+		return nil, nil, nil, errors.New("insufficient balance")
+	}
+	tx.TxOut[0].Value = totalValue - fee
+
+	return tx, prevOutFetcher, inputs, nil
+}
+
+// BuildRecoverCommitTx builds the sweep of abandoned commit outputs and the
+// message hashes to sign externally, see newRecoverCommitTx for the paths.
+func BuildRecoverCommitTx(network *chaincfg.Params, outputs []*RecoverCommitOutput, recoverAddress string, feeRate int64, scriptPath bool) (*RecoverCommitTx, error) {
+	tx, prevOutFetcher, inputs, err := newRecoverCommitTx(network, outputs, recoverAddress, feeRate, scriptPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RecoverCommitTx{
+		Fee:            CalculateCommitTxFee(tx, prevOutFetcher),
+		MessageHashMap: make(map[int]string),
+		MerkleRootMap:  make(map[int]string),
+	}
+	txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	for i, input := range inputs {
+		var sigHash []byte
+		if input.scriptPath {
+			sigHash, err = txscript.CalcTapscriptSignaturehash(txSigHashes, txscript.SigHashDefault, tx, i, prevOutFetcher, input.tapLeaf)
+		} else {
+			sigHash, err = txscript.CalcTaprootSignatureHashRaw(txSigHashes, txscript.SigHashDefault, tx, i, prevOutFetcher)
+			result.MerkleRootMap[i] = hex.EncodeToString(input.merkleRoot)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.MessageHashMap[i] = hexutil.Encode(sigHash)
+	}
+	if result.TxHex, err = GetTxHex(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SignRecoverCommitTx sweeps abandoned commit outputs with the private key the
+// commit tx was built for.
+func SignRecoverCommitTx(network *chaincfg.Params, outputs []*RecoverCommitOutput, recoverAddress string, feeRate int64, scriptPath bool, privateKeyWif string) (string, int64, error) {
+	wif, err := btcutil.DecodeWIF(privateKeyWif)
+	if err != nil {
+		return "", 0, err
+	}
+
+	tx, prevOutFetcher, inputs, err := newRecoverCommitTx(network, outputs, recoverAddress, feeRate, scriptPath)
+	if err != nil {
+		return "", 0, err
+	}
+
+	txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	for i, input := range inputs {
+		if err = signRecoverInput(tx, i, txSigHashes, input, wif.PrivKey); err != nil {
+			return "", 0, err
+		}
+	}
+
+	txHex, err := GetTxHex(tx)
+	if err != nil {
+		return "", 0, err
+	}
+	return txHex, CalculateCommitTxFee(tx, prevOutFetcher), nil
+}
+
+func signRecoverInput(tx *wire.MsgTx, i int, txSigHashes *txscript.TxSigHashes, input *recoverInput, privateKey *btcec.PrivateKey) error {
+	if input.scriptPath {
+		signature, err := txscript.RawTxInTapscriptSignature(tx, txSigHashes, i, input.prevOutput.Value, input.prevOutput.PkScript, input.tapLeaf, txscript.SigHashDefault, privateKey)
+		if err != nil {
+			return err
+		}
+		tx.TxIn[i].Witness = wire.TxWitness{signature, input.tapLeaf.Script, input.controlBlock}
+		return nil
+	}
+	signature, err := txscript.RawTxInTaprootSignature(tx, txSigHashes, i, input.prevOutput.Value, input.prevOutput.PkScript, input.merkleRoot, txscript.SigHashDefault, privateKey)
+	if err != nil {
+		return err
+	}
+	tx.TxIn[i].Witness = wire.TxWitness{signature}
+	return nil
+}
+
+// BuildRecoverCommitRawData injects the signatures of the message hashes
+// returned by BuildRecoverCommitTx, keyed by input index.
+func BuildRecoverCommitRawData(network *chaincfg.Params, txHex string, outputs []*RecoverCommitOutput, signatureMap map[int]string, scriptPath bool) (string, error) {
+	tx, err := NewTxFromHex(txHex)
+	if err != nil {
+		return "", err
+	}
+	if len(tx.TxIn) != len(outputs) {
+		return "", errors.New("recover tx does not match the commit outputs")
+	}
+
+	inputs := make([]*recoverInput, len(outputs))
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, output := range outputs {
+		if output.CtxData == nil {
+			return "", fmt.Errorf("commit output %d: missing ctx data", i)
+		}
+		if inputs[i], err = newRecoverInput(output.CtxData, scriptPath); err != nil {
+			return "", fmt.Errorf("commit output %d: %w", i, err)
+		}
+		prevOutFetcher.AddPrevOut(tx.TxIn[i].PreviousOutPoint, inputs[i].prevOutput)
+	}
+	txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+
+	for i, input := range inputs {
+		signature, err := hex.DecodeString(signatureMap[i])
+		if err != nil {
+			return "", err
+		}
+		sig, err := schnorr.ParseSignature(signature)
+		if err != nil {
+			return "", fmt.Errorf("input %d: %w", i, err)
+		}
+
+		// catch signatures made for the other path before broadcasting
+		var sigHash []byte
+		var pubKey *btcec.PublicKey
+		if input.scriptPath {
+			if pubKey, err = schnorr.ParsePubKey(input.tapLeaf.Script[1:33]); err != nil {
+				return "", err
+			}
+			sigHash, err = txscript.CalcTapscriptSignaturehash(txSigHashes, txscript.SigHashDefault, tx, i, prevOutFetcher, input.tapLeaf)
+			tx.TxIn[i].Witness = wire.TxWitness{signature, input.tapLeaf.Script, input.controlBlock}
+		} else {
+			if pubKey, err = schnorr.ParsePubKey(input.prevOutput.PkScript[2:]); err != nil {
+				return "", err
+			}
+			sigHash, err = txscript.CalcTaprootSignatureHashRaw(txSigHashes, txscript.SigHashDefault, tx, i, prevOutFetcher)
+			tx.TxIn[i].Witness = wire.TxWitness{signature}
+		}
+		if err != nil {
+			return "", err
+		}
+		if !sig.Verify(sigHash, pubKey) {
+			return "", fmt.Errorf("input %d: invalid signature", i)
+		}
+	}
+
+	return GetTxHex(tx)
+}
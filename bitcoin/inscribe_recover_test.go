@@ -0,0 +1,116 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverCommit(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	address := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	pubKey := privateKeyWif.PrivKey.PubKey().SerializeCompressed()
+
+	builder := &InscriptionBuilder{Network: network}
+	parseResult, err := builder.PreProcess(network, []InscriptionData{
+		{ContentType: "text/plain", Body: []byte("abandoned 1"), RevealAddr: address, RecoveryLeaf: true},
+		{ContentType: "text/plain", Body: []byte("abandoned 2"), RevealAddr: address, RecoveryLeaf: true},
+	}, 0, 0, 2, pubKey)
+	require.Nil(t, err)
+	require.Len(t, parseResult.CtxDataList, 2)
+
+	commitTxHash := chainhash.HashH([]byte("commit"))
+	outputs := make([]*RecoverCommitOutput, len(parseResult.CtxDataList))
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, ctxData := range parseResult.CtxDataList {
+		outputs[i] = &RecoverCommitOutput{TxId: commitTxHash.String(), VOut: uint32(i), CtxData: ctxData}
+		prevOutFetcher.AddPrevOut(*wire.NewOutPoint(&commitTxHash, uint32(i)), wire.NewTxOut(ctxData.CommitTxOutValue, ctxData.CommitTxOutPkScript))
+	}
+
+	for _, scriptPath := range []bool{false, true} {
+		txHex, fee, err := SignRecoverCommitTx(network, outputs, address, 2, scriptPath, privateKey)
+		require.Nil(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.Nil(t, err)
+		require.Len(t, tx.TxIn, 2)
+		require.Len(t, tx.TxOut, 1)
+		verifyRevealTx(t, tx, prevOutFetcher)
+		assert.Equal(t, parseResult.CtxDataList[0].CommitTxOutValue+parseResult.CtxDataList[1].CommitTxOutValue-fee, tx.TxOut[0].Value)
+
+		// the script path spends the recovery leaf, not the envelope
+		inscriptions, err := ParseInscriptions(tx, nil)
+		require.Nil(t, err)
+		assert.Len(t, inscriptions, 0)
+
+		recoverTx, err := BuildRecoverCommitTx(network, outputs, address, 2, scriptPath)
+		require.Nil(t, err)
+		assert.Equal(t, fee, recoverTx.Fee)
+		signatureMap := make(map[int]string)
+		for i, messageHash := range recoverTx.MessageHashMap {
+			hash, err := hexutil.Decode(messageHash)
+			require.Nil(t, err)
+			key := privateKeyWif.PrivKey
+			if !scriptPath {
+				merkleRoot, err := hex.DecodeString(recoverTx.MerkleRootMap[i])
+				require.Nil(t, err)
+				key = txscript.TweakTaprootPrivKey(*key, merkleRoot)
+			}
+			signature, err := schnorr.Sign(key, hash)
+			require.Nil(t, err)
+			signatureMap[i] = hex.EncodeToString(signature.Serialize())
+		}
+
+		// a signature for the other path is rejected
+		_, err = BuildRecoverCommitRawData(network, recoverTx.TxHex, outputs, signatureMap, !scriptPath)
+		assert.NotNil(t, err)
+
+		signedTxHex, err := BuildRecoverCommitRawData(network, recoverTx.TxHex, outputs, signatureMap, scriptPath)
+		require.Nil(t, err)
+		signedTx, err := NewTxFromHex(signedTxHex)
+		require.Nil(t, err)
+		verifyRevealTx(t, signedTx, prevOutFetcher)
+	}
+
+	_, err = BuildRecoverCommitTx(network, outputs, address, 1000, false)
+	assert.NotNil(t, err)
+
+	// a commit tree holding the inscription leaf only is swept through it
+	// by script path, the sweep inscribes
+	singleLeafResult, err := builder.PreProcess(network, []InscriptionData{
+		{ContentType: "text/plain", Body: []byte("abandoned 3"), RevealAddr: address},
+	}, 0, 0, 2, pubKey)
+	require.Nil(t, err)
+	singleLeafCtxData := singleLeafResult.CtxDataList[0]
+	assert.Len(t, singleLeafCtxData.ControlBlockWitness, 33)
+	assert.NotEqual(t, parseResult.CtxDataList[0].CommitTxOutPkScript, singleLeafCtxData.CommitTxOutPkScript)
+	singleLeafOutputs := []*RecoverCommitOutput{{TxId: commitTxHash.String(), CtxData: singleLeafCtxData}}
+	singleLeafFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	singleLeafFetcher.AddPrevOut(*wire.NewOutPoint(&commitTxHash, 0), wire.NewTxOut(singleLeafCtxData.CommitTxOutValue, singleLeafCtxData.CommitTxOutPkScript))
+	for _, scriptPath := range []bool{false, true} {
+		txHex, _, err := SignRecoverCommitTx(network, singleLeafOutputs, address, 2, scriptPath, privateKey)
+		require.Nil(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.Nil(t, err)
+		verifyRevealTx(t, tx, singleLeafFetcher)
+		inscriptions, err := ParseInscriptions(tx, nil)
+		require.Nil(t, err)
+		if scriptPath {
+			require.Len(t, inscriptions, 1)
+			assert.Equal(t, []byte("abandoned 3"), inscriptions[0].Body)
+		} else {
+			assert.Len(t, inscriptions, 0)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// RevealOptions lets the reveals pay their own fee, so the commit outputs
+// only carry the postage.
+type RevealOptions struct {
+	// FundingList is spent by the reveals, one UTXO per reveal in order,
+	// right after the commit input. Reveals past the end of the list are
+	// paid by their commit output.
+	FundingList []*PrevOutput `json:"fundingList"`
+	// ChangeAddress receives what a funding input leaves after the fee,
+	// below the dust limit it is left to the fee. It is required along with
+	// FundingList, so that no funding input is paid in full as fee.
+	ChangeAddress string `json:"changeAddress"`
+}
+
+// NeedsBatchTxCtxData tells whether the reveals are laid out by
+// newBatchTxCtxDataList rather than one revealOutValue output per reveal.
+func NeedsBatchTxCtxData(inscriptionDataList []InscriptionData, batchMode string, parent *ParentInscription, revealOptions *RevealOptions) bool {
+	if batchMode != "" || parent != nil || revealOptions != nil {
+		return true
+	}
+	for i := range inscriptionDataList {
+		if inscriptionDataList[i].Postage > 0 || inscriptionDataList[i].Runestone != nil || inscriptionDataList[i].EtchingOnly {
+			return true
+		}
+	}
+	return false
+}
+
+func (options *RevealOptions) apply(network *chaincfg.Params, inscriptionTxCtxDataList []*InscriptionTxCtxData) error {
+	if options == nil {
+		return nil
+	}
+	if len(options.FundingList) > len(inscriptionTxCtxDataList) {
+		return fmt.Errorf("got %d funding outputs for %d reveals", len(options.FundingList), len(inscriptionTxCtxDataList))
+	}
+	if options.ChangeAddress != "" && len(options.FundingList) == 0 {
+		return errors.New("reveal change needs a funding output")
+	}
+	if options.ChangeAddress == "" && len(options.FundingList) > 0 {
+		return errors.New("reveal funding outputs need a change address")
+	}
+	// funding sats follow the postage, into the change or the fee
+	if HasProtectedSats(options.FundingList) {
+		return errors.New("reveal funding outputs must not carry inscriptions")
+	}
+
+	var changePkScript []byte
+	if options.ChangeAddress != "" {
+		var err error
+		if changePkScript, err = AddrToPkScript(options.ChangeAddress, network); err != nil {
+			return err
+		}
+	}
+	for i, funding := range options.FundingList {
+		txHash, err := chainhash.NewHashFromStr(funding.TxId)
+		if err != nil {
+			return err
+		}
+		pkScript, err := AddrToPkScript(funding.Address, network)
+		if err != nil {
+			return err
+		}
+		var privateKey *btcec.PrivateKey
+		if funding.PrivateKey != "" {
+			privateKeyWif, err := btcutil.DecodeWIF(funding.PrivateKey)
+			if err != nil {
+				return err
+			}
+			privateKey = privateKeyWif.PrivKey
+		}
+		inscriptionTxCtxDataList[i].RevealFundingOutPoint = wire.NewOutPoint(txHash, funding.VOut)
+		inscriptionTxCtxDataList[i].RevealFundingPrevOutput = wire.NewTxOut(funding.Amount, pkScript)
+		inscriptionTxCtxDataList[i].RevealFundingPrivateKey = privateKey
+		inscriptionTxCtxDataList[i].RevealChangePkScript = changePkScript
+	}
+	return nil
+}
+
+// fundRevealTx pays the fee of tx from its funding input, the commit output
+// only covers the part the funding input can't. It returns the commit
+// output value and the fee, the change output is dropped when below dust.
+func (builder *InscriptionBuilder) fundRevealTx(tx *wire.MsgTx, ctxData *InscriptionTxCtxData, outValue, revealFeeRate int64) (int64, int64) {
+	funding := ctxData.RevealFundingPrevOutput.Value
+	if ctxData.RevealChangePkScript != nil {
+		fee := estimateRevealFee(tx, ctxData, revealFeeRate)
+		if funding-fee >= GetDustLimit(builder.Network) {
+			tx.TxOut[len(tx.TxOut)-1].Value = funding - fee
+			return outValue, fee
+		}
+		tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+	}
+
+	fee := estimateRevealFee(tx, ctxData, revealFeeRate)
+	if funding >= fee {
+		return outValue, funding
+	}
+	return outValue + fee - funding, fee
+}
+
+// checkRevealSatFlow follows the inscriptions of an unsigned reveal through
+// ord's sat flow, none of them may land past the outputs on a fee sat.
+func checkRevealSatFlow(tx *wire.MsgTx, ctxData *InscriptionTxCtxData) error {
+	inputValues := []int64{ctxData.RevealTxPrevOutput.Value}
+	if ctxData.ParentOutPoint != nil {
+		inputValues = append([]int64{ctxData.ParentPrevOutput.Value}, inputValues...)
+	}
+	if ctxData.RevealFundingOutPoint != nil {
+		inputValues = append(inputValues, ctxData.RevealFundingPrevOutput.Value)
+	}
+
+	fillDummyRevealWitness(tx, ctxData)
+	defer clearRevealWitness(tx)
+	inscriptions, err := ParseInscriptions(tx, inputValues)
+	if err != nil {
+		return err
+	}
+
+	totalOutputValue := int64(0)
+	for _, out := range tx.TxOut {
+		totalOutputValue += out.Value
+	}
+	for i, inscription := range inscriptions {
+		if inscription.Offset >= totalOutputValue {
+			return fmt.Errorf("inscription %d is at sat %d of %d output sats, it would be paid as fee", i, inscription.Offset, totalOutputValue)
+		}
+	}
+	return nil
+}
+
+// SignRevealTxFunding signs the funding input of the reveals spending one,
+// the commit input is signed by SignRevealTx.
+func (builder *InscriptionBuilder) SignRevealTxFunding(revealTxs []*wire.MsgTx, revealTxPrevOutputFetcher *txscript.MultiPrevOutFetcher, inscriptionTxCtxDataList []*InscriptionTxCtxData) error {
+	for i := range inscriptionTxCtxDataList {
+		if inscriptionTxCtxDataList[i].RevealFundingOutPoint == nil {
+			continue
+		}
+		if inscriptionTxCtxDataList[i].RevealFundingPrivateKey == nil {
+			return errors.New("missing funding private key")
+		}
+		txSigHashes := txscript.NewTxSigHashes(revealTxs[i], revealTxPrevOutputFetcher)
+		if err := signTxInput(revealTxs[i], revealCommitInputIndex(inscriptionTxCtxDataList[i])+1, inscriptionTxCtxDataList[i].RevealFundingPrivateKey, revealTxPrevOutputFetcher, txSigHashes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInscribeRevealOptions(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	address := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	funding := &PrevOutput{
+		TxId:       "8b7b0b4b6e3f5e3c4d1a2f9c0e8d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39281706",
+		VOut:       1,
+		Amount:     20000,
+		Address:    address,
+		PrivateKey: privateKey,
+	}
+	newRequest := func(pointer uint64, options *RevealOptions) *InscriptionRequest {
+		return &InscriptionRequest{
+			CommitTxPrevOutputList: []*PrevOutput{{
+				TxId:       "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+				Amount:     100000,
+				Address:    address,
+				PrivateKey: privateKey,
+			}},
+			CommitFeeRate: 2,
+			RevealFeeRate: 3,
+			InscriptionDataList: []InscriptionData{
+				{ContentType: "text/plain", Body: []byte("postage"), RevealAddr: address, Postage: 10000, Pointer: pointer},
+			},
+			ChangeAddress: address,
+			RevealOptions: options,
+		}
+	}
+	inscribe := func(t *testing.T, request *InscriptionRequest) (*wire.MsgTx, *wire.MsgTx, *InscribeTxs) {
+		txs, err := Inscribe(network, request)
+		require.Nil(t, err)
+		require.Len(t, txs.RevealTxs, 1)
+		commitTx, err := NewTxFromHex(txs.CommitTx)
+		require.Nil(t, err)
+		revealTx, err := NewTxFromHex(txs.RevealTxs[0])
+		require.Nil(t, err)
+		return commitTx, revealTx, txs
+	}
+
+	t.Run("postage", func(t *testing.T) {
+		commitTx, revealTx, txs := inscribe(t, newRequest(0, nil))
+		require.Len(t, revealTx.TxOut, 1)
+		assert.Equal(t, int64(10000), revealTx.TxOut[0].Value)
+		assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(revealTx))*3, txs.RevealTxFees[0])
+		assert.Equal(t, revealTx.TxOut[0].Value+txs.RevealTxFees[0], commitTx.TxOut[0].Value)
+	})
+
+	t.Run("funding and change", func(t *testing.T) {
+		commitTx, revealTx, txs := inscribe(t, newRequest(0, &RevealOptions{FundingList: []*PrevOutput{funding}, ChangeAddress: address}))
+		// the commit output only carries the postage
+		assert.Equal(t, int64(10000), commitTx.TxOut[0].Value)
+		require.Len(t, revealTx.TxIn, 2)
+		require.Len(t, revealTx.TxOut, 2)
+		assert.Equal(t, funding.TxId, revealTx.TxIn[1].PreviousOutPoint.Hash.String())
+		assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(revealTx))*3, txs.RevealTxFees[0])
+		assert.Equal(t, funding.Amount-txs.RevealTxFees[0], revealTx.TxOut[1].Value)
+
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[0].PreviousOutPoint, commitTx.TxOut[0])
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[1].PreviousOutPoint, wire.NewTxOut(funding.Amount, revealTx.TxOut[1].PkScript))
+		verifyRevealTx(t, revealTx, prevOutFetcher)
+
+		inscriptions, err := ParseInscriptions(revealTx, []int64{commitTx.TxOut[0].Value, funding.Amount})
+		require.Nil(t, err)
+		require.Len(t, inscriptions, 1)
+		assert.Equal(t, int64(0), inscriptions[0].Offset)
+	})
+
+	t.Run("pointer to change", func(t *testing.T) {
+		commitTx, revealTx, _ := inscribe(t, newRequest(10000, &RevealOptions{FundingList: []*PrevOutput{funding}, ChangeAddress: address}))
+		inscriptions, err := ParseInscriptions(revealTx, []int64{commitTx.TxOut[0].Value, funding.Amount})
+		require.Nil(t, err)
+		require.Len(t, inscriptions, 1)
+		assert.Equal(t, revealTx.TxOut[0].Value, inscriptions[0].Offset)
+	})
+
+	t.Run("dust change", func(t *testing.T) {
+		small := *funding
+		small.Amount = 400
+		commitTx, revealTx, txs := inscribe(t, newRequest(0, &RevealOptions{FundingList: []*PrevOutput{&small}, ChangeAddress: address}))
+		require.Len(t, revealTx.TxOut, 1)
+		// the commit output covers what the funding output can't
+		assert.Equal(t, revealTx.TxOut[0].Value+txs.RevealTxFees[0]-small.Amount, commitTx.TxOut[0].Value)
+		assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(revealTx))*3, txs.RevealTxFees[0])
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := Inscribe(network, newRequest(0, &RevealOptions{FundingList: []*PrevOutput{funding, funding}}))
+		assert.NotNil(t, err)
+		_, err = Inscribe(network, newRequest(0, &RevealOptions{ChangeAddress: address}))
+		assert.NotNil(t, err)
+		inscribed := *funding
+		inscribed.InscriptionOffsets = []int64{0}
+		_, err = Inscribe(network, newRequest(0, &RevealOptions{FundingList: []*PrevOutput{&inscribed}, ChangeAddress: address}))
+		assert.NotNil(t, err)
+		// the funding output would be paid in full as fee
+		_, err = Inscribe(network, newRequest(0, &RevealOptions{FundingList: []*PrevOutput{funding}}))
+		assert.ErrorContains(t, err, "change address")
+		request := newRequest(0, nil)
+		request.CommitTxPrevOutputList[0].InscriptionOffsets = []int64{0}
+		_, err = Inscribe(network, request)
+		assert.NotNil(t, err)
+		request = newRequest(0, nil)
+		request.InscriptionDataList[0].Postage = 100
+		_, err = Inscribe(network, request)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("external", func(t *testing.T) {
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		pubKey := privateKeyWif.PrivKey.PubKey().SerializeCompressed()
+		external := *funding
+		external.PrivateKey = ""
+
+		builder := &InscriptionBuilder{Network: network}
+		request := newRequest(0, nil)
+		parseResult, err := builder.PreProcessBatch(network, request.InscriptionDataList, "", nil, &RevealOptions{FundingList: []*PrevOutput{&external}, ChangeAddress: address}, 0, 0, 3, pubKey)
+		require.Nil(t, err)
+		require.NotNil(t, parseResult.CtxDataList[0].Funding)
+
+		commitTxHash := chainhash.HashH([]byte("commit"))
+		revealTxsHex, messageHashMaps, _, err := BuildBrc20ChildRevealTx(network, commitTxHash, parseResult.CtxDataList, 3, nil, nil)
+		require.Nil(t, err)
+		require.Len(t, messageHashMaps[0], 2)
+
+		sign := func(messageHash string, tweak bool) string {
+			hash, err := hexutil.Decode(messageHash)
+			require.Nil(t, err)
+			key := privateKeyWif.PrivKey
+			if tweak {
+				key = txscript.TweakTaprootPrivKey(*key, nil)
+			}
+			signature, err := schnorr.Sign(key, hash)
+			require.Nil(t, err)
+			return hex.EncodeToString(signature.Serialize())
+		}
+		signatureMap := map[int]string{
+			0: sign(messageHashMaps[0][0], false),
+			1: sign(messageHashMaps[0][1], true),
+		}
+		signedRevealTxsHex, err := SignBrc20ChildRevealTx(network, revealTxsHex, []map[int]string{signatureMap}, parseResult.CtxDataList, "", "")
+		require.Nil(t, err)
+
+		revealTx, err := NewTxFromHex(signedRevealTxsHex[0])
+		require.Nil(t, err)
+		prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+		prevOutFetcher.AddPrevOut(wire.OutPoint{Hash: commitTxHash}, wire.NewTxOut(parseResult.CtxDataList[0].CommitTxOutValue, parseResult.CtxDataList[0].CommitTxOutPkScript))
+		prevOutFetcher.AddPrevOut(revealTx.TxIn[1].PreviousOutPoint, wire.NewTxOut(funding.Amount, revealTx.TxOut[1].PkScript))
+		verifyRevealTx(t, revealTx, prevOutFetcher)
+	})
+}
+
+func TestCheckRevealSatFlow(t *testing.T) {
+	pkScript, err := AddrToPkScript("tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", &chaincfg.TestNet3Params)
+	require.Nil(t, err)
+	privateKeyWif, err := btcutil.DecodeWIF("cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22")
+	require.Nil(t, err)
+	ctxData, err := newInscriptionTxCtxData(&chaincfg.TestNet3Params, &InscriptionData{ContentType: "text/plain", Body: []byte("fee")}, nil, privateKeyWif.PrivKey.PubKey())
+	require.Nil(t, err)
+	ctxData.ParentOutPoint = &wire.OutPoint{}
+	ctxData.ParentPrevOutput = wire.NewTxOut(10000, pkScript)
+	ctxData.RevealTxPrevOutput = wire.NewTxOut(1000, pkScript)
+
+	// the parent output is cut short, the inscribed sats follow it into the fee
+	tx := wire.NewMsgTx(DefaultTxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(9000, pkScript))
+	assert.NotNil(t, checkRevealSatFlow(tx, ctxData))
+
+	tx.TxOut[0].Value = 10000
+	tx.AddTxOut(wire.NewTxOut(546, pkScript))
+	assert.Nil(t, checkRevealSatFlow(tx, ctxData))
+}
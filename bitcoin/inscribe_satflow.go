@@ -0,0 +1,140 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// SatRange is a run of sats of an output that must stay together, an
+// inscribed sat or rare sats reported by the indexer. Start and End are
+// offsets from the first sat of the output, End is exclusive.
+type SatRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// SatLocation is where a protected range of input Input lands under ord's
+// first in first out sat flow. Output is -1 when the range is paid as fee,
+// Offset is then counted from the first fee sat.
+type SatLocation struct {
+	Input  int       `json:"input"`
+	Range  *SatRange `json:"range"`
+	Output int       `json:"output"`
+	Offset int64     `json:"offset"`
+	// Split is set when the range does not end in the output it starts in.
+	Split bool `json:"split,omitempty"`
+}
+
+// protectedRanges returns the inscription offsets and sat ranges of
+// prevOutput as ranges sorted by start.
+func (prevOutput *PrevOutput) protectedRanges() ([]*SatRange, error) {
+	var ranges []*SatRange
+	for _, offset := range prevOutput.InscriptionOffsets {
+		ranges = append(ranges, &SatRange{Start: offset, End: offset + 1})
+	}
+	for _, satRange := range prevOutput.SatRanges {
+		if satRange == nil {
+			return nil, errors.New("missing sat range")
+		}
+		ranges = append(ranges, &SatRange{Start: satRange.Start, End: satRange.End})
+	}
+	for _, satRange := range ranges {
+		if satRange.Start < 0 || satRange.Start >= satRange.End || satRange.End > prevOutput.Amount {
+			return nil, fmt.Errorf("sat range %d-%d is out of %s:%d of %d sats", satRange.Start, satRange.End, prevOutput.TxId, prevOutput.VOut, prevOutput.Amount)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	return ranges, nil
+}
+
+// HasProtectedSats tells whether any of prevOutputList carries inscriptions
+// or protected sat ranges.
+func HasProtectedSats(prevOutputList []*PrevOutput) bool {
+	for _, prevOutput := range prevOutputList {
+		if prevOutput != nil && (len(prevOutput.InscriptionOffsets) > 0 || len(prevOutput.SatRanges) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// TraceSatFlow follows the protected ranges of inputs to the outputs of
+// outputValues: sats are assigned to the outputs in order, input after
+// input, and the ones left over go to the fee.
+func TraceSatFlow(inputs []*PrevOutput, outputValues []int64) ([]*SatLocation, error) {
+	var locations []*SatLocation
+	inputStart := int64(0)
+	for i, input := range inputs {
+		if input == nil {
+			return nil, fmt.Errorf("missing input %d", i)
+		}
+		ranges, err := input.protectedRanges()
+		if err != nil {
+			return nil, err
+		}
+		for _, satRange := range ranges {
+			output, offset := locateSat(outputValues, inputStart+satRange.Start)
+			lastOutput, _ := locateSat(outputValues, inputStart+satRange.End-1)
+			locations = append(locations, &SatLocation{
+				Input:  i,
+				Range:  satRange,
+				Output: output,
+				Offset: offset,
+				Split:  output != lastOutput,
+			})
+		}
+		inputStart += input.Amount
+	}
+	return locations, nil
+}
+
+// locateSat returns the output holding the sat at position of the inputs
+// and its offset there, or -1 and the offset in the fee.
+func locateSat(outputValues []int64, position int64) (int, int64) {
+	outputStart := int64(0)
+	for i, value := range outputValues {
+		if position < outputStart+value {
+			return i, position - outputStart
+		}
+		outputStart += value
+	}
+	return -1, position - outputStart
+}
+
+// CheckSatFlow traces the protected ranges of inputs, the prev outputs of
+// tx in order, and refuses tx when one of them would be paid as fee or cut
+// across outputs, or, unless allowMerge, when ranges of different inputs
+// would end up in one output.
+func CheckSatFlow(tx *wire.MsgTx, inputs []*PrevOutput, allowMerge bool) ([]*SatLocation, error) {
+	if len(tx.TxIn) != len(inputs) {
+		return nil, fmt.Errorf("got %d prev outputs for %d inputs", len(inputs), len(tx.TxIn))
+	}
+	outputValues := make([]int64, len(tx.TxOut))
+	for i, out := range tx.TxOut {
+		outputValues[i] = out.Value
+	}
+	locations, err := TraceSatFlow(inputs, outputValues)
+	if err != nil {
+		return nil, err
+	}
+
+	outputInputs := make(map[int]int)
+	for _, location := range locations {
+		if location.Output < 0 {
+			return nil, fmt.Errorf("sats %d-%d of input %d would be paid as fee", location.Range.Start, location.Range.End, location.Input)
+		}
+		if location.Split {
+			return nil, fmt.Errorf("sats %d-%d of input %d would be split across outputs", location.Range.Start, location.Range.End, location.Input)
+		}
+		if input, ok := outputInputs[location.Output]; ok && input != location.Input && !allowMerge {
+			return nil, fmt.Errorf("output %d would merge the protected sats of inputs %d and %d", location.Output, input, location.Input)
+		}
+		outputInputs[location.Output] = location.Input
+	}
+	return locations, nil
+}
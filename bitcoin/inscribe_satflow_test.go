@@ -0,0 +1,63 @@
+package bitcoin
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceSatFlow(t *testing.T) {
+	inputs := []*PrevOutput{
+		{Amount: 10000, InscriptionOffsets: []int64{600, 0}},
+		{Amount: 5000, SatRanges: []*SatRange{{Start: 100, End: 200}}},
+	}
+	locations, err := TraceSatFlow(inputs, []int64{546, 9454, 4000})
+	require.Nil(t, err)
+	require.Len(t, locations, 3)
+	assert.Equal(t, &SatLocation{Input: 0, Range: &SatRange{Start: 0, End: 1}, Output: 0, Offset: 0}, locations[0])
+	assert.Equal(t, &SatLocation{Input: 0, Range: &SatRange{Start: 600, End: 601}, Output: 1, Offset: 54}, locations[1])
+	assert.Equal(t, &SatLocation{Input: 1, Range: &SatRange{Start: 100, End: 200}, Output: 2, Offset: 100}, locations[2])
+
+	// the second input is left to the fee past its first 150 sats
+	locations, err = TraceSatFlow(inputs, []int64{10150})
+	require.Nil(t, err)
+	assert.True(t, locations[2].Split)
+	locations, err = TraceSatFlow(inputs, []int64{10000})
+	require.Nil(t, err)
+	assert.Equal(t, -1, locations[2].Output)
+	assert.Equal(t, int64(100), locations[2].Offset)
+
+	_, err = TraceSatFlow([]*PrevOutput{{Amount: 546, InscriptionOffsets: []int64{546}}}, []int64{546})
+	assert.NotNil(t, err)
+}
+
+func TestCheckSatFlow(t *testing.T) {
+	newTx := func(outputValues ...int64) *wire.MsgTx {
+		tx := wire.NewMsgTx(DefaultTxVersion)
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+		for _, value := range outputValues {
+			tx.AddTxOut(wire.NewTxOut(value, nil))
+		}
+		return tx
+	}
+	inputs := []*PrevOutput{
+		{Amount: 1000, InscriptionOffsets: []int64{0}},
+		{Amount: 1000, InscriptionOffsets: []int64{0}},
+	}
+
+	_, err := CheckSatFlow(newTx(1000, 900), inputs, false)
+	assert.Nil(t, err)
+	// a consolidation merges both inscriptions
+	_, err = CheckSatFlow(newTx(1900), inputs, false)
+	assert.NotNil(t, err)
+	_, err = CheckSatFlow(newTx(1900), inputs, true)
+	assert.Nil(t, err)
+	// a payment leaving the second inscription to the fee
+	_, err = CheckSatFlow(newTx(900), inputs, true)
+	assert.NotNil(t, err)
+	_, err = CheckSatFlow(newTx(900), inputs[:1], true)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,242 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// SendInscriptionRequest moves the inscriptions of InscriptionUtxo, on its
+// first sat unless InscriptionOffsets or SatRanges say otherwise, to
+// ReceiverAddress. The UTXO is spent at input 0 into output 0, so the
+// inscriptions keep their offsets in the receiver output, and the fee is
+// paid by FundingList.
+type SendInscriptionRequest struct {
+	InscriptionUtxo *PrevOutput   `json:"inscriptionUtxo"`
+	FundingList     []*PrevOutput `json:"fundingList"`
+	ReceiverAddress string        `json:"receiverAddress"`
+	// Postage is the value of the receiver output, the inscription UTXO
+	// amount when zero. Sats of the inscription UTXO past the postage go to
+	// the change output.
+	Postage        int64  `json:"postage"`
+	ChangeAddress  string `json:"changeAddress"`
+	MinChangeValue int64  `json:"minChangeValue"`
+	FeeRate        int64  `json:"feeRate"`
+	// PubKeys are the public keys of the segwit v0 inputs, keyed by input
+	// index.
+	PubKeys map[int]string `json:"pubKeys"`
+}
+
+type SendInscriptionTx struct {
+	TxHex string `json:"txHex"`
+	Fee   int64  `json:"fee"`
+	// MessageHashMap is keyed by input index.
+	MessageHashMap map[int]string `json:"messageHashMap"`
+}
+
+func (request *SendInscriptionRequest) inputs() []*PrevOutput {
+	return append([]*PrevOutput{request.InscriptionUtxo}, request.FundingList...)
+}
+
+// newSendInscriptionTx builds the unsigned send, the change output is only
+// added when above MinChangeValue.
+func newSendInscriptionTx(network *chaincfg.Params, request *SendInscriptionRequest) (*wire.MsgTx, *txscript.MultiPrevOutFetcher, error) {
+	if request.InscriptionUtxo == nil {
+		return nil, nil, errors.New("missing inscription utxo")
+	}
+	if len(request.FundingList) == 0 {
+		return nil, nil, errors.New("the fee must be paid by funding utxos")
+	}
+	builder := &InscriptionBuilder{Network: network}
+	prevOutFetcher, tx, totalSenderAmount, err := builder.ParseCommitTxPrevOutput(request.inputs())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	postage := request.Postage
+	if postage <= 0 {
+		postage = request.InscriptionUtxo.Amount
+	}
+	if postage < GetDustLimit(network) {
+		return nil, nil, errors.New("postage below the dust limit")
+	}
+	receiverPkScript, err := AddrToPkScript(request.ReceiverAddress, network)
+	if err != nil {
+		return nil, nil, err
+	}
+	changePkScript, err := AddrToPkScript(request.ChangeAddress, network)
+	if err != nil {
+		return nil, nil, err
+	}
+	minChangeValue := GetDustLimit(network)
+	if request.MinChangeValue > 0 {
+		minChangeValue = request.MinChangeValue
+	}
+
+	tx.AddTxOut(wire.NewTxOut(postage, receiverPkScript))
+	tx.AddTxOut(wire.NewTxOut(0, changePkScript))
+	fee := estimateSendInscriptionFee(tx, prevOutFetcher, request.FeeRate)
+	change := int64(totalSenderAmount) - postage - fee
+	if change >= minChangeValue {
+		tx.TxOut[1].Value = change
+	} else {
+		tx.TxOut = tx.TxOut[:1]
+		fee = estimateSendInscriptionFee(tx, prevOutFetcher, request.FeeRate)
+		if int64(totalSenderAmount)-postage-fee < 0 {
+			return nil, nil, errors.New("insufficient balance")
+		}
+	}
+
+	if err = request.checkSatFlow(tx); err != nil {
+		return nil, nil, err
+	}
+	return tx, prevOutFetcher, nil
+}
+
+// checkSatFlow makes sure every inscription of the inscription UTXO reaches
+// the receiver output and no funding inscription is burnt or merged.
+func (request *SendInscriptionRequest) checkSatFlow(tx *wire.MsgTx) error {
+	inscriptionUtxo := *request.InscriptionUtxo
+	if !HasProtectedSats([]*PrevOutput{&inscriptionUtxo}) {
+		inscriptionUtxo.InscriptionOffsets = []int64{0}
+	}
+	locations, err := CheckSatFlow(tx, append([]*PrevOutput{&inscriptionUtxo}, request.FundingList...), false)
+	if err != nil {
+		return err
+	}
+	for _, location := range locations {
+		if location.Input == 0 && location.Output != 0 {
+			return fmt.Errorf("sats %d-%d of the inscription utxo are past the postage of %d", location.Range.Start, location.Range.End, tx.TxOut[0].Value)
+		}
+	}
+	return nil
+}
+
+func estimateSendInscriptionFee(tx *wire.MsgTx, prevOutFetcher *txscript.MultiPrevOutFetcher, feeRate int64) int64 {
+	for _, in := range tx.TxIn {
+		in.SignatureScript, in.Witness = dummySignature(prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint).PkScript)
+	}
+	fee := GetTxVirtualSize(btcutil.NewTx(tx)) * feeRate
+	for _, in := range tx.TxIn {
+		in.SignatureScript, in.Witness = nil, nil
+	}
+	return fee
+}
+
+// BuildSendInscriptionTx builds the send and the message hashes to sign
+// externally, taproot inputs are signed through the key path.
+func BuildSendInscriptionTx(network *chaincfg.Params, request *SendInscriptionRequest) (*SendInscriptionTx, error) {
+	tx, prevOutFetcher, err := newSendInscriptionTx(network, request)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SendInscriptionTx{
+		Fee: CalculateCommitTxFee(tx, prevOutFetcher),
+	}
+	if result.MessageHashMap, err = calcMessageHashMap(tx, prevOutFetcher, request.PubKeys); err != nil {
+		return nil, err
+	}
+	if result.TxHex, err = GetTxHex(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// calcMessageHashMap returns the message hash of every input of tx keyed by
+// input index, pubKeys are needed for the segwit v0 inputs.
+func calcMessageHashMap(tx *wire.MsgTx, prevOutFetcher *txscript.MultiPrevOutFetcher, pubKeys map[int]string) (map[int]string, error) {
+	messageHashMap := make(map[int]string)
+	txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	for i := range tx.TxIn {
+		pubKey, err := ParsePubKey(pubKeys[i])
+		var pubKeyBytes []byte
+		if err == nil {
+			pubKeyBytes = pubKey.SerializeCompressed()
+		}
+		prevOut := prevOutFetcher.FetchPrevOutput(tx.TxIn[i].PreviousOutPoint)
+		if pubKeyBytes == nil && !txscript.IsPayToTaproot(prevOut.PkScript) && !txscript.IsPayToPubKeyHash(prevOut.PkScript) {
+			return nil, errors.New("missing public key of a segwit v0 input")
+		}
+		if messageHashMap[i], err = calcInputMessageHash(tx, i, prevOutFetcher, txSigHashes, pubKeyBytes); err != nil {
+			return nil, err
+		}
+	}
+	return messageHashMap, nil
+}
+
+// SignSendInscriptionTx builds and signs the send with the private keys of
+// the request prev outputs.
+func SignSendInscriptionTx(network *chaincfg.Params, request *SendInscriptionRequest) (string, int64, error) {
+	privateKeys, err := decodePrivateKeys(request.inputs())
+	if err != nil {
+		return "", 0, err
+	}
+	tx, prevOutFetcher, err := newSendInscriptionTx(network, request)
+	if err != nil {
+		return "", 0, err
+	}
+	if err = Sign(tx, privateKeys, prevOutFetcher); err != nil {
+		return "", 0, err
+	}
+
+	txHex, err := GetTxHex(tx)
+	if err != nil {
+		return "", 0, err
+	}
+	return txHex, CalculateCommitTxFee(tx, prevOutFetcher), nil
+}
+
+func decodePrivateKeys(prevOutputList []*PrevOutput) ([]*btcec.PrivateKey, error) {
+	var privateKeys []*btcec.PrivateKey
+	for _, prevOutput := range prevOutputList {
+		if prevOutput == nil {
+			return nil, errors.New("missing prev output")
+		}
+		privateKeyWif, err := btcutil.DecodeWIF(prevOutput.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		privateKeys = append(privateKeys, privateKeyWif.PrivKey)
+	}
+	return privateKeys, nil
+}
+
+// BuildSendInscriptionRawData injects the signatures of the message hashes
+// returned by BuildSendInscriptionTx, keyed by input index.
+func BuildSendInscriptionRawData(network *chaincfg.Params, txHex string, request *SendInscriptionRequest, signatureMap map[int]string) (string, error) {
+	if request.InscriptionUtxo == nil {
+		return "", errors.New("missing inscription utxo")
+	}
+	return buildRawDataByInputs(network, txHex, request.inputs(), request.PubKeys, signatureMap)
+}
+
+// buildRawDataByInputs injects signatureMap into txHex spending
+// prevOutputList, pubKeys are needed for the segwit v0 inputs.
+func buildRawDataByInputs(network *chaincfg.Params, txHex string, prevOutputList []*PrevOutput, pubKeys map[int]string, signatureMap map[int]string) (string, error) {
+	tx, err := NewTxFromHex(txHex)
+	if err != nil {
+		return "", err
+	}
+	builder := &InscriptionBuilder{Network: network}
+	prevOutFetcher, _, _, err := builder.ParseCommitTxPrevOutput(prevOutputList)
+	if err != nil {
+		return "", err
+	}
+	if len(tx.TxIn) != len(prevOutputList) {
+		return "", errors.New("tx does not match its inputs")
+	}
+
+	txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	for i := range tx.TxIn {
+		if err = signInputBySignature(tx, i, prevOutFetcher, txSigHashes, signatureMap[i], pubKeys[i]); err != nil {
+			return "", err
+		}
+	}
+	return GetTxHex(tx)
+}
@@ -0,0 +1,133 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendInscription(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	pubKey := privateKeyWif.PrivKey.PubKey().SerializeCompressed()
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	segwitAddress, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey), network)
+	require.Nil(t, err)
+
+	newRequest := func() *SendInscriptionRequest {
+		return &SendInscriptionRequest{
+			InscriptionUtxo: &PrevOutput{
+				TxId:       "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+				Amount:     546,
+				Address:    taprootAddress,
+				PrivateKey: privateKey,
+			},
+			FundingList: []*PrevOutput{{
+				TxId:       "8b7b0b4b6e3f5e3c4d1a2f9c0e8d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39281706",
+				VOut:       2,
+				Amount:     10000,
+				Address:    segwitAddress.EncodeAddress(),
+				PrivateKey: privateKey,
+			}},
+			ReceiverAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+			ChangeAddress:   segwitAddress.EncodeAddress(),
+			FeeRate:         5,
+			PubKeys:         map[int]string{1: hex.EncodeToString(pubKey)},
+		}
+	}
+	prevOutFetcher := func(t *testing.T, request *SendInscriptionRequest) *txscript.MultiPrevOutFetcher {
+		builder := &InscriptionBuilder{Network: network}
+		fetcher, _, _, err := builder.ParseCommitTxPrevOutput(request.inputs())
+		require.Nil(t, err)
+		return fetcher
+	}
+
+	t.Run("local", func(t *testing.T) {
+		request := newRequest()
+		txHex, fee, err := SignSendInscriptionTx(network, request)
+		require.Nil(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.Nil(t, err)
+		require.Len(t, tx.TxOut, 2)
+		assert.Equal(t, request.InscriptionUtxo.TxId, tx.TxIn[0].PreviousOutPoint.Hash.String())
+		assert.Equal(t, int64(546), tx.TxOut[0].Value)
+		assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(tx))*request.FeeRate, fee)
+		assert.Equal(t, int64(10000)-fee, tx.TxOut[1].Value)
+		verifyRevealTx(t, tx, prevOutFetcher(t, request))
+	})
+
+	t.Run("external", func(t *testing.T) {
+		request := newRequest()
+		request.InscriptionUtxo.Amount = 1000
+		request.Postage = 600
+		sendTx, err := BuildSendInscriptionTx(network, request)
+		require.Nil(t, err)
+		require.Len(t, sendTx.MessageHashMap, 2)
+
+		hash, err := hexutil.Decode(sendTx.MessageHashMap[0])
+		require.Nil(t, err)
+		schnorrSignature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*privateKeyWif.PrivKey, nil), hash)
+		require.Nil(t, err)
+		hash, err = hexutil.Decode(sendTx.MessageHashMap[1])
+		require.Nil(t, err)
+		compactSignature, err := ecdsa.SignCompact(privateKeyWif.PrivKey, hash, true)
+		require.Nil(t, err)
+
+		txHex, err := BuildSendInscriptionRawData(network, sendTx.TxHex, request, map[int]string{
+			0: hex.EncodeToString(schnorrSignature.Serialize()),
+			1: hex.EncodeToString(compactSignature[1:]),
+		})
+		require.Nil(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.Nil(t, err)
+		assert.Equal(t, int64(600), tx.TxOut[0].Value)
+		// the sats of the inscription utxo past the postage become change
+		assert.Equal(t, int64(1000+10000-600)-sendTx.Fee, tx.TxOut[1].Value)
+		verifyRevealTx(t, tx, prevOutFetcher(t, request))
+	})
+
+	t.Run("sat flow", func(t *testing.T) {
+		request := newRequest()
+		request.InscriptionUtxo.Amount = 1000
+		request.InscriptionUtxo.InscriptionOffsets = []int64{500}
+		request.Postage = 600
+		_, err := BuildSendInscriptionTx(network, request)
+		assert.Nil(t, err)
+		// the second inscription would go to the change
+		request.InscriptionUtxo.InscriptionOffsets = []int64{500, 700}
+		_, err = BuildSendInscriptionTx(network, request)
+		assert.NotNil(t, err)
+
+		request = newRequest()
+		request.FundingList[0].SatRanges = []*SatRange{{Start: 9900, End: 10000}}
+		_, err = BuildSendInscriptionTx(network, request)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("insufficient", func(t *testing.T) {
+		request := newRequest()
+		request.FundingList[0].Amount = 100
+		_, _, err := SignSendInscriptionTx(network, request)
+		assert.NotNil(t, err)
+
+		request = newRequest()
+		request.FundingList = nil
+		_, err = BuildSendInscriptionTx(network, request)
+		assert.NotNil(t, err)
+
+		request = newRequest()
+		request.PubKeys = nil
+		_, err = BuildSendInscriptionTx(network, request)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,208 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// SplitInscriptionsRequest carves the protected sats of InscriptionUtxo,
+// given by its InscriptionOffsets and SatRanges, into outputs of Postage
+// sats to ReceiverAddress, each starting with its range. Sats between the
+// ranges go to ChangeAddress when above dust, the ones past the last range
+// are change along with FundingList, and the fee is paid from both.
+type SplitInscriptionsRequest struct {
+	InscriptionUtxo *PrevOutput   `json:"inscriptionUtxo"`
+	FundingList     []*PrevOutput `json:"fundingList"`
+	// ReceiverAddress defaults to the address of InscriptionUtxo.
+	ReceiverAddress string `json:"receiverAddress"`
+	// Postage defaults to DefaultRevealOutValue, an output stops short of
+	// it at the next range.
+	Postage       int64  `json:"postage"`
+	ChangeAddress string `json:"changeAddress"`
+	FeeRate       int64  `json:"feeRate"`
+	// PubKeys are the public keys of the segwit v0 inputs, keyed by input
+	// index.
+	PubKeys map[int]string `json:"pubKeys"`
+}
+
+type SplitInscriptionsTx struct {
+	TxHex string `json:"txHex"`
+	Fee   int64  `json:"fee"`
+	// MessageHashMap is keyed by input index, it is empty once signed.
+	MessageHashMap map[int]string `json:"messageHashMap,omitempty"`
+	Locations      []*SatLocation `json:"locations"`
+}
+
+func (request *SplitInscriptionsRequest) inputs() []*PrevOutput {
+	return append([]*PrevOutput{request.InscriptionUtxo}, request.FundingList...)
+}
+
+// mergeSatRanges joins the overlapping ones of sorted ranges, an inscription
+// offset may fall in a sat range reported for it.
+func mergeSatRanges(ranges []*SatRange) []*SatRange {
+	var merged []*SatRange
+	for _, satRange := range ranges {
+		if last := len(merged) - 1; last >= 0 && satRange.Start < merged[last].End {
+			if satRange.End > merged[last].End {
+				merged[last] = &SatRange{Start: merged[last].Start, End: satRange.End}
+			}
+			continue
+		}
+		merged = append(merged, satRange)
+	}
+	return merged
+}
+
+func newSplitInscriptionsTx(network *chaincfg.Params, request *SplitInscriptionsRequest) (*wire.MsgTx, *txscript.MultiPrevOutFetcher, []*SatLocation, error) {
+	if request.InscriptionUtxo == nil {
+		return nil, nil, nil, errors.New("missing inscription utxo")
+	}
+	ranges, err := request.InscriptionUtxo.protectedRanges()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, nil, nil, errors.New("the inscription utxo has no inscription offsets or sat ranges")
+	}
+	builder := &InscriptionBuilder{Network: network}
+	prevOutFetcher, tx, totalSenderAmount, err := builder.ParseCommitTxPrevOutput(request.inputs())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	receiverAddress := request.ReceiverAddress
+	if receiverAddress == "" {
+		receiverAddress = request.InscriptionUtxo.Address
+	}
+	receiverPkScript, err := AddrToPkScript(receiverAddress, network)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	changePkScript, err := AddrToPkScript(request.ChangeAddress, network)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dustLimit := GetDustLimit(network)
+	postage := DefaultRevealOutValue
+	if request.Postage > 0 {
+		postage = request.Postage
+	}
+	if postage < dustLimit {
+		return nil, nil, nil, errors.New("postage below the dust limit")
+	}
+
+	ranges = mergeSatRanges(ranges)
+	cursor := int64(0)
+	for i, satRange := range ranges {
+		start := satRange.Start
+		if gap := satRange.Start - cursor; gap >= dustLimit {
+			tx.AddTxOut(wire.NewTxOut(gap, changePkScript))
+		} else if i > 0 {
+			// too small for an output of its own, the previous one keeps it
+			tx.TxOut[len(tx.TxOut)-1].Value += gap
+		} else {
+			start = cursor
+		}
+
+		end := start + postage
+		if end < satRange.End {
+			end = satRange.End
+		}
+		next := request.InscriptionUtxo.Amount
+		if i+1 < len(ranges) {
+			next = ranges[i+1].Start
+		}
+		if end > next {
+			end = next
+		}
+		if end < satRange.End || end-start < dustLimit {
+			if i+1 == len(ranges) {
+				return nil, nil, nil, fmt.Errorf("sat range %d is too close to the end of the inscription utxo, its output of %d sats would be below the dust limit", i, end-start)
+			}
+			return nil, nil, nil, fmt.Errorf("sat ranges %d and %d are too close to split", i, i+1)
+		}
+		tx.AddTxOut(wire.NewTxOut(end-start, receiverPkScript))
+		cursor = end
+	}
+
+	outputValue := int64(0)
+	for _, out := range tx.TxOut {
+		outputValue += out.Value
+	}
+	tx.AddTxOut(wire.NewTxOut(0, changePkScript))
+	fee := estimateSendInscriptionFee(tx, prevOutFetcher, request.FeeRate)
+	if change := int64(totalSenderAmount) - outputValue - fee; change >= dustLimit {
+		tx.TxOut[len(tx.TxOut)-1].Value = change
+	} else {
+		tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+		fee = estimateSendInscriptionFee(tx, prevOutFetcher, request.FeeRate)
+		if int64(totalSenderAmount)-outputValue-fee < 0 {
+			return nil, nil, nil, errors.New("insufficient balance")
+		}
+	}
+
+	locations, err := CheckSatFlow(tx, request.inputs(), false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return tx, prevOutFetcher, locations, nil
+}
+
+// BuildSplitInscriptionsTx builds the split and the message hashes to sign
+// externally, the signatures go to BuildSplitInscriptionsRawData.
+func BuildSplitInscriptionsTx(network *chaincfg.Params, request *SplitInscriptionsRequest) (*SplitInscriptionsTx, error) {
+	tx, prevOutFetcher, locations, err := newSplitInscriptionsTx(network, request)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SplitInscriptionsTx{
+		Fee:       CalculateCommitTxFee(tx, prevOutFetcher),
+		Locations: locations,
+	}
+	if result.MessageHashMap, err = calcMessageHashMap(tx, prevOutFetcher, request.PubKeys); err != nil {
+		return nil, err
+	}
+	if result.TxHex, err = GetTxHex(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SignSplitInscriptionsTx builds and signs the split with the private keys
+// of the request prev outputs.
+func SignSplitInscriptionsTx(network *chaincfg.Params, request *SplitInscriptionsRequest) (*SplitInscriptionsTx, error) {
+	privateKeys, err := decodePrivateKeys(request.inputs())
+	if err != nil {
+		return nil, err
+	}
+	tx, prevOutFetcher, locations, err := newSplitInscriptionsTx(network, request)
+	if err != nil {
+		return nil, err
+	}
+	if err = Sign(tx, privateKeys, prevOutFetcher); err != nil {
+		return nil, err
+	}
+
+	result := &SplitInscriptionsTx{
+		Fee:       CalculateCommitTxFee(tx, prevOutFetcher),
+		Locations: locations,
+	}
+	if result.TxHex, err = GetTxHex(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BuildSplitInscriptionsRawData injects the signatures of the message hashes
+// returned by BuildSplitInscriptionsTx, keyed by input index.
+func BuildSplitInscriptionsRawData(network *chaincfg.Params, txHex string, request *SplitInscriptionsRequest, signatureMap map[int]string) (string, error) {
+	if request.InscriptionUtxo == nil {
+		return "", errors.New("missing inscription utxo")
+	}
+	return buildRawDataByInputs(network, txHex, request.inputs(), request.PubKeys, signatureMap)
+}
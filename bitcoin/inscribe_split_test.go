@@ -0,0 +1,85 @@
+package bitcoin
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitInscriptions(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	address := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	newRequest := func(offsets []int64, satRanges []*SatRange) *SplitInscriptionsRequest {
+		return &SplitInscriptionsRequest{
+			InscriptionUtxo: &PrevOutput{
+				TxId:               "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+				Amount:             20000,
+				Address:            address,
+				PrivateKey:         privateKey,
+				InscriptionOffsets: offsets,
+				SatRanges:          satRanges,
+			},
+			FundingList: []*PrevOutput{{
+				TxId:       "8b7b0b4b6e3f5e3c4d1a2f9c0e8d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39281706",
+				Amount:     3000,
+				Address:    address,
+				PrivateKey: privateKey,
+			}},
+			Postage:       1000,
+			ChangeAddress: address,
+			FeeRate:       5,
+		}
+	}
+
+	t.Run("split", func(t *testing.T) {
+		request := newRequest([]int64{5000, 0}, []*SatRange{{Start: 12000, End: 12100}})
+		splitTx, err := SignSplitInscriptionsTx(network, request)
+		require.Nil(t, err)
+		tx, err := NewTxFromHex(splitTx.TxHex)
+		require.Nil(t, err)
+
+		var values []int64
+		for _, out := range tx.TxOut {
+			values = append(values, out.Value)
+		}
+		assert.Equal(t, []int64{1000, 4000, 1000, 6000, 1000, 7000 + 3000 - splitTx.Fee}, values)
+		assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(tx))*request.FeeRate, splitTx.Fee)
+		require.Len(t, splitTx.Locations, 3)
+		for i, location := range splitTx.Locations {
+			assert.Equal(t, 2*i, location.Output)
+			assert.Equal(t, int64(0), location.Offset)
+		}
+
+		builder := &InscriptionBuilder{Network: network}
+		prevOutFetcher, _, _, err := builder.ParseCommitTxPrevOutput(request.inputs())
+		require.Nil(t, err)
+		verifyRevealTx(t, tx, prevOutFetcher)
+	})
+
+	t.Run("small gap", func(t *testing.T) {
+		splitTx, err := BuildSplitInscriptionsTx(network, newRequest([]int64{100, 1500}, nil))
+		require.Nil(t, err)
+		tx, err := NewTxFromHex(splitTx.TxHex)
+		require.Nil(t, err)
+		// the 400 sats between the two outputs stay with the first one
+		assert.Equal(t, int64(1500), tx.TxOut[0].Value)
+		assert.Equal(t, int64(1000), tx.TxOut[1].Value)
+		assert.Equal(t, int64(100), splitTx.Locations[0].Offset)
+		assert.Len(t, splitTx.MessageHashMap, 2)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := BuildSplitInscriptionsTx(network, newRequest([]int64{0, 300}, nil))
+		assert.NotNil(t, err)
+		_, err = BuildSplitInscriptionsTx(network, newRequest(nil, nil))
+		assert.NotNil(t, err)
+		_, err = BuildSplitInscriptionsTx(network, newRequest([]int64{20000}, nil))
+		assert.NotNil(t, err)
+		_, err = BuildSplitInscriptionsTx(network, newRequest([]int64{0, 19900}, nil))
+		assert.ErrorContains(t, err, "sat range 1 is too close to the end of the inscription utxo")
+	})
+}
@@ -0,0 +1,391 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// Formats of a signed message: the BIP322 simple and full ones, and the
+// legacy signmessage one P2PKH addresses fall back to, with the BIP137
+// header bytes of P2SH-P2WPKH and P2WPKH addresses.
+const (
+	MessageFormatSimple = "simple"
+	MessageFormatFull   = "full"
+	MessageFormatLegacy = "legacy"
+)
+
+var bip322Tag = []byte("BIP0322-signed-message")
+
+const legacyMessageMagic = "Bitcoin Signed Message:\n"
+
+// SignMessageRequest proves the ownership of Address by signing Message in
+// Format, the default one of the address type when empty. Without
+// PrivateKey the message hash is returned, its Signature (64 byte schnorr
+// for taproot, r and s otherwise along with PublicKey) completes it.
+type SignMessageRequest struct {
+	Address    string `json:"address"`
+	Message    string `json:"message"`
+	Format     string `json:"format"`
+	PrivateKey string `json:"privateKey"`
+	PublicKey  string `json:"publicKey"`
+	Signature  string `json:"signature"`
+}
+
+type SignedMessage struct {
+	Format string `json:"format"`
+	// Signature is base64 encoded, it is empty while MessageHash is left to
+	// sign.
+	Signature   string `json:"signature,omitempty"`
+	MessageHash string `json:"messageHash,omitempty"`
+}
+
+// Bip322MessageHash is the tagged hash BIP322 commits to in to_spend.
+func Bip322MessageHash(message string) []byte {
+	return chainhash.TaggedHash(bip322Tag, []byte(message))[:]
+}
+
+// LegacyMessageHash is the double sha256 hash signed by the legacy
+// signmessage format.
+func LegacyMessageHash(message string) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, legacyMessageMagic)
+	_ = wire.WriteVarString(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// Bip322ToSpend is the virtual to_spend tx of message to pkScript.
+func Bip322ToSpend(pkScript []byte, message string) (*wire.MsgTx, error) {
+	scriptSig, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(Bip322MessageHash(message)).Script()
+	if err != nil {
+		return nil, err
+	}
+	toSpend := wire.NewMsgTx(0)
+	in := wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), scriptSig, nil)
+	in.Sequence = 0
+	toSpend.AddTxIn(in)
+	toSpend.AddTxOut(wire.NewTxOut(0, pkScript))
+	return toSpend, nil
+}
+
+// Bip322ToSign is the virtual to_sign tx spending toSpend, unsigned.
+func Bip322ToSign(toSpend *wire.MsgTx) *wire.MsgTx {
+	toSign := wire.NewMsgTx(0)
+	toSpendHash := toSpend.TxHash()
+	in := wire.NewTxIn(wire.NewOutPoint(&toSpendHash, 0), nil, nil)
+	in.Sequence = 0
+	toSign.AddTxIn(in)
+	toSign.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	return toSign
+}
+
+// messageFormat checks format against the address type of pkScript: the
+// simple format only carries a witness, a P2PKH address signs the legacy
+// way unless full is asked for, segwit v0 ones only when asked for.
+func messageFormat(pkScript []byte, format string) (string, error) {
+	legacy := txscript.IsPayToPubKeyHash(pkScript)
+	switch format {
+	case "":
+		if legacy {
+			return MessageFormatLegacy, nil
+		} else if txscript.IsPayToScriptHash(pkScript) {
+			return MessageFormatFull, nil
+		}
+		return MessageFormatSimple, nil
+	case MessageFormatSimple:
+		if legacy || txscript.IsPayToScriptHash(pkScript) {
+			return "", errors.New("the simple format needs a segwit address")
+		}
+	case MessageFormatFull:
+	case MessageFormatLegacy:
+		if !legacy && !txscript.IsPayToScriptHash(pkScript) && !txscript.IsPayToWitnessPubKeyHash(pkScript) {
+			return "", errors.New("the legacy format needs a P2PKH, P2SH-P2WPKH or P2WPKH address")
+		}
+	default:
+		return "", fmt.Errorf("unknown message format %q", format)
+	}
+	if !legacy && !txscript.IsPayToScriptHash(pkScript) && !txscript.IsPayToWitnessPubKeyHash(pkScript) && !txscript.IsPayToTaproot(pkScript) {
+		return "", errors.New("unsupported address type")
+	}
+	return format, nil
+}
+
+// SignMessage signs request.Message for request.Address, or returns the
+// hash to sign externally when neither PrivateKey nor Signature is given.
+func SignMessage(network *chaincfg.Params, request *SignMessageRequest) (*SignedMessage, error) {
+	pkScript, err := AddrToPkScript(request.Address, network)
+	if err != nil {
+		return nil, err
+	}
+	format, err := messageFormat(pkScript, request.Format)
+	if err != nil {
+		return nil, err
+	}
+	var privKey *btcec.PrivateKey
+	compressed := true
+	if request.PrivateKey != "" {
+		privateKeyWif, err := btcutil.DecodeWIF(request.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		privKey = privateKeyWif.PrivKey
+		compressed = privateKeyWif.CompressPubKey
+	}
+	signed := &SignedMessage{Format: format}
+
+	if format == MessageFormatLegacy {
+		hash := LegacyMessageHash(request.Message)
+		var signature []byte
+		if privKey != nil {
+			signature, err = ecdsa.SignCompact(privKey, hash, compressed)
+		} else if request.Signature != "" {
+			signature, err = compactSignature(hash, request.Signature, request.PublicKey)
+		} else {
+			signed.MessageHash = hexutil.Encode(hash)
+			return signed, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		header, err := bip137Header(pkScript, signature[0] >= 31)
+		if err != nil {
+			return nil, err
+		}
+		signature[0] = header + (signature[0]-27)%4
+		signed.Signature = base64.StdEncoding.EncodeToString(signature)
+		return signed, nil
+	}
+
+	toSpend, err := Bip322ToSpend(pkScript, request.Message)
+	if err != nil {
+		return nil, err
+	}
+	toSign := Bip322ToSign(toSpend)
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	prevOutFetcher.AddPrevOut(toSign.TxIn[0].PreviousOutPoint, toSpend.TxOut[0])
+	txSigHashes := txscript.NewTxSigHashes(toSign, prevOutFetcher)
+	if privKey != nil {
+		err = signTxInput(toSign, 0, privKey, prevOutFetcher, txSigHashes)
+	} else if request.Signature != "" {
+		err = signInputBySignature(toSign, 0, prevOutFetcher, txSigHashes, request.Signature, request.PublicKey)
+	} else {
+		var pubKeyBytes []byte
+		if !txscript.IsPayToTaproot(pkScript) && !txscript.IsPayToPubKeyHash(pkScript) {
+			pubKey, err := ParsePubKey(request.PublicKey)
+			if err != nil {
+				return nil, errors.New("missing public key of a segwit v0 address")
+			}
+			pubKeyBytes = pubKey.SerializeCompressed()
+		}
+		if signed.MessageHash, err = calcInputMessageHash(toSign, 0, prevOutFetcher, txSigHashes, pubKeyBytes); err != nil {
+			return nil, err
+		}
+		return signed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if format == MessageFormatSimple {
+		err = writeWitness(&buf, toSign.TxIn[0].Witness)
+	} else {
+		err = toSign.Serialize(&buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signed.Signature = base64.StdEncoding.EncodeToString(buf.Bytes())
+	return signed, nil
+}
+
+// bip137Header is the header byte of a compact signature by the address
+// type of pkScript, before adding the recovery id.
+func bip137Header(pkScript []byte, compressed bool) (byte, error) {
+	switch {
+	case txscript.IsPayToPubKeyHash(pkScript) && !compressed:
+		return 27, nil
+	case !compressed:
+		return 0, errors.New("segwit addresses need a compressed public key")
+	case txscript.IsPayToPubKeyHash(pkScript):
+		return 31, nil
+	case txscript.IsPayToScriptHash(pkScript):
+		return 35, nil
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		return 39, nil
+	}
+	return 0, errors.New("unsupported address type")
+}
+
+// compactSignature is the legacy 65 byte signature of hash from r and s,
+// the recovery id is the one giving pubKey back.
+func compactSignature(hash []byte, signature string, pubKey string) ([]byte, error) {
+	rs, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) != 64 {
+		return nil, errors.New("signature must be the 64 bytes of r and s")
+	}
+	publicKey, err := ParsePubKey(pubKey)
+	if err != nil {
+		return nil, errors.New("missing public key")
+	}
+	// a hex uncompressed public key is 130 characters long
+	header := byte(27)
+	if len(pubKey) != 130 {
+		header += 4
+	}
+	for recoveryId := byte(0); recoveryId < 4; recoveryId++ {
+		compact := append([]byte{header + recoveryId}, rs...)
+		recovered, _, err := ecdsa.RecoverCompact(compact, hash)
+		if err == nil && recovered.IsEqual(publicKey) {
+			return compact, nil
+		}
+	}
+	return nil, errors.New("the signature does not match the public key")
+}
+
+func writeWitness(buf *bytes.Buffer, witness wire.TxWitness) error {
+	if err := wire.WriteVarInt(buf, 0, uint64(len(witness))); err != nil {
+		return err
+	}
+	for _, item := range witness {
+		if err := wire.WriteVarBytes(buf, 0, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyMessage checks the base64 signature of message by address, in any
+// of the message formats. A nil error means the signature is valid.
+func VerifyMessage(network *chaincfg.Params, address string, message string, signature string) error {
+	pkScript, err := AddrToPkScript(address, network)
+	if err != nil {
+		return err
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	if len(signatureBytes) == 65 && signatureBytes[0] >= 27 && signatureBytes[0] <= 42 {
+		return verifyLegacyMessage(network, address, message, signatureBytes)
+	}
+
+	toSpend, err := Bip322ToSpend(pkScript, message)
+	if err != nil {
+		return err
+	}
+	toSign := Bip322ToSign(toSpend)
+	if witness, err := readWitness(signatureBytes); err == nil && len(witness) > 0 && witnessSize(witness) == len(signatureBytes) {
+		toSign.TxIn[0].Witness = witness
+	} else {
+		full := wire.NewMsgTx(0)
+		if err = full.Deserialize(bytes.NewReader(signatureBytes)); err != nil {
+			return errors.New("the signature is neither a witness nor a to_sign tx")
+		}
+		if len(full.TxIn) != 1 || full.TxIn[0].PreviousOutPoint != toSign.TxIn[0].PreviousOutPoint {
+			return errors.New("to_sign does not spend to_spend")
+		}
+		if len(full.TxOut) != 1 || full.TxOut[0].Value != 0 || !bytes.Equal(full.TxOut[0].PkScript, toSign.TxOut[0].PkScript) {
+			return errors.New("to_sign must have a single empty OP_RETURN output")
+		}
+		toSign = full
+	}
+
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	prevOutFetcher.AddPrevOut(toSign.TxIn[0].PreviousOutPoint, toSpend.TxOut[0])
+	vm, err := txscript.NewEngine(pkScript, toSign, 0, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(toSign, prevOutFetcher), 0, prevOutFetcher)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+// witnessSize is the serialized size of witness.
+func witnessSize(witness wire.TxWitness) int {
+	size := wire.VarIntSerializeSize(uint64(len(witness)))
+	for _, item := range witness {
+		size += wire.VarIntSerializeSize(uint64(len(item))) + len(item)
+	}
+	return size
+}
+
+// RecoverMessagePubKey is the hex public key of the legacy base64
+// signature of message, compressed unless the BIP137 header says otherwise.
+func RecoverMessagePubKey(message string, signature string) (string, error) {
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", err
+	}
+	pubKey, compressed, err := recoverMessagePubKey(message, signatureBytes)
+	if err != nil {
+		return "", err
+	}
+	if !compressed {
+		return hex.EncodeToString(pubKey.SerializeUncompressed()), nil
+	}
+	return hex.EncodeToString(pubKey.SerializeCompressed()), nil
+}
+
+func recoverMessagePubKey(message string, signature []byte) (*btcec.PublicKey, bool, error) {
+	if len(signature) != 65 || signature[0] < 27 || signature[0] > 42 {
+		return nil, false, errors.New("invalid compact signature")
+	}
+	// RecoverCompact only knows the P2PKH headers
+	compact := append([]byte{27 + (signature[0]-27)%4}, signature[1:]...)
+	if signature[0] >= 31 {
+		compact[0] += 4
+	}
+	return ecdsa.RecoverCompact(compact, LegacyMessageHash(message))
+}
+
+// verifyLegacyMessage checks the address type of the BIP137 header too, but
+// like Electrum the P2PKH compressed headers are accepted for segwit
+// addresses of the same key.
+func verifyLegacyMessage(network *chaincfg.Params, address string, message string, signature []byte) error {
+	pubKey, compressed, err := recoverMessagePubKey(message, signature)
+	if err != nil {
+		return err
+	}
+	var addrTypes []string
+	switch {
+	case !compressed:
+		return verifyMessageAddress(network, address, pubKey.SerializeUncompressed(), LEGACY)
+	case signature[0] >= 39:
+		addrTypes = []string{SEGWIT_NATIVE}
+	case signature[0] >= 35:
+		addrTypes = []string{SEGWIT_NESTED}
+	default:
+		addrTypes = []string{LEGACY, SEGWIT_NESTED, SEGWIT_NATIVE}
+	}
+	for _, addrType := range addrTypes {
+		if verifyMessageAddress(network, address, pubKey.SerializeCompressed(), addrType) == nil {
+			return nil
+		}
+	}
+	return errors.New("the signature is not from the address")
+}
+
+func verifyMessageAddress(network *chaincfg.Params, address string, pubKey []byte, addrType string) error {
+	recovered, err := PubKeyToAddr(pubKey, addrType, network)
+	if err != nil {
+		return err
+	}
+	if recovered != address {
+		return errors.New("the signature is not from the address")
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package bitcoin
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBip322Vectors(t *testing.T) {
+	network := &chaincfg.MainNetParams
+	address := "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+
+	assert.Equal(t, "c90c269c4f8fcbe6880f72a721ddfbf1914268a794cbb21cfafee13770ae19f1", hex.EncodeToString(Bip322MessageHash("")))
+	assert.Equal(t, "f0eb03b1a75ac6d9847f55c624a99169b5dccba2a31f5b23bea77ba270de0a7a", hex.EncodeToString(Bip322MessageHash("Hello World")))
+
+	pkScript, err := AddrToPkScript(address, network)
+	require.Nil(t, err)
+	for message, txIds := range map[string][2]string{
+		"":            {"c5680aa69bb8d860bf82d4e9cd3504b55dde018de765a91bb566283c545a99a7", "1e9654e951a5ba44c8604c4de6c67fd78a27e81dcadcfe1edf638ba3aaebaed6"},
+		"Hello World": {"b79d196740ad5217771c1098fc4a4b51e0535c32236c71f1ea4d61a2d603352b", "88737ae86f2077145f93cc4b153ae9a1cb8d56afa511988c149c5c8c9d93bddf"},
+	} {
+		toSpend, err := Bip322ToSpend(pkScript, message)
+		require.Nil(t, err)
+		assert.Equal(t, txIds[0], toSpend.TxHash().String())
+		assert.Equal(t, txIds[1], Bip322ToSign(toSpend).TxHash().String())
+	}
+
+	for _, vector := range []struct {
+		address, message, signature string
+	}{
+		{address, "", "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{address, "Hello World", "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="},
+		{address, "Hello World", "AkgwRQIhAOzyynlqt93lOKJr+wmmxIens//zPzl9tqIOua93wO6MAiBi5n5EyAcPScOjf1lAqIUIQtr3zKNeavYabHyR8eGhowEhAsfxIAMZZEKUPYWI4BruhAQjzFT8FSFSajuFwrDL1Yhy"},
+		{"bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3", "Hello World", "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VSHM7aU0SDbak5IUZRVno2P5mjSafAQ=="},
+	} {
+		assert.Nil(t, VerifyMessage(network, vector.address, vector.message, vector.signature), vector.signature)
+	}
+	assert.NotNil(t, VerifyMessage(network, address, "Hello World", "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRlEylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJqO4XCsMvViHI="))
+
+	signed, err := SignMessage(network, &SignMessageRequest{Address: address, Message: "Hello World", PrivateKey: "L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k"})
+	require.Nil(t, err)
+	assert.Equal(t, MessageFormatSimple, signed.Format)
+	assert.Equal(t, "AkgwRQIhAOzyynlqt93lOKJr+wmmxIens//zPzl9tqIOua93wO6MAiBi5n5EyAcPScOjf1lAqIUIQtr3zKNeavYabHyR8eGhowEhAsfxIAMZZEKUPYWI4BruhAQjzFT8FSFSajuFwrDL1Yhy", signed.Signature)
+}
+
+func TestSignMessage(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	pubKey := hex.EncodeToString(privateKeyWif.PrivKey.PubKey().SerializeCompressed())
+	message := "sign in to the marketplace"
+
+	for _, test := range []struct {
+		address, format, wantFormat string
+	}{
+		{"tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", "", MessageFormatSimple},
+		{"tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", MessageFormatFull, MessageFormatFull},
+		{"tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", "", MessageFormatSimple},
+		{"2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", "", MessageFormatFull},
+		{"mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", "", MessageFormatLegacy},
+		{"mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", MessageFormatFull, MessageFormatFull},
+	} {
+		request := &SignMessageRequest{Address: test.address, Message: message, Format: test.format, PrivateKey: privateKey}
+		signed, err := SignMessage(network, request)
+		require.Nil(t, err, test.address)
+		assert.Equal(t, test.wantFormat, signed.Format)
+		assert.Nil(t, VerifyMessage(network, test.address, message, signed.Signature), test.address)
+		assert.NotNil(t, VerifyMessage(network, test.address, message+".", signed.Signature), test.address)
+
+		// the same signature from the message hash
+		request.PrivateKey = ""
+		request.PublicKey = pubKey
+		unsigned, err := SignMessage(network, request)
+		require.Nil(t, err)
+		hash, err := hexutil.Decode(unsigned.MessageHash)
+		require.Nil(t, err)
+		if test.address[:4] == "tb1p" {
+			signature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*privateKeyWif.PrivKey, nil), hash)
+			require.Nil(t, err)
+			request.Signature = hex.EncodeToString(signature.Serialize())
+		} else {
+			compact, err := ecdsa.SignCompact(privateKeyWif.PrivKey, hash, true)
+			require.Nil(t, err)
+			request.Signature = hex.EncodeToString(compact[1:])
+		}
+		external, err := SignMessage(network, request)
+		require.Nil(t, err)
+		assert.Equal(t, signed.Signature, external.Signature, test.address)
+	}
+
+	for _, request := range []*SignMessageRequest{
+		{Address: "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", Message: message, Format: MessageFormatSimple, PrivateKey: privateKey},
+		{Address: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", Message: message, Format: MessageFormatLegacy, PrivateKey: privateKey},
+		{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Message: message, Format: "other", PrivateKey: privateKey},
+		{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Message: message},
+	} {
+		_, err := SignMessage(network, request)
+		assert.NotNil(t, err, request.Format)
+	}
+}
+
+func TestBip137(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	pubKey := "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+	message := "deposit address verification"
+
+	signatures := map[string][]byte{}
+	for address, header := range map[string]byte{
+		"mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE":         31,
+		"2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc":        35,
+		"tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc": 39,
+	} {
+		signed, err := SignMessage(network, &SignMessageRequest{Address: address, Message: message, Format: MessageFormatLegacy, PrivateKey: privateKey})
+		require.Nil(t, err, address)
+		signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+		require.Nil(t, err)
+		require.Len(t, signature, 65)
+		assert.True(t, signature[0] >= header && signature[0] < header+4, address)
+		signatures[address] = signature
+		assert.Nil(t, VerifyMessage(network, address, message, signed.Signature), address)
+		assert.NotNil(t, VerifyMessage(network, address, message+".", signed.Signature), address)
+
+		recovered, err := RecoverMessagePubKey(message, signed.Signature)
+		require.Nil(t, err)
+		assert.Equal(t, pubKey, recovered)
+
+		// hash out, signature in
+		unsigned, err := SignMessage(network, &SignMessageRequest{Address: address, Message: message, Format: MessageFormatLegacy})
+		require.Nil(t, err)
+		assert.Equal(t, hexutil.Encode(LegacyMessageHash(message)), unsigned.MessageHash)
+		external, err := SignMessage(network, &SignMessageRequest{Address: address, Message: message, Format: MessageFormatLegacy,
+			PublicKey: pubKey, Signature: hex.EncodeToString(signature[1:])})
+		require.Nil(t, err)
+		assert.Equal(t, signed.Signature, external.Signature)
+	}
+
+	// the segwit headers are bound to their address type, the P2PKH one is
+	// accepted for all of them
+	p2pkh := base64.StdEncoding.EncodeToString(signatures["mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE"])
+	assert.Nil(t, VerifyMessage(network, "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", message, p2pkh))
+	assert.Nil(t, VerifyMessage(network, "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", message, p2pkh))
+	p2wpkh := base64.StdEncoding.EncodeToString(signatures["tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"])
+	assert.NotNil(t, VerifyMessage(network, "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", message, p2wpkh))
+	assert.NotNil(t, VerifyMessage(network, "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", message, p2wpkh))
+
+	// an uncompressed key signs with the 27 to 30 headers
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	uncompressedWif, err := btcutil.NewWIF(privateKeyWif.PrivKey, network, false)
+	require.Nil(t, err)
+	uncompressedPubKey := privateKeyWif.PrivKey.PubKey().SerializeUncompressed()
+	address, err := PubKeyToAddr(uncompressedPubKey, LEGACY, network)
+	require.Nil(t, err)
+	signed, err := SignMessage(network, &SignMessageRequest{Address: address, Message: message, PrivateKey: uncompressedWif.String()})
+	require.Nil(t, err)
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	require.Nil(t, err)
+	assert.True(t, signature[0] >= 27 && signature[0] < 31)
+	assert.Nil(t, VerifyMessage(network, address, message, signed.Signature))
+	recovered, err := RecoverMessagePubKey(message, signed.Signature)
+	require.Nil(t, err)
+	assert.Equal(t, hex.EncodeToString(uncompressedPubKey), recovered)
+	_, err = SignMessage(network, &SignMessageRequest{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Message: message,
+		Format: MessageFormatLegacy, PrivateKey: uncompressedWif.String()})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,178 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	MainNet  = "mainnet"
+	TestNet3 = "testnet3"
+	TestNet4 = "testnet4"
+	SigNet   = "signet"
+	RegTest  = "regtest"
+	SimNet   = "simnet"
+)
+
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// TestNet4Params defines the network parameters for the BIP94 test network.
+// Only the fields relevant to address encoding and tx building are meaningful,
+// the consensus fields are inherited from testnet3.
+var TestNet4Params = func() chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = TestNet4
+	params.Net = wire.BitcoinNet(0x283f161c)
+	params.DefaultPort = "48333"
+	params.DNSSeeds = []chaincfg.DNSSeed{
+		{Host: "seed.testnet4.bitcoin.sprovoost.nl", HasFiltering: true},
+		{Host: "seed.testnet4.wiz.biz", HasFiltering: true},
+	}
+	params.GenesisBlock = nil
+	params.GenesisHash, _ = chainhash.NewHashFromStr("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043")
+	params.Checkpoints = nil
+	return params
+}()
+
+// NetworkConfig describes an operator defined network, loaded from the
+// network config file.
+type NetworkConfig struct {
+	Name             string `json:"name"`
+	Bech32HRPSegwit  string `json:"bech32HRPSegwit"`
+	PubKeyHashAddrID byte   `json:"pubKeyHashAddrID"`
+	ScriptHashAddrID byte   `json:"scriptHashAddrID"`
+	PrivateKeyID     byte   `json:"privateKeyID"`
+	// SignetChallenge is the hex encoded block challenge script, when set
+	// the network is a custom signet.
+	SignetChallenge string `json:"signetChallenge"`
+	DustLimit       int64  `json:"dustLimit"`
+}
+
+type network struct {
+	params    *chaincfg.Params
+	dustLimit int64
+}
+
+var (
+	networksMu sync.RWMutex
+	networks   = map[string]*network{
+		MainNet:  {params: &chaincfg.MainNetParams, dustLimit: DefaultMinChangeValue},
+		TestNet3: {params: &chaincfg.TestNet3Params, dustLimit: DefaultMinChangeValue},
+		TestNet4: {params: &TestNet4Params, dustLimit: DefaultMinChangeValue},
+		SigNet:   {params: &chaincfg.SigNetParams, dustLimit: DefaultMinChangeValue},
+		RegTest:  {params: &chaincfg.RegressionNetParams, dustLimit: DefaultMinChangeValue},
+		SimNet:   {params: &chaincfg.SimNetParams, dustLimit: DefaultMinChangeValue},
+	}
+)
+
+func init() {
+	// signet and testnet4 are not registered by chaincfg, their address
+	// prefixes overlap with testnet3 but the net magic has to be known.
+	_ = chaincfg.Register(&TestNet4Params)
+	_ = chaincfg.Register(&chaincfg.SigNetParams)
+}
+
+// GetNetParams resolves a network name to its chain parameters, it never
+// returns nil params without an error.
+func GetNetParams(name string) (*chaincfg.Params, error) {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+	n, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownNetwork, name)
+	}
+	return n.params, nil
+}
+
+// GetDustLimit returns the dust policy of the network, falling back to
+// DefaultMinChangeValue for params that were never registered.
+func GetDustLimit(params *chaincfg.Params) int64 {
+	networksMu.RLock()
+	defer networksMu.RUnlock()
+	if params == nil {
+		return DefaultMinChangeValue
+	}
+	for _, n := range networks {
+		if n.params.Net == params.Net {
+			return n.dustLimit
+		}
+	}
+	return DefaultMinChangeValue
+}
+
+// RegisterNetwork adds an operator defined network, making it resolvable by
+// GetNetParams and its addresses decodable by btcutil.
+func RegisterNetwork(config *NetworkConfig) error {
+	if config.Name == "" {
+		return errors.New("network name is empty")
+	}
+	if config.Bech32HRPSegwit == "" {
+		return fmt.Errorf("network %s: bech32HRPSegwit is empty", config.Name)
+	}
+	if config.PubKeyHashAddrID == config.ScriptHashAddrID {
+		return fmt.Errorf("network %s: pubKeyHashAddrID and scriptHashAddrID collide", config.Name)
+	}
+
+	var params chaincfg.Params
+	if config.SignetChallenge != "" {
+		challenge, err := hex.DecodeString(config.SignetChallenge)
+		if err != nil {
+			return fmt.Errorf("network %s: invalid signetChallenge: %w", config.Name, err)
+		}
+		params = chaincfg.CustomSignetParams(challenge, nil)
+	} else {
+		params = chaincfg.RegressionNetParams
+		hash := chainhash.DoubleHashB([]byte(config.Name))
+		params.Net = wire.BitcoinNet(binary.LittleEndian.Uint32(hash[0:4]))
+		params.DNSSeeds = nil
+	}
+	params.Name = config.Name
+	params.Bech32HRPSegwit = config.Bech32HRPSegwit
+	params.PubKeyHashAddrID = config.PubKeyHashAddrID
+	params.ScriptHashAddrID = config.ScriptHashAddrID
+	params.PrivateKeyID = config.PrivateKeyID
+
+	dustLimit := config.DustLimit
+	if dustLimit <= 0 {
+		dustLimit = DefaultMinChangeValue
+	}
+
+	networksMu.Lock()
+	defer networksMu.Unlock()
+	if _, ok := networks[config.Name]; ok {
+		return fmt.Errorf("network %s already exists", config.Name)
+	}
+	if err := chaincfg.Register(&params); err != nil {
+		return fmt.Errorf("network %s: %w", config.Name, err)
+	}
+	networks[config.Name] = &network{params: &params, dustLimit: dustLimit}
+	return nil
+}
+
+// LoadNetworkConfig registers every network of a JSON config file holding a
+// list of NetworkConfig.
+func LoadNetworkConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var configs []*NetworkConfig
+	if err = json.Unmarshal(data, &configs); err != nil {
+		return err
+	}
+	for _, config := range configs {
+		if err = RegisterNetwork(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetNetParams(t *testing.T) {
+	params, err := GetNetParams(TestNet4)
+	require.Nil(t, err)
+	assert.Equal(t, "tb", params.Bech32HRPSegwit)
+
+	params, err = GetNetParams(SigNet)
+	require.Nil(t, err)
+	assert.Equal(t, chaincfg.SigNetParams.Net, params.Net)
+
+	pkScript, err := AddrToPkScript("tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", params)
+	require.Nil(t, err)
+	assert.Len(t, pkScript, 34)
+
+	params, err = GetNetParams("testnet")
+	assert.Nil(t, params)
+	assert.True(t, errors.Is(err, ErrUnknownNetwork))
+}
+
+func TestLoadNetworkConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "networks.json")
+	config := `[
+		{"name":"qa","bech32HRPSegwit":"qa","pubKeyHashAddrID":50,"scriptHashAddrID":55,"privateKeyID":239,"dustLimit":330},
+		{"name":"qa-signet","bech32HRPSegwit":"tbs","pubKeyHashAddrID":111,"scriptHashAddrID":196,"privateKeyID":239,"signetChallenge":"51"}
+	]`
+	require.Nil(t, os.WriteFile(path, []byte(config), 0600))
+	require.Nil(t, LoadNetworkConfig(path))
+
+	params, err := GetNetParams("qa")
+	require.Nil(t, err)
+	assert.Equal(t, int64(330), GetDustLimit(params))
+
+	publicKey, _ := hex.DecodeString("0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f")
+	addr, err := PubKeyToAddr(publicKey, TAPROOT, params)
+	require.Nil(t, err)
+	assert.Equal(t, "qa1p", addr[:4])
+	_, err = AddrToPkScript(addr, params)
+	require.Nil(t, err)
+
+	params, err = GetNetParams("qa-signet")
+	require.Nil(t, err)
+	assert.Equal(t, DefaultMinChangeValue, GetDustLimit(params))
+	assert.NotEqual(t, chaincfg.SigNetParams.Net, params.Net)
+
+	assert.NotNil(t, LoadNetworkConfig(path))
+}
@@ -0,0 +1,359 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// The BIP174 roles AnalyzePSBT tells to come next, in the order they work
+// on a psbt.
+const (
+	PSBTRoleUpdater   = "updater"
+	PSBTRoleSigner    = "signer"
+	PSBTRoleFinalizer = "finalizer"
+	PSBTRoleExtractor = "extractor"
+)
+
+var psbtRoles = []string{PSBTRoleUpdater, PSBTRoleSigner, PSBTRoleFinalizer, PSBTRoleExtractor}
+
+// DefaultMaxFeeRate is the fee rate in sat/vB above which AnalyzePSBT warns
+// by default.
+const DefaultMaxFeeRate = 1000
+
+// AnalyzePSBTRequest is the analysis of Psbt, base64 or hex encoded, with
+// a warning if its fee rate is above MaxFeeRate sat/vB.
+type AnalyzePSBTRequest struct {
+	Psbt       string `json:"psbt"`
+	MaxFeeRate int64  `json:"maxFeeRate"`
+}
+
+// PSBTMissing is what an input still needs: the public keys known by their
+// hash only, the public keys (or their hashes) left to sign, and the hash
+// of a missing redeem script or witness script.
+type PSBTMissing struct {
+	PubKeys       []string `json:"pubKeys,omitempty"`
+	Signatures    []string `json:"signatures,omitempty"`
+	RedeemScript  string   `json:"redeemScript,omitempty"`
+	WitnessScript string   `json:"witnessScript,omitempty"`
+}
+
+type AnalyzedPSBTInput struct {
+	HasUtxo bool         `json:"hasUtxo"`
+	IsFinal bool         `json:"isFinal"`
+	Missing *PSBTMissing `json:"missing,omitempty"`
+	Next    string       `json:"next"`
+}
+
+// AnalyzedPSBT is what Bitcoin Core's analyzepsbt tells: the role each
+// input and the psbt wait for, and once all the utxos are known the fee,
+// along with the size and fee rate of the final tx when its inputs can
+// be estimated.
+type AnalyzedPSBT struct {
+	Inputs           []*AnalyzedPSBTInput `json:"inputs"`
+	EstimatedVSize   int64                `json:"estimatedVSize,omitempty"`
+	EstimatedFeeRate float64              `json:"estimatedFeeRate,omitempty"`
+	Fee              int64                `json:"fee,omitempty"`
+	Next             string               `json:"next"`
+	Warnings         []string             `json:"warnings,omitempty"`
+}
+
+func AnalyzePSBT(request *AnalyzePSBTRequest) (*AnalyzedPSBT, error) {
+	p, _, err := decodePSBT(request.Psbt)
+	if err != nil {
+		return nil, err
+	}
+	maxFeeRate := request.MaxFeeRate
+	if maxFeeRate == 0 {
+		maxFeeRate = DefaultMaxFeeRate
+	}
+
+	analyzed := &AnalyzedPSBT{Next: PSBTRoleExtractor}
+	tx := p.UnsignedTx.Copy()
+	var inputValue int64
+	hasUtxos, estimable := true, true
+	for i := range p.Inputs {
+		in := analyzePSBTInput(p, i)
+		analyzed.Inputs = append(analyzed.Inputs, in)
+		if roleIndex(in.Next) < roleIndex(analyzed.Next) {
+			analyzed.Next = in.Next
+		}
+		analyzed.Warnings = append(analyzed.Warnings, psbtInputWarnings(p, i)...)
+		if !in.HasUtxo {
+			hasUtxos, estimable = false, false
+			continue
+		}
+		prevOut, _ := psbtPrevOutput(p, i)
+		inputValue += prevOut.Value
+		if estimable {
+			var ok bool
+			tx.TxIn[i].SignatureScript, tx.TxIn[i].Witness, ok = estimatePSBTInput(p, i)
+			estimable = estimable && ok
+		}
+	}
+	if !hasUtxos {
+		return analyzed, nil
+	}
+
+	for _, out := range tx.TxOut {
+		inputValue -= out.Value
+	}
+	if inputValue < 0 {
+		analyzed.Warnings = append(analyzed.Warnings, "the outputs spend more than the inputs")
+		return analyzed, nil
+	}
+	analyzed.Fee = inputValue
+	if estimable {
+		analyzed.EstimatedVSize = GetTxVirtualSize(btcutil.NewTx(tx))
+		analyzed.EstimatedFeeRate = math.Round(float64(analyzed.Fee)/float64(analyzed.EstimatedVSize)*100) / 100
+		if analyzed.EstimatedFeeRate > float64(maxFeeRate) {
+			analyzed.Warnings = append(analyzed.Warnings, fmt.Sprintf("the fee rate %.2f sat/vB is above %d sat/vB", analyzed.EstimatedFeeRate, maxFeeRate))
+		}
+	}
+	return analyzed, nil
+}
+
+func roleIndex(role string) int {
+	for i, r := range psbtRoles {
+		if r == role {
+			return i
+		}
+	}
+	return len(psbtRoles)
+}
+
+func analyzePSBTInput(p *psbt.Packet, i int) *AnalyzedPSBTInput {
+	in := &p.Inputs[i]
+	analyzed := &AnalyzedPSBTInput{Next: PSBTRoleUpdater}
+	prevOut, err := psbtPrevOutput(p, i)
+	if err != nil {
+		return analyzed
+	}
+	analyzed.HasUtxo = true
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		analyzed.IsFinal, analyzed.Next = true, PSBTRoleExtractor
+		return analyzed
+	}
+
+	inputScript, err := newPSBTInputScript(p, i)
+	if err != nil {
+		// the redeem script or witness script is missing or wrong
+		script := prevOut.PkScript
+		if txscript.IsPayToScriptHash(script) && in.RedeemScript == nil {
+			analyzed.Missing = &PSBTMissing{RedeemScript: hex.EncodeToString(script[2:22])}
+		} else if in.RedeemScript != nil {
+			script = in.RedeemScript
+		}
+		if txscript.IsPayToWitnessScriptHash(script) && in.WitnessScript == nil {
+			analyzed.Missing = &PSBTMissing{WitnessScript: hex.EncodeToString(script[2:])}
+		}
+		return analyzed
+	}
+	if missing := psbtInputMissingSignatures(in, inputScript); missing != nil {
+		analyzed.Missing, analyzed.Next = missing, PSBTRoleSigner
+		return analyzed
+	}
+	analyzed.Next = PSBTRoleFinalizer
+	return analyzed
+}
+
+// psbtInputMissingSignatures is nil once in has the signatures to be
+// finalized.
+func psbtInputMissingSignatures(in *psbt.PInput, inputScript *psbtInputScript) *PSBTMissing {
+	missing := &PSBTMissing{}
+	switch {
+	case inputScript.tapLeaf != nil:
+		if _, ok := tapscriptWitness(in); ok {
+			return nil
+		}
+		leafHash := inputScript.tapLeaf.TapHash()
+		keys, _ := tapscriptKeys(inputScript.tapLeaf.Script)
+		for _, key := range keys {
+			if !hasTaprootScriptSpendSig(in, &psbt.TaprootScriptSpendSig{XOnlyPubKey: key, LeafHash: leafHash[:]}) {
+				missing.Signatures = append(missing.Signatures, hex.EncodeToString(key))
+			}
+		}
+	case inputScript.taproot:
+		if in.TaprootKeySpendSig != nil {
+			return nil
+		}
+		key := inputScript.scriptCode[2:]
+		if in.TaprootInternalKey != nil {
+			key = in.TaprootInternalKey
+		}
+		missing.Signatures = append(missing.Signatures, hex.EncodeToString(key))
+	case txscript.IsPayToPubKeyHash(inputScript.scriptCode):
+		if len(in.PartialSigs) > 0 {
+			return nil
+		}
+		pubKeyHash := inputScript.scriptCode[3:23]
+		for _, derivation := range in.Bip32Derivation {
+			if bytes.Equal(btcutil.Hash160(derivation.PubKey), pubKeyHash) {
+				missing.Signatures = append(missing.Signatures, hex.EncodeToString(derivation.PubKey))
+				return missing
+			}
+		}
+		missing.PubKeys = append(missing.PubKeys, hex.EncodeToString(pubKeyHash))
+		missing.Signatures = append(missing.Signatures, hex.EncodeToString(pubKeyHash))
+	default:
+		required := 1
+		if _, threshold, err := txscript.CalcMultiSigStats(inputScript.scriptCode); err == nil {
+			required = threshold
+		}
+		if len(in.PartialSigs) >= required {
+			return nil
+		}
+		pushes, _ := txscript.PushedData(inputScript.scriptCode)
+		for _, push := range pushes {
+			if len(push) != 33 && len(push) != 65 {
+				continue
+			}
+			signed := false
+			for _, partialSig := range in.PartialSigs {
+				signed = signed || bytes.Equal(partialSig.PubKey, push)
+			}
+			if !signed {
+				missing.Signatures = append(missing.Signatures, hex.EncodeToString(push))
+			}
+		}
+	}
+	return missing
+}
+
+// psbtInputWarnings warns of a segwit v0 input the signer can't check the
+// amount of without its non witness utxo, and of a sighash type other than
+// SIGHASH_ALL.
+func psbtInputWarnings(p *psbt.Packet, i int) []string {
+	in := &p.Inputs[i]
+	var warnings []string
+	if in.FinalScriptSig == nil && in.FinalScriptWitness == nil && in.NonWitnessUtxo == nil && in.WitnessUtxo != nil {
+		script := in.WitnessUtxo.PkScript
+		if txscript.IsPayToScriptHash(script) && in.RedeemScript != nil {
+			script = in.RedeemScript
+		}
+		if txscript.IsWitnessProgram(script) && !txscript.IsPayToTaproot(script) {
+			warnings = append(warnings, fmt.Sprintf("input %d: segwit v0 input without its non witness utxo, its amount can't be verified", i))
+		}
+	}
+
+	hashTypes := []txscript.SigHashType{in.SighashType}
+	for _, partialSig := range in.PartialSigs {
+		if len(partialSig.Signature) > 0 {
+			hashTypes = append(hashTypes, txscript.SigHashType(partialSig.Signature[len(partialSig.Signature)-1]))
+		}
+	}
+	if len(in.TaprootKeySpendSig) == 65 {
+		hashTypes = append(hashTypes, txscript.SigHashType(in.TaprootKeySpendSig[64]))
+	}
+	for _, scriptSpendSig := range in.TaprootScriptSpendSig {
+		hashTypes = append(hashTypes, scriptSpendSig.SigHash)
+	}
+	for _, hashType := range hashTypes {
+		if hashType != txscript.SigHashDefault && hashType != txscript.SigHashAll {
+			warnings = append(warnings, fmt.Sprintf("input %d: sighash type 0x%02x is not SIGHASH_ALL", i, byte(hashType)))
+			break
+		}
+	}
+	return warnings
+}
+
+// estimatePSBTInput is the signature script and witness of input i once
+// finalized, with placeholder signatures when it is not yet.
+func estimatePSBTInput(p *psbt.Packet, i int) ([]byte, wire.TxWitness, bool) {
+	in := &p.Inputs[i]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		witness, err := parseWitness(in.FinalScriptWitness)
+		return in.FinalScriptSig, witness, err == nil
+	}
+	inputScript, err := newPSBTInputScript(p, i)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	// a SIGHASH_DEFAULT schnorr signature has no sighash type byte
+	schnorrSigSize := schnorr.SignatureSize + 1
+	if inputScript.hashType == txscript.SigHashDefault {
+		schnorrSigSize = schnorr.SignatureSize
+	}
+	if inputScript.tapLeaf != nil {
+		var witness wire.TxWitness
+		keys, threshold := tapscriptKeys(inputScript.tapLeaf.Script)
+		for j := range keys {
+			if j < threshold {
+				witness = append(witness, make([]byte, schnorrSigSize))
+			} else {
+				witness = append(witness, []byte{})
+			}
+		}
+		return nil, append(witness, inputScript.tapLeaf.Script, in.TaprootLeafScript[0].ControlBlock), true
+	} else if inputScript.taproot {
+		return nil, wire.TxWitness{make([]byte, schnorrSigSize)}, true
+	}
+
+	var stack [][]byte
+	_, threshold, multisigErr := txscript.CalcMultiSigStats(inputScript.scriptCode)
+	switch {
+	case txscript.IsPayToPubKeyHash(inputScript.scriptCode):
+		stack = [][]byte{make([]byte, 72), make([]byte, 33)}
+	case multisigErr == nil:
+		stack = [][]byte{{}}
+		for j := 0; j < threshold; j++ {
+			stack = append(stack, make([]byte, 72))
+		}
+	case txscript.IsPayToPubKey(inputScript.scriptCode):
+		stack = [][]byte{make([]byte, 72)}
+	default:
+		return nil, nil, false
+	}
+
+	builder := txscript.NewScriptBuilder()
+	var witness wire.TxWitness
+	if inputScript.witness {
+		witness = stack
+		if in.WitnessScript != nil {
+			witness = append(witness, in.WitnessScript)
+		}
+	} else {
+		for _, item := range stack {
+			builder.AddData(item)
+		}
+	}
+	if in.RedeemScript != nil {
+		builder.AddData(in.RedeemScript)
+	}
+	sigScript, err := builder.Script()
+	if err != nil {
+		return nil, nil, false
+	}
+	return sigScript, witness, true
+}
+
+// parseWitness parses a serialized witness stack, the PSBT_IN_FINAL_SCRIPTWITNESS
+// value.
+func parseWitness(serialized []byte) (wire.TxWitness, error) {
+	if len(serialized) == 0 {
+		return nil, nil
+	}
+	r := bytes.NewReader(serialized)
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(serialized)) {
+		return nil, psbt.ErrInvalidPsbtFormat
+	}
+	witness := make(wire.TxWitness, count)
+	for j := range witness {
+		if witness[j], err = wire.ReadVarBytes(r, 0, uint32(len(serialized)), "witness item"); err != nil {
+			return nil, err
+		}
+	}
+	return witness, nil
+}
@@ -0,0 +1,242 @@
+package bitcoin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzePSBT(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	pubKey := "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+	addresses := []string{
+		"tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		"tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		"2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc",
+		"mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE",
+	}
+
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+	for _, address := range addresses {
+		pkScript, err := AddrToPkScript(address, network)
+		require.Nil(t, err)
+		prevTx.AddTxOut(wire.NewTxOut(10000, pkScript))
+	}
+	prevTxHex, err := GetTxHex(prevTx)
+	require.Nil(t, err)
+	var inputs []*TxInput
+	for i := range addresses {
+		inputs = append(inputs, &TxInput{TxId: prevTx.TxHash().String(), VOut: uint32(i)})
+	}
+
+	created, err := CreatePSBT(network, &CreatePSBTRequest{Inputs: inputs, Outputs: []*TxOutput{{Address: addresses[0], Amount: 39000}}})
+	require.Nil(t, err)
+	analyzed, err := AnalyzePSBT(&AnalyzePSBTRequest{Psbt: created.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, PSBTRoleUpdater, analyzed.Next)
+	assert.False(t, analyzed.Inputs[0].HasUtxo)
+	assert.Zero(t, analyzed.Fee)
+	assert.Zero(t, analyzed.EstimatedVSize)
+
+	derivation := []*PSBTBip32Derivation{{PublicKey: pubKey, MasterFingerprint: 0x12345678, DerivationPath: "m/84'/1'/0'/0/0"}}
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt: created.Psbt,
+		Inputs: []*PSBTInputUpdate{
+			{Index: 0, Address: addresses[0], Amount: 10000},
+			{Index: 1, NonWitnessUtxo: prevTxHex, Bip32Derivation: derivation},
+			{Index: 2, Address: addresses[2], Amount: 10000, Bip32Derivation: derivation},
+			{Index: 3, NonWitnessUtxo: prevTxHex},
+		},
+	})
+	require.Nil(t, err)
+	analyzed, err = AnalyzePSBT(&AnalyzePSBTRequest{Psbt: updated.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, PSBTRoleSigner, analyzed.Next)
+	assert.Equal(t, int64(1000), analyzed.Fee)
+	// the internal key of the taproot input is unknown, its output key is told
+	assert.Equal(t, []string{"b7ee7f83a6a7fdb513040856c56778aa3abea9a451e0c9bb012f22a77ed99b21"}, analyzed.Inputs[0].Missing.Signatures)
+	assert.Equal(t, []string{pubKey}, analyzed.Inputs[1].Missing.Signatures)
+	// the key of a P2PKH input without derivation is known by its hash only
+	assert.Equal(t, []string{"5c005c5532ce810ddf20f9d1d939631b47089ecd"}, analyzed.Inputs[3].Missing.PubKeys)
+	assert.Equal(t, []string{"input 2: segwit v0 input without its non witness utxo, its amount can't be verified"}, analyzed.Warnings)
+	estimatedVSize := analyzed.EstimatedVSize
+
+	signed, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{privateKey}})
+	require.Nil(t, err)
+	analyzed, err = AnalyzePSBT(&AnalyzePSBTRequest{Psbt: signed.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, PSBTRoleFinalizer, analyzed.Next)
+	for _, in := range analyzed.Inputs {
+		assert.Nil(t, in.Missing)
+	}
+
+	finalized, err := FinalizePSBT(signed.Psbt)
+	require.Nil(t, err)
+	analyzed, err = AnalyzePSBT(&AnalyzePSBTRequest{Psbt: finalized.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, PSBTRoleExtractor, analyzed.Next)
+	assert.True(t, analyzed.Inputs[0].IsFinal)
+	assert.Empty(t, analyzed.Warnings)
+	txHex, err := ExtractPSBT(finalized.Psbt)
+	require.Nil(t, err)
+	tx, err := NewTxFromHex(txHex)
+	require.Nil(t, err)
+	vSize := GetTxVirtualSize(btcutil.NewTx(tx))
+	assert.Equal(t, vSize, analyzed.EstimatedVSize)
+	assert.InDelta(t, vSize, estimatedVSize, 3)
+	assert.InDelta(t, float64(1000)/float64(vSize), analyzed.EstimatedFeeRate, 0.01)
+
+	t.Run("warnings", func(t *testing.T) {
+		analyzed, err := AnalyzePSBT(&AnalyzePSBTRequest{Psbt: finalized.Psbt, MaxFeeRate: 1})
+		require.Nil(t, err)
+		require.Len(t, analyzed.Warnings, 1)
+		assert.Contains(t, analyzed.Warnings[0], "above 1 sat/vB")
+
+		single, err := UpdatePSBT(network, &UpdatePSBTRequest{
+			Psbt:   updated.Psbt,
+			Inputs: []*PSBTInputUpdate{{Index: 1, SighashType: uint32(txscript.SigHashSingle | txscript.SigHashAnyOneCanPay)}},
+		})
+		require.Nil(t, err)
+		analyzed, err = AnalyzePSBT(&AnalyzePSBTRequest{Psbt: single.Psbt})
+		require.Nil(t, err)
+		assert.Contains(t, analyzed.Warnings, "input 1: sighash type 0x83 is not SIGHASH_ALL")
+
+		other, err := CreatePSBT(network, &CreatePSBTRequest{Inputs: inputs[1:2], Outputs: []*TxOutput{{Address: addresses[1], Amount: 20000}}})
+		require.Nil(t, err)
+		other, err = UpdatePSBT(network, &UpdatePSBTRequest{Psbt: other.Psbt, Inputs: []*PSBTInputUpdate{{Index: 0, NonWitnessUtxo: prevTxHex}}})
+		require.Nil(t, err)
+		analyzed, err = AnalyzePSBT(&AnalyzePSBTRequest{Psbt: other.Psbt})
+		require.Nil(t, err)
+		assert.Zero(t, analyzed.Fee)
+		assert.Equal(t, []string{"the outputs spend more than the inputs"}, analyzed.Warnings)
+	})
+}
+
+func TestAnalyzePSBTMultisig(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	var wifs []*btcutil.WIF
+	var pubKeys []*btcutil.AddressPubKey
+	for _, seed := range []string{"cosigner a", "cosigner b", "cosigner c"} {
+		hash := sha256.Sum256([]byte(seed))
+		privKey, _ := btcec.PrivKeyFromBytes(hash[:])
+		wif, err := btcutil.NewWIF(privKey, network, true)
+		require.Nil(t, err)
+		wifs = append(wifs, wif)
+		pubKey, err := btcutil.NewAddressPubKey(wif.SerializePubKey(), network)
+		require.Nil(t, err)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	witnessScript, err := txscript.MultiSigScript(pubKeys, 2)
+	require.Nil(t, err)
+	scriptHash := sha256.Sum256(witnessScript)
+	address, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], network)
+	require.Nil(t, err)
+
+	created, err := CreatePSBT(network, &CreatePSBTRequest{
+		Inputs:  []*TxInput{{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5"}},
+		Outputs: []*TxOutput{{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 9000}},
+	})
+	require.Nil(t, err)
+	withoutScript, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt:   created.Psbt,
+		Inputs: []*PSBTInputUpdate{{Index: 0, Address: address.EncodeAddress(), Amount: 10000}},
+	})
+	require.Nil(t, err)
+	analyzed, err := AnalyzePSBT(&AnalyzePSBTRequest{Psbt: withoutScript.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, PSBTRoleUpdater, analyzed.Next)
+	assert.True(t, analyzed.Inputs[0].HasUtxo)
+	assert.Equal(t, hex.EncodeToString(scriptHash[:]), analyzed.Inputs[0].Missing.WitnessScript)
+	assert.Equal(t, int64(1000), analyzed.Fee)
+	assert.Zero(t, analyzed.EstimatedVSize)
+
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt:   withoutScript.Psbt,
+		Inputs: []*PSBTInputUpdate{{Index: 0, WitnessScript: hex.EncodeToString(witnessScript)}},
+	})
+	require.Nil(t, err)
+	signed, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{wifs[1].String()}})
+	require.Nil(t, err)
+	analyzed, err = AnalyzePSBT(&AnalyzePSBTRequest{Psbt: signed.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, PSBTRoleSigner, analyzed.Next)
+	assert.Equal(t, []string{hex.EncodeToString(wifs[0].SerializePubKey()), hex.EncodeToString(wifs[2].SerializePubKey())}, analyzed.Inputs[0].Missing.Signatures)
+	estimatedVSize := analyzed.EstimatedVSize
+
+	signed, err = SignPSBT(&SignPSBTRequest{Psbt: signed.Psbt, PrivateKeys: []string{wifs[2].String()}})
+	require.Nil(t, err)
+	finalized, err := FinalizePSBT(signed.Psbt)
+	require.Nil(t, err)
+	txHex, err := ExtractPSBT(finalized.Psbt)
+	require.Nil(t, err)
+	tx, err := NewTxFromHex(txHex)
+	require.Nil(t, err)
+	assert.InDelta(t, GetTxVirtualSize(btcutil.NewTx(tx)), estimatedVSize, 1)
+}
+
+func TestAnalyzePSBTTapscript(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	hash := sha256.Sum256([]byte("tapscript"))
+	privKey, _ := btcec.PrivKeyFromBytes(hash[:])
+	wif, err := btcutil.NewWIF(privKey, network, true)
+	require.Nil(t, err)
+	leafScript, err := txscript.NewScriptBuilder().AddData(schnorr.SerializePubKey(privKey.PubKey())).AddOp(txscript.OP_CHECKSIG).Script()
+	require.Nil(t, err)
+	tree := txscript.AssembleTaprootScriptTree(txscript.NewBaseTapLeaf(leafScript))
+	proof := tree.LeafMerkleProofs[0].ToControlBlock(privKey.PubKey())
+	controlBlock, err := proof.ToBytes()
+	require.Nil(t, err)
+	rootHash := tree.RootNode.TapHash()
+	address, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootOutputKey(privKey.PubKey(), rootHash[:])), network)
+	require.Nil(t, err)
+
+	created, err := CreatePSBT(network, &CreatePSBTRequest{
+		Inputs:  []*TxInput{{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5"}},
+		Outputs: []*TxOutput{{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 9000}},
+	})
+	require.Nil(t, err)
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt: created.Psbt,
+		Inputs: []*PSBTInputUpdate{{
+			Index:               0,
+			Address:             address.EncodeAddress(),
+			Amount:              10000,
+			TaprootLeafScript:   hex.EncodeToString(leafScript),
+			TaprootControlBlock: hex.EncodeToString(controlBlock),
+		}},
+	})
+	require.Nil(t, err)
+	analyzed, err := AnalyzePSBT(&AnalyzePSBTRequest{Psbt: updated.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, PSBTRoleSigner, analyzed.Next)
+	estimatedVSize := analyzed.EstimatedVSize
+
+	signed, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{wif.String()}})
+	require.Nil(t, err)
+	finalized, err := FinalizePSBT(signed.Psbt)
+	require.Nil(t, err)
+	txHex, err := ExtractPSBT(finalized.Psbt)
+	require.Nil(t, err)
+	tx, err := NewTxFromHex(txHex)
+	require.Nil(t, err)
+	// a SIGHASH_DEFAULT signature is 64 bytes
+	require.Len(t, tx.TxIn[0].Witness[0], 64)
+	assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(tx)), estimatedVSize)
+	p, err := DecodePSBT(updated.Psbt)
+	require.Nil(t, err)
+	_, witness, ok := estimatePSBTInput(p, 0)
+	require.True(t, ok)
+	assert.Equal(t, tx.TxIn[0].Witness.SerializeSize(), witness.SerializeSize())
+}
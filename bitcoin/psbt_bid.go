@@ -0,0 +1,363 @@
+package bitcoin
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BidSigHashType commits the buyer to its inputs and all the outputs, the
+// holder accepting the bid adds the inscription input.
+const BidSigHashType = txscript.SigHashAll | txscript.SigHashAnyOneCanPay
+
+// BidInscriptionIndex is the placeholder input of a bid the holder replaces
+// with the inscription utxo, its first sats go to output 0.
+const BidInscriptionIndex = 0
+
+// BidRequest offers Price sats paid to SellerAddress for an inscription sent
+// to ReceiverAddress in an output of Postage sats, DefaultRevealOutValue
+// when zero, the inscription utxo being expected to hold as many. The bid
+// commits to SellerAddress, the holder accepting it is paid there. Inputs
+// pay the price and fee, the ones without PrivateKey are left to sign
+// externally and segwit v0 ones then need PublicKey.
+type BidRequest struct {
+	Inputs          []*TxInput `json:"inputs"`
+	Price           int64      `json:"price"`
+	SellerAddress   string     `json:"sellerAddress"`
+	ReceiverAddress string     `json:"receiverAddress"`
+	Postage         int64      `json:"postage"`
+	ChangeAddress   string     `json:"changeAddress"`
+	FeeRate         int64      `json:"feeRate"`
+}
+
+type BidPSBT struct {
+	// Psbt is base64 encoded, its buyer inputs are signed BidSigHashType.
+	Psbt string `json:"psbt"`
+	// Fee is estimated for a taproot inscription input.
+	Fee int64 `json:"fee"`
+	// MessageHashMap is the sighash of each buyer input left to sign, keyed
+	// by input index, see SignBidPSBTBySignature.
+	MessageHashMap map[int]string `json:"messageHashMap,omitempty"`
+}
+
+// BidExpectation is what a holder accepting a bid wants: Price sats paid to
+// Address for the inscription at InscriptionOffset of a utxo holding
+// InscriptionValue sats, not checked when zero.
+type BidExpectation struct {
+	Price             int64  `json:"price"`
+	Address           string `json:"address"`
+	InscriptionValue  int64  `json:"inscriptionValue"`
+	InscriptionOffset int64  `json:"inscriptionOffset"`
+}
+
+// AcceptBidRequest sells the inscription at InscriptionOffset of Input for
+// the bid in Psbt, once checked to pay Price to SellerAddress. Without
+// Input.PrivateKey the inscription input is left to sign externally.
+type AcceptBidRequest struct {
+	Psbt              string   `json:"psbt"`
+	Input             *TxInput `json:"input"`
+	InscriptionOffset int64    `json:"inscriptionOffset"`
+	Price             int64    `json:"price"`
+	SellerAddress     string   `json:"sellerAddress"`
+}
+
+type AcceptedBid struct {
+	TxHex string `json:"txHex,omitempty"`
+	// Psbt and MessageHash are returned when the inscription input is left
+	// to sign, see AcceptBidBySignature.
+	Psbt        string `json:"psbt,omitempty"`
+	MessageHash string `json:"messageHash,omitempty"`
+}
+
+// estimateBidFee sizes tx with placeholder signatures, the ones of the
+// buyer taproot inputs carry their sighash type.
+func estimateBidFee(tx *wire.MsgTx, prevOutFetcher *txscript.MultiPrevOutFetcher, feeRate int64) int64 {
+	tx = tx.Copy()
+	for i, in := range tx.TxIn {
+		prevPkScript := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint).PkScript
+		in.SignatureScript, in.Witness = dummySignature(prevPkScript)
+		if i != BidInscriptionIndex && txscript.IsPayToTaproot(prevPkScript) {
+			in.Witness = wire.TxWitness{make([]byte, 65)}
+		}
+	}
+	return GetTxVirtualSize(btcutil.NewTx(tx)) * feeRate
+}
+
+// GenerateBidPSBT builds the bid of request: the placeholder input at
+// BidInscriptionIndex, then request.Inputs, and the outputs paying the
+// postage to the receiver, the price to the seller and the change.
+func GenerateBidPSBT(network *chaincfg.Params, request *BidRequest) (*BidPSBT, error) {
+	if len(request.Inputs) == 0 {
+		return nil, errors.New("no bid input")
+	}
+	if request.Price <= 0 {
+		return nil, errors.New("missing price")
+	}
+	postage := DefaultRevealOutValue
+	if request.Postage > 0 {
+		postage = request.Postage
+	}
+	dustLimit := GetDustLimit(network)
+	if postage < dustLimit || request.Price < dustLimit {
+		return nil, errors.New("postage or price below the dust limit")
+	}
+	receiverPkScript, err := AddrToPkScript(request.ReceiverAddress, network)
+	if err != nil {
+		return nil, err
+	}
+	sellerPkScript, err := AddrToPkScript(request.SellerAddress, network)
+	if err != nil {
+		return nil, err
+	}
+	changePkScript, err := AddrToPkScript(request.ChangeAddress, network)
+	if err != nil {
+		return nil, err
+	}
+
+	placeholder := wire.NewTxOut(postage, listingPlaceholderPkScript)
+	prevOuts := map[wire.OutPoint]*wire.TxOut{{}: placeholder}
+	inputs := []*wire.OutPoint{{}}
+	nSequences := []uint32{wire.MaxTxInSequenceNum}
+	totalSenderAmount := int64(0)
+	for _, in := range request.Inputs {
+		txHash, err := chainhash.NewHashFromStr(in.TxId)
+		if err != nil {
+			return nil, err
+		}
+		prevPkScript, err := AddrToPkScript(in.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		outPoint := wire.NewOutPoint(txHash, in.VOut)
+		if _, ok := prevOuts[*outPoint]; ok {
+			return nil, errors.New("duplicate bid input")
+		}
+		inputs = append(inputs, outPoint)
+		nSequences = append(nSequences, wire.MaxTxInSequenceNum)
+		prevOuts[*outPoint] = wire.NewTxOut(in.Amount, prevPkScript)
+		totalSenderAmount += in.Amount
+	}
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+
+	outputs := []*wire.TxOut{
+		wire.NewTxOut(postage, receiverPkScript),
+		wire.NewTxOut(request.Price, sellerPkScript),
+		wire.NewTxOut(0, changePkScript),
+	}
+	p, err := psbt.New(inputs, outputs, int32(2), uint32(0), nSequences)
+	if err != nil {
+		return nil, err
+	}
+	// the inscription input brings the postage
+	fee := estimateBidFee(p.UnsignedTx, prevOutFetcher, request.FeeRate)
+	if change := totalSenderAmount - request.Price - fee; change >= dustLimit {
+		p.UnsignedTx.TxOut[2].Value = change
+	} else {
+		p.UnsignedTx.TxOut = p.UnsignedTx.TxOut[:2]
+		p.Outputs = p.Outputs[:2]
+		fee = estimateBidFee(p.UnsignedTx, prevOutFetcher, request.FeeRate)
+		if totalSenderAmount-request.Price-fee < 0 {
+			return nil, errors.New("insufficient balance")
+		}
+	}
+
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	if err = updater.AddInWitnessUtxo(placeholder, BidInscriptionIndex); err != nil {
+		return nil, err
+	}
+	bidPsbt := &BidPSBT{Fee: totalSenderAmount - request.Price, MessageHashMap: make(map[int]string)}
+	for _, out := range p.UnsignedTx.TxOut[2:] {
+		bidPsbt.Fee -= out.Value
+	}
+	for j, in := range request.Inputs {
+		i := j + 1
+		if in.PrivateKey != "" {
+			err = signInput(updater, i, in, prevOutFetcher, BidSigHashType, network)
+		} else if err = addInputUtxo(updater, i, in, BidSigHashType, network); err == nil {
+			bidPsbt.MessageHashMap[i], err = inputMessageHash(p.UnsignedTx, i, prevOutFetcher, in.PublicKey, BidSigHashType)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bidPsbt.Psbt, err = p.B64Encode(); err != nil {
+		return nil, err
+	}
+	return bidPsbt, nil
+}
+
+// SignBidPSBTBySignature adds to the bid the signatures of the message
+// hashes returned by GenerateBidPSBT, both keyed by input index: 64 byte
+// schnorr signatures for taproot inputs, r and s otherwise along with the
+// public key of pubKeys.
+func SignBidPSBTBySignature(bidPsbt string, signatureMap map[int]string, pubKeys map[int]string) (string, error) {
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(bidPsbt)), true)
+	if err != nil {
+		return "", err
+	}
+	for i, signature := range signatureMap {
+		if i == BidInscriptionIndex {
+			return "", errors.New("the inscription input is signed by the holder")
+		}
+		if err = addInputSignature(p, i, signature, pubKeys[i], BidSigHashType); err != nil {
+			return "", err
+		}
+	}
+	return p.B64Encode()
+}
+
+// CheckBidPSBT checks the base64 bid against expected before a holder
+// accepts it. It returns a *ListingCheckError.
+func CheckBidPSBT(network *chaincfg.Params, bidPsbt string, expected *BidExpectation) error {
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(bidPsbt)), true)
+	if err != nil {
+		return listingCheckError(ListingCheckInvalidPsbt, "%v", err)
+	}
+	return checkBidPacket(network, p, expected)
+}
+
+func checkBidPacket(network *chaincfg.Params, p *psbt.Packet, expected *BidExpectation) error {
+	if len(p.UnsignedTx.TxIn) < 2 || len(p.UnsignedTx.TxOut) < 2 {
+		return listingCheckError(ListingCheckInvalidPsbt, "a bid has at least 2 inputs and outputs")
+	}
+	postage := p.UnsignedTx.TxOut[0].Value
+	if expected.InscriptionOffset < 0 || expected.InscriptionOffset >= postage {
+		return listingCheckError(ListingCheckInscription, "offset %d is out of the %d sats of the bid output", expected.InscriptionOffset, postage)
+	}
+	if expected.InscriptionValue > 0 && expected.InscriptionValue < postage {
+		return listingCheckError(ListingCheckInscription, "the inscription utxo holds %d sats, the bid output %d", expected.InscriptionValue, postage)
+	}
+
+	output := p.UnsignedTx.TxOut[1]
+	if output.Value != expected.Price {
+		return listingCheckError(ListingCheckPrice, "bid output pays %d, expected %d", output.Value, expected.Price)
+	}
+	pkScript, err := AddrToPkScript(expected.Address, network)
+	if err != nil {
+		return listingCheckError(ListingCheckAddress, "%v", err)
+	}
+	if !bytes.Equal(output.PkScript, pkScript) {
+		return listingCheckError(ListingCheckAddress, "bid output does not pay %s", expected.Address)
+	}
+
+	inputValue := postage
+	for i := range p.UnsignedTx.TxIn {
+		if i == BidInscriptionIndex {
+			continue
+		}
+		prevOut, err := listingPrevOutput(p, i)
+		if err != nil {
+			return err
+		}
+		inputValue += prevOut.Value
+		if p.Inputs[i].SighashType != 0 && p.Inputs[i].SighashType != BidSigHashType {
+			return listingCheckError(ListingCheckSigHashType, "input %d psbt sighash type %#x, want ALL|ANYONECANPAY", i, uint32(p.Inputs[i].SighashType))
+		}
+		signature := listingSignature(p.Inputs[i])
+		if len(signature) == 0 {
+			return listingCheckError(ListingCheckMissingSignature, "bid input %d is not signed", i)
+		}
+		// a 64 byte schnorr signature is SIGHASH_DEFAULT
+		if (txscript.IsPayToTaproot(prevOut.PkScript) && len(signature) == 64) || txscript.SigHashType(signature[len(signature)-1]) != BidSigHashType {
+			return listingCheckError(ListingCheckSigHashType, "bid input %d signature is not ALL|ANYONECANPAY", i)
+		}
+		if err = verifyListingSignature(p, i, prevOut); err != nil {
+			return err
+		}
+	}
+	for _, out := range p.UnsignedTx.TxOut {
+		inputValue -= out.Value
+	}
+	if inputValue < 0 {
+		return listingCheckError(ListingCheckPrice, "bid inputs are %d sats short of the outputs", -inputValue)
+	}
+	return nil
+}
+
+// AcceptBid checks the bid of request, replaces its placeholder input with
+// the inscription utxo and signs it SIGHASH_ALL, the tx is extracted
+// unless the input is left to sign externally.
+func AcceptBid(network *chaincfg.Params, request *AcceptBidRequest) (*AcceptedBid, error) {
+	in := request.Input
+	if in == nil {
+		return nil, errors.New("missing inscription input")
+	}
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(request.Psbt)), true)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkBidPacket(network, p, &BidExpectation{
+		Price:             request.Price,
+		Address:           request.SellerAddress,
+		InscriptionValue:  in.Amount,
+		InscriptionOffset: request.InscriptionOffset,
+	}); err != nil {
+		return nil, err
+	}
+
+	txHash, err := chainhash.NewHashFromStr(in.TxId)
+	if err != nil {
+		return nil, err
+	}
+	prevPkScript, err := AddrToPkScript(in.Address, network)
+	if err != nil {
+		return nil, err
+	}
+	p.UnsignedTx.TxIn[BidInscriptionIndex].PreviousOutPoint = *wire.NewOutPoint(txHash, in.VOut)
+	p.Inputs[BidInscriptionIndex] = psbt.PInput{}
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, txIn := range p.UnsignedTx.TxIn {
+		prevOut := wire.NewTxOut(in.Amount, prevPkScript)
+		if i != BidInscriptionIndex {
+			if prevOut, err = listingPrevOutput(p, i); err != nil {
+				return nil, err
+			}
+		}
+		prevOutFetcher.AddPrevOut(txIn.PreviousOutPoint, prevOut)
+	}
+
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	accepted := &AcceptedBid{}
+	if in.PrivateKey == "" {
+		if err = addInputUtxo(updater, BidInscriptionIndex, in, txscript.SigHashAll, network); err != nil {
+			return nil, err
+		}
+		if accepted.MessageHash, err = inputMessageHash(p.UnsignedTx, BidInscriptionIndex, prevOutFetcher, in.PublicKey, txscript.SigHashAll); err != nil {
+			return nil, err
+		}
+		if accepted.Psbt, err = p.B64Encode(); err != nil {
+			return nil, err
+		}
+		return accepted, nil
+	}
+	if err = signInput(updater, BidInscriptionIndex, in, prevOutFetcher, txscript.SigHashAll, network); err != nil {
+		return nil, err
+	}
+	if accepted.TxHex, err = extractBySignatures(p, nil, nil, txscript.SigHashAll); err != nil {
+		return nil, err
+	}
+	return accepted, nil
+}
+
+// AcceptBidBySignature adds the signature of the message hash returned by
+// AcceptBid, with the public key of a non taproot inscription input, and
+// extracts the tx.
+func AcceptBidBySignature(acceptedPsbt string, signature string, pubKey string) (string, error) {
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(acceptedPsbt)), true)
+	if err != nil {
+		return "", err
+	}
+	return extractBySignatures(p, map[int]string{BidInscriptionIndex: signature}, map[int]string{BidInscriptionIndex: pubKey}, txscript.SigHashAll)
+}
@@ -0,0 +1,136 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBidPSBT(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	segwitAddress := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	pubKey := hex.EncodeToString(privateKeyWif.PrivKey.PubKey().SerializeCompressed())
+
+	newRequest := func(withKey bool) *BidRequest {
+		key := ""
+		if withKey {
+			key = privateKey
+		}
+		return &BidRequest{
+			Inputs: []*TxInput{
+				{TxId: "25b9d08a26c8d47795301dd47a861cff0459d14f27fbd41cffaca17d9aa20f87", Amount: 60000, Address: segwitAddress, PrivateKey: key, PublicKey: pubKey},
+				{TxId: "25b9d08a26c8d47795301dd47a861cff0459d14f27fbd41cffaca17d9aa20f87", VOut: 1, Amount: 50000, Address: taprootAddress, PrivateKey: key},
+			},
+			Price:           100000,
+			SellerAddress:   segwitAddress,
+			ReceiverAddress: taprootAddress,
+			ChangeAddress:   taprootAddress,
+			FeeRate:         2,
+		}
+	}
+	inscriptionInput := func(withKey bool) *TxInput {
+		key := ""
+		if withKey {
+			key = privateKey
+		}
+		return &TxInput{TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 546, Address: taprootAddress, PrivateKey: key}
+	}
+
+	bidPsbt, err := GenerateBidPSBT(network, newRequest(true))
+	require.Nil(t, err)
+	assert.Empty(t, bidPsbt.MessageHashMap)
+	expected := &BidExpectation{Price: 100000, Address: segwitAddress, InscriptionValue: 546}
+	assert.Nil(t, CheckBidPSBT(network, bidPsbt.Psbt, expected))
+
+	accepted, err := AcceptBid(network, &AcceptBidRequest{Psbt: bidPsbt.Psbt, Input: inscriptionInput(true), Price: 100000, SellerAddress: segwitAddress})
+	require.Nil(t, err)
+	tx, err := NewTxFromHex(accepted.TxHex)
+	require.Nil(t, err)
+	require.Len(t, tx.TxIn, 3)
+	require.Len(t, tx.TxOut, 3)
+	assert.Equal(t, int64(546), tx.TxOut[0].Value)
+	assert.Equal(t, int64(100000), tx.TxOut[1].Value)
+	assert.Equal(t, 110000-100000-bidPsbt.Fee, tx.TxOut[2].Value)
+	assert.True(t, bidPsbt.Fee >= GetTxVirtualSize(btcutil.NewTx(tx))*2)
+
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, address := range []string{taprootAddress, segwitAddress, taprootAddress} {
+		pkScript, err := AddrToPkScript(address, network)
+		require.Nil(t, err)
+		prevOutFetcher.AddPrevOut(tx.TxIn[i].PreviousOutPoint, wire.NewTxOut([]int64{546, 60000, 50000}[i], pkScript))
+	}
+	verifyRevealTx(t, tx, prevOutFetcher)
+
+	t.Run("external", func(t *testing.T) {
+		sign := func(messageHash string, taproot bool) string {
+			hash, err := hexutil.Decode(messageHash)
+			require.Nil(t, err)
+			if taproot {
+				signature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*privateKeyWif.PrivKey, nil), hash)
+				require.Nil(t, err)
+				return hex.EncodeToString(signature.Serialize())
+			}
+			compact, err := ecdsa.SignCompact(privateKeyWif.PrivKey, hash, true)
+			require.Nil(t, err)
+			return hex.EncodeToString(compact[1:])
+		}
+
+		unsigned, err := GenerateBidPSBT(network, newRequest(false))
+		require.Nil(t, err)
+		require.Len(t, unsigned.MessageHashMap, 2)
+		assert.Equal(t, bidPsbt.Fee, unsigned.Fee)
+		signedPsbt, err := SignBidPSBTBySignature(unsigned.Psbt, map[int]string{
+			1: sign(unsigned.MessageHashMap[1], false),
+			2: sign(unsigned.MessageHashMap[2], true),
+		}, map[int]string{1: pubKey})
+		require.Nil(t, err)
+		assert.Nil(t, CheckBidPSBT(network, signedPsbt, expected))
+
+		acceptedPsbt, err := AcceptBid(network, &AcceptBidRequest{Psbt: signedPsbt, Input: inscriptionInput(false), Price: 100000, SellerAddress: segwitAddress})
+		require.Nil(t, err)
+		assert.Empty(t, acceptedPsbt.TxHex)
+		txHex, err := AcceptBidBySignature(acceptedPsbt.Psbt, sign(acceptedPsbt.MessageHash, true), "")
+		require.Nil(t, err)
+		assert.Equal(t, accepted.TxHex, txHex)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		reason := func(err error) string {
+			var checkErr *ListingCheckError
+			require.True(t, errors.As(err, &checkErr), "%v", err)
+			return checkErr.Reason
+		}
+		assert.Equal(t, ListingCheckPrice, reason(CheckBidPSBT(network, bidPsbt.Psbt, &BidExpectation{Price: 120000, Address: segwitAddress})))
+		assert.Equal(t, ListingCheckAddress, reason(CheckBidPSBT(network, bidPsbt.Psbt, &BidExpectation{Price: 100000, Address: taprootAddress})))
+		assert.Equal(t, ListingCheckInscription, reason(CheckBidPSBT(network, bidPsbt.Psbt, &BidExpectation{Price: 100000, Address: segwitAddress, InscriptionOffset: 546})))
+		assert.Equal(t, ListingCheckInscription, reason(CheckBidPSBT(network, bidPsbt.Psbt, &BidExpectation{Price: 100000, Address: segwitAddress, InscriptionValue: 330})))
+
+		unsigned, err := GenerateBidPSBT(network, newRequest(false))
+		require.Nil(t, err)
+		assert.Equal(t, ListingCheckMissingSignature, reason(CheckBidPSBT(network, unsigned.Psbt, expected)))
+
+		_, err = AcceptBid(network, &AcceptBidRequest{Psbt: bidPsbt.Psbt, Input: inscriptionInput(true), Price: 90000, SellerAddress: segwitAddress})
+		assert.NotNil(t, err)
+		_, err = SignBidPSBTBySignature(unsigned.Psbt, map[int]string{BidInscriptionIndex: "00"}, nil)
+		assert.NotNil(t, err)
+
+		request := newRequest(true)
+		request.Price = 200000
+		_, err = GenerateBidPSBT(network, request)
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,275 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// DefaultDummyUtxoValue is the value of the dummy utxos a buyer spends
+// before the seller input of a purchase.
+const DefaultDummyUtxoValue = int64(600)
+
+// PrepareDummyUtxosRequest splits FundingList into the Count dummy utxos of
+// Value sats the buyer of a listing needs, less the ones already in
+// UtxoList, see FindDummyUtxos. Count defaults to the DummyInputCount of
+// ListingLayoutTwoDummies and Value to DefaultDummyUtxoValue.
+type PrepareDummyUtxosRequest struct {
+	UtxoList    []*PrevOutput `json:"utxoList"`
+	FundingList []*PrevOutput `json:"fundingList"`
+	Count       int           `json:"count"`
+	Value       int64         `json:"value"`
+	// DummyAddress defaults to the address of the first funding utxo.
+	DummyAddress  string `json:"dummyAddress"`
+	ChangeAddress string `json:"changeAddress"`
+	FeeRate       int64  `json:"feeRate"`
+	// PubKeys are the public keys of the segwit v0 inputs, keyed by input
+	// index.
+	PubKeys map[int]string `json:"pubKeys"`
+}
+
+type PrepareDummyUtxosTx struct {
+	// Dummies are the utxos of UtxoList usable as dummies.
+	Dummies []*PrevOutput `json:"dummies"`
+	// TxHex is empty when Dummies are enough.
+	TxHex string `json:"txHex,omitempty"`
+	Fee   int64  `json:"fee"`
+	// MessageHashMap is keyed by input index, it is empty once signed.
+	MessageHashMap map[int]string `json:"messageHashMap,omitempty"`
+	// DummyOutputs are the indexes of the new dummy outputs of the tx.
+	DummyOutputs []int `json:"dummyOutputs,omitempty"`
+}
+
+func (request *PrepareDummyUtxosRequest) count() int {
+	if request.Count > 0 {
+		return request.Count
+	}
+	return ListingLayouts[ListingLayoutTwoDummies].DummyInputCount
+}
+
+func (request *PrepareDummyUtxosRequest) value() int64 {
+	if request.Value > 0 {
+		return request.Value
+	}
+	return DefaultDummyUtxoValue
+}
+
+// FindDummyUtxos is the utxos of utxoList worth at most maxValue sats and
+// at least the dust limit, the ones carrying inscriptions or sat ranges
+// are left out.
+func FindDummyUtxos(network *chaincfg.Params, utxoList []*PrevOutput, maxValue int64) []*PrevOutput {
+	dustLimit := GetDustLimit(network)
+	var dummies []*PrevOutput
+	for _, utxo := range utxoList {
+		if utxo == nil || utxo.Amount < dustLimit || utxo.Amount > maxValue || HasProtectedSats([]*PrevOutput{utxo}) {
+			continue
+		}
+		dummies = append(dummies, utxo)
+	}
+	return dummies
+}
+
+// newPrepareDummyUtxosTx is nil when the dummies found are enough.
+func newPrepareDummyUtxosTx(network *chaincfg.Params, request *PrepareDummyUtxosRequest) (*wire.MsgTx, *txscript.MultiPrevOutFetcher, []*PrevOutput, []int, error) {
+	value := request.value()
+	dustLimit := GetDustLimit(network)
+	if value < dustLimit {
+		return nil, nil, nil, nil, errors.New("dummy value below the dust limit")
+	}
+	dummies := FindDummyUtxos(network, request.UtxoList, value)
+	missing := request.count() - len(dummies)
+	if missing <= 0 {
+		return nil, nil, dummies, nil, nil
+	}
+
+	if len(request.FundingList) == 0 {
+		return nil, nil, nil, nil, errors.New("missing funding utxos")
+	}
+	for i, prevOutput := range request.FundingList {
+		if HasProtectedSats([]*PrevOutput{prevOutput}) {
+			return nil, nil, nil, nil, fmt.Errorf("funding utxo %d carries inscriptions or sat ranges", i)
+		}
+	}
+	builder := &InscriptionBuilder{Network: network}
+	prevOutFetcher, tx, totalSenderAmount, err := builder.ParseCommitTxPrevOutput(request.FundingList)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	dummyAddress := request.DummyAddress
+	if dummyAddress == "" {
+		dummyAddress = request.FundingList[0].Address
+	}
+	dummyPkScript, err := AddrToPkScript(dummyAddress, network)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	changePkScript, err := AddrToPkScript(request.ChangeAddress, network)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var dummyOutputs []int
+	for i := 0; i < missing; i++ {
+		dummyOutputs = append(dummyOutputs, len(tx.TxOut))
+		tx.AddTxOut(wire.NewTxOut(value, dummyPkScript))
+	}
+
+	outputValue := value * int64(missing)
+	tx.AddTxOut(wire.NewTxOut(0, changePkScript))
+	fee := estimateSendInscriptionFee(tx, prevOutFetcher, request.FeeRate)
+	if change := int64(totalSenderAmount) - outputValue - fee; change >= dustLimit {
+		tx.TxOut[len(tx.TxOut)-1].Value = change
+	} else {
+		tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+		fee = estimateSendInscriptionFee(tx, prevOutFetcher, request.FeeRate)
+		if int64(totalSenderAmount)-outputValue-fee < 0 {
+			return nil, nil, nil, nil, errors.New("insufficient balance")
+		}
+	}
+	return tx, prevOutFetcher, dummies, dummyOutputs, nil
+}
+
+// BuildPrepareDummyUtxosTx builds the split and the message hashes to sign
+// externally, the signatures go to BuildPrepareDummyUtxosRawData.
+func BuildPrepareDummyUtxosTx(network *chaincfg.Params, request *PrepareDummyUtxosRequest) (*PrepareDummyUtxosTx, error) {
+	tx, prevOutFetcher, dummies, dummyOutputs, err := newPrepareDummyUtxosTx(network, request)
+	if err != nil {
+		return nil, err
+	}
+	result := &PrepareDummyUtxosTx{Dummies: dummies, DummyOutputs: dummyOutputs}
+	if tx == nil {
+		return result, nil
+	}
+
+	result.Fee = CalculateCommitTxFee(tx, prevOutFetcher)
+	if result.MessageHashMap, err = calcMessageHashMap(tx, prevOutFetcher, request.PubKeys); err != nil {
+		return nil, err
+	}
+	if result.TxHex, err = GetTxHex(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SignPrepareDummyUtxosTx builds and signs the split with the private keys
+// of the funding utxos.
+func SignPrepareDummyUtxosTx(network *chaincfg.Params, request *PrepareDummyUtxosRequest) (*PrepareDummyUtxosTx, error) {
+	tx, prevOutFetcher, dummies, dummyOutputs, err := newPrepareDummyUtxosTx(network, request)
+	if err != nil {
+		return nil, err
+	}
+	result := &PrepareDummyUtxosTx{Dummies: dummies, DummyOutputs: dummyOutputs}
+	if tx == nil {
+		return result, nil
+	}
+
+	privateKeys, err := decodePrivateKeys(request.FundingList)
+	if err != nil {
+		return nil, err
+	}
+	if err = Sign(tx, privateKeys, prevOutFetcher); err != nil {
+		return nil, err
+	}
+	result.Fee = CalculateCommitTxFee(tx, prevOutFetcher)
+	if result.TxHex, err = GetTxHex(tx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BuildPrepareDummyUtxosRawData injects the signatures of the message
+// hashes returned by BuildPrepareDummyUtxosTx, keyed by input index.
+func BuildPrepareDummyUtxosRawData(network *chaincfg.Params, txHex string, request *PrepareDummyUtxosRequest, signatureMap map[int]string) (string, error) {
+	return buildRawDataByInputs(network, txHex, request.FundingList, request.PubKeys, signatureMap)
+}
+
+// DummyPurchaseRequest spends Dummies, as found by FindDummyUtxos, at the
+// inputs before the seller one of Layout. The seller input holds Postage
+// sats, the ones carrying the inscription go to ReceiverAddress.
+type DummyPurchaseRequest struct {
+	Dummies         []*PrevOutput `json:"dummies"`
+	Layout          ListingLayout `json:"layout"`
+	ReceiverAddress string        `json:"receiverAddress"`
+	Postage         int64         `json:"postage"`
+	// DummyAddress takes the merged sats of two dummies, and the
+	// NewDummyCount outputs of DummyValue sats following the seller output
+	// for the next purchase. Without new dummies the spent ones are not
+	// replaced.
+	DummyAddress  string `json:"dummyAddress"`
+	NewDummyCount int    `json:"newDummyCount"`
+	DummyValue    int64  `json:"dummyValue"`
+}
+
+// DummyPurchase is the buyer side of a purchase around the seller input
+// and output, for GenerateSignedBuyingTx at the SellerIndex of the layout:
+// Inputs and Outputs go before the seller ones, NewDummyOutputs right after
+// the seller output.
+type DummyPurchase struct {
+	Inputs          []*TxInput  `json:"inputs"`
+	Outputs         []*TxOutput `json:"outputs"`
+	NewDummyOutputs []*TxOutput `json:"newDummyOutputs"`
+}
+
+// BuildDummyPurchase merges the dummies of request into a single output
+// instead of carrying them over: with a single dummy input its sats pad
+// the output taking the inscription, otherwise they all go to the first
+// output and the inscription lands at offset 0 of the second.
+func BuildDummyPurchase(network *chaincfg.Params, request *DummyPurchaseRequest) (*DummyPurchase, error) {
+	layout := request.Layout
+	if layout.DummyInputCount != layout.SellerIndex || layout.SellerIndex > 2 {
+		return nil, errors.New("the dummy inputs must fill the inputs before a seller index of 1 or 2")
+	}
+	if len(request.Dummies) != layout.DummyInputCount {
+		return nil, fmt.Errorf("%d dummies for %d dummy inputs", len(request.Dummies), layout.DummyInputCount)
+	}
+	if request.Postage <= 0 {
+		return nil, errors.New("missing postage")
+	}
+	if _, err := AddrToPkScript(request.ReceiverAddress, network); err != nil {
+		return nil, err
+	}
+	if layout.SellerIndex > 1 || request.NewDummyCount > 0 {
+		if _, err := AddrToPkScript(request.DummyAddress, network); err != nil {
+			return nil, err
+		}
+	}
+
+	purchase := &DummyPurchase{}
+	merged := int64(0)
+	for i, dummy := range request.Dummies {
+		if len(FindDummyUtxos(network, []*PrevOutput{dummy}, dummy.Amount)) == 0 {
+			return nil, fmt.Errorf("dummy %d is below the dust limit or carries inscriptions", i)
+		}
+		purchase.Inputs = append(purchase.Inputs, &TxInput{
+			TxId:       dummy.TxId,
+			VOut:       dummy.VOut,
+			Amount:     dummy.Amount,
+			Address:    dummy.Address,
+			PrivateKey: dummy.PrivateKey,
+		})
+		merged += dummy.Amount
+	}
+	if layout.SellerIndex == 1 {
+		purchase.Outputs = []*TxOutput{{Address: request.ReceiverAddress, Amount: merged + request.Postage}}
+	} else {
+		purchase.Outputs = []*TxOutput{
+			{Address: request.DummyAddress, Amount: merged},
+			{Address: request.ReceiverAddress, Amount: request.Postage},
+		}
+	}
+
+	dummyValue := request.DummyValue
+	if dummyValue <= 0 {
+		dummyValue = DefaultDummyUtxoValue
+	}
+	if request.NewDummyCount > 0 && dummyValue < GetDustLimit(network) {
+		return nil, errors.New("dummy value below the dust limit")
+	}
+	for i := 0; i < request.NewDummyCount; i++ {
+		purchase.NewDummyOutputs = append(purchase.NewDummyOutputs, &TxOutput{Address: request.DummyAddress, Amount: dummyValue})
+	}
+	return purchase, nil
+}
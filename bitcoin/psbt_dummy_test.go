@@ -0,0 +1,149 @@
+package bitcoin
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareDummyUtxos(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	address := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	segwitAddress := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	utxoList := []*PrevOutput{
+		{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5", Amount: 600, Address: address, PrivateKey: privateKey},
+		{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5", VOut: 1, Amount: 546, Address: address, InscriptionOffsets: []int64{0}},
+		{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5", VOut: 2, Amount: 100, Address: address},
+		{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5", VOut: 3, Amount: 50000, Address: address},
+	}
+	newRequest := func() *PrepareDummyUtxosRequest {
+		return &PrepareDummyUtxosRequest{
+			UtxoList: utxoList,
+			FundingList: []*PrevOutput{{
+				TxId:       "8b7b0b4b6e3f5e3c4d1a2f9c0e8d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39281706",
+				Amount:     10000,
+				Address:    address,
+				PrivateKey: privateKey,
+			}},
+			ChangeAddress: address,
+			FeeRate:       5,
+		}
+	}
+
+	t.Run("prepare", func(t *testing.T) {
+		assert.Equal(t, utxoList[:1], FindDummyUtxos(network, utxoList, DefaultDummyUtxoValue))
+
+		request := newRequest()
+		request.Count = 3
+		dummyTx, err := SignPrepareDummyUtxosTx(network, request)
+		require.Nil(t, err)
+		assert.Equal(t, utxoList[:1], dummyTx.Dummies)
+		assert.Equal(t, []int{0, 1}, dummyTx.DummyOutputs)
+		tx, err := NewTxFromHex(dummyTx.TxHex)
+		require.Nil(t, err)
+		require.Len(t, tx.TxOut, 3)
+		assert.Equal(t, DefaultDummyUtxoValue, tx.TxOut[0].Value)
+		assert.Equal(t, DefaultDummyUtxoValue, tx.TxOut[1].Value)
+		assert.Equal(t, 10000-2*DefaultDummyUtxoValue-dummyTx.Fee, tx.TxOut[2].Value)
+		assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(tx))*request.FeeRate, dummyTx.Fee)
+
+		builder := &InscriptionBuilder{Network: network}
+		prevOutFetcher, _, _, err := builder.ParseCommitTxPrevOutput(request.FundingList)
+		require.Nil(t, err)
+		verifyRevealTx(t, tx, prevOutFetcher)
+
+		built, err := BuildPrepareDummyUtxosTx(network, request)
+		require.Nil(t, err)
+		assert.Len(t, built.MessageHashMap, 1)
+	})
+
+	t.Run("enough dummies", func(t *testing.T) {
+		request := newRequest()
+		request.Count = 1
+		request.FundingList = nil
+		dummyTx, err := BuildPrepareDummyUtxosTx(network, request)
+		require.Nil(t, err)
+		assert.Empty(t, dummyTx.TxHex)
+		assert.Len(t, dummyTx.Dummies, 1)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		request := newRequest()
+		request.FundingList[0].SatRanges = []*SatRange{{Start: 0, End: 10}}
+		_, err := BuildPrepareDummyUtxosTx(network, request)
+		assert.NotNil(t, err)
+
+		request = newRequest()
+		request.Value = 100
+		_, err = BuildPrepareDummyUtxosTx(network, request)
+		assert.NotNil(t, err)
+
+		request = newRequest()
+		request.FundingList[0].Amount = 1000
+		_, err = BuildPrepareDummyUtxosTx(network, request)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("purchase", func(t *testing.T) {
+		dummies := []*PrevOutput{utxoList[0], {TxId: utxoList[0].TxId, VOut: 4, Amount: 600, Address: address, PrivateKey: privateKey}}
+		purchase, err := BuildDummyPurchase(network, &DummyPurchaseRequest{
+			Dummies:         dummies,
+			Layout:          ListingLayouts[ListingLayoutTwoDummies],
+			ReceiverAddress: segwitAddress,
+			Postage:         546,
+			DummyAddress:    address,
+			NewDummyCount:   2,
+		})
+		require.Nil(t, err)
+		require.Len(t, purchase.Inputs, 2)
+		assert.Equal(t, []*TxOutput{{Address: address, Amount: 1200}, {Address: segwitAddress, Amount: 546}}, purchase.Outputs)
+		require.Len(t, purchase.NewDummyOutputs, 2)
+		assert.Equal(t, DefaultDummyUtxoValue, purchase.NewDummyOutputs[0].Amount)
+
+		listingPsbts, err := GenerateListingPSBTs(network, &ListingRequest{Listings: []*Listing{{
+			Input:  &TxInput{TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 546, Address: address, PrivateKey: privateKey},
+			Output: &TxOutput{Address: segwitAddress, Amount: 5000},
+		}}})
+		require.Nil(t, err)
+		ins := append(purchase.Inputs, &TxInput{TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 546, Address: address},
+			&TxInput{TxId: "8b7b0b4b6e3f5e3c4d1a2f9c0e8d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39281706", Amount: 10000, Address: address, PrivateKey: privateKey})
+		outs := append(purchase.Outputs, &TxOutput{Address: segwitAddress, Amount: 5000})
+		outs = append(append(outs, purchase.NewDummyOutputs...), &TxOutput{Address: address, Amount: 3000})
+		_, err = GenerateSignedBuyingTx(ins, outs, listingPsbts[0].Psbt, listingPsbts[0].SellerIndex, network)
+		require.Nil(t, err)
+
+		purchase, err = BuildDummyPurchase(network, &DummyPurchaseRequest{
+			Dummies:         dummies[:1],
+			Layout:          ListingLayouts[ListingLayoutOneDummy],
+			ReceiverAddress: segwitAddress,
+			Postage:         546,
+		})
+		require.Nil(t, err)
+		assert.Equal(t, []*TxOutput{{Address: segwitAddress, Amount: 1146}}, purchase.Outputs)
+		assert.Empty(t, purchase.NewDummyOutputs)
+
+		listingPsbts, err = GenerateListingPSBTs(network, &ListingRequest{Listings: []*Listing{{
+			Input:  &TxInput{TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 546, Address: address, PrivateKey: privateKey},
+			Output: &TxOutput{Address: segwitAddress, Amount: 5000},
+		}}, Layout: ListingLayoutOneDummy})
+		require.Nil(t, err)
+		ins = append(purchase.Inputs, &TxInput{TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 546, Address: address},
+			&TxInput{TxId: "8b7b0b4b6e3f5e3c4d1a2f9c0e8d7b6a5f4e3d2c1b0a9f8e7d6c5b4a39281706", Amount: 10000, Address: address, PrivateKey: privateKey})
+		outs = append(purchase.Outputs, &TxOutput{Address: segwitAddress, Amount: 5000}, &TxOutput{Address: address, Amount: 4000})
+		_, err = GenerateSignedBuyingTx(ins, outs, listingPsbts[0].Psbt, listingPsbts[0].SellerIndex, network)
+		require.Nil(t, err)
+
+		_, err = BuildDummyPurchase(network, &DummyPurchaseRequest{
+			Dummies:         dummies[:1],
+			Layout:          ListingLayouts[ListingLayoutTwoDummies],
+			ReceiverAddress: segwitAddress,
+			Postage:         546,
+			DummyAddress:    address,
+		})
+		assert.NotNil(t, err)
+	})
+}
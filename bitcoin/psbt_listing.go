@@ -0,0 +1,225 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ListingSigHashType commits the seller to its input and the output paying
+// it at the same index, the buyer adds the rest.
+const ListingSigHashType = txscript.SigHashSingle | txscript.SigHashAnyOneCanPay
+
+const (
+	// ListingLayoutTwoDummies has two dummy inputs before the seller input,
+	// merged into the first output: the SellerSignatureIndex layout.
+	ListingLayoutTwoDummies = "two-dummies"
+	// ListingLayoutOneDummy has a single dummy input before the seller
+	// input, its sats pad the output taking the inscription.
+	ListingLayoutOneDummy = "one-dummy"
+)
+
+// ListingLayout is where a marketplace puts the seller input and output in
+// the purchase, the buyer provides the DummyInputCount inputs first.
+type ListingLayout struct {
+	SellerIndex     int `json:"sellerIndex"`
+	DummyInputCount int `json:"dummyInputCount"`
+}
+
+var ListingLayouts = map[string]ListingLayout{
+	ListingLayoutTwoDummies: {SellerIndex: SellerSignatureIndex, DummyInputCount: 2},
+	ListingLayoutOneDummy:   {SellerIndex: 1, DummyInputCount: 1},
+}
+
+// listingPlaceholderPkScript pays to the BIP341 NUMS point, it fills the
+// inputs and outputs before the seller ones whatever the network.
+var listingPlaceholderPkScript, _ = hex.DecodeString("512050929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac0")
+
+// Listing sells the inscription of Input for Output.Amount paid to
+// Output.Address. Without Input.PrivateKey the seller input is left for an
+// external signature, Input.PublicKey is then needed by segwit v0 inputs.
+type Listing struct {
+	Input  *TxInput  `json:"input"`
+	Output *TxOutput `json:"output"`
+}
+
+// ListingRequest lists each inscription of Listings in a PSBT of its own.
+// Layout picks the seller index and dummy input count of ListingLayouts,
+// ListingLayoutTwoDummies when empty, SellerIndex and DummyInputCount
+// override it.
+type ListingRequest struct {
+	Listings        []*Listing `json:"listings"`
+	Layout          string     `json:"layout"`
+	SellerIndex     *int       `json:"sellerIndex"`
+	DummyInputCount *int       `json:"dummyInputCount"`
+}
+
+type ListingPSBT struct {
+	// Psbt is base64 encoded, the seller input is signed but not finalized.
+	Psbt            string `json:"psbt"`
+	SellerIndex     int    `json:"sellerIndex"`
+	DummyInputCount int    `json:"dummyInputCount"`
+	// MessageHash is the sighash of the seller input left to sign
+	// externally, see SignListingPSBTsBySignature.
+	MessageHash string `json:"messageHash,omitempty"`
+}
+
+func (request *ListingRequest) layout() (ListingLayout, error) {
+	name := request.Layout
+	if name == "" {
+		name = ListingLayoutTwoDummies
+	}
+	layout, ok := ListingLayouts[name]
+	if !ok {
+		return ListingLayout{}, fmt.Errorf("unknown listing layout %q", request.Layout)
+	}
+	if request.SellerIndex != nil {
+		layout.SellerIndex = *request.SellerIndex
+	}
+	if request.DummyInputCount != nil {
+		layout.DummyInputCount = *request.DummyInputCount
+	}
+	// the inscription must not land on the output paying the seller
+	if layout.SellerIndex < 1 {
+		return ListingLayout{}, errors.New("the seller index must be at least 1")
+	}
+	if layout.DummyInputCount < 1 || layout.DummyInputCount > layout.SellerIndex {
+		return ListingLayout{}, fmt.Errorf("dummy input count must be between 1 and the seller index %d", layout.SellerIndex)
+	}
+	return layout, nil
+}
+
+// newListingPSBT pads the seller input and output to layout.SellerIndex
+// with placeholders and adds what the seller input needs to be signed.
+func newListingPSBT(network *chaincfg.Params, listing *Listing, layout ListingLayout) (*psbt.Updater, *txscript.MultiPrevOutFetcher, error) {
+	if listing == nil || listing.Input == nil || listing.Output == nil {
+		return nil, nil, errors.New("missing listing input or output")
+	}
+	in, out := listing.Input, listing.Output
+	txHash, err := chainhash.NewHashFromStr(in.TxId)
+	if err != nil {
+		return nil, nil, err
+	}
+	prevPkScript, err := AddrToPkScript(in.Address, network)
+	if err != nil {
+		return nil, nil, err
+	}
+	pkScript, err := AddrToPkScript(out.Address, network)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	placeholder := wire.NewTxOut(0, listingPlaceholderPkScript)
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	var inputs []*wire.OutPoint
+	var outputs []*wire.TxOut
+	var nSequences []uint32
+	for i := 0; i < layout.SellerIndex; i++ {
+		outPoint := wire.OutPoint{Index: uint32(i)}
+		inputs = append(inputs, &outPoint)
+		outputs = append(outputs, placeholder)
+		nSequences = append(nSequences, wire.MaxTxInSequenceNum)
+		prevOuts[outPoint] = placeholder
+	}
+	sellerOutPoint := wire.NewOutPoint(txHash, in.VOut)
+	inputs = append(inputs, sellerOutPoint)
+	outputs = append(outputs, wire.NewTxOut(out.Amount, pkScript))
+	nSequences = append(nSequences, wire.MaxTxInSequenceNum)
+	prevOuts[*sellerOutPoint] = wire.NewTxOut(in.Amount, prevPkScript)
+
+	p, err := psbt.New(inputs, outputs, int32(2), uint32(0), nSequences)
+	if err != nil {
+		return nil, nil, err
+	}
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := 0; i < layout.SellerIndex; i++ {
+		if err = updater.AddInWitnessUtxo(placeholder, i); err != nil {
+			return nil, nil, err
+		}
+	}
+	return updater, txscript.NewMultiPrevOutFetcher(prevOuts), nil
+}
+
+// GenerateListingPSBTs builds a listing PSBT for each of request.Listings,
+// the ones with a private key are signed, the others come with the message
+// hash of their seller input.
+func GenerateListingPSBTs(network *chaincfg.Params, request *ListingRequest) ([]*ListingPSBT, error) {
+	layout, err := request.layout()
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Listings) == 0 {
+		return nil, errors.New("no listing")
+	}
+
+	listingPsbts := make([]*ListingPSBT, len(request.Listings))
+	for i, listing := range request.Listings {
+		updater, prevOutFetcher, err := newListingPSBT(network, listing, layout)
+		if err != nil {
+			return nil, fmt.Errorf("listing %d: %w", i, err)
+		}
+		listingPsbt := &ListingPSBT{SellerIndex: layout.SellerIndex, DummyInputCount: layout.DummyInputCount}
+		if listing.Input.PrivateKey != "" {
+			err = signInput(updater, layout.SellerIndex, listing.Input, prevOutFetcher, ListingSigHashType, network)
+		} else if err = addInputUtxo(updater, layout.SellerIndex, listing.Input, ListingSigHashType, network); err == nil {
+			listingPsbt.MessageHash, err = inputMessageHash(updater.Upsbt.UnsignedTx, layout.SellerIndex, prevOutFetcher, listing.Input.PublicKey, ListingSigHashType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing %d: %w", i, err)
+		}
+		if listingPsbt.Psbt, err = updater.Upsbt.B64Encode(); err != nil {
+			return nil, err
+		}
+		listingPsbts[i] = listingPsbt
+	}
+	return listingPsbts, nil
+}
+
+// SignListingPSBTsBySignature adds to the listing PSBTs the signatures of the
+// message hashes returned by GenerateListingPSBTs, both keyed by listing
+// index: 64 byte schnorr signatures for taproot inputs, r and s otherwise
+// along with the public key of pubKeys.
+func SignListingPSBTsBySignature(listingPsbts []*ListingPSBT, signatureMap map[int]string, pubKeys map[int]string) ([]*ListingPSBT, error) {
+	signed := make([]*ListingPSBT, len(listingPsbts))
+	for i, listingPsbt := range listingPsbts {
+		if listingPsbt == nil {
+			return nil, fmt.Errorf("listing %d: missing psbt", i)
+		}
+		p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(listingPsbt.Psbt)), true)
+		if err != nil {
+			return nil, fmt.Errorf("listing %d: %w", i, err)
+		}
+		if err = addInputSignature(p, listingPsbt.SellerIndex, signatureMap[i], pubKeys[i], ListingSigHashType); err != nil {
+			return nil, fmt.Errorf("listing %d: %w", i, err)
+		}
+		signedPsbt := *listingPsbt
+		signedPsbt.MessageHash = ""
+		if signedPsbt.Psbt, err = p.B64Encode(); err != nil {
+			return nil, err
+		}
+		signed[i] = &signedPsbt
+	}
+	return signed, nil
+}
+
+// GenerateSignedListingPSBTBase64 lists a single inscription in the
+// ListingLayoutTwoDummies layout, signed with in.PrivateKey.
+func GenerateSignedListingPSBTBase64(in *TxInput, out *TxOutput, network *chaincfg.Params) (string, error) {
+	listingPsbts, err := GenerateListingPSBTs(network, &ListingRequest{
+		Listings: []*Listing{{Input: in, Output: out}},
+	})
+	if err != nil {
+		return "", err
+	}
+	return listingPsbts[0].Psbt, nil
+}
@@ -0,0 +1,216 @@
+package bitcoin
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Reasons of a ListingCheckError.
+const (
+	ListingCheckInvalidPsbt       = "invalid-psbt"
+	ListingCheckSellerIndex       = "seller-index"
+	ListingCheckUtxo              = "utxo"
+	ListingCheckMissingPrevOutput = "missing-prev-output"
+	ListingCheckInscription       = "inscription-offset"
+	ListingCheckSigHashType       = "sighash-type"
+	ListingCheckMissingSignature  = "missing-signature"
+	ListingCheckSignature         = "invalid-signature"
+	ListingCheckPrice             = "price"
+	ListingCheckAddress           = "address"
+)
+
+// ListingCheckError is why a seller PSBT is refused, Reason is one of the
+// ListingCheck constants.
+type ListingCheckError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (err *ListingCheckError) Error() string {
+	return err.Reason + ": " + err.Message
+}
+
+func listingCheckError(reason string, format string, a ...interface{}) *ListingCheckError {
+	return &ListingCheckError{Reason: reason, Message: fmt.Sprintf(format, a...)}
+}
+
+// ListingExpectation is what a buyer was advertised: the inscription at
+// InscriptionOffset of TxId:VOut for Price sats paid to Address, the seller
+// input and output at SellerIndex, SellerSignatureIndex when nil.
+type ListingExpectation struct {
+	TxId              string `json:"txId"`
+	VOut              uint32 `json:"vOut"`
+	InscriptionOffset int64  `json:"inscriptionOffset"`
+	Price             int64  `json:"price"`
+	Address           string `json:"address"`
+	SellerIndex       *int   `json:"sellerIndex"`
+}
+
+// CheckListingPSBT checks the base64 seller PSBT against expected before a
+// buyer signs the purchase. It returns a *ListingCheckError.
+func CheckListingPSBT(network *chaincfg.Params, sellerPsbt string, expected *ListingExpectation) error {
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(sellerPsbt)), true)
+	if err != nil {
+		return listingCheckError(ListingCheckInvalidPsbt, "%v", err)
+	}
+	return checkListingPacket(network, p, expected)
+}
+
+func checkListingPacket(network *chaincfg.Params, p *psbt.Packet, expected *ListingExpectation) error {
+	i := SellerSignatureIndex
+	if expected.SellerIndex != nil {
+		i = *expected.SellerIndex
+	}
+	if i < 0 || i >= len(p.UnsignedTx.TxIn) || i >= len(p.UnsignedTx.TxOut) {
+		return listingCheckError(ListingCheckSellerIndex, "seller index %d out of %d inputs and %d outputs", i, len(p.UnsignedTx.TxIn), len(p.UnsignedTx.TxOut))
+	}
+
+	txHash, err := chainhash.NewHashFromStr(expected.TxId)
+	if err != nil {
+		return listingCheckError(ListingCheckUtxo, "%v", err)
+	}
+	outPoint := p.UnsignedTx.TxIn[i].PreviousOutPoint
+	if outPoint != *wire.NewOutPoint(txHash, expected.VOut) {
+		return listingCheckError(ListingCheckUtxo, "seller input spends %s, not %s:%d", outPoint, expected.TxId, expected.VOut)
+	}
+	prevOut, err := listingPrevOutput(p, i)
+	if err != nil {
+		return err
+	}
+	if expected.InscriptionOffset < 0 || expected.InscriptionOffset >= prevOut.Value {
+		return listingCheckError(ListingCheckInscription, "offset %d is out of the %d sats of the listed utxo", expected.InscriptionOffset, prevOut.Value)
+	}
+
+	if p.Inputs[i].SighashType != 0 && p.Inputs[i].SighashType != ListingSigHashType {
+		return listingCheckError(ListingCheckSigHashType, "psbt sighash type %#x, want SINGLE|ANYONECANPAY", uint32(p.Inputs[i].SighashType))
+	}
+	signature := listingSignature(p.Inputs[i])
+	if len(signature) == 0 {
+		return listingCheckError(ListingCheckMissingSignature, "seller input %d is not signed", i)
+	}
+	// a 64 byte schnorr signature is SIGHASH_DEFAULT
+	if (txscript.IsPayToTaproot(prevOut.PkScript) && len(signature) == 64) || txscript.SigHashType(signature[len(signature)-1]) != ListingSigHashType {
+		return listingCheckError(ListingCheckSigHashType, "seller signature is not SINGLE|ANYONECANPAY")
+	}
+
+	output := p.UnsignedTx.TxOut[i]
+	if output.Value != expected.Price {
+		return listingCheckError(ListingCheckPrice, "seller output pays %d, advertised %d", output.Value, expected.Price)
+	}
+	pkScript, err := AddrToPkScript(expected.Address, network)
+	if err != nil {
+		return listingCheckError(ListingCheckAddress, "%v", err)
+	}
+	if !bytes.Equal(output.PkScript, pkScript) {
+		return listingCheckError(ListingCheckAddress, "seller output does not pay %s", expected.Address)
+	}
+
+	return verifyListingSignature(p, i, prevOut)
+}
+
+// listingPrevOutput is the prev output of input i, a non witness utxo must
+// be the transaction the input spends.
+func listingPrevOutput(p *psbt.Packet, i int) (*wire.TxOut, error) {
+	outPoint := p.UnsignedTx.TxIn[i].PreviousOutPoint
+	if prevTx := p.Inputs[i].NonWitnessUtxo; prevTx != nil {
+		if prevTx.TxHash() != outPoint.Hash || int(outPoint.Index) >= len(prevTx.TxOut) {
+			return nil, listingCheckError(ListingCheckMissingPrevOutput, "non witness utxo is not the tx of %s", outPoint)
+		}
+		return prevTx.TxOut[outPoint.Index], nil
+	}
+	if p.Inputs[i].WitnessUtxo != nil {
+		return p.Inputs[i].WitnessUtxo, nil
+	}
+	return nil, listingCheckError(ListingCheckMissingPrevOutput, "input %d has no utxo", i)
+}
+
+// listingSignature is the seller signature, partial or finalized.
+func listingSignature(in psbt.PInput) []byte {
+	if len(in.TaprootKeySpendSig) > 0 {
+		return in.TaprootKeySpendSig
+	}
+	if len(in.PartialSigs) > 0 {
+		return in.PartialSigs[0].Signature
+	}
+	if len(in.FinalScriptWitness) > 0 {
+		witness, err := readWitness(in.FinalScriptWitness)
+		if err == nil && len(witness) > 0 {
+			return witness[0]
+		}
+	}
+	if len(in.FinalScriptSig) > 0 {
+		pushes, err := txscript.PushedData(in.FinalScriptSig)
+		if err == nil && len(pushes) > 0 {
+			return pushes[0]
+		}
+	}
+	return nil
+}
+
+// readWitness parses a witness serialized as in PSBT final script witnesses.
+func readWitness(serialized []byte) (wire.TxWitness, error) {
+	r := bytes.NewReader(serialized)
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	var witness wire.TxWitness
+	for j := uint64(0); j < count; j++ {
+		item, err := wire.ReadVarBytes(r, 0, wire.MaxMessagePayload, "witness item")
+		if err != nil {
+			return nil, err
+		}
+		witness = append(witness, item)
+	}
+	return witness, nil
+}
+
+// verifyListingSignature finalizes the seller input of a copy of p and runs
+// its script, the other inputs are not committed to by the signature.
+func verifyListingSignature(p *psbt.Packet, i int, prevOut *wire.TxOut) error {
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		return listingCheckError(ListingCheckInvalidPsbt, "%v", err)
+	}
+	packet, err := psbt.NewFromRawBytes(&buf, false)
+	if err != nil {
+		return listingCheckError(ListingCheckInvalidPsbt, "%v", err)
+	}
+	if packet.Inputs[i].FinalScriptSig == nil && packet.Inputs[i].FinalScriptWitness == nil {
+		if err = psbt.Finalize(packet, i); err != nil {
+			return listingCheckError(ListingCheckSignature, "%v", err)
+		}
+	}
+
+	tx := packet.UnsignedTx.Copy()
+	tx.TxIn[i].SignatureScript = packet.Inputs[i].FinalScriptSig
+	if len(packet.Inputs[i].FinalScriptWitness) > 0 {
+		if tx.TxIn[i].Witness, err = readWitness(packet.Inputs[i].FinalScriptWitness); err != nil {
+			return listingCheckError(ListingCheckSignature, "%v", err)
+		}
+	}
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for j, in := range tx.TxIn {
+		other := wire.NewTxOut(0, nil)
+		if j == i {
+			other = prevOut
+		} else if packet.Inputs[j].WitnessUtxo != nil {
+			other = packet.Inputs[j].WitnessUtxo
+		}
+		prevOutFetcher.AddPrevOut(in.PreviousOutPoint, other)
+	}
+	vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx, prevOutFetcher), prevOut.Value, prevOutFetcher)
+	if err != nil {
+		return listingCheckError(ListingCheckSignature, "%v", err)
+	}
+	if err = vm.Execute(); err != nil {
+		return listingCheckError(ListingCheckSignature, "%v", err)
+	}
+	return nil
+}
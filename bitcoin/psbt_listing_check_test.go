@@ -0,0 +1,92 @@
+package bitcoin
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckListingPSBT(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	segwitAddress := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+	txId := "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206"
+
+	listingPsbts, err := GenerateListingPSBTs(network, &ListingRequest{Listings: []*Listing{
+		{
+			Input:  &TxInput{TxId: txId, Amount: 546, Address: taprootAddress, PrivateKey: privateKey},
+			Output: &TxOutput{Address: segwitAddress, Amount: 100000},
+		},
+		{
+			Input:  &TxInput{TxId: txId, VOut: 1, Amount: 10000, Address: segwitAddress, PrivateKey: privateKey},
+			Output: &TxOutput{Address: taprootAddress, Amount: 200000},
+		},
+		{
+			Input:  &TxInput{TxId: txId, VOut: 2, Amount: 546, Address: taprootAddress},
+			Output: &TxOutput{Address: segwitAddress, Amount: 100000},
+		},
+	}})
+	require.Nil(t, err)
+	expected := func() *ListingExpectation {
+		return &ListingExpectation{TxId: txId, Price: 100000, Address: segwitAddress}
+	}
+	reason := func(err error) string {
+		var checkErr *ListingCheckError
+		require.True(t, errors.As(err, &checkErr), "%v", err)
+		return checkErr.Reason
+	}
+	// tamper re-encodes the first listing after edit
+	tamper := func(edit func(p *psbt.Packet)) string {
+		p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(listingPsbts[0].Psbt)), true)
+		require.Nil(t, err)
+		edit(p)
+		tampered, err := p.B64Encode()
+		require.Nil(t, err)
+		return tampered
+	}
+
+	assert.Nil(t, CheckListingPSBT(network, listingPsbts[0].Psbt, expected()))
+	assert.Nil(t, CheckListingPSBT(network, listingPsbts[1].Psbt, &ListingExpectation{TxId: txId, VOut: 1, InscriptionOffset: 9999, Price: 200000, Address: taprootAddress}))
+
+	for name, test := range map[string]struct {
+		psbt   string
+		edit   func(expected *ListingExpectation)
+		reason string
+	}{
+		"invalid psbt": {psbt: "cHNidP8=", reason: ListingCheckInvalidPsbt},
+		"seller index": {edit: func(expected *ListingExpectation) {
+			sellerIndex := 3
+			expected.SellerIndex = &sellerIndex
+		}, reason: ListingCheckSellerIndex},
+		"utxo":        {edit: func(expected *ListingExpectation) { expected.VOut = 1 }, reason: ListingCheckUtxo},
+		"inscription": {edit: func(expected *ListingExpectation) { expected.InscriptionOffset = 546 }, reason: ListingCheckInscription},
+		"price":       {edit: func(expected *ListingExpectation) { expected.Price = 90000 }, reason: ListingCheckPrice},
+		"address":     {edit: func(expected *ListingExpectation) { expected.Address = taprootAddress }, reason: ListingCheckAddress},
+		"unsigned": {psbt: listingPsbts[2].Psbt, edit: func(expected *ListingExpectation) { expected.VOut = 2 },
+			reason: ListingCheckMissingSignature},
+		"sighash default": {psbt: tamper(func(p *psbt.Packet) {
+			p.Inputs[SellerSignatureIndex].TaprootKeySpendSig = p.Inputs[SellerSignatureIndex].TaprootKeySpendSig[:64]
+			p.Inputs[SellerSignatureIndex].SighashType = 0
+		}), reason: ListingCheckSigHashType},
+		"lowered price": {psbt: tamper(func(p *psbt.Packet) {
+			p.UnsignedTx.TxOut[SellerSignatureIndex].Value = 1000
+		}), edit: func(expected *ListingExpectation) { expected.Price = 1000 }, reason: ListingCheckSignature},
+	} {
+		sellerPsbt := listingPsbts[0].Psbt
+		if test.psbt != "" {
+			sellerPsbt = test.psbt
+		}
+		listing := expected()
+		if test.edit != nil {
+			test.edit(listing)
+		}
+		err := CheckListingPSBT(network, sellerPsbt, listing)
+		assert.Equal(t, test.reason, reason(err), name)
+	}
+}
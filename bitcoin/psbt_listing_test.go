@@ -0,0 +1,140 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyListingPSBT finalizes the seller input of listingPsbt and runs its
+// script, in the amount of sats of address.
+func verifyListingPSBT(t *testing.T, network *chaincfg.Params, listingPsbt *ListingPSBT, address string, amount int64) {
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(listingPsbt.Psbt)), true)
+	require.Nil(t, err)
+	i := listingPsbt.SellerIndex
+	require.Len(t, p.UnsignedTx.TxIn, i+1)
+	require.Len(t, p.UnsignedTx.TxOut, i+1)
+	assert.Equal(t, ListingSigHashType, p.Inputs[i].SighashType)
+	require.Nil(t, psbt.Finalize(p, i))
+
+	tx := p.UnsignedTx.Copy()
+	tx.TxIn[i].SignatureScript = p.Inputs[i].FinalScriptSig
+	if len(p.Inputs[i].FinalScriptWitness) > 0 {
+		tx.TxIn[i].Witness, err = readWitness(p.Inputs[i].FinalScriptWitness)
+		require.Nil(t, err)
+	}
+	prevPkScript, err := AddrToPkScript(address, network)
+	require.Nil(t, err)
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for j, in := range tx.TxIn {
+		prevOutFetcher.AddPrevOut(in.PreviousOutPoint, p.Inputs[j].WitnessUtxo)
+	}
+	prevOutFetcher.AddPrevOut(tx.TxIn[i].PreviousOutPoint, wire.NewTxOut(amount, prevPkScript))
+	vm, err := txscript.NewEngine(prevPkScript, tx, i, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx, prevOutFetcher), amount, prevOutFetcher)
+	require.Nil(t, err)
+	require.Nil(t, vm.Execute())
+}
+
+func TestListingPSBTs(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	segwitAddress := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	pubKey := hex.EncodeToString(privateKeyWif.PrivKey.PubKey().SerializeCompressed())
+
+	listings := func(withKey bool) []*Listing {
+		key := ""
+		if withKey {
+			key = privateKey
+		}
+		return []*Listing{
+			{
+				Input:  &TxInput{TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 546, Address: taprootAddress, PrivateKey: key},
+				Output: &TxOutput{Address: segwitAddress, Amount: 100000},
+			},
+			{
+				Input:  &TxInput{TxId: "25b9d08a26c8d47795301dd47a861cff0459d14f27fbd41cffaca17d9aa20f87", VOut: 1, Amount: 10000, Address: segwitAddress, PrivateKey: key, PublicKey: pubKey},
+				Output: &TxOutput{Address: taprootAddress, Amount: 200000},
+			},
+		}
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		listingPsbts, err := GenerateListingPSBTs(network, &ListingRequest{Listings: listings(true)})
+		require.Nil(t, err)
+		require.Len(t, listingPsbts, 2)
+		for _, listingPsbt := range listingPsbts {
+			assert.Equal(t, SellerSignatureIndex, listingPsbt.SellerIndex)
+			assert.Equal(t, 2, listingPsbt.DummyInputCount)
+			assert.Empty(t, listingPsbt.MessageHash)
+		}
+		verifyListingPSBT(t, network, listingPsbts[0], taprootAddress, 546)
+		verifyListingPSBT(t, network, listingPsbts[1], segwitAddress, 10000)
+	})
+
+	t.Run("one dummy", func(t *testing.T) {
+		listingPsbts, err := GenerateListingPSBTs(network, &ListingRequest{Listings: listings(true), Layout: ListingLayoutOneDummy})
+		require.Nil(t, err)
+		assert.Equal(t, 1, listingPsbts[0].SellerIndex)
+		verifyListingPSBT(t, network, listingPsbts[0], taprootAddress, 546)
+
+		sellerIndex := 3
+		listingPsbts, err = GenerateListingPSBTs(network, &ListingRequest{Listings: listings(true), SellerIndex: &sellerIndex})
+		require.Nil(t, err)
+		assert.Equal(t, 3, listingPsbts[1].SellerIndex)
+		verifyListingPSBT(t, network, listingPsbts[1], segwitAddress, 10000)
+	})
+
+	t.Run("external", func(t *testing.T) {
+		listingPsbts, err := GenerateListingPSBTs(network, &ListingRequest{Listings: listings(false)})
+		require.Nil(t, err)
+
+		hash, err := hexutil.Decode(listingPsbts[0].MessageHash)
+		require.Nil(t, err)
+		schnorrSignature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*privateKeyWif.PrivKey, nil), hash)
+		require.Nil(t, err)
+		hash, err = hexutil.Decode(listingPsbts[1].MessageHash)
+		require.Nil(t, err)
+		compact, err := ecdsa.SignCompact(privateKeyWif.PrivKey, hash, true)
+		require.Nil(t, err)
+
+		signed, err := SignListingPSBTsBySignature(listingPsbts, map[int]string{
+			0: hex.EncodeToString(schnorrSignature.Serialize()),
+			1: hex.EncodeToString(compact[1:]),
+		}, map[int]string{1: pubKey})
+		require.Nil(t, err)
+		assert.Empty(t, signed[0].MessageHash)
+		verifyListingPSBT(t, network, signed[0], taprootAddress, 546)
+		verifyListingPSBT(t, network, signed[1], segwitAddress, 10000)
+
+		_, err = SignListingPSBTsBySignature(listingPsbts, map[int]string{0: hex.EncodeToString(schnorrSignature.Serialize())}, nil)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid layout", func(t *testing.T) {
+		zero, three := 0, 3
+		for _, request := range []*ListingRequest{
+			{Listings: listings(true), Layout: "unknown"},
+			{Listings: listings(true), SellerIndex: &zero},
+			{Listings: listings(true), DummyInputCount: &three},
+			{Listings: listings(true), DummyInputCount: &zero},
+			{},
+		} {
+			_, err := GenerateListingPSBTs(network, request)
+			assert.NotNil(t, err)
+		}
+	})
+}
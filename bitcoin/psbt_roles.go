@@ -0,0 +1,1146 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// EncodedPSBT is a psbt in both encodings, base64 as Bitcoin Core and
+// Sparrow exchange it, and hex.
+type EncodedPSBT struct {
+	// Version is 0, or 2 for a BIP370 psbt.
+	Version uint32 `json:"version"`
+	Psbt    string `json:"psbt"`
+	PsbtHex string `json:"psbtHex"`
+}
+
+// DecodePSBT parses a base64 or hex encoded psbt, a version 2 one is
+// converted to version 0.
+func DecodePSBT(encoded string) (*psbt.Packet, error) {
+	p, _, err := decodePSBT(encoded)
+	return p, err
+}
+
+// decodePSBT is DecodePSBT keeping the version 2 psbt, the roles work on
+// its version 0 conversion and encodePSBT takes their changes back.
+func decodePSBT(encoded string) (*psbt.Packet, *PSBTv2, error) {
+	raw, err := decodePSBTBytes(encoded)
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := psbtVersion(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch version {
+	case 0:
+		p, err := psbt.NewFromRawBytes(bytes.NewReader(raw), false)
+		return p, nil, err
+	case 2:
+		p2, err := ParsePSBTv2(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		p, err := p2.ToV0()
+		return p, p2, err
+	}
+	return nil, nil, fmt.Errorf("unsupported psbt version %d", version)
+}
+
+func decodePSBTBytes(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if raw, err := hex.DecodeString(encoded); err == nil && bytes.HasPrefix(raw, psbtMagic) {
+		return raw, nil
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func EncodePSBT(p *psbt.Packet) (*EncodedPSBT, error) {
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return &EncodedPSBT{
+		Psbt:    base64.StdEncoding.EncodeToString(buf.Bytes()),
+		PsbtHex: hex.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+func EncodePSBTv2(p *PSBTv2) (*EncodedPSBT, error) {
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return &EncodedPSBT{
+		Version: 2,
+		Psbt:    base64.StdEncoding.EncodeToString(buf.Bytes()),
+		PsbtHex: hex.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// encodePSBT encodes p in the version it was decoded from, p2 takes its
+// changes when it was version 2.
+func encodePSBT(p *psbt.Packet, p2 *PSBTv2) (*EncodedPSBT, error) {
+	if p2 == nil {
+		return EncodePSBT(p)
+	}
+	if err := p2.update(p); err != nil {
+		return nil, err
+	}
+	return EncodePSBTv2(p2)
+}
+
+// CreatePSBTRequest is the Creator role: the unsigned tx of Inputs and
+// Outputs, the input data is left to the Updater. Version defaults to 2
+// and an input Sequence to the final one.
+type CreatePSBTRequest struct {
+	Inputs   []*TxInput  `json:"inputs"`
+	Outputs  []*TxOutput `json:"outputs"`
+	Version  int32       `json:"version"`
+	LockTime uint32      `json:"lockTime"`
+	// PsbtVersion is 0 or 2. A version 2 psbt may start without inputs or
+	// outputs, the modifiable flags let ConstructPSBT add them, LockTime is
+	// its fallback one.
+	PsbtVersion       uint32 `json:"psbtVersion"`
+	InputsModifiable  bool   `json:"inputsModifiable"`
+	OutputsModifiable bool   `json:"outputsModifiable"`
+}
+
+func CreatePSBT(network *chaincfg.Params, request *CreatePSBTRequest) (*EncodedPSBT, error) {
+	if request.PsbtVersion != 0 && request.PsbtVersion != 2 {
+		return nil, fmt.Errorf("unsupported psbt version %d", request.PsbtVersion)
+	}
+	if request.PsbtVersion == 0 && (len(request.Inputs) == 0 || len(request.Outputs) == 0) {
+		return nil, errors.New("a psbt needs inputs and outputs")
+	}
+	version := request.Version
+	if version == 0 {
+		version = 2
+	}
+	tx := wire.NewMsgTx(version)
+	tx.LockTime = request.LockTime
+	for _, in := range request.Inputs {
+		txHash, err := chainhash.NewHashFromStr(in.TxId)
+		if err != nil {
+			return nil, err
+		}
+		txIn := wire.NewTxIn(wire.NewOutPoint(txHash, in.VOut), nil, nil)
+		if in.Sequence != 0 {
+			txIn.Sequence = in.Sequence
+		}
+		tx.AddTxIn(txIn)
+	}
+	for _, out := range request.Outputs {
+		pkScript, err := AddrToPkScript(out.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		tx.AddTxOut(wire.NewTxOut(out.Amount, pkScript))
+	}
+
+	if request.PsbtVersion == 2 {
+		if version < 2 {
+			return nil, errors.New("a version 2 psbt needs a tx version of 2 at least")
+		}
+		p2 := newPSBTv2(tx, modifiableFlags(request.InputsModifiable, request.OutputsModifiable))
+		return EncodePSBTv2(p2)
+	}
+	p, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePSBT(p)
+}
+
+func modifiableFlags(inputs bool, outputs bool) uint8 {
+	var flags uint8
+	if inputs {
+		flags |= PSBTInputsModifiable
+	}
+	if outputs {
+		flags |= PSBTOutputsModifiable
+	}
+	return flags
+}
+
+// ConstructPSBTInput is an input the Constructor adds, its required lock
+// times set the tx lock time as PSBTv2.LockTime tells.
+type ConstructPSBTInput struct {
+	TxId                   string `json:"txId"`
+	VOut                   uint32 `json:"vOut"`
+	Sequence               uint32 `json:"sequence"`
+	RequiredTimeLockTime   uint32 `json:"requiredTimeLockTime"`
+	RequiredHeightLockTime uint32 `json:"requiredHeightLockTime"`
+}
+
+// ConstructPSBTRequest is the BIP370 Constructor role adding Inputs and
+// Outputs to a version 2 Psbt while its modifiable flags allow it. Once
+// an input is signed SIGHASH_SINGLE they go in pairs.
+type ConstructPSBTRequest struct {
+	Psbt    string                `json:"psbt"`
+	Inputs  []*ConstructPSBTInput `json:"inputs"`
+	Outputs []*TxOutput           `json:"outputs"`
+}
+
+func ConstructPSBT(network *chaincfg.Params, request *ConstructPSBTRequest) (*EncodedPSBT, error) {
+	// an empty version 2 psbt has no version 0 conversion
+	raw, err := decodePSBTBytes(request.Psbt)
+	if err != nil {
+		return nil, err
+	}
+	if version, err := psbtVersion(raw); err != nil || version != 2 {
+		return nil, errors.New("only a version 2 psbt can be added to")
+	}
+	p2, err := ParsePSBTv2(raw)
+	if err != nil {
+		return nil, err
+	}
+	if p2.Modifiable&PSBTHasSighashSingle != 0 && len(request.Inputs) != len(request.Outputs) {
+		return nil, errors.New("inputs and outputs go in pairs once signed SIGHASH_SINGLE")
+	}
+	for i, in := range request.Inputs {
+		txHash, err := chainhash.NewHashFromStr(in.TxId)
+		if err != nil {
+			return nil, err
+		}
+		input := &PSBTv2Input{PreviousTxId: *txHash, OutputIndex: in.VOut}
+		if in.Sequence != 0 {
+			sequence := in.Sequence
+			input.Sequence = &sequence
+		}
+		if in.RequiredTimeLockTime != 0 {
+			if in.RequiredTimeLockTime < txscript.LockTimeThreshold {
+				return nil, fmt.Errorf("input %d required time lock time must be a timestamp", i)
+			}
+			lockTime := in.RequiredTimeLockTime
+			input.RequiredTimeLockTime = &lockTime
+		}
+		if in.RequiredHeightLockTime != 0 {
+			if in.RequiredHeightLockTime >= txscript.LockTimeThreshold {
+				return nil, fmt.Errorf("input %d required height lock time must be a block height", i)
+			}
+			lockTime := in.RequiredHeightLockTime
+			input.RequiredHeightLockTime = &lockTime
+		}
+		for _, other := range p2.Inputs {
+			if other.PreviousTxId == input.PreviousTxId && other.OutputIndex == input.OutputIndex {
+				return nil, fmt.Errorf("input %d is already spent by the psbt", i)
+			}
+		}
+		if err = p2.AddInput(input); err != nil {
+			return nil, err
+		}
+	}
+	for _, out := range request.Outputs {
+		pkScript, err := AddrToPkScript(out.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		if err = p2.AddOutput(&PSBTv2Output{Amount: out.Amount, Script: pkScript}); err != nil {
+			return nil, err
+		}
+	}
+	return EncodePSBTv2(p2)
+}
+
+// ConvertPSBTRequest converts Psbt to PsbtVersion 0 or 2, the modifiable
+// flags are the ones of a new version 2 psbt.
+type ConvertPSBTRequest struct {
+	Psbt              string `json:"psbt"`
+	PsbtVersion       uint32 `json:"psbtVersion"`
+	InputsModifiable  bool   `json:"inputsModifiable"`
+	OutputsModifiable bool   `json:"outputsModifiable"`
+}
+
+func ConvertPSBT(request *ConvertPSBTRequest) (*EncodedPSBT, error) {
+	p, p2, err := decodePSBT(request.Psbt)
+	if err != nil {
+		return nil, err
+	}
+	switch request.PsbtVersion {
+	case 0:
+		return EncodePSBT(p)
+	case 2:
+		if p2 == nil {
+			if p2, err = NewPSBTv2(p, modifiableFlags(request.InputsModifiable, request.OutputsModifiable)); err != nil {
+				return nil, err
+			}
+		}
+		return EncodePSBTv2(p2)
+	}
+	return nil, fmt.Errorf("unsupported psbt version %d", request.PsbtVersion)
+}
+
+type PSBTBip32Derivation struct {
+	PublicKey         string `json:"publicKey"`
+	MasterFingerprint uint32 `json:"masterFingerprint"`
+	DerivationPath    string `json:"derivationPath"`
+}
+
+// PSBTInputUpdate is the data the Updater adds to input Index, empty
+// fields are left as they are.
+type PSBTInputUpdate struct {
+	Index int `json:"index"`
+	// Address and Amount are the witness utxo, NonWitnessUtxo the hex tx
+	// the input spends, needed by P2PKH and legacy P2SH inputs. A segwit
+	// input given only the tx gets its witness utxo too, as Bitcoin Core
+	// keeps both.
+	Address        string `json:"address"`
+	Amount         int64  `json:"amount"`
+	NonWitnessUtxo string `json:"nonWitnessUtxo"`
+	// RedeemScript and WitnessScript are hex. Without a redeem script a
+	// P2SH input gets the P2SH-P2WPKH one of a derivation key matching it.
+	RedeemScript    string                 `json:"redeemScript"`
+	WitnessScript   string                 `json:"witnessScript"`
+	SighashType     uint32                 `json:"sighashType"`
+	Bip32Derivation []*PSBTBip32Derivation `json:"bip32Derivation"`
+	// TaprootInternalKey and TaprootMerkleRoot are the hex BIP371 fields of
+	// a taproot key path input, the internal key defaulting to the
+	// derivation key of a BIP86 output. TaprootLeafScript and
+	// TaprootControlBlock are the hex leaf of a script path input.
+	TaprootInternalKey  string `json:"taprootInternalKey"`
+	TaprootMerkleRoot   string `json:"taprootMerkleRoot"`
+	TaprootLeafScript   string `json:"taprootLeafScript"`
+	TaprootControlBlock string `json:"taprootControlBlock"`
+}
+
+// PSBTOutputUpdate is the data the Updater adds to output Index, a change
+// output usually only carries its derivation.
+type PSBTOutputUpdate struct {
+	Index           int                    `json:"index"`
+	RedeemScript    string                 `json:"redeemScript"`
+	WitnessScript   string                 `json:"witnessScript"`
+	Bip32Derivation []*PSBTBip32Derivation `json:"bip32Derivation"`
+}
+
+// UpdatePSBTRequest is the Updater role on Psbt, base64 or hex encoded.
+type UpdatePSBTRequest struct {
+	Psbt    string              `json:"psbt"`
+	Inputs  []*PSBTInputUpdate  `json:"inputs"`
+	Outputs []*PSBTOutputUpdate `json:"outputs"`
+}
+
+func UpdatePSBT(network *chaincfg.Params, request *UpdatePSBTRequest) (*EncodedPSBT, error) {
+	p, p2, err := decodePSBT(request.Psbt)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, update := range request.Inputs {
+		if update == nil || update.Index < 0 || update.Index >= len(p.Inputs) {
+			return nil, errors.New("input index out of range")
+		}
+		if err = updatePSBTInput(network, updater, update); err != nil {
+			return nil, fmt.Errorf("input %d: %w", update.Index, err)
+		}
+	}
+	for _, update := range request.Outputs {
+		if update == nil || update.Index < 0 || update.Index >= len(p.Outputs) {
+			return nil, errors.New("output index out of range")
+		}
+		if err = updatePSBTOutput(updater, update); err != nil {
+			return nil, fmt.Errorf("output %d: %w", update.Index, err)
+		}
+	}
+	return encodePSBT(p, p2)
+}
+
+func updatePSBTInput(network *chaincfg.Params, updater *psbt.Updater, update *PSBTInputUpdate) error {
+	i := update.Index
+	outPoint := updater.Upsbt.UnsignedTx.TxIn[i].PreviousOutPoint
+	var prevOut *wire.TxOut
+	if update.NonWitnessUtxo != "" {
+		prevTx, err := NewTxFromHex(update.NonWitnessUtxo)
+		if err != nil {
+			return err
+		}
+		if prevTx.TxHash() != outPoint.Hash || int(outPoint.Index) >= len(prevTx.TxOut) {
+			return fmt.Errorf("non witness utxo is not the tx of %s", outPoint)
+		}
+		if err = updater.AddInNonWitnessUtxo(prevTx, i); err != nil {
+			return err
+		}
+		prevOut = prevTx.TxOut[outPoint.Index]
+	}
+	if update.Address != "" {
+		pkScript, err := AddrToPkScript(update.Address, network)
+		if err != nil {
+			return err
+		}
+		if prevOut != nil && (!bytes.Equal(prevOut.PkScript, pkScript) || update.Amount != 0 && update.Amount != prevOut.Value) {
+			return errors.New("address and amount do not match the non witness utxo")
+		}
+		if prevOut == nil {
+			prevOut = wire.NewTxOut(update.Amount, pkScript)
+		}
+	}
+	if prevOut == nil {
+		prevOut, _ = psbtPrevOutput(updater.Upsbt, i)
+	}
+
+	if prevOut != nil && txscript.IsPayToTaproot(prevOut.PkScript) {
+		if err := updateTaprootPSBTInput(&updater.Upsbt.Inputs[i], prevOut.PkScript, update); err != nil {
+			return err
+		}
+	} else {
+		for _, derivation := range update.Bip32Derivation {
+			pubKey, path, err := parsePSBTBip32Derivation(derivation)
+			if err != nil {
+				return err
+			}
+			if err = updater.AddInBip32Derivation(derivation.MasterFingerprint, path, pubKey, i); err != nil {
+				return err
+			}
+		}
+	}
+
+	redeemScript, err := hex.DecodeString(update.RedeemScript)
+	if err != nil {
+		return err
+	}
+	if len(redeemScript) == 0 && prevOut != nil && txscript.IsPayToScriptHash(prevOut.PkScript) && updater.Upsbt.Inputs[i].RedeemScript == nil {
+		for _, derivation := range updater.Upsbt.Inputs[i].Bip32Derivation {
+			script, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(derivation.PubKey))
+			if err == nil && bytes.Equal(prevOut.PkScript[2:22], btcutil.Hash160(script)) {
+				redeemScript = script
+				break
+			}
+		}
+	}
+	if len(redeemScript) > 0 {
+		if prevOut != nil && (!txscript.IsPayToScriptHash(prevOut.PkScript) || !bytes.Equal(prevOut.PkScript[2:22], btcutil.Hash160(redeemScript))) {
+			return errors.New("redeem script does not match the utxo")
+		}
+		if err = updater.AddInRedeemScript(redeemScript, i); err != nil {
+			return err
+		}
+	}
+	if update.WitnessScript != "" {
+		witnessScript, err := hex.DecodeString(update.WitnessScript)
+		if err != nil {
+			return err
+		}
+		if err = updater.AddInWitnessScript(witnessScript, i); err != nil {
+			return err
+		}
+	}
+
+	if prevOut != nil && (txscript.IsWitnessProgram(prevOut.PkScript) || txscript.IsWitnessProgram(updater.Upsbt.Inputs[i].RedeemScript)) {
+		if err = updater.AddInWitnessUtxo(prevOut, i); err != nil {
+			return err
+		}
+	}
+	if update.SighashType != 0 {
+		return updater.AddInSighashType(txscript.SigHashType(update.SighashType), i)
+	}
+	return nil
+}
+
+// updateTaprootPSBTInput adds the BIP371 fields of update to the taproot
+// input in spending pkScript.
+func updateTaprootPSBTInput(in *psbt.PInput, pkScript []byte, update *PSBTInputUpdate) error {
+	var internalKey []byte
+	var err error
+	if update.TaprootInternalKey != "" {
+		if internalKey, err = parseXOnlyPubKey(update.TaprootInternalKey); err != nil {
+			return err
+		}
+	}
+	merkleRoot, err := hex.DecodeString(update.TaprootMerkleRoot)
+	if err != nil {
+		return err
+	}
+	leafScript, err := hex.DecodeString(update.TaprootLeafScript)
+	if err != nil {
+		return err
+	}
+	controlBlock, err := hex.DecodeString(update.TaprootControlBlock)
+	if err != nil {
+		return err
+	}
+	if len(internalKey) == 0 && len(merkleRoot) == 0 && len(controlBlock) == 0 && in.TaprootInternalKey == nil {
+		for _, derivation := range update.Bip32Derivation {
+			if derivation == nil {
+				continue
+			}
+			pubKey, err := parseXOnlyPubKey(derivation.PublicKey)
+			if err == nil && checkTaprootOutputKey(pkScript, pubKey, nil) == nil {
+				internalKey = pubKey
+				break
+			}
+		}
+	}
+	if err = addTaprootInputScript(in, pkScript, internalKey, merkleRoot, leafScript, controlBlock); err != nil {
+		return err
+	}
+	for _, derivation := range update.Bip32Derivation {
+		pubKey, path, err := parsePSBTBip32Derivation(derivation)
+		if err != nil {
+			return err
+		}
+		addTaprootInputBip32Derivation(in, pubKey[1:], derivation.MasterFingerprint, path)
+	}
+	return nil
+}
+
+func updatePSBTOutput(updater *psbt.Updater, update *PSBTOutputUpdate) error {
+	i := update.Index
+	pkScript := updater.Upsbt.UnsignedTx.TxOut[i].PkScript
+	for _, derivation := range update.Bip32Derivation {
+		pubKey, path, err := parsePSBTBip32Derivation(derivation)
+		if err != nil {
+			return err
+		}
+		if txscript.IsPayToTaproot(pkScript) {
+			err = addTaprootOutputBip32Derivation(&updater.Upsbt.Outputs[i], pkScript, pubKey[1:], derivation.MasterFingerprint, path)
+		} else {
+			err = updater.AddOutBip32Derivation(derivation.MasterFingerprint, path, pubKey, i)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if update.RedeemScript != "" {
+		redeemScript, err := hex.DecodeString(update.RedeemScript)
+		if err != nil {
+			return err
+		}
+		if err = updater.AddOutRedeemScript(redeemScript, i); err != nil {
+			return err
+		}
+	}
+	if update.WitnessScript != "" {
+		witnessScript, err := hex.DecodeString(update.WitnessScript)
+		if err != nil {
+			return err
+		}
+		if err = updater.AddOutWitnessScript(witnessScript, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parsePSBTBip32Derivation(derivation *PSBTBip32Derivation) ([]byte, []uint32, error) {
+	if derivation == nil {
+		return nil, nil, errors.New("missing bip32 derivation")
+	}
+	pubKey, err := ParsePubKey(derivation.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := accounts.ParseDerivationPath(derivation.DerivationPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pubKey.SerializeCompressed(), path, nil
+}
+
+// psbtPrevOutput is the prev output of input i, a non witness utxo must be
+// the transaction the input spends.
+func psbtPrevOutput(p *psbt.Packet, i int) (*wire.TxOut, error) {
+	outPoint := p.UnsignedTx.TxIn[i].PreviousOutPoint
+	if prevTx := p.Inputs[i].NonWitnessUtxo; prevTx != nil {
+		if prevTx.TxHash() != outPoint.Hash || int(outPoint.Index) >= len(prevTx.TxOut) {
+			return nil, fmt.Errorf("non witness utxo is not the tx of %s", outPoint)
+		}
+		return prevTx.TxOut[outPoint.Index], nil
+	}
+	if p.Inputs[i].WitnessUtxo != nil {
+		return p.Inputs[i].WitnessUtxo, nil
+	}
+	return nil, fmt.Errorf("input %d has no utxo", i)
+}
+
+// psbtPrevOutFetcher fetches the prev outputs of all the inputs of p.
+func psbtPrevOutFetcher(p *psbt.Packet) (*txscript.MultiPrevOutFetcher, error) {
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range p.UnsignedTx.TxIn {
+		prevOut, err := psbtPrevOutput(p, i)
+		if err != nil {
+			return nil, err
+		}
+		prevOutFetcher.AddPrevOut(in.PreviousOutPoint, prevOut)
+	}
+	return prevOutFetcher, nil
+}
+
+// psbtInputScript is how input i is signed: the script the signature
+// commits to, the pkScript itself for taproot, and whether it is a witness.
+// A taproot input carrying a leaf script is spent through its tapLeaf, as
+// an inscription reveal must be.
+type psbtInputScript struct {
+	prevOut    *wire.TxOut
+	scriptCode []byte
+	witness    bool
+	taproot    bool
+	tapLeaf    *txscript.TapLeaf
+	hashType   txscript.SigHashType
+}
+
+func newPSBTInputScript(p *psbt.Packet, i int) (*psbtInputScript, error) {
+	prevOut, err := psbtPrevOutput(p, i)
+	if err != nil {
+		return nil, err
+	}
+	in := &p.Inputs[i]
+	script := prevOut.PkScript
+	if txscript.IsPayToScriptHash(script) {
+		if in.RedeemScript == nil {
+			return nil, fmt.Errorf("input %d has no redeem script", i)
+		}
+		if !bytes.Equal(script[2:22], btcutil.Hash160(in.RedeemScript)) {
+			return nil, fmt.Errorf("input %d redeem script does not match its utxo", i)
+		}
+		script = in.RedeemScript
+	}
+
+	inputScript := &psbtInputScript{prevOut: prevOut, hashType: in.SighashType}
+	switch {
+	case txscript.IsPayToTaproot(script):
+		inputScript.scriptCode, inputScript.witness, inputScript.taproot = script, true, true
+		if len(in.TaprootLeafScript) > 0 {
+			leaf := txscript.NewTapLeaf(in.TaprootLeafScript[0].LeafVersion, in.TaprootLeafScript[0].Script)
+			inputScript.tapLeaf = &leaf
+		}
+		// an unset type is SIGHASH_DEFAULT
+		return inputScript, nil
+	case txscript.IsPayToWitnessPubKeyHash(script):
+		inputScript.witness = true
+		if inputScript.scriptCode, err = PayToPubKeyHashScript(script[2:]); err != nil {
+			return nil, err
+		}
+	case txscript.IsPayToWitnessScriptHash(script):
+		if in.WitnessScript == nil {
+			return nil, fmt.Errorf("input %d has no witness script", i)
+		}
+		if hash := chainhash.HashB(in.WitnessScript); !bytes.Equal(script[2:], hash) {
+			return nil, fmt.Errorf("input %d witness script does not match its utxo", i)
+		}
+		inputScript.scriptCode, inputScript.witness = in.WitnessScript, true
+	default:
+		inputScript.scriptCode = script
+	}
+	if inputScript.hashType == 0 {
+		inputScript.hashType = txscript.SigHashAll
+	}
+	return inputScript, nil
+}
+
+// messageHash is the sighash an external signer signs for input i, see
+// leafMessageHash for the script path.
+func (inputScript *psbtInputScript) messageHash(tx *wire.MsgTx, i int, prevOutFetcher *txscript.MultiPrevOutFetcher) ([]byte, error) {
+	if inputScript.taproot {
+		return txscript.CalcTaprootSignatureHash(txscript.NewTxSigHashes(tx, prevOutFetcher), inputScript.hashType, tx, i, prevOutFetcher)
+	} else if inputScript.witness {
+		return txscript.CalcWitnessSigHash(inputScript.scriptCode, txscript.NewTxSigHashes(tx, prevOutFetcher), inputScript.hashType, tx, i, inputScript.prevOut.Value)
+	}
+	return txscript.CalcSignatureHash(inputScript.scriptCode, inputScript.hashType, tx, i)
+}
+
+// leafMessageHash is the sighash an external signer signs for input i
+// spent through tapLeaf.
+func (inputScript *psbtInputScript) leafMessageHash(tx *wire.MsgTx, i int, prevOutFetcher *txscript.MultiPrevOutFetcher, tapLeaf txscript.TapLeaf) ([]byte, error) {
+	return txscript.CalcTapscriptSignaturehash(txscript.NewTxSigHashes(tx, prevOutFetcher), inputScript.hashType, tx, i, prevOutFetcher, tapLeaf)
+}
+
+// ownedBy tells if pubKey signs for the input, by its hash or as one of
+// the keys of its script.
+func (inputScript *psbtInputScript) ownedBy(pubKey []byte) bool {
+	script := inputScript.scriptCode
+	if txscript.IsPayToPubKeyHash(script) {
+		return bytes.Equal(script[3:23], btcutil.Hash160(pubKey))
+	}
+	pushes, err := txscript.PushedData(script)
+	if err != nil {
+		return false
+	}
+	for _, push := range pushes {
+		if bytes.Equal(push, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignPSBTRequest is the Signer role on Psbt, base64 or hex encoded.
+type SignPSBTRequest struct {
+	Psbt string `json:"psbt"`
+	// PrivateKeys are WIF keys, each signs all the inputs it can spend.
+	PrivateKeys []string `json:"privateKeys"`
+	// SignatureMap holds the external signatures of the MessageHashMap of
+	// a previous call, keyed by input index: 64 byte schnorr ones for
+	// taproot inputs, r and s otherwise along with the public key of
+	// PubKeys, which defaults to the one of a single bip32 derivation.
+	SignatureMap map[int]string `json:"signatureMap"`
+	PubKeys      map[int]string `json:"pubKeys"`
+	// TapscriptSignatureMap holds the 64 byte schnorr signatures of the
+	// TapscriptMessageHashMap of a previous call, keyed by input index then
+	// hex leaf hash. The x-only key of PubKeys defaults to the one of the
+	// leaf script its taproot derivations or the script itself have.
+	TapscriptSignatureMap map[int]map[string]string `json:"tapscriptSignatureMap"`
+}
+
+type SignedPSBT struct {
+	*EncodedPSBT
+	// MessageHashMap is the sighash of each input left to sign, keyed by
+	// input index. Script path inputs are in TapscriptMessageHashMap.
+	MessageHashMap map[int]string `json:"messageHashMap,omitempty"`
+	// TapscriptMessageHashMap is the sighash of each leaf script left to
+	// sign of the script path inputs, keyed by input index then hex leaf
+	// hash, see tapscriptLeavesToSign.
+	TapscriptMessageHashMap map[int]map[string]string `json:"tapscriptMessageHashMap,omitempty"`
+}
+
+func SignPSBT(request *SignPSBTRequest) (*SignedPSBT, error) {
+	p, p2, err := decodePSBT(request.Psbt)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return nil, err
+	}
+	prevOutFetcher, err := psbtPrevOutFetcher(p)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, privateKey := range request.PrivateKeys {
+		wif, err := btcutil.DecodeWIF(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		signed := false
+		for i := range p.Inputs {
+			ok, err := signPSBTInput(updater, i, wif, prevOutFetcher)
+			if err != nil {
+				return nil, fmt.Errorf("input %d: %w", i, err)
+			}
+			signed = signed || ok
+		}
+		if !signed {
+			return nil, fmt.Errorf("no input is spendable by the private key of %s", wif.SerializePubKey())
+		}
+	}
+
+	for i, signature := range request.SignatureMap {
+		if i < 0 || i >= len(p.Inputs) {
+			return nil, fmt.Errorf("input index %d out of the %d inputs", i, len(p.Inputs))
+		}
+		inputScript, err := newPSBTInputScript(p, i)
+		if err != nil {
+			return nil, err
+		}
+		pubKey := request.PubKeys[i]
+		if inputScript.tapLeaf != nil {
+			if len(p.Inputs[i].TaprootLeafScript) > 1 {
+				return nil, fmt.Errorf("input %d has %d leaf scripts, its signatures go in the tapscript signature map", i, len(p.Inputs[i].TaprootLeafScript))
+			}
+			if err = addTapscriptSignature(&p.Inputs[i], signature, pubKey, *inputScript.tapLeaf, inputScript.hashType); err != nil {
+				return nil, fmt.Errorf("input %d: %w", i, err)
+			}
+			continue
+		}
+		if pubKey == "" && len(p.Inputs[i].Bip32Derivation) == 1 {
+			pubKey = hex.EncodeToString(p.Inputs[i].Bip32Derivation[0].PubKey)
+		}
+		if err = ensureWitnessUtxo(p, i, inputScript); err != nil {
+			return nil, err
+		}
+		if err = addInputSignature(p, i, signature, pubKey, inputScript.hashType); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+
+	for i, leafSignatures := range request.TapscriptSignatureMap {
+		if i < 0 || i >= len(p.Inputs) {
+			return nil, fmt.Errorf("input index %d out of the %d inputs", i, len(p.Inputs))
+		}
+		inputScript, err := newPSBTInputScript(p, i)
+		if err != nil {
+			return nil, err
+		}
+		if inputScript.tapLeaf == nil {
+			return nil, fmt.Errorf("input %d has no leaf script", i)
+		}
+		for leafHash, signature := range leafSignatures {
+			tapLeaf, err := psbtTapLeaf(&p.Inputs[i], leafHash)
+			if err != nil {
+				return nil, fmt.Errorf("input %d: %w", i, err)
+			}
+			if err = addTapscriptSignature(&p.Inputs[i], signature, request.PubKeys[i], tapLeaf, inputScript.hashType); err != nil {
+				return nil, fmt.Errorf("input %d leaf %s: %w", i, leafHash, err)
+			}
+		}
+	}
+
+	signedPsbt := &SignedPSBT{MessageHashMap: make(map[int]string), TapscriptMessageHashMap: make(map[int]map[string]string)}
+	for i, in := range p.Inputs {
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil || in.TaprootKeySpendSig != nil {
+			continue
+		}
+		if _, ok := tapscriptWitness(&p.Inputs[i]); ok {
+			continue
+		}
+		inputScript, err := newPSBTInputScript(p, i)
+		if err != nil {
+			continue
+		}
+		if inputScript.tapLeaf != nil {
+			leafHashes := make(map[string]string)
+			for _, leaf := range tapscriptLeavesToSign(&p.Inputs[i]) {
+				tapLeaf := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script)
+				hash, err := inputScript.leafMessageHash(p.UnsignedTx, i, prevOutFetcher, tapLeaf)
+				if err != nil {
+					return nil, err
+				}
+				leafHash := tapLeaf.TapHash()
+				leafHashes[hex.EncodeToString(leafHash[:])] = hexutil.Encode(hash)
+			}
+			if len(leafHashes) > 0 {
+				signedPsbt.TapscriptMessageHashMap[i] = leafHashes
+			}
+			continue
+		}
+		// a single key input is done once it has a signature, a multisig
+		// one once it has the threshold
+		required := 1
+		if _, threshold, err := txscript.CalcMultiSigStats(inputScript.scriptCode); err == nil {
+			required = threshold
+		}
+		if len(in.PartialSigs) >= required {
+			continue
+		}
+		hash, err := inputScript.messageHash(p.UnsignedTx, i, prevOutFetcher)
+		if err != nil {
+			return nil, err
+		}
+		signedPsbt.MessageHashMap[i] = hexutil.Encode(hash)
+	}
+	if signedPsbt.EncodedPSBT, err = encodePSBT(p, p2); err != nil {
+		return nil, err
+	}
+	return signedPsbt, nil
+}
+
+// signPSBTInput signs input i if wif can spend it and has not signed it
+// yet, taproot inputs carrying leaf scripts by the leaves having its key.
+func signPSBTInput(updater *psbt.Updater, i int, wif *btcutil.WIF, prevOutFetcher *txscript.MultiPrevOutFetcher) (bool, error) {
+	p := updater.Upsbt
+	in := &p.Inputs[i]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+		return false, nil
+	}
+	inputScript, err := newPSBTInputScript(p, i)
+	if err != nil {
+		// a P2SH-P2WPKH input of the key needs no redeem script
+		prevOut, prevErr := psbtPrevOutput(p, i)
+		if prevErr != nil || !txscript.IsPayToScriptHash(prevOut.PkScript) || in.RedeemScript != nil {
+			return false, nil
+		}
+		redeemScript, _ := PayToWitnessPubKeyHashScript(btcutil.Hash160(wif.SerializePubKey()))
+		if !bytes.Equal(prevOut.PkScript[2:22], btcutil.Hash160(redeemScript)) {
+			return false, nil
+		}
+		if err = updater.AddInRedeemScript(redeemScript, i); err != nil {
+			return false, err
+		}
+		if inputScript, err = newPSBTInputScript(p, i); err != nil {
+			return false, err
+		}
+	}
+	tx := p.UnsignedTx
+	privKey := wif.PrivKey
+
+	if inputScript.tapLeaf != nil {
+		return signTapscriptPSBTInput(p, i, privKey, inputScript, prevOutFetcher)
+	} else if inputScript.taproot {
+		if in.TaprootKeySpendSig != nil {
+			return false, nil
+		}
+		outputKey := txscript.ComputeTaprootOutputKey(privKey.PubKey(), in.TaprootMerkleRoot)
+		if !bytes.Equal(schnorr.SerializePubKey(outputKey), inputScript.scriptCode[2:]) {
+			return false, nil
+		}
+		signature, err := txscript.RawTxInTaprootSignature(tx, txscript.NewTxSigHashes(tx, prevOutFetcher), i,
+			inputScript.prevOut.Value, inputScript.scriptCode, in.TaprootMerkleRoot, inputScript.hashType, privKey)
+		if err != nil {
+			return false, err
+		}
+		in.TaprootKeySpendSig = signature
+		if in.TaprootInternalKey == nil {
+			in.TaprootInternalKey = schnorr.SerializePubKey(privKey.PubKey())
+		}
+		return true, nil
+	}
+
+	pubKey := wif.SerializePubKey()
+	if !inputScript.ownedBy(pubKey) {
+		return false, nil
+	}
+	for _, partialSig := range in.PartialSigs {
+		if bytes.Equal(partialSig.PubKey, pubKey) {
+			return false, nil
+		}
+	}
+	var signature []byte
+	if inputScript.witness {
+		signature, err = txscript.RawTxInWitnessSignature(tx, txscript.NewTxSigHashes(tx, prevOutFetcher), i,
+			inputScript.prevOut.Value, inputScript.scriptCode, inputScript.hashType, privKey)
+	} else {
+		signature, err = txscript.RawTxInSignature(tx, i, inputScript.scriptCode, inputScript.hashType, privKey)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = ensureWitnessUtxo(p, i, inputScript); err != nil {
+		return false, err
+	}
+	if _, err = updater.Sign(i, signature, pubKey, nil, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ensureWitnessUtxo adds the witness utxo of a segwit input known by its
+// non witness utxo only, the finalizer needs it.
+func ensureWitnessUtxo(p *psbt.Packet, i int, inputScript *psbtInputScript) error {
+	if !inputScript.witness || p.Inputs[i].WitnessUtxo != nil {
+		return nil
+	}
+	updater := &psbt.Updater{Upsbt: p}
+	return updater.AddInWitnessUtxo(inputScript.prevOut, i)
+}
+
+// CombinePSBTs is the Combiner role: it merges the psbts of co-signers of
+// the same unsigned tx, a field set by several of them keeps the first
+// value. The result has the version of the first psbt, version 2 ones
+// stay modifiable only as far as all of them are.
+func CombinePSBTs(psbts []string) (*EncodedPSBT, error) {
+	if len(psbts) == 0 {
+		return nil, errors.New("missing psbts")
+	}
+	combined, combinedV2, err := decodePSBT(psbts[0])
+	if err != nil {
+		return nil, err
+	}
+	for n, encoded := range psbts[1:] {
+		p, p2, err := decodePSBT(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("psbt %d: %w", n+1, err)
+		}
+		if combinedV2 != nil && p2 != nil {
+			modifiable := PSBTInputsModifiable | PSBTOutputsModifiable
+			combinedV2.Modifiable = combinedV2.Modifiable&p2.Modifiable&modifiable | (combinedV2.Modifiable|p2.Modifiable)&PSBTHasSighashSingle
+		}
+		if p.UnsignedTx.TxHash() != combined.UnsignedTx.TxHash() {
+			return nil, fmt.Errorf("psbt %d is not of the same unsigned tx", n+1)
+		}
+		for i := range p.Inputs {
+			if err = combinePSBTInput(&combined.Inputs[i], &p.Inputs[i]); err != nil {
+				return nil, fmt.Errorf("input %d: %w", i, err)
+			}
+		}
+		for i := range p.Outputs {
+			combinePSBTOutput(&combined.Outputs[i], &p.Outputs[i])
+		}
+		combined.Unknowns = combineUnknowns(combined.Unknowns, p.Unknowns)
+	}
+	if err = combined.SanityCheck(); err != nil {
+		return nil, err
+	}
+	return encodePSBT(combined, combinedV2)
+}
+
+func combinePSBTInput(to *psbt.PInput, from *psbt.PInput) error {
+	if to.SighashType != 0 && from.SighashType != 0 && to.SighashType != from.SighashType {
+		return errors.New("conflicting sighash types")
+	}
+	if to.SighashType == 0 {
+		to.SighashType = from.SighashType
+	}
+	if to.NonWitnessUtxo == nil {
+		to.NonWitnessUtxo = from.NonWitnessUtxo
+	}
+	if to.WitnessUtxo == nil {
+		to.WitnessUtxo = from.WitnessUtxo
+	}
+	to.RedeemScript = combineBytes(to.RedeemScript, from.RedeemScript)
+	to.WitnessScript = combineBytes(to.WitnessScript, from.WitnessScript)
+	to.FinalScriptSig = combineBytes(to.FinalScriptSig, from.FinalScriptSig)
+	to.FinalScriptWitness = combineBytes(to.FinalScriptWitness, from.FinalScriptWitness)
+	to.TaprootKeySpendSig = combineBytes(to.TaprootKeySpendSig, from.TaprootKeySpendSig)
+	to.TaprootInternalKey = combineBytes(to.TaprootInternalKey, from.TaprootInternalKey)
+	to.TaprootMerkleRoot = combineBytes(to.TaprootMerkleRoot, from.TaprootMerkleRoot)
+
+	for _, partialSig := range from.PartialSigs {
+		if !containsPartialSig(to.PartialSigs, partialSig.PubKey) {
+			to.PartialSigs = append(to.PartialSigs, partialSig)
+		}
+	}
+	for _, derivation := range from.Bip32Derivation {
+		if !containsBip32Derivation(to.Bip32Derivation, derivation.PubKey) {
+			to.Bip32Derivation = append(to.Bip32Derivation, derivation)
+		}
+	}
+	for _, scriptSpendSig := range from.TaprootScriptSpendSig {
+		found := false
+		for _, sig := range to.TaprootScriptSpendSig {
+			found = found || bytes.Equal(sig.XOnlyPubKey, scriptSpendSig.XOnlyPubKey) && bytes.Equal(sig.LeafHash, scriptSpendSig.LeafHash)
+		}
+		if !found {
+			to.TaprootScriptSpendSig = append(to.TaprootScriptSpendSig, scriptSpendSig)
+		}
+	}
+	for _, leafScript := range from.TaprootLeafScript {
+		found := false
+		for _, leaf := range to.TaprootLeafScript {
+			found = found || bytes.Equal(leaf.ControlBlock, leafScript.ControlBlock)
+		}
+		if !found {
+			to.TaprootLeafScript = append(to.TaprootLeafScript, leafScript)
+		}
+	}
+	to.TaprootBip32Derivation = combineTaprootBip32Derivations(to.TaprootBip32Derivation, from.TaprootBip32Derivation)
+	to.Unknowns = combineUnknowns(to.Unknowns, from.Unknowns)
+	return nil
+}
+
+func combinePSBTOutput(to *psbt.POutput, from *psbt.POutput) {
+	to.RedeemScript = combineBytes(to.RedeemScript, from.RedeemScript)
+	to.WitnessScript = combineBytes(to.WitnessScript, from.WitnessScript)
+	to.TaprootInternalKey = combineBytes(to.TaprootInternalKey, from.TaprootInternalKey)
+	to.TaprootTapTree = combineBytes(to.TaprootTapTree, from.TaprootTapTree)
+	for _, derivation := range from.Bip32Derivation {
+		if !containsBip32Derivation(to.Bip32Derivation, derivation.PubKey) {
+			to.Bip32Derivation = append(to.Bip32Derivation, derivation)
+		}
+	}
+	to.TaprootBip32Derivation = combineTaprootBip32Derivations(to.TaprootBip32Derivation, from.TaprootBip32Derivation)
+	to.Unknowns = combineUnknowns(to.Unknowns, from.Unknowns)
+}
+
+func combineBytes(to []byte, from []byte) []byte {
+	if to == nil {
+		return from
+	}
+	return to
+}
+
+func containsPartialSig(partialSigs []*psbt.PartialSig, pubKey []byte) bool {
+	for _, partialSig := range partialSigs {
+		if bytes.Equal(partialSig.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsBip32Derivation(derivations []*psbt.Bip32Derivation, pubKey []byte) bool {
+	for _, derivation := range derivations {
+		if bytes.Equal(derivation.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func combineTaprootBip32Derivations(to []*psbt.TaprootBip32Derivation, from []*psbt.TaprootBip32Derivation) []*psbt.TaprootBip32Derivation {
+	for _, derivation := range from {
+		found := false
+		for _, d := range to {
+			found = found || bytes.Equal(d.XOnlyPubKey, derivation.XOnlyPubKey)
+		}
+		if !found {
+			to = append(to, derivation)
+		}
+	}
+	return to
+}
+
+func combineUnknowns(to []*psbt.Unknown, from []*psbt.Unknown) []*psbt.Unknown {
+	for _, unknown := range from {
+		found := false
+		for _, u := range to {
+			found = found || bytes.Equal(u.Key, unknown.Key)
+		}
+		if !found {
+			to = append(to, unknown)
+		}
+	}
+	return to
+}
+
+type FinalizedPSBT struct {
+	*EncodedPSBT
+	// Complete is set once all the inputs are finalized.
+	Complete bool `json:"complete"`
+}
+
+// FinalizePSBT is the Finalizer role: it finalizes every input having all
+// its signatures, the others are left for more signers.
+func FinalizePSBT(encoded string) (*FinalizedPSBT, error) {
+	p, p2, err := decodePSBT(encoded)
+	if err != nil {
+		return nil, err
+	}
+	for i := range p.Inputs {
+		// btcd reports a multisig input missing signatures as unsupported
+		_, err = finalizePSBTInput(p, i)
+		if err != nil && !errors.Is(err, psbt.ErrNotFinalizable) && !errors.Is(err, psbt.ErrUnsupportedScriptType) {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	finalized := &FinalizedPSBT{Complete: p.IsComplete()}
+	if finalized.EncodedPSBT, err = encodePSBT(p, p2); err != nil {
+		return nil, err
+	}
+	return finalized, nil
+}
+
+// ExtractPSBT is the Extractor role: the hex tx of a finalized psbt, once
+// its inputs pass the script engine.
+func ExtractPSBT(encoded string) (string, error) {
+	p, err := DecodePSBT(encoded)
+	if err != nil {
+		return "", err
+	}
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return "", err
+	}
+	prevOutFetcher, err := psbtPrevOutFetcher(p)
+	if err != nil {
+		return "", err
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	for i := range tx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(tx.TxIn[i].PreviousOutPoint)
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, prevOutFetcher)
+		if err != nil {
+			return "", err
+		}
+		if err = vm.Execute(); err != nil {
+			return "", fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return GetTxHex(tx)
+}
@@ -0,0 +1,191 @@
+package bitcoin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPSBTRoles(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	pubKey := "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+	addresses := []string{
+		"tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		"tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		"2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc",
+		"mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE",
+	}
+
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+	for _, address := range addresses {
+		pkScript, err := AddrToPkScript(address, network)
+		require.Nil(t, err)
+		prevTx.AddTxOut(wire.NewTxOut(10000, pkScript))
+	}
+	prevTxHex, err := GetTxHex(prevTx)
+	require.Nil(t, err)
+
+	var inputs []*TxInput
+	for i := range addresses {
+		inputs = append(inputs, &TxInput{TxId: prevTx.TxHash().String(), VOut: uint32(i)})
+	}
+	created, err := CreatePSBT(network, &CreatePSBTRequest{
+		Inputs:  inputs,
+		Outputs: []*TxOutput{{Address: addresses[0], Amount: 39000}},
+	})
+	require.Nil(t, err)
+
+	derivation := []*PSBTBip32Derivation{{PublicKey: pubKey, MasterFingerprint: 0x12345678, DerivationPath: "m/84'/1'/0'/0/0"}}
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		// the hex encoding is accepted as well
+		Psbt: created.PsbtHex,
+		Inputs: []*PSBTInputUpdate{
+			{Index: 0, Address: addresses[0], Amount: 10000},
+			{Index: 1, NonWitnessUtxo: prevTxHex, Bip32Derivation: derivation},
+			{Index: 2, Address: addresses[2], Amount: 10000, Bip32Derivation: derivation},
+			{Index: 3, NonWitnessUtxo: prevTxHex, Bip32Derivation: derivation},
+		},
+		Outputs: []*PSBTOutputUpdate{{Index: 0, Bip32Derivation: derivation}},
+	})
+	require.Nil(t, err)
+	p, err := DecodePSBT(updated.Psbt)
+	require.Nil(t, err)
+	assert.NotNil(t, p.Inputs[1].WitnessUtxo)
+	assert.NotNil(t, p.Inputs[2].RedeemScript)
+	assert.Nil(t, p.Inputs[3].WitnessUtxo)
+	// the taproot output and input get the BIP371 derivation instead
+	assert.Empty(t, p.Outputs[0].Bip32Derivation)
+	assert.Len(t, p.Outputs[0].TaprootBip32Derivation, 1)
+	assert.NotNil(t, p.Outputs[0].TaprootInternalKey)
+
+	t.Run("by key", func(t *testing.T) {
+		signed, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{privateKey}})
+		require.Nil(t, err)
+		assert.Empty(t, signed.MessageHashMap)
+		finalized, err := FinalizePSBT(signed.Psbt)
+		require.Nil(t, err)
+		assert.True(t, finalized.Complete)
+		txHex, err := ExtractPSBT(finalized.PsbtHex)
+		require.Nil(t, err)
+
+		privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+		require.Nil(t, err)
+		unsigned, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt})
+		require.Nil(t, err)
+		require.Len(t, unsigned.MessageHashMap, 4)
+		signatureMap := make(map[int]string)
+		for i, messageHash := range unsigned.MessageHashMap {
+			hash, err := hexutil.Decode(messageHash)
+			require.Nil(t, err)
+			if i == 0 {
+				signature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*privateKeyWif.PrivKey, nil), hash)
+				require.Nil(t, err)
+				signatureMap[i] = hex.EncodeToString(signature.Serialize())
+			} else {
+				compact, err := ecdsa.SignCompact(privateKeyWif.PrivKey, hash, true)
+				require.Nil(t, err)
+				signatureMap[i] = hex.EncodeToString(compact[1:])
+			}
+		}
+		externallySigned, err := SignPSBT(&SignPSBTRequest{Psbt: unsigned.Psbt, SignatureMap: signatureMap})
+		require.Nil(t, err)
+		assert.Empty(t, externallySigned.MessageHashMap)
+		finalized, err = FinalizePSBT(externallySigned.Psbt)
+		require.Nil(t, err)
+		externalTxHex, err := ExtractPSBT(finalized.Psbt)
+		require.Nil(t, err)
+		assert.Equal(t, txHex, externalTxHex)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{"cVdZ6iajhDrzRRW1GHK2VoTnBNEiafr9G9tW4WhVKBXN8vvZYmbH"}})
+		assert.NotNil(t, err)
+
+		_, err = UpdatePSBT(network, &UpdatePSBTRequest{Psbt: created.Psbt, Inputs: []*PSBTInputUpdate{{Index: 1, NonWitnessUtxo: prevTxHex, Address: addresses[0]}}})
+		assert.NotNil(t, err)
+		_, err = UpdatePSBT(network, &UpdatePSBTRequest{Psbt: created.Psbt, Inputs: []*PSBTInputUpdate{{Index: 4}}})
+		assert.NotNil(t, err)
+
+		finalized, err := FinalizePSBT(updated.Psbt)
+		require.Nil(t, err)
+		assert.False(t, finalized.Complete)
+		_, err = ExtractPSBT(finalized.Psbt)
+		assert.NotNil(t, err)
+
+		other, err := CreatePSBT(network, &CreatePSBTRequest{Inputs: inputs[:1], Outputs: []*TxOutput{{Address: addresses[0], Amount: 9000}}})
+		require.Nil(t, err)
+		_, err = CombinePSBTs([]string{updated.Psbt, other.Psbt})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestPSBTRolesMultisig(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	var wifs []*btcutil.WIF
+	var pubKeys []*btcutil.AddressPubKey
+	for _, seed := range []string{"cosigner a", "cosigner b", "cosigner c"} {
+		hash := sha256.Sum256([]byte(seed))
+		privKey, _ := btcec.PrivKeyFromBytes(hash[:])
+		wif, err := btcutil.NewWIF(privKey, network, true)
+		require.Nil(t, err)
+		wifs = append(wifs, wif)
+		pubKey, err := btcutil.NewAddressPubKey(wif.SerializePubKey(), network)
+		require.Nil(t, err)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	witnessScript, err := txscript.MultiSigScript(pubKeys, 2)
+	require.Nil(t, err)
+	scriptHash := sha256.Sum256(witnessScript)
+	address, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], network)
+	require.Nil(t, err)
+
+	created, err := CreatePSBT(network, &CreatePSBTRequest{
+		Inputs:  []*TxInput{{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5"}},
+		Outputs: []*TxOutput{{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 9000}},
+	})
+	require.Nil(t, err)
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt:   created.Psbt,
+		Inputs: []*PSBTInputUpdate{{Index: 0, Address: address.EncodeAddress(), Amount: 10000, WitnessScript: hex.EncodeToString(witnessScript)}},
+	})
+	require.Nil(t, err)
+
+	signedA, err := SignPSBT(&SignPSBTRequest{Psbt: updated.PsbtHex, PrivateKeys: []string{wifs[0].String()}})
+	require.Nil(t, err)
+	// the second signature is still missing
+	assert.Len(t, signedA.MessageHashMap, 1)
+	finalized, err := FinalizePSBT(signedA.Psbt)
+	require.Nil(t, err)
+	assert.False(t, finalized.Complete)
+
+	signedC, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{wifs[2].String()}})
+	require.Nil(t, err)
+	combined, err := CombinePSBTs([]string{signedA.Psbt, signedC.PsbtHex})
+	require.Nil(t, err)
+	p, err := DecodePSBT(combined.Psbt)
+	require.Nil(t, err)
+	assert.Len(t, p.Inputs[0].PartialSigs, 2)
+	signed, err := SignPSBT(&SignPSBTRequest{Psbt: combined.Psbt})
+	require.Nil(t, err)
+	assert.Empty(t, signed.MessageHashMap)
+
+	finalized, err = FinalizePSBT(combined.Psbt)
+	require.Nil(t, err)
+	assert.True(t, finalized.Complete)
+	_, err = ExtractPSBT(finalized.Psbt)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,358 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// parseXOnlyPubKey parses a hex public key, compressed or already x-only.
+func parseXOnlyPubKey(pubKey string) ([]byte, error) {
+	pubKeyBytes, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(pubKeyBytes) == schnorr.PubKeyBytesLen {
+		if _, err = schnorr.ParsePubKey(pubKeyBytes); err != nil {
+			return nil, err
+		}
+		return pubKeyBytes, nil
+	}
+	key, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.SerializePubKey(key), nil
+}
+
+// addTaprootInputScript sets the BIP371 fields of a taproot input spending
+// pkScript: the leaf script of controlBlock for a script path spend, along
+// with the internal key and merkle root it proves, or else internalKey and
+// merkleRoot for a key path spend. They must commit to pkScript.
+func addTaprootInputScript(in *psbt.PInput, pkScript []byte, internalKey []byte, merkleRoot []byte, leafScript []byte, controlBlock []byte) error {
+	if len(controlBlock) > 0 {
+		cb, err := txscript.ParseControlBlock(controlBlock)
+		if err != nil {
+			return err
+		}
+		if len(leafScript) == 0 {
+			return errors.New("missing the leaf script of the control block")
+		}
+		internalKey, merkleRoot = schnorr.SerializePubKey(cb.InternalKey), cb.RootHash(leafScript)
+		if err = checkTaprootOutputKey(pkScript, internalKey, merkleRoot); err != nil {
+			return err
+		}
+		leafVersion := cb.LeafVersion
+		found := false
+		for _, leaf := range in.TaprootLeafScript {
+			found = found || bytes.Equal(leaf.ControlBlock, controlBlock) && bytes.Equal(leaf.Script, leafScript)
+		}
+		if !found {
+			in.TaprootLeafScript = append(in.TaprootLeafScript, &psbt.TaprootTapLeafScript{
+				ControlBlock: controlBlock,
+				Script:       leafScript,
+				LeafVersion:  leafVersion,
+			})
+		}
+	} else if len(leafScript) > 0 {
+		return errors.New("missing the control block of the leaf script")
+	} else if len(internalKey) == 0 {
+		return nil
+	} else if err := checkTaprootOutputKey(pkScript, internalKey, merkleRoot); err != nil {
+		return err
+	}
+	in.TaprootInternalKey = internalKey
+	if len(merkleRoot) > 0 {
+		in.TaprootMerkleRoot = merkleRoot
+	}
+	return nil
+}
+
+// checkTaprootOutputKey checks that the output key of pkScript is the
+// internal key tweaked by merkleRoot, an empty one for a BIP86 key.
+func checkTaprootOutputKey(pkScript []byte, internalKey []byte, merkleRoot []byte) error {
+	key, err := schnorr.ParsePubKey(internalKey)
+	if err != nil {
+		return err
+	}
+	outputKey := txscript.ComputeTaprootOutputKey(key, merkleRoot)
+	if !txscript.IsPayToTaproot(pkScript) || !bytes.Equal(schnorr.SerializePubKey(outputKey), pkScript[2:]) {
+		return errors.New("the internal key does not match the taproot output")
+	}
+	return nil
+}
+
+// addTaprootInputBip32Derivation adds the derivation of the x-only pubKey
+// along with the hashes of the leaf scripts of the input it signs in, the
+// leaf scripts must be set first.
+func addTaprootInputBip32Derivation(in *psbt.PInput, pubKey []byte, masterFingerprint uint32, path []uint32) {
+	derivation := &psbt.TaprootBip32Derivation{XOnlyPubKey: pubKey, MasterKeyFingerprint: masterFingerprint, Bip32Path: path}
+	for _, leaf := range in.TaprootLeafScript {
+		if tapscriptHasKey(leaf.Script, pubKey) {
+			leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+			derivation.LeafHashes = append(derivation.LeafHashes, leafHash[:])
+		}
+	}
+	in.TaprootBip32Derivation = combineTaprootBip32Derivations(in.TaprootBip32Derivation, []*psbt.TaprootBip32Derivation{derivation})
+}
+
+// addTaprootOutputBip32Derivation adds the internal key and derivation of
+// a BIP86 taproot output, a change output of the wallet.
+func addTaprootOutputBip32Derivation(out *psbt.POutput, pkScript []byte, pubKey []byte, masterFingerprint uint32, path []uint32) error {
+	if err := checkTaprootOutputKey(pkScript, pubKey, nil); err != nil {
+		return err
+	}
+	out.TaprootInternalKey = pubKey
+	out.TaprootBip32Derivation = combineTaprootBip32Derivations(out.TaprootBip32Derivation, []*psbt.TaprootBip32Derivation{{
+		XOnlyPubKey:          pubKey,
+		MasterKeyFingerprint: masterFingerprint,
+		Bip32Path:            path,
+	}})
+	return nil
+}
+
+// tapscriptKeys are the x-only keys a leaf script checks signatures of, in
+// script order, and how many signatures it needs: all of them, or the
+// number OP_CHECKSIGADD sums up to.
+func tapscriptKeys(script []byte) ([][]byte, int) {
+	var keys [][]byte
+	var lastData []byte
+	lastOp := byte(txscript.OP_INVALIDOPCODE)
+	checkSigAdd, threshold := false, 0
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		op := tokenizer.Opcode()
+		switch op {
+		case txscript.OP_CHECKSIG, txscript.OP_CHECKSIGVERIFY, txscript.OP_CHECKSIGADD:
+			if len(lastData) == schnorr.PubKeyBytesLen {
+				keys = append(keys, lastData)
+			}
+			checkSigAdd = checkSigAdd || op == txscript.OP_CHECKSIGADD
+		case txscript.OP_NUMEQUAL, txscript.OP_NUMEQUALVERIFY:
+			if !checkSigAdd {
+				break
+			}
+			if txscript.IsSmallInt(lastOp) {
+				threshold = txscript.AsSmallInt(lastOp)
+			} else if len(lastData) > 0 && len(lastData) <= 4 {
+				threshold = 0
+				for i := len(lastData) - 1; i >= 0; i-- {
+					threshold = threshold<<8 | int(lastData[i])
+				}
+			}
+		}
+		lastOp, lastData = op, tokenizer.Data()
+	}
+	if !checkSigAdd {
+		threshold = len(keys)
+	}
+	return keys, threshold
+}
+
+func tapscriptHasKey(script []byte, pubKey []byte) bool {
+	keys, _ := tapscriptKeys(script)
+	for _, key := range keys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// tapscriptWitness is the script path witness of the cheapest leaf script
+// of in its signatures satisfy. The signatures are ordered by the keys of
+// the script, missing ones of an OP_CHECKSIGADD script left empty.
+func tapscriptWitness(in *psbt.PInput) (wire.TxWitness, bool) {
+	var witness wire.TxWitness
+	for _, leaf := range in.TaprootLeafScript {
+		if witness != nil && len(leaf.ControlBlock) >= len(witness[len(witness)-1]) {
+			continue
+		}
+		leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+		keys, threshold := tapscriptKeys(leaf.Script)
+		if threshold == 0 {
+			continue
+		}
+		stack := make(wire.TxWitness, len(keys))
+		count := 0
+		for j, key := range keys {
+			stack[len(keys)-1-j] = []byte{}
+			for _, scriptSpendSig := range in.TaprootScriptSpendSig {
+				if count < threshold && bytes.Equal(scriptSpendSig.LeafHash, leafHash[:]) && bytes.Equal(scriptSpendSig.XOnlyPubKey, key) {
+					signature := append([]byte{}, scriptSpendSig.Signature...)
+					if scriptSpendSig.SigHash != txscript.SigHashDefault {
+						signature = append(signature, byte(scriptSpendSig.SigHash))
+					}
+					stack[len(keys)-1-j] = signature
+					count++
+					break
+				}
+			}
+		}
+		if count == threshold {
+			witness = append(stack, leaf.Script, leaf.ControlBlock)
+		}
+	}
+	return witness, witness != nil
+}
+
+// signTapscriptPSBTInput signs input i by every leaf script having the key
+// of privKey it has not signed yet.
+func signTapscriptPSBTInput(p *psbt.Packet, i int, privKey *btcec.PrivateKey, inputScript *psbtInputScript, prevOutFetcher *txscript.MultiPrevOutFetcher) (bool, error) {
+	in := &p.Inputs[i]
+	pubKey := schnorr.SerializePubKey(privKey.PubKey())
+	tx := p.UnsignedTx
+	signed := false
+	for _, leaf := range in.TaprootLeafScript {
+		if !tapscriptHasKey(leaf.Script, pubKey) {
+			continue
+		}
+		tapLeaf := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script)
+		leafHash := tapLeaf.TapHash()
+		scriptSpendSig := &psbt.TaprootScriptSpendSig{XOnlyPubKey: pubKey, LeafHash: leafHash[:], SigHash: inputScript.hashType}
+		if hasTaprootScriptSpendSig(in, scriptSpendSig) {
+			continue
+		}
+		signature, err := txscript.RawTxInTapscriptSignature(tx, txscript.NewTxSigHashes(tx, prevOutFetcher), i,
+			inputScript.prevOut.Value, inputScript.scriptCode, tapLeaf, inputScript.hashType, privKey)
+		if err != nil {
+			return false, err
+		}
+		scriptSpendSig.Signature = signature[:schnorr.SignatureSize]
+		in.TaprootScriptSpendSig = append(in.TaprootScriptSpendSig, scriptSpendSig)
+		signed = true
+	}
+	return signed, nil
+}
+
+func hasTaprootScriptSpendSig(in *psbt.PInput, scriptSpendSig *psbt.TaprootScriptSpendSig) bool {
+	for _, sig := range in.TaprootScriptSpendSig {
+		if sig.EqualKey(scriptSpendSig) {
+			return true
+		}
+	}
+	return false
+}
+
+// tapscriptLeavesToSign are the leaf scripts of in holding one of the keys
+// of its taproot derivations that has not signed them, any of their keys
+// without derivations.
+func tapscriptLeavesToSign(in *psbt.PInput) []*psbt.TaprootTapLeafScript {
+	var leaves []*psbt.TaprootTapLeafScript
+	for _, leaf := range in.TaprootLeafScript {
+		keys, _ := tapscriptKeys(leaf.Script)
+		if len(in.TaprootBip32Derivation) > 0 {
+			var signerKeys [][]byte
+			for _, derivation := range in.TaprootBip32Derivation {
+				if tapscriptHasKey(leaf.Script, derivation.XOnlyPubKey) {
+					signerKeys = append(signerKeys, derivation.XOnlyPubKey)
+				}
+			}
+			keys = signerKeys
+		}
+		leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+		for _, key := range keys {
+			if !hasTaprootScriptSpendSig(in, &psbt.TaprootScriptSpendSig{XOnlyPubKey: key, LeafHash: leafHash[:]}) {
+				leaves = append(leaves, leaf)
+				break
+			}
+		}
+	}
+	return leaves
+}
+
+// psbtTapLeaf is the leaf script of in whose hex leaf hash is leafHash.
+func psbtTapLeaf(in *psbt.PInput, leafHash string) (txscript.TapLeaf, error) {
+	for _, leaf := range in.TaprootLeafScript {
+		tapLeaf := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script)
+		hash := tapLeaf.TapHash()
+		if hex.EncodeToString(hash[:]) == leafHash {
+			return tapLeaf, nil
+		}
+	}
+	return txscript.TapLeaf{}, fmt.Errorf("no leaf script of hash %s", leafHash)
+}
+
+// addTapscriptSignature adds the external 64 byte schnorr signature of
+// tapLeaf by pubKey, which defaults to the derivation key of the input in
+// the leaf script or to its single key.
+func addTapscriptSignature(in *psbt.PInput, signature string, pubKey string, tapLeaf txscript.TapLeaf, hashType txscript.SigHashType) error {
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	if len(signatureBytes) != schnorr.SignatureSize {
+		return errors.New("taproot signature must be 64 bytes")
+	}
+	var xOnlyPubKey []byte
+	if pubKey != "" {
+		if xOnlyPubKey, err = parseXOnlyPubKey(pubKey); err != nil {
+			return err
+		}
+	} else {
+		// the derivation key of this leaf, or its single key
+		var keys [][]byte
+		for _, derivation := range in.TaprootBip32Derivation {
+			if tapscriptHasKey(tapLeaf.Script, derivation.XOnlyPubKey) {
+				keys = append(keys, derivation.XOnlyPubKey)
+			}
+		}
+		if len(keys) == 0 {
+			keys, _ = tapscriptKeys(tapLeaf.Script)
+		}
+		if len(keys) != 1 {
+			return errors.New("missing public key")
+		}
+		xOnlyPubKey = keys[0]
+	}
+	if !tapscriptHasKey(tapLeaf.Script, xOnlyPubKey) {
+		return errors.New("the public key is not one of the leaf script")
+	}
+	leafHash := tapLeaf.TapHash()
+	scriptSpendSig := &psbt.TaprootScriptSpendSig{XOnlyPubKey: xOnlyPubKey, LeafHash: leafHash[:], Signature: signatureBytes, SigHash: hashType}
+	if hasTaprootScriptSpendSig(in, scriptSpendSig) {
+		return errors.New("the leaf script is already signed by the public key")
+	}
+	in.TaprootScriptSpendSig = append(in.TaprootScriptSpendSig, scriptSpendSig)
+	return nil
+}
+
+// finalizePSBTInput is psbt.MaybeFinalize, but for the script path of
+// taproot inputs: btcd takes their signatures in the order they are
+// stored, whatever the order of the keys in the script.
+func finalizePSBTInput(p *psbt.Packet, i int) (bool, error) {
+	in := &p.Inputs[i]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil || in.TaprootKeySpendSig != nil ||
+		len(in.TaprootScriptSpendSig) == 0 || in.WitnessUtxo == nil || !txscript.IsPayToTaproot(in.WitnessUtxo.PkScript) {
+		return psbt.MaybeFinalize(p, i)
+	}
+	witness, ok := tapscriptWitness(in)
+	if !ok {
+		return false, psbt.ErrNotFinalizable
+	}
+	var buf bytes.Buffer
+	if err := writeWitness(&buf, witness); err != nil {
+		return false, err
+	}
+	finalized := psbt.NewPsbtInput(nil, in.WitnessUtxo)
+	finalized.FinalScriptWitness = buf.Bytes()
+	p.Inputs[i] = *finalized
+	return true, nil
+}
+
+// finalizePSBT is psbt.MaybeFinalizeAll by finalizePSBTInput.
+func finalizePSBT(p *psbt.Packet) error {
+	for i := range p.UnsignedTx.TxIn {
+		if ok, err := finalizePSBTInput(p, i); err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package bitcoin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateUnsignedTaprootPSBTHex(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	pubKey := "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	wif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	xOnlyPubKey := schnorr.SerializePubKey(wif.PrivKey.PubKey())
+
+	// the reveal leaf of an inscription, committed to by its commit address
+	leafScript, err := txscript.NewScriptBuilder().AddData(xOnlyPubKey).AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte("ord")).AddOp(txscript.OP_ENDIF).Script()
+	require.Nil(t, err)
+	ctxData, err := newTapscriptTxCtxData(network, leafScript, true, wif.PrivKey, wif.PrivKey.PubKey())
+	require.Nil(t, err)
+
+	inputs := []*TxInput{
+		{
+			TxId:              "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+			Amount:            10000,
+			Address:           taprootAddress,
+			MasterFingerprint: 0xF23F9FD2,
+			DerivationPath:    "m/86'/1'/0'/0/0",
+			PublicKey:         pubKey,
+		},
+		{
+			TxId:                "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+			VOut:                1,
+			Amount:              10000,
+			Address:             ctxData.CommitTxAddress,
+			MasterFingerprint:   0xF23F9FD2,
+			DerivationPath:      "m/86'/1'/0'/0/0",
+			PublicKey:           pubKey,
+			TaprootLeafScript:   hex.EncodeToString(ctxData.InscriptionScript),
+			TaprootControlBlock: hex.EncodeToString(ctxData.ControlBlockWitness),
+		},
+	}
+	outputs := []*TxOutput{
+		{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 9000},
+		{Address: taprootAddress, Amount: 10000, IsChange: true, MasterFingerprint: 0xF23F9FD2, DerivationPath: "m/86'/1'/0'/1/0", PublicKey: pubKey},
+	}
+	psbtHex, err := GenerateUnsignedPSBTHex(inputs, outputs, network)
+	require.Nil(t, err)
+	p, err := DecodePSBT(psbtHex)
+	require.Nil(t, err)
+
+	assert.Empty(t, p.Inputs[0].Bip32Derivation)
+	assert.Equal(t, xOnlyPubKey, p.Inputs[0].TaprootInternalKey)
+	assert.Nil(t, p.Inputs[0].TaprootMerkleRoot)
+	require.Len(t, p.Inputs[0].TaprootBip32Derivation, 1)
+	assert.Empty(t, p.Inputs[0].TaprootBip32Derivation[0].LeafHashes)
+
+	// the commit tree also holds the recovery leaf
+	leafHash := txscript.NewBaseTapLeaf(leafScript).TapHash()
+	controlBlock, err := txscript.ParseControlBlock(ctxData.ControlBlockWitness)
+	require.Nil(t, err)
+	assert.Equal(t, xOnlyPubKey, p.Inputs[1].TaprootInternalKey)
+	assert.Equal(t, controlBlock.RootHash(leafScript), p.Inputs[1].TaprootMerkleRoot)
+	require.Len(t, p.Inputs[1].TaprootLeafScript, 1)
+	assert.Equal(t, ctxData.ControlBlockWitness, p.Inputs[1].TaprootLeafScript[0].ControlBlock)
+	require.Len(t, p.Inputs[1].TaprootBip32Derivation, 1)
+	assert.Equal(t, [][]byte{leafHash[:]}, p.Inputs[1].TaprootBip32Derivation[0].LeafHashes)
+
+	assert.Empty(t, p.Outputs[1].Bip32Derivation)
+	assert.Equal(t, xOnlyPubKey, p.Outputs[1].TaprootInternalKey)
+	assert.Len(t, p.Outputs[1].TaprootBip32Derivation, 1)
+
+	t.Run("sign", func(t *testing.T) {
+		signed, err := SignPSBT(&SignPSBTRequest{Psbt: psbtHex, PrivateKeys: []string{privateKey}})
+		require.Nil(t, err)
+		assert.Empty(t, signed.MessageHashMap)
+		p, err := DecodePSBT(signed.Psbt)
+		require.Nil(t, err)
+		assert.NotNil(t, p.Inputs[0].TaprootKeySpendSig)
+		assert.Nil(t, p.Inputs[1].TaprootKeySpendSig)
+		assert.Len(t, p.Inputs[1].TaprootScriptSpendSig, 1)
+
+		txHex, err := ExtractTxFromSignedPSBT(signed.PsbtHex)
+		require.Nil(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.Nil(t, err)
+		assert.Equal(t, ctxData.InscriptionScript, []byte(tx.TxIn[1].Witness[1]))
+		finalized, err := FinalizePSBT(signed.Psbt)
+		require.Nil(t, err)
+		assert.True(t, finalized.Complete)
+		extracted, err := ExtractPSBT(finalized.Psbt)
+		require.Nil(t, err)
+		assert.Equal(t, txHex, extracted)
+
+		unsigned, err := SignPSBT(&SignPSBTRequest{Psbt: psbtHex})
+		require.Nil(t, err)
+		require.Len(t, unsigned.MessageHashMap, 1)
+		hash, err := hexutil.Decode(unsigned.MessageHashMap[0])
+		require.Nil(t, err)
+		signature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*wif.PrivKey, nil), hash)
+		require.Nil(t, err)
+		signatureMap := map[int]string{0: hex.EncodeToString(signature.Serialize())}
+		// the script path is signed by the untweaked key, per leaf
+		require.Len(t, unsigned.TapscriptMessageHashMap, 1)
+		require.Len(t, unsigned.TapscriptMessageHashMap[1], 1)
+		leafHash := txscript.NewBaseTapLeaf(ctxData.InscriptionScript).TapHash()
+		hash, err = hexutil.Decode(unsigned.TapscriptMessageHashMap[1][hex.EncodeToString(leafHash[:])])
+		require.Nil(t, err)
+		signature, err = schnorr.Sign(wif.PrivKey, hash)
+		require.Nil(t, err)
+		tapscriptSignatureMap := map[int]map[string]string{1: {hex.EncodeToString(leafHash[:]): hex.EncodeToString(signature.Serialize())}}
+		externallySigned, err := SignPSBT(&SignPSBTRequest{Psbt: unsigned.Psbt, SignatureMap: signatureMap, TapscriptSignatureMap: tapscriptSignatureMap})
+		require.Nil(t, err)
+		assert.Empty(t, externallySigned.MessageHashMap)
+		assert.Empty(t, externallySigned.TapscriptMessageHashMap)
+		finalized, err = FinalizePSBT(externallySigned.Psbt)
+		require.Nil(t, err)
+		_, err = ExtractPSBT(finalized.Psbt)
+		assert.Nil(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		// the key does not commit to the output
+		other := *inputs[0]
+		other.PublicKey = "02a1633cafcc01ebfb6d78e39f687a1f0995c62fc95f51ead10a02ee0be551b5dc"
+		_, err := GenerateUnsignedPSBTHex([]*TxInput{&other}, outputs, network)
+		assert.NotNil(t, err)
+		other = *inputs[1]
+		other.TaprootLeafScript = hex.EncodeToString(leafScript[:len(leafScript)-1])
+		_, err = GenerateUnsignedPSBTHex([]*TxInput{&other}, outputs, network)
+		assert.NotNil(t, err)
+		other.TaprootControlBlock = ""
+		_, err = GenerateUnsignedPSBTHex([]*TxInput{&other}, outputs, network)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestTapscriptMultisigPSBT(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	var wifs []*btcutil.WIF
+	builder := txscript.NewScriptBuilder()
+	for i, seed := range []string{"cosigner a", "cosigner b", "cosigner c"} {
+		hash := sha256.Sum256([]byte(seed))
+		privKey, _ := btcec.PrivKeyFromBytes(hash[:])
+		wif, err := btcutil.NewWIF(privKey, network, true)
+		require.Nil(t, err)
+		wifs = append(wifs, wif)
+		builder.AddData(schnorr.SerializePubKey(privKey.PubKey()))
+		if i == 0 {
+			builder.AddOp(txscript.OP_CHECKSIG)
+		} else {
+			builder.AddOp(txscript.OP_CHECKSIGADD)
+		}
+	}
+	multisigScript, err := builder.AddOp(txscript.OP_2).AddOp(txscript.OP_NUMEQUAL).Script()
+	require.Nil(t, err)
+	keys, threshold := tapscriptKeys(multisigScript)
+	assert.Len(t, keys, 3)
+	assert.Equal(t, 2, threshold)
+
+	// a second leaf makes the control block carry a proof
+	otherScript, err := txscript.NewScriptBuilder().AddInt64(144).AddOp(txscript.OP_CHECKSEQUENCEVERIFY).AddOp(txscript.OP_DROP).
+		AddData(keys[0]).AddOp(txscript.OP_CHECKSIG).Script()
+	require.Nil(t, err)
+	tree := txscript.AssembleTaprootScriptTree(txscript.NewBaseTapLeaf(multisigScript), txscript.NewBaseTapLeaf(otherScript))
+	internalKey := wifs[0].PrivKey.PubKey()
+	proof := tree.LeafMerkleProofs[0].ToControlBlock(internalKey)
+	controlBlock, err := proof.ToBytes()
+	require.Nil(t, err)
+	rootHash := tree.RootNode.TapHash()
+	address, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootOutputKey(internalKey, rootHash[:])), network)
+	require.Nil(t, err)
+
+	created, err := CreatePSBT(network, &CreatePSBTRequest{
+		Inputs:  []*TxInput{{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5"}},
+		Outputs: []*TxOutput{{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 9000}},
+	})
+	require.Nil(t, err)
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt: created.Psbt,
+		Inputs: []*PSBTInputUpdate{{
+			Index:               0,
+			Address:             address.EncodeAddress(),
+			Amount:              10000,
+			TaprootLeafScript:   hex.EncodeToString(multisigScript),
+			TaprootControlBlock: hex.EncodeToString(controlBlock),
+		}},
+	})
+	require.Nil(t, err)
+	p, err := DecodePSBT(updated.Psbt)
+	require.Nil(t, err)
+	assert.Equal(t, rootHash[:], p.Inputs[0].TaprootMerkleRoot)
+
+	// the signatures come in the reverse order of their keys
+	signedC, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{wifs[2].String()}})
+	require.Nil(t, err)
+	assert.Empty(t, signedC.MessageHashMap)
+	assert.Len(t, signedC.TapscriptMessageHashMap[0], 1)
+	finalized, err := FinalizePSBT(signedC.Psbt)
+	require.Nil(t, err)
+	assert.False(t, finalized.Complete)
+	signed, err := SignPSBT(&SignPSBTRequest{Psbt: signedC.Psbt, PrivateKeys: []string{wifs[0].String()}})
+	require.Nil(t, err)
+	assert.Empty(t, signed.MessageHashMap)
+	p, err = DecodePSBT(signed.Psbt)
+	require.Nil(t, err)
+	assert.Nil(t, p.Inputs[0].TaprootKeySpendSig)
+
+	finalized, err = FinalizePSBT(signed.Psbt)
+	require.Nil(t, err)
+	assert.True(t, finalized.Complete)
+	txHex, err := ExtractPSBT(finalized.Psbt)
+	require.Nil(t, err)
+	tx, err := NewTxFromHex(txHex)
+	require.Nil(t, err)
+	// the missing signature of b is left empty
+	assert.Len(t, tx.TxIn[0].Witness, 5)
+	assert.Empty(t, tx.TxIn[0].Witness[1])
+
+	// btcd alone orders the signatures as they are stored
+	p, err = DecodePSBT(signed.Psbt)
+	require.Nil(t, err)
+	_, err = psbt.MaybeFinalize(p, 0)
+	require.Nil(t, err)
+	extracted, err := psbt.Extract(p)
+	require.Nil(t, err)
+	assert.NotEqual(t, tx.TxIn[0].Witness, extracted.TxIn[0].Witness)
+
+	t.Run("several leaves", func(t *testing.T) {
+		// both leaves have the key of a
+		otherProof := tree.LeafMerkleProofs[1].ToControlBlock(internalKey)
+		otherControlBlock, err := otherProof.ToBytes()
+		require.Nil(t, err)
+		p, err := DecodePSBT(updated.Psbt)
+		require.Nil(t, err)
+		p.Inputs[0].TaprootLeafScript = append(p.Inputs[0].TaprootLeafScript, &psbt.TaprootTapLeafScript{
+			ControlBlock: otherControlBlock,
+			Script:       otherScript,
+			LeafVersion:  txscript.BaseLeafVersion,
+		})
+		p.Inputs[0].TaprootBip32Derivation = []*psbt.TaprootBip32Derivation{{XOnlyPubKey: keys[0], Bip32Path: []uint32{}}}
+		encoded, err := EncodePSBT(p)
+		require.Nil(t, err)
+
+		unsigned, err := SignPSBT(&SignPSBTRequest{Psbt: encoded.Psbt})
+		require.Nil(t, err)
+		assert.Empty(t, unsigned.MessageHashMap)
+		leafHashes := unsigned.TapscriptMessageHashMap[0]
+		require.Len(t, leafHashes, 2)
+		tapscriptSignatureMap := map[int]map[string]string{0: {}}
+		for leafHash, messageHash := range leafHashes {
+			hash, err := hexutil.Decode(messageHash)
+			require.Nil(t, err)
+			signature, err := schnorr.Sign(wifs[0].PrivKey, hash)
+			require.Nil(t, err)
+			tapscriptSignatureMap[0][leafHash] = hex.EncodeToString(signature.Serialize())
+		}
+		// the signature map can't tell the leaves apart
+		multisigLeafHash := txscript.NewBaseTapLeaf(multisigScript).TapHash()
+		_, err = SignPSBT(&SignPSBTRequest{Psbt: unsigned.Psbt, SignatureMap: map[int]string{0: tapscriptSignatureMap[0][hex.EncodeToString(multisigLeafHash[:])]}})
+		assert.NotNil(t, err)
+
+		signed, err := SignPSBT(&SignPSBTRequest{Psbt: unsigned.Psbt, TapscriptSignatureMap: tapscriptSignatureMap})
+		require.Nil(t, err)
+		assert.Empty(t, signed.TapscriptMessageHashMap)
+		p, err = DecodePSBT(signed.Psbt)
+		require.Nil(t, err)
+		require.Len(t, p.Inputs[0].TaprootScriptSpendSig, 2)
+		for _, sig := range p.Inputs[0].TaprootScriptSpendSig {
+			assert.Equal(t, keys[0], sig.XOnlyPubKey)
+			hash, err := hexutil.Decode(leafHashes[hex.EncodeToString(sig.LeafHash)])
+			require.Nil(t, err)
+			signature, err := schnorr.ParseSignature(sig.Signature)
+			require.Nil(t, err)
+			pubKey, err := schnorr.ParsePubKey(sig.XOnlyPubKey)
+			require.Nil(t, err)
+			assert.True(t, signature.Verify(hash, pubKey))
+		}
+	})
+}
@@ -3,6 +3,8 @@ package bitcoin
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/psbt"
@@ -11,6 +13,8 @@ import (
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	txscript2 "github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
 )
 
 type TxInput struct {
@@ -24,6 +28,12 @@ type TxInput struct {
 	MasterFingerprint uint32
 	DerivationPath    string
 	PublicKey         string
+	// TaprootMerkleRoot is the hex script tree root a taproot key path
+	// input commits to, TaprootLeafScript and TaprootControlBlock the hex
+	// leaf a script path input is spent through.
+	TaprootMerkleRoot   string
+	TaprootLeafScript   string
+	TaprootControlBlock string
 }
 
 type TxOutput struct {
@@ -35,72 +45,43 @@ type TxOutput struct {
 	PublicKey         string
 }
 
+// SellerSignatureIndex is the seller index of ListingLayoutTwoDummies, the
+// default of listings and of the purchase requests.
 const SellerSignatureIndex = 2
 
-func GenerateSignedListingPSBTBase64(in *TxInput, out *TxOutput, network *chaincfg.Params) (string, error) {
-	txHash, err := chainhash.NewHashFromStr(in.TxId)
-	if err != nil {
-		return "", err
-	}
-	prevOut := wire.NewOutPoint(txHash, in.VOut)
-	inputs := []*wire.OutPoint{{Index: 0}, {Index: 1}, prevOut}
-
-	pkScript, err := AddrToPkScript(out.Address, network)
-	if err != nil {
-		return "", err
-	}
-	// placeholder
-	dummyPkScript, err := AddrToPkScript("bc1pcyj5mt2q4t4py8jnur8vpxvxxchke4pzy7tdr9yvj3u3kdfgrj6sw3rzmr", network)
-	if err != nil {
-		return "", err
-	}
-	outputs := []*wire.TxOut{{PkScript: dummyPkScript}, {PkScript: dummyPkScript}, wire.NewTxOut(out.Amount, pkScript)}
-
-	nSequences := []uint32{wire.MaxTxInSequenceNum, wire.MaxTxInSequenceNum, wire.MaxTxInSequenceNum}
-	p, err := psbt.New(inputs, outputs, int32(2), uint32(0), nSequences)
-	if err != nil {
-		return "", err
-	}
-
-	updater, err := psbt.NewUpdater(p)
-	if err != nil {
-		return "", err
-	}
-
-	dummyWitnessUtxo := wire.NewTxOut(0, dummyPkScript)
-	err = updater.AddInWitnessUtxo(dummyWitnessUtxo, 0)
-	if err != nil {
-		return "", err
-	}
-	err = updater.AddInWitnessUtxo(dummyWitnessUtxo, 1)
-	if err != nil {
-		return "", err
-	}
-
-	prevPkScript, err := AddrToPkScript(in.Address, network)
-	if err != nil {
-		return "", err
-	}
-	witnessUtxo := wire.NewTxOut(in.Amount, prevPkScript)
-	prevOuts := map[wire.OutPoint]*wire.TxOut{
-		wire.OutPoint{Index: 0}: dummyWitnessUtxo,
-		wire.OutPoint{Index: 1}: dummyWitnessUtxo,
-		*prevOut:                witnessUtxo,
-	}
-	prevOutputFetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
-
-	err = signInput(updater, SellerSignatureIndex, in, prevOutputFetcher, txscript.SigHashSingle|txscript.SigHashAnyOneCanPay, network)
-	if err != nil {
-		return "", err
-	}
-
-	return p.B64Encode()
+// BuyingPSBT is a purchase whose buyer inputs without a private key are
+// left to sign externally, see SignBuyingPSBTBySignature.
+type BuyingPSBT struct {
+	// Psbt is base64 encoded.
+	Psbt string `json:"psbt"`
+	// MessageHashMap is the SIGHASH_ALL sighash of each buyer input left to
+	// sign, keyed by input index.
+	MessageHashMap map[int]string `json:"messageHashMap,omitempty"`
+	// SellerIndex is the index of the seller input and output, to pass on
+	// to SignBuyingPSBTBySignature.
+	SellerIndex int `json:"sellerIndex"`
 }
 
-func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string, network *chaincfg.Params) (string, error) {
+// newBuyingPSBT combines the buyer ins and outs with the seller input and
+// output of sellerPsbt at sellerIndex, the ListingPSBT.SellerIndex of the
+// listing, checked against ins[sellerIndex] and outs[sellerIndex].
+func newBuyingPSBT(ins []*TxInput, outs []*TxOutput, sellerPsbt string, sellerIndex int, network *chaincfg.Params) (*psbt.Updater, *txscript.MultiPrevOutFetcher, error) {
 	sp, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(sellerPsbt)), true)
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+	if sellerIndex < 0 || len(ins) <= sellerIndex || len(outs) <= sellerIndex {
+		return nil, nil, errors.New("missing seller input or output")
+	}
+	// the buyer's view of the listing, the seller PSBT must match it
+	if err = checkListingPacket(network, sp, &ListingExpectation{
+		TxId:        ins[sellerIndex].TxId,
+		VOut:        ins[sellerIndex].VOut,
+		Price:       outs[sellerIndex].Amount,
+		Address:     outs[sellerIndex].Address,
+		SellerIndex: &sellerIndex,
+	}); err != nil {
+		return nil, nil, err
 	}
 
 	var inputs []*wire.OutPoint
@@ -108,12 +89,12 @@ func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string,
 	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
 	for i, in := range ins {
 		var prevOut *wire.OutPoint
-		if i == SellerSignatureIndex {
+		if i == sellerIndex {
 			prevOut = &sp.UnsignedTx.TxIn[i].PreviousOutPoint
 		} else {
 			txHash, err := chainhash.NewHashFromStr(in.TxId)
 			if err != nil {
-				return "", err
+				return nil, nil, err
 			}
 			prevOut = wire.NewOutPoint(txHash, in.VOut)
 		}
@@ -121,7 +102,7 @@ func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string,
 
 		prevPkScript, err := AddrToPkScript(in.Address, network)
 		if err != nil {
-			return "", err
+			return nil, nil, err
 		}
 		witnessUtxo := wire.NewTxOut(in.Amount, prevPkScript)
 		prevOuts[*prevOut] = witnessUtxo
@@ -131,12 +112,12 @@ func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string,
 
 	var outputs []*wire.TxOut
 	for i, out := range outs {
-		if i == SellerSignatureIndex {
+		if i == sellerIndex {
 			outputs = append(outputs, sp.UnsignedTx.TxOut[i])
 		} else {
 			pkScript, err := AddrToPkScript(out.Address, network)
 			if err != nil {
-				return "", err
+				return nil, nil, err
 			}
 			outputs = append(outputs, wire.NewTxOut(out.Amount, pkScript))
 		}
@@ -144,18 +125,28 @@ func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string,
 
 	bp, err := psbt.New(inputs, outputs, int32(2), uint32(0), nSequences)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	updater, err := psbt.NewUpdater(bp)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	prevOutputFetcher := txscript.NewMultiPrevOutFetcher(prevOuts)
+	bp.Inputs[sellerIndex] = sp.Inputs[sellerIndex]
+
+	return updater, txscript.NewMultiPrevOutFetcher(prevOuts), nil
+}
+
+func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string, sellerIndex int, network *chaincfg.Params) (string, error) {
+	updater, prevOutputFetcher, err := newBuyingPSBT(ins, outs, sellerPsbt, sellerIndex, network)
+	if err != nil {
+		return "", err
+	}
+	bp := updater.Upsbt
 
 	for i, in := range ins {
-		if i == SellerSignatureIndex {
+		if i == sellerIndex {
 			continue
 		}
 
@@ -168,9 +159,6 @@ func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string,
 		}
 	}
 
-	// bp.UnsignedTx.TxIn[SellerSignatureIndex] = sp.UnsignedTx.TxIn[SellerSignatureIndex]
-	bp.Inputs[SellerSignatureIndex] = sp.Inputs[SellerSignatureIndex]
-
 	if err = psbt.MaybeFinalizeAll(bp); err != nil {
 		return "", err
 	}
@@ -188,6 +176,71 @@ func GenerateSignedBuyingTx(ins []*TxInput, outs []*TxOutput, sellerPsbt string,
 	return hex.EncodeToString(buf.Bytes()), nil
 }
 
+// BuildBuyingPSBT is the first phase of an externally signed purchase: the
+// buyer inputs with a private key are signed, the others come back with
+// their message hash. segwit v0 inputs without a key need in.PublicKey.
+func BuildBuyingPSBT(ins []*TxInput, outs []*TxOutput, sellerPsbt string, sellerIndex int, network *chaincfg.Params) (*BuyingPSBT, error) {
+	updater, prevOutputFetcher, err := newBuyingPSBT(ins, outs, sellerPsbt, sellerIndex, network)
+	if err != nil {
+		return nil, err
+	}
+
+	buyingPsbt := &BuyingPSBT{MessageHashMap: make(map[int]string), SellerIndex: sellerIndex}
+	for i, in := range ins {
+		if i == sellerIndex {
+			continue
+		}
+		if in.PrivateKey != "" {
+			err = signInput(updater, i, in, prevOutputFetcher, txscript.SigHashAll, network)
+		} else if err = addInputUtxo(updater, i, in, txscript.SigHashAll, network); err == nil {
+			buyingPsbt.MessageHashMap[i], err = inputMessageHash(updater.Upsbt.UnsignedTx, i, prevOutputFetcher, in.PublicKey, txscript.SigHashAll)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	if buyingPsbt.Psbt, err = updater.Upsbt.B64Encode(); err != nil {
+		return nil, err
+	}
+	return buyingPsbt, nil
+}
+
+// SignBuyingPSBTBySignature is the second phase of an externally signed
+// purchase: it adds the signatures of the message hashes returned by
+// BuildBuyingPSBT, keyed by input index, then finalizes and extracts the
+// tx. Signatures are 64 byte schnorr ones for taproot inputs, r and s
+// otherwise along with the public key of pubKeys. sellerIndex is the
+// BuyingPSBT.SellerIndex of the purchase.
+func SignBuyingPSBTBySignature(buyingPsbt string, signatureMap map[int]string, pubKeys map[int]string, sellerIndex int) (string, error) {
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(buyingPsbt)), true)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := signatureMap[sellerIndex]; ok {
+		return "", errors.New("the seller input is already signed")
+	}
+	return extractBySignatures(p, signatureMap, pubKeys, txscript.SigHashAll)
+}
+
+// extractBySignatures adds the hashType signatures of signatureMap to p,
+// keyed by input index, then finalizes it and extracts the tx.
+func extractBySignatures(p *psbt.Packet, signatureMap map[int]string, pubKeys map[int]string, hashType txscript.SigHashType) (string, error) {
+	for i, signature := range signatureMap {
+		if err := addInputSignature(p, i, signature, pubKeys[i], hashType); err != nil {
+			return "", fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+
+	if err := psbt.MaybeFinalizeAll(p); err != nil {
+		return "", err
+	}
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return "", err
+	}
+	return GetTxHex(tx)
+}
+
 func signInput(updater *psbt.Updater, i int, in *TxInput, prevOutFetcher *txscript.MultiPrevOutFetcher, hashType txscript.SigHashType, network *chaincfg.Params) error {
 	wif, err := btcutil.DecodeWIF(in.PrivateKey)
 	if err != nil {
@@ -228,7 +281,9 @@ func signInput(updater *psbt.Updater, i int, in *TxInput, prevOutFetcher *txscri
 
 		sigHashes := txscript.NewTxSigHashes(updater.Upsbt.UnsignedTx, prevOutFetcher)
 		if hashType == txscript.SigHashAll {
+			// a 64 byte signature, the finalizer appends a non default type
 			hashType = txscript.SigHashDefault
+			updater.Upsbt.Inputs[i].SighashType = hashType
 		}
 		witness, err := txscript.TaprootWitnessSignature(updater.Upsbt.UnsignedTx, sigHashes,
 			i, in.Amount, prevPkScript, hashType, privKey)
@@ -277,19 +332,153 @@ func signInput(updater *psbt.Updater, i int, in *TxInput, prevOutFetcher *txscri
 	return nil
 }
 
-func CalcFee(ins []*TxInput, outs []*TxOutput, sellerPsbt string, feeRate int64, network *chaincfg.Params) (int64, error) {
-	txHex, err := GenerateSignedBuyingTx(ins, outs, sellerPsbt, network)
+// addInputUtxo adds the prev output of input i and, for an external
+// signature, the redeem script of a nested segwit input.
+func addInputUtxo(updater *psbt.Updater, i int, in *TxInput, hashType txscript.SigHashType, network *chaincfg.Params) error {
+	prevPkScript, err := AddrToPkScript(in.Address, network)
+	if err != nil {
+		return err
+	}
+	if txscript.IsPayToPubKeyHash(prevPkScript) {
+		prevTx, err := NewTxFromHex(in.NonWitnessUtxo)
+		if err != nil {
+			return err
+		}
+		if err = updater.AddInNonWitnessUtxo(prevTx, i); err != nil {
+			return err
+		}
+	} else if err = updater.AddInWitnessUtxo(wire.NewTxOut(in.Amount, prevPkScript), i); err != nil {
+		return err
+	}
+	if txscript.IsPayToScriptHash(prevPkScript) {
+		pubKeyBytes, err := hex.DecodeString(in.PublicKey)
+		if err != nil {
+			return err
+		}
+		redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
+		if err != nil {
+			return err
+		}
+		if err = updater.AddInRedeemScript(redeemScript, i); err != nil {
+			return err
+		}
+	}
+	if txscript.IsPayToTaproot(prevPkScript) && hashType == txscript.SigHashAll {
+		hashType = txscript.SigHashDefault
+	}
+	return updater.AddInSighashType(hashType, i)
+}
 
-	tx := wire.NewMsgTx(2)
-	txBytes, err := hex.DecodeString(txHex)
+// inputMessageHash is the hashType sighash of input i, SIGHASH_ALL is
+// SIGHASH_DEFAULT for taproot inputs as in signInput.
+func inputMessageHash(tx *wire.MsgTx, i int, prevOutFetcher *txscript.MultiPrevOutFetcher, pubKey string, hashType txscript.SigHashType) (string, error) {
+	prevOut := prevOutFetcher.FetchPrevOutput(tx.TxIn[i].PreviousOutPoint)
+	if txscript.IsPayToTaproot(prevOut.PkScript) {
+		if hashType == txscript.SigHashAll {
+			hashType = txscript.SigHashDefault
+		}
+		sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+		hash, err := txscript.CalcTaprootSignatureHash(sigHashes, hashType, tx, i, prevOutFetcher)
+		if err != nil {
+			return "", err
+		}
+		return hexutil.Encode(hash), nil
+	} else if txscript.IsPayToPubKeyHash(prevOut.PkScript) {
+		hash, err := txscript.CalcSignatureHash(prevOut.PkScript, hashType, tx, i)
+		if err != nil {
+			return "", err
+		}
+		return hexutil.Encode(hash), nil
+	}
+	pubKeyBytes, err := hex.DecodeString(pubKey)
+	if err != nil || len(pubKeyBytes) == 0 {
+		return "", errors.New("missing public key of a segwit v0 input")
+	}
+	script, err := PayToPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
 	if err != nil {
-		return 0, err
+		return "", err
 	}
-	err = tx.Deserialize(bytes.NewReader(txBytes))
+	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	hash, err := txscript.CalcWitnessSigHash(script, sigHashes, hashType, tx, i, prevOut.Value)
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(hash), nil
+}
+
+// addInputSignature adds the external signature of the inputMessageHash of
+// input i as a partial signature, or as the taproot key spend signature.
+func addInputSignature(p *psbt.Packet, i int, signature string, pubKey string, hashType txscript.SigHashType) error {
+	if i < 0 || i >= len(p.Inputs) {
+		return fmt.Errorf("input index %d out of the %d inputs", i, len(p.Inputs))
+	}
+	if signature == "" {
+		return errors.New("missing signature")
+	}
+	var prevPkScript []byte
+	if witnessUtxo := p.Inputs[i].WitnessUtxo; witnessUtxo != nil {
+		prevPkScript = witnessUtxo.PkScript
+	} else if prevTx := p.Inputs[i].NonWitnessUtxo; prevTx != nil {
+		prevPkScript = prevTx.TxOut[p.UnsignedTx.TxIn[i].PreviousOutPoint.Index].PkScript
+	} else {
+		return errors.New("missing prev output")
+	}
+
+	if txscript.IsPayToTaproot(prevPkScript) {
+		signatureBytes, err := hex.DecodeString(signature)
+		if err != nil {
+			return err
+		}
+		if len(signatureBytes) != 64 {
+			return errors.New("taproot signature must be 64 bytes")
+		}
+		if hashType != txscript.SigHashAll && hashType != txscript.SigHashDefault {
+			signatureBytes = append(signatureBytes, byte(hashType))
+		}
+		p.Inputs[i].TaprootKeySpendSig = signatureBytes
+		return nil
+	}
+
+	pubKeyBytes, err := hex.DecodeString(pubKey)
+	if err != nil || len(pubKeyBytes) == 0 {
+		return errors.New("missing public key")
+	}
+	if len(signature) != 128 {
+		return errors.New("signature must be the 64 bytes of r and s")
+	}
+	ecdsaSignature, err := txscript2.BuildSignature(signature)
+	if err != nil {
+		return err
+	}
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return err
+	}
+	sig := append(ecdsaSignature.Serialize(), byte(hashType))
+	if _, err = updater.Sign(i, sig, pubKeyBytes, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CalcFee estimates the fee of the purchase at feeRate from its size with
+// placeholder signatures, no private key is needed.
+func CalcFee(ins []*TxInput, outs []*TxOutput, sellerPsbt string, sellerIndex int, feeRate int64, network *chaincfg.Params) (int64, error) {
+	updater, prevOutputFetcher, err := newBuyingPSBT(ins, outs, sellerPsbt, sellerIndex, network)
 	if err != nil {
 		return 0, err
 	}
 
+	tx := updater.Upsbt.UnsignedTx.Copy()
+	for i, in := range tx.TxIn {
+		prevPkScript := prevOutputFetcher.FetchPrevOutput(in.PreviousOutPoint).PkScript
+		in.SignatureScript, in.Witness = dummySignature(prevPkScript)
+		// the seller signature carries its sighash type
+		if i == sellerIndex && txscript.IsPayToTaproot(prevPkScript) {
+			in.Witness = wire.TxWitness{make([]byte, 65)}
+		}
+	}
+
 	return GetTxVirtualSize(btcutil.NewTx(tx)) * feeRate, nil
 }
 
@@ -388,6 +577,12 @@ func GenerateUnsignedPSBTHex(ins []*TxInput, outs []*TxOutput, network *chaincfg
 		if err != nil {
 			return "", err
 		}
+		if txscript.IsPayToTaproot(prevPkScript) {
+			if err := addTaprootInput(&p.Inputs[i], prevPkScript, in, derivationPath); err != nil {
+				return "", fmt.Errorf("input %d: %w", i, err)
+			}
+			continue
+		}
 		if err := updater.AddInBip32Derivation(in.MasterFingerprint, derivationPath, publicKeyBytes, i); err != nil {
 			return "", err
 		}
@@ -399,6 +594,16 @@ func GenerateUnsignedPSBTHex(ins []*TxInput, outs []*TxOutput, network *chaincfg
 			if err != nil {
 				return "", err
 			}
+			if pkScript := p.UnsignedTx.TxOut[i].PkScript; txscript.IsPayToTaproot(pkScript) {
+				pubKey, err := parseXOnlyPubKey(out.PublicKey)
+				if err != nil {
+					return "", err
+				}
+				if err = addTaprootOutputBip32Derivation(&p.Outputs[i], pkScript, pubKey, out.MasterFingerprint, derivationPath); err != nil {
+					return "", fmt.Errorf("output %d: %w", i, err)
+				}
+				continue
+			}
 			publicKeyBytes, err := hex.DecodeString(out.PublicKey)
 			if err != nil {
 				return "", err
@@ -416,6 +621,33 @@ func GenerateUnsignedPSBTHex(ins []*TxInput, outs []*TxOutput, network *chaincfg
 	return hex.EncodeToString(b.Bytes()), nil
 }
 
+// addTaprootInput sets the BIP371 fields of taproot input in: the leaf
+// script and control block of a script path spend, or else the public key
+// as the internal key, and the derivation of the public key.
+func addTaprootInput(pInput *psbt.PInput, prevPkScript []byte, in *TxInput, derivationPath []uint32) error {
+	pubKey, err := parseXOnlyPubKey(in.PublicKey)
+	if err != nil {
+		return err
+	}
+	merkleRoot, err := hex.DecodeString(in.TaprootMerkleRoot)
+	if err != nil {
+		return err
+	}
+	leafScript, err := hex.DecodeString(in.TaprootLeafScript)
+	if err != nil {
+		return err
+	}
+	controlBlock, err := hex.DecodeString(in.TaprootControlBlock)
+	if err != nil {
+		return err
+	}
+	if err = addTaprootInputScript(pInput, prevPkScript, pubKey, merkleRoot, leafScript, controlBlock); err != nil {
+		return err
+	}
+	addTaprootInputBip32Derivation(pInput, pubKey, in.MasterFingerprint, derivationPath)
+	return nil
+}
+
 func ExtractTxFromSignedPSBT(psbtHex string) (string, error) {
 	psbtBytes, err := hex.DecodeString(psbtHex)
 	if err != nil {
@@ -426,7 +658,7 @@ func ExtractTxFromSignedPSBT(psbtHex string) (string, error) {
 		return "", err
 	}
 
-	if err = psbt.MaybeFinalizeAll(p); err != nil {
+	if err = finalizePSBT(p); err != nil {
 		return "", err
 	}
 
@@ -1,7 +1,14 @@
 package bitcoin
 
 import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -78,11 +85,11 @@ func TestPsbt(t *testing.T) {
 		Amount:  int64(1000),
 	})
 
-	fee, err := CalcFee(inputs, outputs, sellerPsbt, 2, network)
+	fee, err := CalcFee(inputs, outputs, sellerPsbt, SellerSignatureIndex, 2, network)
 	require.Nil(t, err)
 	t.Log(fee)
 
-	buyerTx, err := GenerateSignedBuyingTx(inputs, outputs, sellerPsbt, network)
+	buyerTx, err := GenerateSignedBuyingTx(inputs, outputs, sellerPsbt, SellerSignatureIndex, network)
 	require.Nil(t, err)
 	t.Log(buyerTx)
 }
@@ -118,3 +125,196 @@ func TestExtractTxFromSignedPSBT(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, "02000000000104870fa29a7da1acff1cd4fb274fd15904ff1c867ad41d309577d4c8268ad0b9250000000000ffffffff1558fd0c79199219e27ce50e07a84c4b01d7563e5c53f9e6550d7c4450aa596d000000006b483045022100bd9b8c17d68efed18f0882bdb77db303a0a547864305e32ed7a9a951b650caa90220131c361e5c27652a3a05603306a87d8f6e117b78fdb1082db23d8960eb6214bf01210357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2fffffffff06424462f9b8179b1cdc6229a4e213ff3628060b2a0a7680dae6740405cee3460000000000ffffffffa21ba51db540d68c0feaf3fb958058e1f2f123194f9238d9b2c86e04106c69d100000000171600145c005c5532ce810ddf20f9d1d939631b47089ecdffffffff06400d0300000000001600145c005c5532ce810ddf20f9d1d939631b47089ecd400d0300000000001976a9145c005c5532ce810ddf20f9d1d939631b47089ecd88aca08601000000000017a914ef05515a0595d15eaf90d9f62fb85873a6d8c0b487e4c2030000000000225120b7ee7f83a6a7fdb513040856c56778aa3abea9a451e0c9bb012f22a77ed99b21e803000000000000225120b7ee7f83a6a7fdb513040856c56778aa3abea9a451e0c9bb012f22a77ed99b21e803000000000000225120b7ee7f83a6a7fdb513040856c56778aa3abea9a451e0c9bb012f22a77ed99b2102483045022100a1d12dee8d87d2f8a12ff43f656a6b52183fa5ce4ffd1ab349b978d4dc5e68620220060d8c6d20ea34d3b2f744624d9f027c9020cb80cfb9babe015ebd70db0a927a01210357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f000141f24c018bc95e051c33e4659cacad365db8f3afbaf61ee163e3e1bf1d419baaeb681f681c75a545a19d4ade0b972e226448015d9cbdaee121f4148b5bee9d27068302483045022100bb251cc4a4db4eab3352d54541a03d20d5067e8261b6f7ba8a20a7d955dfafde022078be1dd187ff61934177a9245872f4a90beef32ec40b69f75d9c50c32053d97101210357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f00000000", txHex)
 }
+
+func TestBuyingPSBT(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	pubKey := "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	sellerInput := &TxInput{
+		TxId:       "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+		Amount:     546,
+		Address:    taprootAddress,
+		PrivateKey: privateKey,
+	}
+	sellerOutput := &TxOutput{Address: "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", Amount: 100000}
+	sellerPsbt, err := GenerateSignedListingPSBTBase64(sellerInput, sellerOutput, network)
+	require.Nil(t, err)
+
+	inputs := func(withKey bool) []*TxInput {
+		key := ""
+		if withKey {
+			key = privateKey
+		}
+		return []*TxInput{
+			{TxId: "25b9d08a26c8d47795301dd47a861cff0459d14f27fbd41cffaca17d9aa20f87", Amount: 249352, Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", PrivateKey: key, PublicKey: pubKey},
+			{
+				TxId:           "6d59aa50447c0d55e6f9535c3e56d7014b4ca8070ee57ce2199219790cfd5815",
+				Amount:         499356,
+				Address:        "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE",
+				PrivateKey:     key,
+				NonWitnessUtxo: "02000000010a6b13715c8effde51dac60d572358005a589cd80413a88e0912e4c6d275abbe010000006a473044022019e34aa16cf55eb9c7a8627f61bcd671525a3818a23ab8a78af13c35121ea3c8022055a5bfb3e8486f6e83707660f1fca3da06f140f449902a63900625f43fadf10501210357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2fffffffff019c9e0700000000001976a9145c005c5532ce810ddf20f9d1d939631b47089ecd88ac00000000",
+			},
+			{TxId: sellerInput.TxId, Amount: sellerInput.Amount, Address: sellerInput.Address},
+			{TxId: "d1696c10046ec8b2d938924f1923f1f2e1588095fbf3ea0f8cd640b51da51ba2", Amount: 400, Address: "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", PrivateKey: key, PublicKey: pubKey},
+			{TxId: "d1696c10046ec8b2d938924f1923f1f2e1588095fbf3ea0f8cd640b51da51ba2", VOut: 1, Amount: 5000, Address: taprootAddress, PrivateKey: key},
+		}
+	}
+	outputs := []*TxOutput{
+		{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 200000},
+		{Address: "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", Amount: 200000},
+		sellerOutput,
+		{Address: taprootAddress, Amount: 251500},
+	}
+
+	signedTx, err := GenerateSignedBuyingTx(inputs(true), outputs, sellerPsbt, SellerSignatureIndex, network)
+	require.Nil(t, err)
+
+	buyingPsbt, err := BuildBuyingPSBT(inputs(false), outputs, sellerPsbt, SellerSignatureIndex, network)
+	require.Nil(t, err)
+	require.Len(t, buyingPsbt.MessageHashMap, 4)
+	assert.NotContains(t, buyingPsbt.MessageHashMap, SellerSignatureIndex)
+
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	signatureMap := make(map[int]string)
+	pubKeys := make(map[int]string)
+	for i, messageHash := range buyingPsbt.MessageHashMap {
+		hash, err := hexutil.Decode(messageHash)
+		require.Nil(t, err)
+		if i == 4 {
+			signature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*privateKeyWif.PrivKey, nil), hash)
+			require.Nil(t, err)
+			signatureMap[i] = hex.EncodeToString(signature.Serialize())
+			continue
+		}
+		compact, err := ecdsa.SignCompact(privateKeyWif.PrivKey, hash, true)
+		require.Nil(t, err)
+		signatureMap[i] = hex.EncodeToString(compact[1:])
+		pubKeys[i] = pubKey
+	}
+	txHex, err := SignBuyingPSBTBySignature(buyingPsbt.Psbt, signatureMap, pubKeys, buyingPsbt.SellerIndex)
+	require.Nil(t, err)
+	assert.Equal(t, signedTx, txHex)
+
+	delete(signatureMap, 4)
+	_, err = SignBuyingPSBTBySignature(buyingPsbt.Psbt, signatureMap, pubKeys, buyingPsbt.SellerIndex)
+	assert.NotNil(t, err)
+
+	tx, err := NewTxFromHex(signedTx)
+	require.Nil(t, err)
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range inputs(false) {
+		pkScript, err := AddrToPkScript(in.Address, network)
+		require.Nil(t, err)
+		prevOutFetcher.AddPrevOut(tx.TxIn[i].PreviousOutPoint, wire.NewTxOut(in.Amount, pkScript))
+	}
+	for i, in := range tx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx, prevOutFetcher), prevOut.Value, prevOutFetcher)
+		require.Nil(t, err)
+		require.Nil(t, vm.Execute(), "input %d", i)
+	}
+
+	fee, err := CalcFee(inputs(false), outputs, sellerPsbt, SellerSignatureIndex, 2, network)
+	require.Nil(t, err)
+	signedFee := GetTxVirtualSize(btcutil.NewTx(tx)) * 2
+	assert.True(t, fee >= signedFee && fee-signedFee <= 2*4, "fee %d, signed %d", fee, signedFee)
+}
+
+func TestBuyingOneDummyListing(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	segwitAddress := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+	sellerInput := &TxInput{
+		TxId:       "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+		Amount:     546,
+		Address:    taprootAddress,
+		PrivateKey: privateKey,
+	}
+	sellerOutput := &TxOutput{Address: "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", Amount: 5000}
+	listingPsbts, err := GenerateListingPSBTs(network, &ListingRequest{
+		Listings: []*Listing{{Input: sellerInput, Output: sellerOutput}},
+		Layout:   ListingLayoutOneDummy,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 1, listingPsbts[0].SellerIndex)
+	sellerPsbt, sellerIndex := listingPsbts[0].Psbt, listingPsbts[0].SellerIndex
+
+	inputs := []*TxInput{
+		{TxId: "d1696c10046ec8b2d938924f1923f1f2e1588095fbf3ea0f8cd640b51da51ba2", Amount: 600, Address: taprootAddress, PrivateKey: privateKey},
+		{TxId: sellerInput.TxId, Amount: sellerInput.Amount, Address: sellerInput.Address},
+		{TxId: "25b9d08a26c8d47795301dd47a861cff0459d14f27fbd41cffaca17d9aa20f87", Amount: 10000, Address: segwitAddress, PrivateKey: privateKey},
+	}
+	outputs := []*TxOutput{
+		{Address: segwitAddress, Amount: 1146},
+		sellerOutput,
+		{Address: taprootAddress, Amount: 4000},
+	}
+
+	// the seller input is not where the two dummies layout expects it
+	_, err = GenerateSignedBuyingTx(inputs, outputs, sellerPsbt, SellerSignatureIndex, network)
+	assert.NotNil(t, err)
+
+	signedTx, err := GenerateSignedBuyingTx(inputs, outputs, sellerPsbt, sellerIndex, network)
+	require.Nil(t, err)
+	tx, err := NewTxFromHex(signedTx)
+	require.Nil(t, err)
+	require.Len(t, tx.TxIn, 3)
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range inputs {
+		pkScript, err := AddrToPkScript(in.Address, network)
+		require.Nil(t, err)
+		prevOutFetcher.AddPrevOut(tx.TxIn[i].PreviousOutPoint, wire.NewTxOut(in.Amount, pkScript))
+	}
+	for i, in := range tx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, txscript.NewTxSigHashes(tx, prevOutFetcher), prevOut.Value, prevOutFetcher)
+		require.Nil(t, err)
+		require.Nil(t, vm.Execute(), "input %d", i)
+	}
+
+	// the same purchase signed externally
+	var unsignedInputs []*TxInput
+	for _, in := range inputs {
+		unsigned := *in
+		unsigned.PrivateKey = ""
+		unsigned.PublicKey = "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+		unsignedInputs = append(unsignedInputs, &unsigned)
+	}
+	buyingPsbt, err := BuildBuyingPSBT(unsignedInputs, outputs, sellerPsbt, sellerIndex, network)
+	require.Nil(t, err)
+	assert.Equal(t, sellerIndex, buyingPsbt.SellerIndex)
+	require.Len(t, buyingPsbt.MessageHashMap, 2)
+	assert.NotContains(t, buyingPsbt.MessageHashMap, sellerIndex)
+	privateKeyWif, err := btcutil.DecodeWIF(privateKey)
+	require.Nil(t, err)
+	signatureMap := make(map[int]string)
+	pubKeys := make(map[int]string)
+	for i, messageHash := range buyingPsbt.MessageHashMap {
+		hash, err := hexutil.Decode(messageHash)
+		require.Nil(t, err)
+		if i == 0 {
+			signature, err := schnorr.Sign(txscript.TweakTaprootPrivKey(*privateKeyWif.PrivKey, nil), hash)
+			require.Nil(t, err)
+			signatureMap[i] = hex.EncodeToString(signature.Serialize())
+			continue
+		}
+		compact, err := ecdsa.SignCompact(privateKeyWif.PrivKey, hash, true)
+		require.Nil(t, err)
+		signatureMap[i] = hex.EncodeToString(compact[1:])
+		pubKeys[i] = unsignedInputs[i].PublicKey
+	}
+	txHex, err := SignBuyingPSBTBySignature(buyingPsbt.Psbt, signatureMap, pubKeys, buyingPsbt.SellerIndex)
+	require.Nil(t, err)
+	assert.Equal(t, signedTx, txHex)
+	signatureMap[sellerIndex] = signatureMap[0]
+	_, err = SignBuyingPSBTBySignature(buyingPsbt.Psbt, signatureMap, pubKeys, buyingPsbt.SellerIndex)
+	assert.NotNil(t, err)
+
+	fee, err := CalcFee(unsignedInputs, outputs, sellerPsbt, sellerIndex, 2, network)
+	require.Nil(t, err)
+	signedFee := GetTxVirtualSize(btcutil.NewTx(tx)) * 2
+	assert.True(t, fee >= signedFee && fee-signedFee <= 2*4, "fee %d, signed %d", fee, signedFee)
+}
@@ -0,0 +1,613 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BIP370 key types, the vendored psbt package only knows version 0.
+const (
+	psbtGlobalUnsignedTx         = 0x00
+	psbtGlobalTxVersion          = 0x02
+	psbtGlobalFallbackLockTime   = 0x03
+	psbtGlobalInputCount         = 0x04
+	psbtGlobalOutputCount        = 0x05
+	psbtGlobalTxModifiable       = 0x06
+	psbtGlobalVersion            = 0xfb
+	psbtInPreviousTxId           = 0x0e
+	psbtInOutputIndex            = 0x0f
+	psbtInSequence               = 0x10
+	psbtInRequiredTimeLockTime   = 0x11
+	psbtInRequiredHeightLockTime = 0x12
+	psbtOutAmount                = 0x03
+	psbtOutScript                = 0x04
+)
+
+// The PSBT_GLOBAL_TX_MODIFIABLE flags.
+const (
+	PSBTInputsModifiable  = uint8(1 << 0)
+	PSBTOutputsModifiable = uint8(1 << 1)
+	PSBTHasSighashSingle  = uint8(1 << 2)
+)
+
+var psbtMagic = []byte("psbt\xff")
+
+// PSBTv2 is a BIP370 psbt: there is no global unsigned tx, the inputs and
+// outputs carry their part of it and Constructors can add to them while
+// Modifiable allows it. The other fields are kept as raw key-value pairs,
+// the vendored psbt package handles them once converted by ToV0.
+type PSBTv2 struct {
+	TxVersion        int32
+	FallbackLockTime *uint32
+	Modifiable       uint8
+	Unknowns         []*psbt.Unknown
+	Inputs           []*PSBTv2Input
+	Outputs          []*PSBTv2Output
+}
+
+type PSBTv2Input struct {
+	PreviousTxId           chainhash.Hash
+	OutputIndex            uint32
+	Sequence               *uint32
+	RequiredTimeLockTime   *uint32
+	RequiredHeightLockTime *uint32
+	Fields                 []*psbt.Unknown
+}
+
+type PSBTv2Output struct {
+	Amount int64
+	Script []byte
+	Fields []*psbt.Unknown
+}
+
+// readPSBTMap reads the key-value pairs of a map up to its separator.
+func readPSBTMap(r io.Reader) ([]*psbt.Unknown, error) {
+	var pairs []*psbt.Unknown
+	for {
+		keyLen, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if keyLen == 0 {
+			return pairs, nil
+		}
+		if keyLen > psbt.MaxPsbtKeyLength {
+			return nil, psbt.ErrInvalidKeyData
+		}
+		key := make([]byte, keyLen)
+		if _, err = io.ReadFull(r, key); err != nil {
+			return nil, err
+		}
+		value, err := wire.ReadVarBytes(r, 0, psbt.MaxPsbtValueLength, "PSBT value")
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			if bytes.Equal(pair.Key, key) {
+				return nil, psbt.ErrDuplicateKey
+			}
+		}
+		pairs = append(pairs, &psbt.Unknown{Key: key, Value: value})
+	}
+}
+
+// writePSBTMap writes pairs sorted by key, then the separator.
+func writePSBTMap(w io.Writer, pairs []*psbt.Unknown) error {
+	sorted := append([]*psbt.Unknown{}, pairs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0
+	})
+	for _, pair := range sorted {
+		if err := wire.WriteVarBytes(w, 0, pair.Key); err != nil {
+			return err
+		}
+		if err := wire.WriteVarBytes(w, 0, pair.Value); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0})
+	return err
+}
+
+func uint32Pair(keyType byte, value uint32) *psbt.Unknown {
+	return &psbt.Unknown{Key: []byte{keyType}, Value: binary.LittleEndian.AppendUint32(nil, value)}
+}
+
+func readUint32(pair *psbt.Unknown) (uint32, error) {
+	if len(pair.Value) != 4 {
+		return 0, fmt.Errorf("key type 0x%02x needs a 4 byte value", pair.Key[0])
+	}
+	return binary.LittleEndian.Uint32(pair.Value), nil
+}
+
+// psbtVersion is the PSBT_GLOBAL_VERSION of raw, 0 when missing.
+func psbtVersion(raw []byte) (uint32, error) {
+	r := bytes.NewReader(raw)
+	magic := make([]byte, len(psbtMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, psbtMagic) {
+		return 0, psbt.ErrInvalidMagicBytes
+	}
+	global, err := readPSBTMap(r)
+	if err != nil {
+		return 0, err
+	}
+	for _, pair := range global {
+		if len(pair.Key) == 1 && pair.Key[0] == psbtGlobalVersion {
+			return readUint32(pair)
+		}
+	}
+	return 0, nil
+}
+
+// ParsePSBTv2 parses a serialized version 2 psbt.
+func ParsePSBTv2(raw []byte) (*PSBTv2, error) {
+	r := bytes.NewReader(raw)
+	magic := make([]byte, len(psbtMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, psbtMagic) {
+		return nil, psbt.ErrInvalidMagicBytes
+	}
+	global, err := readPSBTMap(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PSBTv2{}
+	var version uint32
+	var inputCount, outputCount int64 = -1, -1
+	hasTxVersion := false
+	for _, pair := range global {
+		if len(pair.Key) != 1 {
+			p.Unknowns = append(p.Unknowns, pair)
+			continue
+		}
+		switch pair.Key[0] {
+		case psbtGlobalUnsignedTx:
+			return nil, errors.New("a version 2 psbt has no global unsigned tx")
+		case psbtGlobalTxVersion:
+			txVersion, err := readUint32(pair)
+			if err != nil {
+				return nil, err
+			}
+			p.TxVersion, hasTxVersion = int32(txVersion), true
+		case psbtGlobalFallbackLockTime:
+			lockTime, err := readUint32(pair)
+			if err != nil {
+				return nil, err
+			}
+			p.FallbackLockTime = &lockTime
+		case psbtGlobalInputCount, psbtGlobalOutputCount:
+			count, err := wire.ReadVarInt(bytes.NewReader(pair.Value), 0)
+			if err != nil || uint64(wire.VarIntSerializeSize(count)) != uint64(len(pair.Value)) {
+				return nil, fmt.Errorf("invalid count of key type 0x%02x", pair.Key[0])
+			}
+			if pair.Key[0] == psbtGlobalInputCount {
+				inputCount = int64(count)
+			} else {
+				outputCount = int64(count)
+			}
+		case psbtGlobalTxModifiable:
+			if len(pair.Value) != 1 {
+				return nil, errors.New("the tx modifiable flags need a 1 byte value")
+			}
+			p.Modifiable = pair.Value[0]
+		case psbtGlobalVersion:
+			if version, err = readUint32(pair); err != nil {
+				return nil, err
+			}
+		default:
+			p.Unknowns = append(p.Unknowns, pair)
+		}
+	}
+	if version != 2 {
+		return nil, fmt.Errorf("psbt version %d is not 2", version)
+	}
+	if !hasTxVersion || p.TxVersion < 2 {
+		return nil, errors.New("a version 2 psbt needs a tx version of 2 at least")
+	}
+	if inputCount < 0 || outputCount < 0 {
+		return nil, errors.New("missing input or output count")
+	}
+	if inputCount > int64(r.Len()) || outputCount > int64(r.Len()) {
+		return nil, psbt.ErrInvalidPsbtFormat
+	}
+
+	for i := int64(0); i < inputCount; i++ {
+		pairs, err := readPSBTMap(r)
+		if err != nil {
+			return nil, err
+		}
+		in, err := parsePSBTv2Input(pairs)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		p.Inputs = append(p.Inputs, in)
+	}
+	for i := int64(0); i < outputCount; i++ {
+		pairs, err := readPSBTMap(r)
+		if err != nil {
+			return nil, err
+		}
+		out, err := parsePSBTv2Output(pairs)
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+		p.Outputs = append(p.Outputs, out)
+	}
+	if _, err = p.LockTime(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parsePSBTv2Input(pairs []*psbt.Unknown) (*PSBTv2Input, error) {
+	in := &PSBTv2Input{}
+	hasTxId, hasOutputIndex := false, false
+	for _, pair := range pairs {
+		if len(pair.Key) != 1 || pair.Key[0] < psbtInPreviousTxId || pair.Key[0] > psbtInRequiredHeightLockTime {
+			in.Fields = append(in.Fields, pair)
+			continue
+		}
+		if pair.Key[0] == psbtInPreviousTxId {
+			if len(pair.Value) != chainhash.HashSize {
+				return nil, errors.New("the previous txid needs 32 bytes")
+			}
+			copy(in.PreviousTxId[:], pair.Value)
+			hasTxId = true
+			continue
+		}
+		value, err := readUint32(pair)
+		if err != nil {
+			return nil, err
+		}
+		switch pair.Key[0] {
+		case psbtInOutputIndex:
+			in.OutputIndex, hasOutputIndex = value, true
+		case psbtInSequence:
+			in.Sequence = &value
+		case psbtInRequiredTimeLockTime:
+			if value < txscript.LockTimeThreshold {
+				return nil, errors.New("a required time lock time must be a timestamp")
+			}
+			in.RequiredTimeLockTime = &value
+		case psbtInRequiredHeightLockTime:
+			if value == 0 || value >= txscript.LockTimeThreshold {
+				return nil, errors.New("a required height lock time must be a block height")
+			}
+			in.RequiredHeightLockTime = &value
+		}
+	}
+	if !hasTxId || !hasOutputIndex {
+		return nil, errors.New("missing previous txid or output index")
+	}
+	return in, nil
+}
+
+func parsePSBTv2Output(pairs []*psbt.Unknown) (*PSBTv2Output, error) {
+	out := &PSBTv2Output{}
+	hasAmount := false
+	for _, pair := range pairs {
+		if len(pair.Key) != 1 || pair.Key[0] != psbtOutAmount && pair.Key[0] != psbtOutScript {
+			out.Fields = append(out.Fields, pair)
+			continue
+		}
+		if pair.Key[0] == psbtOutAmount {
+			if len(pair.Value) != 8 {
+				return nil, errors.New("the amount needs 8 bytes")
+			}
+			out.Amount, hasAmount = int64(binary.LittleEndian.Uint64(pair.Value)), true
+		} else {
+			out.Script = pair.Value
+		}
+	}
+	if !hasAmount || out.Script == nil {
+		return nil, errors.New("missing amount or script")
+	}
+	return out, nil
+}
+
+func (p *PSBTv2) Serialize(w io.Writer) error {
+	if _, err := w.Write(psbtMagic); err != nil {
+		return err
+	}
+	global := append([]*psbt.Unknown{
+		uint32Pair(psbtGlobalTxVersion, uint32(p.TxVersion)),
+		{Key: []byte{psbtGlobalInputCount}, Value: varIntBytes(uint64(len(p.Inputs)))},
+		{Key: []byte{psbtGlobalOutputCount}, Value: varIntBytes(uint64(len(p.Outputs)))},
+		uint32Pair(psbtGlobalVersion, 2),
+	}, p.Unknowns...)
+	if p.FallbackLockTime != nil {
+		global = append(global, uint32Pair(psbtGlobalFallbackLockTime, *p.FallbackLockTime))
+	}
+	if p.Modifiable != 0 {
+		global = append(global, &psbt.Unknown{Key: []byte{psbtGlobalTxModifiable}, Value: []byte{p.Modifiable}})
+	}
+	if err := writePSBTMap(w, global); err != nil {
+		return err
+	}
+
+	for _, in := range p.Inputs {
+		pairs := append([]*psbt.Unknown{
+			{Key: []byte{psbtInPreviousTxId}, Value: in.PreviousTxId[:]},
+			uint32Pair(psbtInOutputIndex, in.OutputIndex),
+		}, in.Fields...)
+		if in.Sequence != nil {
+			pairs = append(pairs, uint32Pair(psbtInSequence, *in.Sequence))
+		}
+		if in.RequiredTimeLockTime != nil {
+			pairs = append(pairs, uint32Pair(psbtInRequiredTimeLockTime, *in.RequiredTimeLockTime))
+		}
+		if in.RequiredHeightLockTime != nil {
+			pairs = append(pairs, uint32Pair(psbtInRequiredHeightLockTime, *in.RequiredHeightLockTime))
+		}
+		if err := writePSBTMap(w, pairs); err != nil {
+			return err
+		}
+	}
+	for _, out := range p.Outputs {
+		pairs := append([]*psbt.Unknown{
+			{Key: []byte{psbtOutAmount}, Value: binary.LittleEndian.AppendUint64(nil, uint64(out.Amount))},
+			{Key: []byte{psbtOutScript}, Value: out.Script},
+		}, out.Fields...)
+		if err := writePSBTMap(w, pairs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func varIntBytes(value uint64) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarInt(&buf, 0, value)
+	return buf.Bytes()
+}
+
+// LockTime is the lock time of the tx as BIP370 determines it: the
+// highest required one of the type every input requiring one accepts,
+// heights first, or else the fallback one.
+func (p *PSBTv2) LockTime() (uint32, error) {
+	var height, time uint32
+	heights, times, required := true, true, false
+	for _, in := range p.Inputs {
+		if in.RequiredHeightLockTime == nil && in.RequiredTimeLockTime == nil {
+			continue
+		}
+		required = true
+		if in.RequiredHeightLockTime == nil {
+			heights = false
+		} else if *in.RequiredHeightLockTime > height {
+			height = *in.RequiredHeightLockTime
+		}
+		if in.RequiredTimeLockTime == nil {
+			times = false
+		} else if *in.RequiredTimeLockTime > time {
+			time = *in.RequiredTimeLockTime
+		}
+	}
+	switch {
+	case !required && p.FallbackLockTime != nil:
+		return *p.FallbackLockTime, nil
+	case !required:
+		return 0, nil
+	case heights:
+		return height, nil
+	case times:
+		return time, nil
+	}
+	return 0, errors.New("the inputs require both a height and a time lock time")
+}
+
+func (p *PSBTv2) UnsignedTx() (*wire.MsgTx, error) {
+	lockTime, err := p.LockTime()
+	if err != nil {
+		return nil, err
+	}
+	tx := wire.NewMsgTx(p.TxVersion)
+	tx.LockTime = lockTime
+	for _, in := range p.Inputs {
+		txIn := wire.NewTxIn(wire.NewOutPoint(&in.PreviousTxId, in.OutputIndex), nil, nil)
+		if in.Sequence != nil {
+			txIn.Sequence = *in.Sequence
+		}
+		tx.AddTxIn(txIn)
+	}
+	for _, out := range p.Outputs {
+		tx.AddTxOut(wire.NewTxOut(out.Amount, out.Script))
+	}
+	return tx, nil
+}
+
+// ToV0 is the version 0 psbt of the same tx and fields.
+func (p *PSBTv2) ToV0() (*psbt.Packet, error) {
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return nil, err
+	}
+	var txBuf, buf bytes.Buffer
+	if err = tx.SerializeNoWitness(&txBuf); err != nil {
+		return nil, err
+	}
+	buf.Write(psbtMagic)
+	// the vendored parser wants the unsigned tx first, which sorting keeps
+	global := append([]*psbt.Unknown{{Key: []byte{psbtGlobalUnsignedTx}, Value: txBuf.Bytes()}}, p.Unknowns...)
+	if err = writePSBTMap(&buf, global); err != nil {
+		return nil, err
+	}
+	for _, in := range p.Inputs {
+		if err = writePSBTMap(&buf, in.Fields); err != nil {
+			return nil, err
+		}
+	}
+	for _, out := range p.Outputs {
+		if err = writePSBTMap(&buf, out.Fields); err != nil {
+			return nil, err
+		}
+	}
+	return psbt.NewFromRawBytes(&buf, false)
+}
+
+// NewPSBTv2 converts the version 0 psbt p, modifiable is cleared of what
+// its signatures commit to.
+func NewPSBTv2(p *psbt.Packet, modifiable uint8) (*PSBTv2, error) {
+	if p.UnsignedTx.Version < 2 {
+		return nil, errors.New("a version 2 psbt needs a tx version of 2 at least")
+	}
+	p2 := newPSBTv2(p.UnsignedTx, modifiable)
+	if err := p2.update(p); err != nil {
+		return nil, err
+	}
+	return p2, nil
+}
+
+// newPSBTv2 is the version 2 psbt of tx without any other field.
+func newPSBTv2(tx *wire.MsgTx, modifiable uint8) *PSBTv2 {
+	p2 := &PSBTv2{TxVersion: tx.Version, Modifiable: modifiable}
+	if tx.LockTime != 0 {
+		lockTime := tx.LockTime
+		p2.FallbackLockTime = &lockTime
+	}
+	for _, txIn := range tx.TxIn {
+		in := &PSBTv2Input{PreviousTxId: txIn.PreviousOutPoint.Hash, OutputIndex: txIn.PreviousOutPoint.Index}
+		if txIn.Sequence != wire.MaxTxInSequenceNum {
+			sequence := txIn.Sequence
+			in.Sequence = &sequence
+		}
+		p2.Inputs = append(p2.Inputs, in)
+	}
+	for _, txOut := range tx.TxOut {
+		p2.Outputs = append(p2.Outputs, &PSBTv2Output{Amount: txOut.Value, Script: txOut.PkScript})
+	}
+	return p2
+}
+
+// update takes the fields of p, the version 0 psbt of the same tx, after
+// the roles working on version 0 filled them.
+func (p *PSBTv2) update(v0 *psbt.Packet) error {
+	if len(v0.Inputs) != len(p.Inputs) || len(v0.Outputs) != len(p.Outputs) {
+		return errors.New("the psbt is not of the same tx")
+	}
+	var buf bytes.Buffer
+	if err := v0.Serialize(&buf); err != nil {
+		return err
+	}
+	r := bytes.NewReader(buf.Bytes()[len(psbtMagic):])
+	global, err := readPSBTMap(r)
+	if err != nil {
+		return err
+	}
+	p.Unknowns = nil
+	for _, pair := range global {
+		if pair.Key[0] != psbtGlobalUnsignedTx {
+			p.Unknowns = append(p.Unknowns, pair)
+		}
+	}
+	for _, in := range p.Inputs {
+		if in.Fields, err = readPSBTMap(r); err != nil {
+			return err
+		}
+	}
+	for _, out := range p.Outputs {
+		if out.Fields, err = readPSBTMap(r); err != nil {
+			return err
+		}
+	}
+	p.clearModifiable(v0)
+	return nil
+}
+
+// clearModifiable applies the BIP370 Signer rules to the signatures of v0:
+// inputs stay modifiable under SIGHASH_ANYONECANPAY only, outputs under
+// SIGHASH_NONE or SIGHASH_SINGLE, which also sets PSBTHasSighashSingle. A
+// finalized input is taken as signed SIGHASH_ALL.
+func (p *PSBTv2) clearModifiable(v0 *psbt.Packet) {
+	for _, in := range v0.Inputs {
+		var signatures [][]byte
+		for _, partialSig := range in.PartialSigs {
+			signatures = append(signatures, partialSig.Signature)
+		}
+		if in.TaprootKeySpendSig != nil {
+			signatures = append(signatures, in.TaprootKeySpendSig)
+		}
+		for _, scriptSpendSig := range in.TaprootScriptSpendSig {
+			signatures = append(signatures, scriptSpendSig.Signature)
+		}
+		var hashTypes []txscript.SigHashType
+		for _, signature := range signatures {
+			if len(signature) == 64 {
+				hashTypes = append(hashTypes, txscript.SigHashDefault)
+			} else if len(signature) > 0 {
+				hashTypes = append(hashTypes, txscript.SigHashType(signature[len(signature)-1]))
+			}
+		}
+		if in.FinalScriptSig != nil || in.FinalScriptWitness != nil {
+			hashTypes = append(hashTypes, txscript.SigHashAll)
+		}
+		for _, hashType := range hashTypes {
+			if hashType&txscript.SigHashAnyOneCanPay == 0 {
+				p.Modifiable &^= PSBTInputsModifiable
+			}
+			switch hashType & sigHashMask {
+			case txscript.SigHashNone:
+			case txscript.SigHashSingle:
+				p.Modifiable |= PSBTHasSighashSingle
+			default:
+				p.Modifiable &^= PSBTOutputsModifiable
+			}
+		}
+	}
+}
+
+// sigHashMask is the base type bits of a sighash type.
+const sigHashMask = txscript.SigHashType(0x1f)
+
+// AddInput is the Constructor role adding in, while inputs are modifiable.
+func (p *PSBTv2) AddInput(in *PSBTv2Input) error {
+	if p.Modifiable&PSBTInputsModifiable == 0 {
+		return errors.New("the inputs are not modifiable")
+	}
+	lockTime, err := p.LockTime()
+	if err != nil {
+		return err
+	}
+	p.Inputs = append(p.Inputs, in)
+	newLockTime, err := p.LockTime()
+	if err == nil && newLockTime != lockTime && p.hasSignatures() {
+		err = errors.New("the input changes the lock time signed for")
+	}
+	if err != nil {
+		p.Inputs = p.Inputs[:len(p.Inputs)-1]
+		return err
+	}
+	return nil
+}
+
+// AddOutput is the Constructor role adding out, while outputs are
+// modifiable.
+func (p *PSBTv2) AddOutput(out *PSBTv2Output) error {
+	if p.Modifiable&PSBTOutputsModifiable == 0 {
+		return errors.New("the outputs are not modifiable")
+	}
+	p.Outputs = append(p.Outputs, out)
+	return nil
+}
+
+func (p *PSBTv2) hasSignatures() bool {
+	for _, in := range p.Inputs {
+		for _, pair := range in.Fields {
+			switch pair.Key[0] {
+			case byte(psbt.PartialSigType), byte(psbt.TaprootKeySpendSignatureType), byte(psbt.TaprootScriptSpendSignatureType),
+				byte(psbt.FinalScriptSigType), byte(psbt.FinalScriptWitnessType):
+				return true
+			}
+		}
+	}
+	return false
+}
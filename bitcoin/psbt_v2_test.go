@@ -0,0 +1,222 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPSBTv2Conversion(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	created, err := CreatePSBT(network, &CreatePSBTRequest{
+		Inputs:   []*TxInput{{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5", VOut: 1, Sequence: 0xfffffffd}},
+		Outputs:  []*TxOutput{{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 9000}},
+		LockTime: 800000,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, uint32(0), created.Version)
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt:   created.Psbt,
+		Inputs: []*PSBTInputUpdate{{Index: 0, Address: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", Amount: 10000}},
+	})
+	require.Nil(t, err)
+
+	v2, err := ConvertPSBT(&ConvertPSBTRequest{Psbt: updated.Psbt, PsbtVersion: 2, InputsModifiable: true})
+	require.Nil(t, err)
+	assert.Equal(t, uint32(2), v2.Version)
+	raw, err := base64.StdEncoding.DecodeString(v2.Psbt)
+	require.Nil(t, err)
+	p2, err := ParsePSBTv2(raw)
+	require.Nil(t, err)
+	assert.Equal(t, PSBTInputsModifiable, p2.Modifiable)
+	assert.Equal(t, uint32(800000), *p2.FallbackLockTime)
+	assert.Equal(t, uint32(0xfffffffd), *p2.Inputs[0].Sequence)
+	var buf bytes.Buffer
+	require.Nil(t, p2.Serialize(&buf))
+	assert.Equal(t, raw, buf.Bytes())
+
+	// the version 2 psbt is used by the other roles as it is
+	again, err := UpdatePSBT(network, &UpdatePSBTRequest{Psbt: v2.PsbtHex})
+	require.Nil(t, err)
+	assert.Equal(t, v2.Psbt, again.Psbt)
+	v0, err := ConvertPSBT(&ConvertPSBTRequest{Psbt: v2.Psbt})
+	require.Nil(t, err)
+	assert.Equal(t, updated.Psbt, v0.Psbt)
+
+	_, err = ConvertPSBT(&ConvertPSBTRequest{Psbt: updated.Psbt, PsbtVersion: 1})
+	assert.NotNil(t, err)
+	v1, err := CreatePSBT(network, &CreatePSBTRequest{
+		Inputs:  []*TxInput{{TxId: "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5"}},
+		Outputs: []*TxOutput{{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 9000}},
+		Version: 1,
+	})
+	require.Nil(t, err)
+	_, err = ConvertPSBT(&ConvertPSBTRequest{Psbt: v1.Psbt, PsbtVersion: 2})
+	assert.NotNil(t, err)
+}
+
+func TestPSBTv2Constructor(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	privateKey := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	taprootAddress := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	segwitAddress := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+	txId := "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5"
+
+	created, err := CreatePSBT(network, &CreatePSBTRequest{PsbtVersion: 2, InputsModifiable: true, OutputsModifiable: true})
+	require.Nil(t, err)
+	assert.Equal(t, uint32(2), created.Version)
+	constructed, err := ConstructPSBT(network, &ConstructPSBTRequest{
+		Psbt:    created.Psbt,
+		Inputs:  []*ConstructPSBTInput{{TxId: txId, VOut: 0}},
+		Outputs: []*TxOutput{{Address: segwitAddress, Amount: 9000}},
+	})
+	require.Nil(t, err)
+
+	// the seller signs SIGHASH_SINGLE|ANYONECANPAY, the buyer adds to it
+	updated, err := UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt:   constructed.Psbt,
+		Inputs: []*PSBTInputUpdate{{Index: 0, Address: taprootAddress, Amount: 10000, SighashType: uint32(txscript.SigHashSingle | txscript.SigHashAnyOneCanPay)}},
+	})
+	require.Nil(t, err)
+	signed, err := SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{privateKey}})
+	require.Nil(t, err)
+	assert.Equal(t, uint32(2), signed.Version)
+	raw, err := base64.StdEncoding.DecodeString(signed.Psbt)
+	require.Nil(t, err)
+	p2, err := ParsePSBTv2(raw)
+	require.Nil(t, err)
+	assert.Equal(t, PSBTInputsModifiable|PSBTOutputsModifiable|PSBTHasSighashSingle, p2.Modifiable)
+
+	_, err = ConstructPSBT(network, &ConstructPSBTRequest{Psbt: signed.Psbt, Inputs: []*ConstructPSBTInput{{TxId: txId, VOut: 1}}})
+	assert.NotNil(t, err)
+	_, err = ConstructPSBT(network, &ConstructPSBTRequest{
+		Psbt:    signed.Psbt,
+		Inputs:  []*ConstructPSBTInput{{TxId: txId, VOut: 0}},
+		Outputs: []*TxOutput{{Address: segwitAddress, Amount: 9000}},
+	})
+	assert.NotNil(t, err)
+	// a required lock time would change the lock time signed for
+	_, err = ConstructPSBT(network, &ConstructPSBTRequest{
+		Psbt:    signed.Psbt,
+		Inputs:  []*ConstructPSBTInput{{TxId: txId, VOut: 1, RequiredHeightLockTime: 800000}},
+		Outputs: []*TxOutput{{Address: segwitAddress, Amount: 9000}},
+	})
+	assert.NotNil(t, err)
+	constructed, err = ConstructPSBT(network, &ConstructPSBTRequest{
+		Psbt:    signed.Psbt,
+		Inputs:  []*ConstructPSBTInput{{TxId: txId, VOut: 1}},
+		Outputs: []*TxOutput{{Address: taprootAddress, Amount: 9000}},
+	})
+	require.Nil(t, err)
+
+	updated, err = UpdatePSBT(network, &UpdatePSBTRequest{
+		Psbt:   constructed.Psbt,
+		Inputs: []*PSBTInputUpdate{{Index: 1, Address: segwitAddress, Amount: 10000}},
+	})
+	require.Nil(t, err)
+	signed, err = SignPSBT(&SignPSBTRequest{Psbt: updated.Psbt, PrivateKeys: []string{privateKey}})
+	require.Nil(t, err)
+	assert.Empty(t, signed.MessageHashMap)
+	raw, err = base64.StdEncoding.DecodeString(signed.Psbt)
+	require.Nil(t, err)
+	p2, err = ParsePSBTv2(raw)
+	require.Nil(t, err)
+	assert.Equal(t, PSBTHasSighashSingle, p2.Modifiable)
+	_, err = ConstructPSBT(network, &ConstructPSBTRequest{
+		Psbt:    signed.Psbt,
+		Inputs:  []*ConstructPSBTInput{{TxId: txId, VOut: 2}},
+		Outputs: []*TxOutput{{Address: segwitAddress, Amount: 9000}},
+	})
+	assert.NotNil(t, err)
+
+	finalized, err := FinalizePSBT(signed.Psbt)
+	require.Nil(t, err)
+	assert.True(t, finalized.Complete)
+	assert.Equal(t, uint32(2), finalized.Version)
+	txHex, err := ExtractPSBT(finalized.Psbt)
+	require.Nil(t, err)
+	tx, err := NewTxFromHex(txHex)
+	require.Nil(t, err)
+	assert.Len(t, tx.TxIn, 2)
+	assert.Len(t, tx.TxOut, 2)
+}
+
+func TestPSBTv2LockTime(t *testing.T) {
+	height, time := uint32(800000), uint32(1700000000)
+	fallback := uint32(10)
+	tests := []struct {
+		name     string
+		inputs   []*PSBTv2Input
+		lockTime uint32
+		fails    bool
+	}{
+		{name: "fallback", inputs: []*PSBTv2Input{{}}, lockTime: fallback},
+		{name: "height", inputs: []*PSBTv2Input{{RequiredHeightLockTime: &height}, {}}, lockTime: height},
+		{name: "time", inputs: []*PSBTv2Input{{RequiredTimeLockTime: &time}, {RequiredHeightLockTime: &height, RequiredTimeLockTime: &time}}, lockTime: time},
+		{name: "height first", inputs: []*PSBTv2Input{{RequiredHeightLockTime: &height, RequiredTimeLockTime: &time}}, lockTime: height},
+		{name: "conflict", inputs: []*PSBTv2Input{{RequiredHeightLockTime: &height}, {RequiredTimeLockTime: &time}}, fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p2 := &PSBTv2{TxVersion: 2, FallbackLockTime: &fallback, Inputs: test.inputs}
+			lockTime, err := p2.LockTime()
+			if test.fails {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, test.lockTime, lockTime)
+		})
+	}
+}
+
+func TestParsePSBTv2Invalid(t *testing.T) {
+	serialize := func(global []*psbt.Unknown, maps ...[]*psbt.Unknown) []byte {
+		var buf bytes.Buffer
+		buf.Write(psbtMagic)
+		require.Nil(t, writePSBTMap(&buf, global))
+		for _, pairs := range maps {
+			require.Nil(t, writePSBTMap(&buf, pairs))
+		}
+		return buf.Bytes()
+	}
+	version := uint32Pair(psbtGlobalVersion, 2)
+	txVersion := uint32Pair(psbtGlobalTxVersion, 2)
+	inputCount := &psbt.Unknown{Key: []byte{psbtGlobalInputCount}, Value: []byte{1}}
+	outputCount := &psbt.Unknown{Key: []byte{psbtGlobalOutputCount}, Value: []byte{0}}
+	input := []*psbt.Unknown{
+		{Key: []byte{psbtInPreviousTxId}, Value: make([]byte, 32)},
+		uint32Pair(psbtInOutputIndex, 0),
+	}
+
+	_, err := ParsePSBTv2(serialize([]*psbt.Unknown{version, txVersion, inputCount, outputCount}, input))
+	require.Nil(t, err)
+
+	tx := wire.NewMsgTx(2)
+	var txBuf bytes.Buffer
+	require.Nil(t, tx.SerializeNoWitness(&txBuf))
+	tests := map[string][]byte{
+		"unsigned tx":      serialize([]*psbt.Unknown{{Key: []byte{psbtGlobalUnsignedTx}, Value: txBuf.Bytes()}, version, txVersion, inputCount, outputCount}, input),
+		"version 0":        serialize([]*psbt.Unknown{txVersion, inputCount, outputCount}, input),
+		"tx version 1":     serialize([]*psbt.Unknown{version, uint32Pair(psbtGlobalTxVersion, 1), inputCount, outputCount}, input),
+		"no input count":   serialize([]*psbt.Unknown{version, txVersion, outputCount}, input),
+		"no output index":  serialize([]*psbt.Unknown{version, txVersion, inputCount, outputCount}, input[:1]),
+		"missing input":    serialize([]*psbt.Unknown{version, txVersion, inputCount, outputCount}),
+		"height as time":   serialize([]*psbt.Unknown{version, txVersion, inputCount, outputCount}, append(input, uint32Pair(psbtInRequiredTimeLockTime, 800000))),
+		"duplicate key":    serialize([]*psbt.Unknown{version, version, txVersion, inputCount, outputCount}, input),
+		"invalid modifier": serialize([]*psbt.Unknown{version, txVersion, inputCount, outputCount, {Key: []byte{psbtGlobalTxModifiable}, Value: []byte{1, 0}}}, input),
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParsePSBTv2(raw)
+			assert.NotNil(t, err)
+		})
+	}
+}
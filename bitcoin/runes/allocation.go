@@ -0,0 +1,146 @@
+package runes
+
+import (
+	"math/big"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// Balances are rune amounts by rune id.
+type Balances map[RuneId]*big.Int
+
+func (balances Balances) add(id RuneId, amount *big.Int) {
+	if amount == nil || amount.Sign() == 0 {
+		return
+	}
+	if balances[id] == nil {
+		balances[id] = new(big.Int)
+	}
+	balances[id].Add(balances[id], amount)
+}
+
+// Allocation is where the runes of a transaction go. The premine of an
+// etching is under RuneId{}, the id of the etched rune is only known once
+// the transaction is mined.
+type Allocation struct {
+	Outputs []Balances `json:"outputs"`
+	Burned  Balances   `json:"burned"`
+}
+
+// Allocate applies the runestone of tx to the rune balances of its inputs.
+// mintAmount is the amount the terms of the minted rune give, nil when the
+// mint is not open. Without runestone the runes go to the first non
+// OP_RETURN output, a cenotaph burns them all.
+func Allocate(tx *wire.MsgTx, inputBalances Balances, mintAmount *big.Int) *Allocation {
+	allocation := &Allocation{
+		Outputs: make([]Balances, len(tx.TxOut)),
+		Burned:  make(Balances),
+	}
+	for i := range allocation.Outputs {
+		allocation.Outputs[i] = make(Balances)
+	}
+	unallocated := make(Balances)
+	for id, amount := range inputBalances {
+		unallocated.add(id, amount)
+	}
+
+	artifact := Decipher(tx)
+	if artifact != nil && artifact.Runestone.Mint != nil {
+		unallocated.add(*artifact.Runestone.Mint, mintAmount)
+	}
+	if artifact != nil && artifact.IsCenotaph() {
+		for id, amount := range unallocated {
+			allocation.Burned.add(id, amount)
+		}
+		return allocation
+	}
+
+	var destinations []int
+	for i, out := range tx.TxOut {
+		if !isOpReturn(out.PkScript) {
+			destinations = append(destinations, i)
+		}
+	}
+	allocate := func(id RuneId, amount *big.Int, output int) {
+		if amount.Sign() > 0 {
+			unallocated[id].Sub(unallocated[id], amount)
+			allocation.Outputs[output].add(id, amount)
+		}
+	}
+
+	var pointer *uint32
+	if artifact != nil {
+		runestone := artifact.Runestone
+		pointer = runestone.Pointer
+		if runestone.Etching != nil {
+			unallocated.add(RuneId{}, runestone.Etching.Premine)
+		}
+		for _, edict := range runestone.Edicts {
+			balance := unallocated[edict.Id]
+			if balance == nil {
+				continue
+			}
+			if int(edict.Output) == len(tx.TxOut) {
+				if len(destinations) == 0 {
+					continue
+				}
+				if edict.Amount.Sign() == 0 {
+					count := big.NewInt(int64(len(destinations)))
+					amount, remainder := new(big.Int).QuoRem(balance, count, new(big.Int))
+					for i, output := range destinations {
+						share := new(big.Int).Set(amount)
+						if big.NewInt(int64(i)).Cmp(remainder) < 0 {
+							share.Add(share, big.NewInt(1))
+						}
+						allocate(edict.Id, share, output)
+					}
+				} else {
+					for _, output := range destinations {
+						allocate(edict.Id, minBig(edict.Amount, balance), output)
+					}
+				}
+				continue
+			}
+			if edict.Amount.Sign() == 0 {
+				allocate(edict.Id, new(big.Int).Set(balance), int(edict.Output))
+			} else {
+				allocate(edict.Id, minBig(edict.Amount, balance), int(edict.Output))
+			}
+		}
+	}
+
+	output := -1
+	if pointer != nil {
+		output = int(*pointer)
+	} else if len(destinations) > 0 {
+		output = destinations[0]
+	}
+	for id, amount := range unallocated {
+		if output < 0 {
+			allocation.Burned.add(id, amount)
+		} else {
+			allocation.Outputs[output].add(id, amount)
+		}
+	}
+	for i, out := range tx.TxOut {
+		if isOpReturn(out.PkScript) {
+			for id, amount := range allocation.Outputs[i] {
+				allocation.Burned.add(id, amount)
+			}
+			allocation.Outputs[i] = make(Balances)
+		}
+	}
+	return allocation
+}
+
+func minBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return new(big.Int).Set(a)
+	}
+	return new(big.Int).Set(b)
+}
+
+func isOpReturn(pkScript []byte) bool {
+	return len(pkScript) > 0 && pkScript[0] == txscript.OP_RETURN
+}
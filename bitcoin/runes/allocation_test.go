@@ -0,0 +1,73 @@
+package runes
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllocate(t *testing.T) {
+	id := RuneId{Block: 840000, Tx: 3}
+	inputs := func() Balances {
+		return Balances{id: big.NewInt(1001)}
+	}
+	encipher := func(t *testing.T, runestone *Runestone, outputCount int) *wire.MsgTx {
+		script, err := runestone.Encipher()
+		require.Nil(t, err)
+		tx := scriptTx(outputCount, script)
+		return tx
+	}
+
+	t.Run("no runestone", func(t *testing.T) {
+		tx := scriptTx(2, []byte{0x6a})
+		tx.TxOut[0], tx.TxOut[1] = tx.TxOut[1], tx.TxOut[0]
+		allocation := Allocate(tx, inputs(), nil)
+		assert.Equal(t, Balances{id: big.NewInt(1001)}, allocation.Outputs[1])
+		assert.Empty(t, allocation.Burned)
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		pointer := uint32(1)
+		tx := encipher(t, &Runestone{Edicts: []*Edict{{Id: id, Amount: big.NewInt(100), Output: 0}}, Pointer: &pointer}, 3)
+		allocation := Allocate(tx, inputs(), nil)
+		assert.Equal(t, Balances{id: big.NewInt(100)}, allocation.Outputs[0])
+		assert.Equal(t, Balances{id: big.NewInt(901)}, allocation.Outputs[1])
+		assert.Empty(t, allocation.Outputs[2])
+	})
+
+	t.Run("split", func(t *testing.T) {
+		tx := encipher(t, &Runestone{Edicts: []*Edict{{Id: id, Amount: big.NewInt(0), Output: 3}}}, 3)
+		allocation := Allocate(tx, inputs(), nil)
+		assert.Equal(t, Balances{id: big.NewInt(501)}, allocation.Outputs[0])
+		assert.Equal(t, Balances{id: big.NewInt(500)}, allocation.Outputs[1])
+
+		tx = encipher(t, &Runestone{Edicts: []*Edict{{Id: id, Amount: big.NewInt(600), Output: 3}}}, 3)
+		allocation = Allocate(tx, inputs(), nil)
+		assert.Equal(t, Balances{id: big.NewInt(600)}, allocation.Outputs[0])
+		assert.Equal(t, Balances{id: big.NewInt(401)}, allocation.Outputs[1])
+	})
+
+	t.Run("burn", func(t *testing.T) {
+		tx := encipher(t, &Runestone{Edicts: []*Edict{{Id: id, Amount: big.NewInt(1), Output: 1}}}, 2)
+		allocation := Allocate(tx, inputs(), nil)
+		assert.Equal(t, Balances{id: big.NewInt(1)}, allocation.Burned)
+		assert.Equal(t, Balances{id: big.NewInt(1000)}, allocation.Outputs[0])
+	})
+
+	t.Run("mint and etching", func(t *testing.T) {
+		mint := RuneId{Block: 1, Tx: 0}
+		tx := encipher(t, &Runestone{Mint: &mint, Etching: &Etching{Premine: big.NewInt(7)}}, 2)
+		allocation := Allocate(tx, inputs(), big.NewInt(5))
+		assert.Equal(t, Balances{id: big.NewInt(1001), mint: big.NewInt(5), RuneId{}: big.NewInt(7)}, allocation.Outputs[0])
+	})
+
+	t.Run("cenotaph", func(t *testing.T) {
+		tx := runestoneTx(2, TagMint, 1, TagMint, 0, TagCenotaph, 0)
+		allocation := Allocate(tx, inputs(), big.NewInt(5))
+		assert.Equal(t, Balances{id: big.NewInt(1001), RuneId{Block: 1}: big.NewInt(5)}, allocation.Burned)
+		assert.Empty(t, allocation.Outputs[0])
+	})
+}
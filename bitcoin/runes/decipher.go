@@ -0,0 +1,317 @@
+package runes
+
+import (
+	"errors"
+	"math"
+	"math/big"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// Flaw is why a runestone is a cenotaph, its input runes are then burnt.
+type Flaw string
+
+const (
+	FlawEdictOutput         Flaw = "edict output greater than transaction output count"
+	FlawEdictRuneId         Flaw = "invalid rune id in edict"
+	FlawInvalidScript       Flaw = "invalid script in OP_RETURN"
+	FlawOpcode              Flaw = "non-pushdata opcode in OP_RETURN"
+	FlawSupplyOverflow      Flaw = "supply overflows u128"
+	FlawTrailingIntegers    Flaw = "trailing integers in body"
+	FlawTruncatedField      Flaw = "field with missing value"
+	FlawUnrecognizedEvenTag Flaw = "unrecognized even tag"
+	FlawUnrecognizedFlag    Flaw = "unrecognized field"
+	FlawVarint              Flaw = "invalid varint"
+)
+
+var (
+	errVarintOverlong     = errors.New("varint too long")
+	errVarintOverflow     = errors.New("varint overflows u128")
+	errVarintUnterminated = errors.New("unterminated varint")
+)
+
+// DecodeVarint reads a LEB128 u128 from the start of buf and returns it
+// with the number of bytes read.
+func DecodeVarint(buf []byte) (*big.Int, int, error) {
+	n := new(big.Int)
+	for i, b := range buf {
+		if i >= MaxVarintLength {
+			return nil, 0, errVarintOverlong
+		}
+		value := uint64(b & 0x7f)
+		if i == MaxVarintLength-1 && value&0b0111_1100 != 0 {
+			return nil, 0, errVarintOverflow
+		}
+		n.Or(n, new(big.Int).Lsh(new(big.Int).SetUint64(value), uint(7*i)))
+		if b&0x80 == 0 {
+			return n, i + 1, nil
+		}
+	}
+	return nil, 0, errVarintUnterminated
+}
+
+// Artifact is what Decipher finds in a transaction. Flaw is empty for a
+// runestone, a cenotaph only keeps the rune of its etching and its mint.
+type Artifact struct {
+	Runestone *Runestone `json:"runestone"`
+	Flaw      Flaw       `json:"flaw,omitempty"`
+}
+
+func (artifact *Artifact) IsCenotaph() bool {
+	return artifact.Flaw != ""
+}
+
+// payload returns the pushes of the first OP_RETURN OP_13 output of tx,
+// nil when there is none.
+func payload(tx *wire.MsgTx) ([]byte, Flaw, bool) {
+	for _, out := range tx.TxOut {
+		tokenizer := txscript.MakeScriptTokenizer(0, out.PkScript)
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_RETURN {
+			continue
+		}
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_13 {
+			continue
+		}
+
+		payload := []byte{}
+		for tokenizer.Next() {
+			if tokenizer.Opcode() > txscript.OP_PUSHDATA4 {
+				return nil, FlawOpcode, true
+			}
+			payload = append(payload, tokenizer.Data()...)
+		}
+		if tokenizer.Err() != nil {
+			return nil, FlawInvalidScript, true
+		}
+		return payload, "", true
+	}
+	return nil, "", false
+}
+
+// fields are the tag value pairs before the body, in order of appearance.
+type fields map[uint64][]*big.Int
+
+// take removes the first n values of tag when there are n of them and
+// parse accepts them, a rejected field stays to be seen as unrecognized.
+func (fields fields) take(tag uint64, n int, parse func([]*big.Int) bool) {
+	values := fields[tag]
+	if len(values) < n || !parse(values[:n]) {
+		return
+	}
+	if len(values) == n {
+		delete(fields, tag)
+	} else {
+		fields[tag] = values[n:]
+	}
+}
+
+func (fields fields) takeBig(tag uint64) *big.Int {
+	var value *big.Int
+	fields.take(tag, 1, func(values []*big.Int) bool {
+		value = values[0]
+		return true
+	})
+	return value
+}
+
+func (fields fields) takeUint64(tag uint64, max uint64) *uint64 {
+	var value *uint64
+	fields.take(tag, 1, func(values []*big.Int) bool {
+		if !values[0].IsUint64() || values[0].Uint64() > max {
+			return false
+		}
+		v := values[0].Uint64()
+		value = &v
+		return true
+	})
+	return value
+}
+
+// takeFlag clears bit flag of flags and tells whether it was set.
+func takeFlag(flags *big.Int, flag int) bool {
+	if flags.Bit(flag) == 0 {
+		return false
+	}
+	flags.SetBit(flags, flag, 0)
+	return true
+}
+
+// next is the rune id of an edict delta encoded from id.
+func (id RuneId) next(block, tx *big.Int) (RuneId, bool) {
+	if !block.IsUint64() || !tx.IsUint64() {
+		return RuneId{}, false
+	}
+	next := RuneId{}
+	if block.Sign() == 0 {
+		next.Block = id.Block
+		if tx.Uint64() > math.MaxUint32-uint64(id.Tx) {
+			return RuneId{}, false
+		}
+		next.Tx = id.Tx + uint32(tx.Uint64())
+	} else {
+		if block.Uint64() > math.MaxUint64-id.Block || tx.Uint64() > math.MaxUint32 {
+			return RuneId{}, false
+		}
+		next.Block = id.Block + block.Uint64()
+		next.Tx = uint32(tx.Uint64())
+	}
+	if next.Block == 0 && next.Tx != 0 {
+		return RuneId{}, false
+	}
+	return next, true
+}
+
+// Decipher finds the runestone of tx following the protocol rules, it
+// returns nil when tx has no OP_RETURN OP_13 output.
+func Decipher(tx *wire.MsgTx) *Artifact {
+	payload, flaw, ok := payload(tx)
+	if !ok {
+		return nil
+	}
+	if flaw != "" {
+		return &Artifact{Runestone: &Runestone{}, Flaw: flaw}
+	}
+
+	var integers []*big.Int
+	for len(payload) > 0 {
+		n, size, err := DecodeVarint(payload)
+		if err != nil {
+			return &Artifact{Runestone: &Runestone{}, Flaw: FlawVarint}
+		}
+		integers = append(integers, n)
+		payload = payload[size:]
+	}
+
+	runestone := &Runestone{}
+	fields := make(fields)
+	unknownEvenTag := false
+	for i := 0; i < len(integers); i += 2 {
+		tag := integers[i]
+		if tag.Sign() == 0 { // TagBody
+			id := RuneId{}
+			for chunk := integers[i+1:]; len(chunk) > 0; chunk = chunk[4:] {
+				if len(chunk) < 4 {
+					flaw = orFlaw(flaw, FlawTrailingIntegers)
+					break
+				}
+				next, ok := id.next(chunk[0], chunk[1])
+				if !ok {
+					flaw = orFlaw(flaw, FlawEdictRuneId)
+					break
+				}
+				if !chunk[3].IsUint64() || chunk[3].Uint64() > uint64(len(tx.TxOut)) {
+					flaw = orFlaw(flaw, FlawEdictOutput)
+					break
+				}
+				id = next
+				runestone.Edicts = append(runestone.Edicts, &Edict{Id: next, Amount: chunk[2], Output: uint32(chunk[3].Uint64())})
+			}
+			break
+		}
+		if i+1 >= len(integers) {
+			flaw = orFlaw(flaw, FlawTruncatedField)
+			break
+		}
+		if !tag.IsUint64() {
+			unknownEvenTag = unknownEvenTag || tag.Bit(0) == 0
+			continue
+		}
+		fields[tag.Uint64()] = append(fields[tag.Uint64()], integers[i+1])
+	}
+
+	flags := new(big.Int)
+	if value := fields.takeBig(TagFlags); value != nil {
+		flags.Set(value)
+	}
+	var runeValue *big.Int
+	var spacers *uint64
+	if takeFlag(flags, FlagEtching) {
+		etching := &Etching{}
+		if divisibility := fields.takeUint64(TagDivisibility, MaxDivisibility); divisibility != nil {
+			value := uint8(*divisibility)
+			etching.Divisibility = &value
+		}
+		etching.Premine = fields.takeBig(TagPremine)
+		runeValue = fields.takeBig(TagRune)
+		spacers = fields.takeUint64(TagSpacers, MaxSpacers)
+		fields.take(TagSymbol, 1, func(values []*big.Int) bool {
+			if !values[0].IsUint64() || values[0].Uint64() > 0x10ffff || (values[0].Uint64() >= 0xd800 && values[0].Uint64() <= 0xdfff) {
+				return false
+			}
+			etching.Symbol = string(rune(values[0].Uint64()))
+			return true
+		})
+		if takeFlag(flags, FlagTerms) {
+			etching.Terms = &Terms{
+				Cap:         fields.takeBig(TagCap),
+				HeightStart: fields.takeUint64(TagHeightStart, math.MaxUint64),
+				HeightEnd:   fields.takeUint64(TagHeightEnd, math.MaxUint64),
+				Amount:      fields.takeBig(TagAmount),
+				OffsetStart: fields.takeUint64(TagOffsetStart, math.MaxUint64),
+				OffsetEnd:   fields.takeUint64(TagOffsetEnd, math.MaxUint64),
+			}
+		}
+		etching.Turbo = takeFlag(flags, FlagTurbo)
+		if runeValue != nil {
+			spacerBits := uint32(0)
+			if spacers != nil {
+				spacerBits = uint32(*spacers)
+			}
+			etching.Rune = SpacedRuneName(runeValue, spacerBits)
+		}
+		runestone.Etching = etching
+	}
+
+	fields.take(TagMint, 2, func(values []*big.Int) bool {
+		if !values[0].IsUint64() || !values[1].IsUint64() || values[1].Uint64() > math.MaxUint32 {
+			return false
+		}
+		id := RuneId{Block: values[0].Uint64(), Tx: uint32(values[1].Uint64())}
+		if id.Block == 0 && id.Tx != 0 {
+			return false
+		}
+		runestone.Mint = &id
+		return true
+	})
+	fields.take(TagPointer, 1, func(values []*big.Int) bool {
+		if !values[0].IsUint64() || values[0].Uint64() >= uint64(len(tx.TxOut)) {
+			return false
+		}
+		pointer := uint32(values[0].Uint64())
+		runestone.Pointer = &pointer
+		return true
+	})
+
+	if runestone.Etching != nil {
+		if _, err := runestone.Etching.Supply(); err != nil {
+			flaw = orFlaw(flaw, FlawSupplyOverflow)
+		}
+	}
+	if flags.Sign() != 0 {
+		flaw = orFlaw(flaw, FlawUnrecognizedFlag)
+	}
+	for tag := range fields {
+		unknownEvenTag = unknownEvenTag || tag%2 == 0
+	}
+	if unknownEvenTag {
+		flaw = orFlaw(flaw, FlawUnrecognizedEvenTag)
+	}
+
+	if flaw != "" {
+		cenotaph := &Runestone{Mint: runestone.Mint}
+		if runestone.Etching != nil && runeValue != nil {
+			cenotaph.Etching = &Etching{Rune: RuneName(runeValue)}
+		}
+		return &Artifact{Runestone: cenotaph, Flaw: flaw}
+	}
+	return &Artifact{Runestone: runestone}
+}
+
+// orFlaw keeps the first flaw found.
+func orFlaw(flaw, next Flaw) Flaw {
+	if flaw != "" {
+		return flaw
+	}
+	return next
+}
@@ -0,0 +1,110 @@
+package runes
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runestoneTx returns a tx with outputCount - 1 plain outputs followed by
+// the OP_RETURN OP_13 output pushing the integers given.
+func runestoneTx(outputCount int, integers ...uint64) *wire.MsgTx {
+	var payload []byte
+	for _, n := range integers {
+		payload = EncodeUvarint(payload, n)
+	}
+	return scriptTx(outputCount, append([]byte{0x6a, 0x5d, byte(len(payload))}, payload...))
+}
+
+func scriptTx(outputCount int, script []byte) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	for i := 0; i < outputCount-1; i++ {
+		tx.AddTxOut(wire.NewTxOut(546, []byte{0x51}))
+	}
+	tx.AddTxOut(wire.NewTxOut(0, script))
+	return tx
+}
+
+func TestDecipher(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		divisibility := uint8(2)
+		heightStart, offsetEnd := uint64(840000), uint64(100)
+		pointer := uint32(0)
+		runestone := &Runestone{
+			Edicts: []*Edict{
+				{Id: RuneId{Block: 840000, Tx: 3}, Amount: big.NewInt(100), Output: 0},
+				{Id: RuneId{Block: 840000, Tx: 1}, Amount: big.NewInt(0), Output: 2},
+			},
+			Etching: &Etching{
+				Divisibility: &divisibility,
+				Premine:      big.NewInt(1000),
+				Rune:         "UNCOMMON•GOODS",
+				Symbol:       "⧉",
+				Terms:        &Terms{Amount: big.NewInt(1), Cap: big.NewInt(21000000), HeightStart: &heightStart, OffsetEnd: &offsetEnd},
+				Turbo:        true,
+			},
+			Mint:    &RuneId{Block: 1, Tx: 0},
+			Pointer: &pointer,
+		}
+		script, err := runestone.Encipher()
+		require.Nil(t, err)
+		tx := scriptTx(2, script)
+		tx.TxOut = append([]*wire.TxOut{wire.NewTxOut(0, []byte{0x6a, 0x01, 0x01})}, tx.TxOut...)
+
+		artifact := Decipher(tx)
+		require.NotNil(t, artifact)
+		assert.False(t, artifact.IsCenotaph())
+		// edicts come back sorted
+		runestone.Edicts[0], runestone.Edicts[1] = runestone.Edicts[1], runestone.Edicts[0]
+		assert.Equal(t, runestone, artifact.Runestone)
+	})
+
+	t.Run("no runestone", func(t *testing.T) {
+		assert.Nil(t, Decipher(scriptTx(1, []byte{0x6a, 0x01, 0x0d})))
+	})
+
+	t.Run("odd tags", func(t *testing.T) {
+		artifact := Decipher(runestoneTx(2, TagNop, 5, 9, 1, TagDivisibility, 39))
+		require.NotNil(t, artifact)
+		assert.False(t, artifact.IsCenotaph())
+	})
+
+	tests := []struct {
+		name string
+		tx   *wire.MsgTx
+		flaw Flaw
+	}{
+		{"opcode", scriptTx(1, []byte{0x6a, 0x5d, 0x51}), FlawOpcode},
+		{"invalid script", scriptTx(1, []byte{0x6a, 0x5d, 0x05, 0x01}), FlawInvalidScript},
+		{"varint", scriptTx(1, []byte{0x6a, 0x5d, 0x01, 0x80}), FlawVarint},
+		{"truncated field", runestoneTx(1, TagFlags), FlawTruncatedField},
+		{"trailing integers", runestoneTx(2, TagBody, 1, 1, 1), FlawTrailingIntegers},
+		{"edict rune id", runestoneTx(2, TagBody, 0, 1, 1, 0), FlawEdictRuneId},
+		{"edict output", runestoneTx(2, TagBody, 1, 1, 1, 3), FlawEdictOutput},
+		{"unrecognized flag", runestoneTx(2, TagFlags, 1<<FlagTerms), FlawUnrecognizedFlag},
+		{"unrecognized even tag", runestoneTx(2, TagCenotaph, 0), FlawUnrecognizedEvenTag},
+		{"pointer", runestoneTx(2, TagPointer, 2), FlawUnrecognizedEvenTag},
+		{"mint", runestoneTx(2, TagMint, 0, TagMint, 1), FlawUnrecognizedEvenTag},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			artifact := Decipher(test.tx)
+			require.NotNil(t, artifact)
+			assert.Equal(t, test.flaw, artifact.Flaw)
+		})
+	}
+
+	t.Run("cenotaph keeps mint and rune", func(t *testing.T) {
+		value, err := ParseRune("ABC")
+		require.Nil(t, err)
+		artifact := Decipher(runestoneTx(2, TagFlags, 1<<FlagEtching, TagRune, value.Uint64(), TagMint, 5, TagMint, 1, TagCenotaph, 0))
+		require.NotNil(t, artifact)
+		assert.True(t, artifact.IsCenotaph())
+		assert.Equal(t, &RuneId{Block: 5, Tx: 1}, artifact.Runestone.Mint)
+		assert.Equal(t, "ABC", artifact.Runestone.Etching.Rune)
+		assert.Nil(t, artifact.Runestone.Edicts)
+	})
+}
@@ -0,0 +1,113 @@
+package runes
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Spacer is the dot shown between the letters of a spaced rune name, a
+// plain '.' is accepted as well.
+const Spacer = '•'
+
+// Reserved is the first rune of the names given to etchings without one,
+// they can't be etched explicitly.
+var Reserved, _ = new(big.Int).SetString("6402364363415443603228541259936211926", 10)
+
+var big26 = big.NewInt(26)
+
+// ParseRune reads a rune name of capital letters into its value, "A" is 0
+// and "AA" is 26.
+func ParseRune(name string) (*big.Int, error) {
+	if name == "" {
+		return nil, errors.New("empty rune name")
+	}
+	value := new(big.Int)
+	for i, c := range name {
+		if i > 0 {
+			value.Add(value, big.NewInt(1))
+		}
+		if c < 'A' || c > 'Z' {
+			return nil, fmt.Errorf("invalid character %q in rune name %q", c, name)
+		}
+		value.Mul(value, big26)
+		value.Add(value, big.NewInt(int64(c-'A')))
+		if value.Cmp(MaxUint128) > 0 {
+			return nil, fmt.Errorf("rune name %q is out of range", name)
+		}
+	}
+	return value, nil
+}
+
+// RuneName is the inverse of ParseRune.
+func RuneName(value *big.Int) string {
+	n := new(big.Int).Add(value, big.NewInt(1))
+	var name []byte
+	for n.Sign() > 0 {
+		n.Sub(n, big.NewInt(1))
+		name = append(name, byte('A'+new(big.Int).Mod(n, big26).Int64()))
+		n.Div(n, big26)
+	}
+	for i, j := 0, len(name)-1; i < j; i, j = i+1, j-1 {
+		name[i], name[j] = name[j], name[i]
+	}
+	return string(name)
+}
+
+// ParseSpacedRune reads a name such as "UNCOMMON•GOODS" into the rune value
+// and its spacers, bit i of which puts a spacer after letter i.
+func ParseSpacedRune(spacedName string) (*big.Int, uint32, error) {
+	var name strings.Builder
+	spacers := uint32(0)
+	for _, c := range spacedName {
+		if c == Spacer || c == '.' {
+			if name.Len() == 0 {
+				return nil, 0, fmt.Errorf("leading spacer in %q", spacedName)
+			}
+			bit := uint32(1) << (name.Len() - 1)
+			if spacers&bit != 0 {
+				return nil, 0, fmt.Errorf("double spacer in %q", spacedName)
+			}
+			spacers |= bit
+			continue
+		}
+		name.WriteRune(c)
+	}
+	if name.Len() == 0 {
+		return nil, 0, errors.New("empty rune name")
+	}
+	if spacers>>(name.Len()-1) != 0 {
+		return nil, 0, fmt.Errorf("trailing spacer in %q", spacedName)
+	}
+	value, err := ParseRune(name.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, spacers, nil
+}
+
+// SpacedRuneName is the inverse of ParseSpacedRune.
+func SpacedRuneName(value *big.Int, spacers uint32) string {
+	var spacedName strings.Builder
+	name := RuneName(value)
+	for i, c := range name {
+		spacedName.WriteRune(c)
+		if i < len(name)-1 && spacers&(1<<i) != 0 {
+			spacedName.WriteRune(Spacer)
+		}
+	}
+	return spacedName.String()
+}
+
+// Commitment is the push an etching of the rune value must find in the
+// tapscript of one of its inputs: the value as little endian bytes with the
+// trailing zeros trimmed.
+func Commitment(value *big.Int) []byte {
+	bytes := value.Bytes()
+	commitment := make([]byte, len(bytes))
+	for i, b := range bytes {
+		commitment[len(bytes)-1-i] = b
+	}
+	return commitment
+}
@@ -0,0 +1,58 @@
+package runes
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuneName(t *testing.T) {
+	for name, value := range map[string]string{
+		"A":                            "0",
+		"Z":                            "25",
+		"AA":                           "26",
+		"UNCOMMONGOODS":                "2055900680524219742",
+		"AAAAAAAAAAAAA":                "99246114928149462",
+		"BCGDENLQRQWDSLRUGSNLBTMFIJAV": MaxUint128.String(),
+	} {
+		expected, _ := new(big.Int).SetString(value, 10)
+		parsed, err := ParseRune(name)
+		require.Nil(t, err)
+		assert.Equal(t, expected, parsed, name)
+		assert.Equal(t, name, RuneName(expected))
+	}
+
+	_, err := ParseRune("BCGDENLQRQWDSLRUGSNLBTMFIJAW")
+	assert.NotNil(t, err)
+	_, err = ParseRune("abc")
+	assert.NotNil(t, err)
+}
+
+func TestSpacedRune(t *testing.T) {
+	value, spacers, err := ParseSpacedRune("UNCOMMON•GOODS")
+	require.Nil(t, err)
+	assert.Equal(t, "2055900680524219742", value.String())
+	assert.Equal(t, uint32(1<<7), spacers)
+	assert.Equal(t, "UNCOMMON•GOODS", SpacedRuneName(value, spacers))
+
+	_, spacers, err = ParseSpacedRune("A.B.C")
+	require.Nil(t, err)
+	assert.Equal(t, uint32(0b11), spacers)
+
+	for _, name := range []string{"", "•A", "A•", "A••B"} {
+		_, _, err = ParseSpacedRune(name)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestCommitment(t *testing.T) {
+	value, err := ParseRune("UNCOMMONGOODS")
+	require.Nil(t, err)
+	assert.Equal(t, "5e4521bcc606881c", hex.EncodeToString(Commitment(value)))
+	assert.Equal(t, []byte{0x01}, Commitment(big.NewInt(1)))
+	assert.Equal(t, []byte{0x00, 0x01}, Commitment(big.NewInt(256)))
+	assert.Empty(t, Commitment(new(big.Int)))
+}
@@ -0,0 +1,349 @@
+package runes
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+)
+
+// Runestone tags, the even ones make unknown runestones cenotaphs.
+const (
+	TagBody         = 0
+	TagDivisibility = 1
+	TagFlags        = 2
+	TagSpacers      = 3
+	TagRune         = 4
+	TagSymbol       = 5
+	TagPremine      = 6
+	TagCap          = 8
+	TagAmount       = 10
+	TagHeightStart  = 12
+	TagHeightEnd    = 14
+	TagOffsetStart  = 16
+	TagOffsetEnd    = 18
+	TagMint         = 20
+	TagPointer      = 22
+	TagCenotaph     = 126
+	TagNop          = 127
+)
+
+// Bits of the flags field.
+const (
+	FlagEtching  = 0
+	FlagTerms    = 1
+	FlagTurbo    = 2
+	FlagCenotaph = 127
+)
+
+const (
+	MaxDivisibility = 38
+	MaxSpacers      = 0b00000111_11111111_11111111_11111111
+	// MaxScriptElementSize is the largest push of the payload.
+	MaxScriptElementSize = 520
+	// MaxStandardScriptSize is the largest OP_RETURN output relayed by
+	// default, MaxDataCarrierSize plus the opcodes.
+	MaxStandardScriptSize = txscript.MaxDataCarrierSize + 3
+	// CommitConfirmations is how deep the output committing to the rune of
+	// an etching must be when the etching is mined.
+	CommitConfirmations = 6
+)
+
+// RuneId is the block and transaction index of an etching, 0:0 stands for
+// the rune etched by the runestone itself.
+type RuneId struct {
+	Block uint64
+	Tx    uint32
+}
+
+// ParseRuneId reads the "block:tx" form of a rune id.
+func ParseRuneId(s string) (RuneId, error) {
+	block, tx, ok := strings.Cut(s, ":")
+	if !ok {
+		return RuneId{}, fmt.Errorf("rune id %q is not block:tx", s)
+	}
+	blockValue, err := strconv.ParseUint(block, 10, 64)
+	if err != nil {
+		return RuneId{}, fmt.Errorf("rune id %q: %w", s, err)
+	}
+	txValue, err := strconv.ParseUint(tx, 10, 32)
+	if err != nil {
+		return RuneId{}, fmt.Errorf("rune id %q: %w", s, err)
+	}
+	return RuneId{Block: blockValue, Tx: uint32(txValue)}, nil
+}
+
+func (id RuneId) String() string {
+	return fmt.Sprintf("%d:%d", id.Block, id.Tx)
+}
+
+func (id RuneId) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *RuneId) UnmarshalText(text []byte) error {
+	parsed, err := ParseRuneId(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+func (id RuneId) less(other RuneId) bool {
+	return id.Block < other.Block || (id.Block == other.Block && id.Tx < other.Tx)
+}
+
+// Edict moves Amount of rune Id to output Output, Output equal to the
+// number of outputs spreads it over all the non OP_RETURN ones. A zero
+// Amount moves all that is left.
+type Edict struct {
+	Id     RuneId   `json:"id"`
+	Amount *big.Int `json:"amount"`
+	Output uint32   `json:"output"`
+}
+
+// Terms open a rune to mints of Amount, at most Cap of them, within the
+// absolute heights and the offsets from the etching block given.
+type Terms struct {
+	Amount      *big.Int `json:"amount,omitempty"`
+	Cap         *big.Int `json:"cap,omitempty"`
+	HeightStart *uint64  `json:"heightStart,omitempty"`
+	HeightEnd   *uint64  `json:"heightEnd,omitempty"`
+	OffsetStart *uint64  `json:"offsetStart,omitempty"`
+	OffsetEnd   *uint64  `json:"offsetEnd,omitempty"`
+}
+
+// Etching creates a rune. Rune is its spaced name such as "UNCOMMON•GOODS",
+// the spacers come from its dots, a reserved name is assigned when empty.
+type Etching struct {
+	Divisibility *uint8   `json:"divisibility,omitempty"`
+	Premine      *big.Int `json:"premine,omitempty"`
+	Rune         string   `json:"rune,omitempty"`
+	Symbol       string   `json:"symbol,omitempty"`
+	Terms        *Terms   `json:"terms,omitempty"`
+	Turbo        bool     `json:"turbo,omitempty"`
+}
+
+type Runestone struct {
+	Edicts  []*Edict `json:"edicts,omitempty"`
+	Etching *Etching `json:"etching,omitempty"`
+	Mint    *RuneId  `json:"mint,omitempty"`
+	Pointer *uint32  `json:"pointer,omitempty"`
+}
+
+type payloadWriter struct {
+	payload []byte
+}
+
+func (writer *payloadWriter) tag(tag uint64, value uint64) {
+	writer.payload = EncodeUvarint(writer.payload, tag)
+	writer.payload = EncodeUvarint(writer.payload, value)
+}
+
+func (writer *payloadWriter) bigTag(tag uint64, value *big.Int) error {
+	if value == nil {
+		return nil
+	}
+	if err := checkUint128(value); err != nil {
+		return fmt.Errorf("tag %d: %w", tag, err)
+	}
+	writer.payload = EncodeUvarint(writer.payload, tag)
+	writer.payload = EncodeVarint(writer.payload, value)
+	return nil
+}
+
+func (writer *payloadWriter) optionalTag(tag uint64, value *uint64) {
+	if value != nil {
+		writer.tag(tag, *value)
+	}
+}
+
+// Payload returns the integers of the runestone as LEB128 varints, the
+// edicts sorted and delta encoded after the body tag.
+func (runestone *Runestone) Payload() ([]byte, error) {
+	writer := &payloadWriter{}
+	if etching := runestone.Etching; etching != nil {
+		if err := etching.write(writer); err != nil {
+			return nil, err
+		}
+	}
+	if runestone.Mint != nil {
+		if runestone.Mint.Block == 0 {
+			return nil, errors.New("mint of the rune etched by the runestone itself")
+		}
+		writer.tag(TagMint, runestone.Mint.Block)
+		writer.tag(TagMint, uint64(runestone.Mint.Tx))
+	}
+	if runestone.Pointer != nil {
+		writer.tag(TagPointer, uint64(*runestone.Pointer))
+	}
+
+	if len(runestone.Edicts) > 0 {
+		edicts := make([]*Edict, len(runestone.Edicts))
+		copy(edicts, runestone.Edicts)
+		sort.SliceStable(edicts, func(i, j int) bool {
+			return edicts[i].Id.less(edicts[j].Id)
+		})
+
+		writer.payload = EncodeUvarint(writer.payload, TagBody)
+		previous := RuneId{}
+		for _, edict := range edicts {
+			if edict.Id.Block == 0 && edict.Id.Tx != 0 {
+				return nil, fmt.Errorf("invalid rune id %s", edict.Id)
+			}
+			if edict.Amount == nil {
+				return nil, fmt.Errorf("missing amount of the edict of %s", edict.Id)
+			}
+			if err := checkUint128(edict.Amount); err != nil {
+				return nil, fmt.Errorf("edict of %s: %w", edict.Id, err)
+			}
+			// the tx index is only a delta within the same block
+			txDelta := uint64(edict.Id.Tx)
+			if edict.Id.Block == previous.Block {
+				txDelta -= uint64(previous.Tx)
+			}
+			writer.payload = EncodeUvarint(writer.payload, edict.Id.Block-previous.Block)
+			writer.payload = EncodeUvarint(writer.payload, txDelta)
+			writer.payload = EncodeVarint(writer.payload, edict.Amount)
+			writer.payload = EncodeUvarint(writer.payload, uint64(edict.Output))
+			previous = edict.Id
+		}
+	}
+	return writer.payload, nil
+}
+
+func (etching *Etching) write(writer *payloadWriter) error {
+	flags := uint64(1) << FlagEtching
+	if etching.Terms != nil {
+		flags |= 1 << FlagTerms
+	}
+	if etching.Turbo {
+		flags |= 1 << FlagTurbo
+	}
+	writer.tag(TagFlags, flags)
+
+	spacers := uint32(0)
+	if etching.Rune != "" {
+		value, runeSpacers, err := ParseSpacedRune(etching.Rune)
+		if err != nil {
+			return err
+		}
+		if value.Cmp(Reserved) >= 0 {
+			return fmt.Errorf("rune %s is reserved", etching.Rune)
+		}
+		if err = writer.bigTag(TagRune, value); err != nil {
+			return err
+		}
+		spacers = runeSpacers
+	}
+	if etching.Divisibility != nil {
+		if *etching.Divisibility > MaxDivisibility {
+			return fmt.Errorf("divisibility must be at most %d", MaxDivisibility)
+		}
+		writer.tag(TagDivisibility, uint64(*etching.Divisibility))
+	}
+	if spacers != 0 {
+		writer.tag(TagSpacers, uint64(spacers))
+	}
+	if etching.Symbol != "" {
+		symbol, size := utf8.DecodeRuneInString(etching.Symbol)
+		if symbol == utf8.RuneError || size != len(etching.Symbol) {
+			return fmt.Errorf("symbol %q must be a single character", etching.Symbol)
+		}
+		writer.tag(TagSymbol, uint64(symbol))
+	}
+	if err := writer.bigTag(TagPremine, etching.Premine); err != nil {
+		return err
+	}
+
+	if terms := etching.Terms; terms != nil {
+		if err := writer.bigTag(TagAmount, terms.Amount); err != nil {
+			return err
+		}
+		if err := writer.bigTag(TagCap, terms.Cap); err != nil {
+			return err
+		}
+		writer.optionalTag(TagHeightStart, terms.HeightStart)
+		writer.optionalTag(TagHeightEnd, terms.HeightEnd)
+		writer.optionalTag(TagOffsetStart, terms.OffsetStart)
+		writer.optionalTag(TagOffsetEnd, terms.OffsetEnd)
+	}
+	if _, err := etching.Supply(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Supply is the premine plus cap times amount, it must fit in a u128.
+func (etching *Etching) Supply() (*big.Int, error) {
+	supply := new(big.Int)
+	if etching.Premine != nil {
+		supply.Set(etching.Premine)
+	}
+	if etching.Terms != nil && etching.Terms.Cap != nil && etching.Terms.Amount != nil {
+		supply.Add(supply, new(big.Int).Mul(etching.Terms.Cap, etching.Terms.Amount))
+	}
+	if supply.Cmp(MaxUint128) > 0 {
+		return nil, errors.New("premine plus cap times amount overflows a u128")
+	}
+	return supply, nil
+}
+
+// Encipher returns the OP_RETURN OP_13 output script carrying the payload,
+// split in pushes of at most MaxScriptElementSize bytes.
+func (runestone *Runestone) Encipher() ([]byte, error) {
+	payload, err := runestone.Payload()
+	if err != nil {
+		return nil, err
+	}
+	script := []byte{txscript.OP_RETURN, txscript.OP_13}
+	for len(payload) > 0 {
+		chunk := payload
+		if len(chunk) > MaxScriptElementSize {
+			chunk = chunk[:MaxScriptElementSize]
+		}
+		payload = payload[len(chunk):]
+		// plain pushes, small integers would not read as payload
+		switch {
+		case len(chunk) < txscript.OP_PUSHDATA1:
+			script = append(script, byte(len(chunk)))
+		case len(chunk) <= 0xff:
+			script = append(script, txscript.OP_PUSHDATA1, byte(len(chunk)))
+		default:
+			script = append(script, txscript.OP_PUSHDATA2)
+			script = binary.LittleEndian.AppendUint16(script, uint16(len(chunk)))
+		}
+		script = append(script, chunk...)
+	}
+	return script, nil
+}
+
+// CheckOutputs checks the edict outputs and the pointer of the runestone
+// against the number of outputs of its transaction.
+func (runestone *Runestone) CheckOutputs(outputCount int) error {
+	for _, edict := range runestone.Edicts {
+		if int(edict.Output) > outputCount {
+			return fmt.Errorf("edict of %s to output %d of %d", edict.Id, edict.Output, outputCount)
+		}
+	}
+	if runestone.Pointer != nil && int(*runestone.Pointer) >= outputCount {
+		return fmt.Errorf("pointer to output %d of %d", *runestone.Pointer, outputCount)
+	}
+	return nil
+}
+
+// CheckStandardScript rejects runestone scripts nodes would not relay with
+// their default data carrier size.
+func CheckStandardScript(script []byte) error {
+	if len(script) > MaxStandardScriptSize {
+		return fmt.Errorf("runestone script of %d bytes is above the standard %d", len(script), MaxStandardScriptSize)
+	}
+	return nil
+}
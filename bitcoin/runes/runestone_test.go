@@ -0,0 +1,94 @@
+package runes
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeVarint(t *testing.T) {
+	assert.Equal(t, []byte{0x00}, EncodeVarint(nil, big.NewInt(0)))
+	assert.Equal(t, []byte{0x7f}, EncodeVarint(nil, big.NewInt(127)))
+	assert.Equal(t, []byte{0x80, 0x01}, EncodeVarint(nil, big.NewInt(128)))
+	assert.Equal(t, []byte{0xc0, 0xa2, 0x33}, EncodeUvarint(nil, 840000))
+	encoded := EncodeVarint(nil, MaxUint128)
+	assert.Len(t, encoded, MaxVarintLength)
+	assert.Equal(t, byte(0x03), encoded[MaxVarintLength-1])
+}
+
+func TestEncipher(t *testing.T) {
+	t.Run("mint", func(t *testing.T) {
+		script, err := (&Runestone{Mint: &RuneId{Block: 1, Tx: 0}}).Encipher()
+		require.Nil(t, err)
+		assert.Equal(t, "6a5d0414011400", hex.EncodeToString(script))
+	})
+
+	t.Run("edicts", func(t *testing.T) {
+		runestone := &Runestone{Edicts: []*Edict{
+			{Id: RuneId{Block: 840000, Tx: 3}, Amount: big.NewInt(100), Output: 1},
+			{Id: RuneId{Block: 840000, Tx: 1}, Amount: big.NewInt(5), Output: 0},
+			{Id: RuneId{Block: 840001, Tx: 2}, Amount: big.NewInt(7), Output: 2},
+		}}
+		payload, err := runestone.Payload()
+		require.Nil(t, err)
+		assert.Equal(t, "00"+"c0a233010500"+"00026401"+"01020702", hex.EncodeToString(payload))
+		assert.Nil(t, runestone.CheckOutputs(3))
+		assert.NotNil(t, runestone.CheckOutputs(1))
+	})
+
+	t.Run("etching", func(t *testing.T) {
+		divisibility := uint8(2)
+		heightStart, heightEnd := uint64(840000), uint64(1050000)
+		pointer := uint32(1)
+		runestone := &Runestone{
+			Etching: &Etching{
+				Divisibility: &divisibility,
+				Premine:      big.NewInt(1000),
+				Rune:         "UNCOMMON•GOODS",
+				Symbol:       "⧉",
+				Terms: &Terms{
+					Amount:      big.NewInt(1),
+					Cap:         new(big.Int).Sub(MaxUint128, big.NewInt(1000)),
+					HeightStart: &heightStart,
+					HeightEnd:   &heightEnd,
+				},
+			},
+			Pointer: &pointer,
+		}
+		script, err := runestone.Encipher()
+		require.Nil(t, err)
+		assert.Nil(t, CheckStandardScript(script))
+		payload, err := runestone.Payload()
+		require.Nil(t, err)
+		assert.Equal(t, []byte{TagFlags, 0b11, TagRune}, payload[:3])
+		assert.Equal(t, []byte{TagPointer, 1}, payload[len(payload)-2:])
+
+		runestone.Etching.Premine = big.NewInt(1001)
+		_, err = runestone.Encipher()
+		assert.NotNil(t, err)
+		runestone.Etching.Premine = nil
+		runestone.Etching.Rune = RuneName(Reserved)
+		_, err = runestone.Encipher()
+		assert.NotNil(t, err)
+		runestone.Etching.Rune = "AAAAAAAAAAAAA"
+		runestone.Etching.Symbol = "ab"
+		_, err = runestone.Encipher()
+		assert.NotNil(t, err)
+	})
+
+	t.Run("large payload", func(t *testing.T) {
+		runestone := &Runestone{}
+		for i := 0; i < 200; i++ {
+			runestone.Edicts = append(runestone.Edicts, &Edict{Id: RuneId{Block: 840000, Tx: uint32(i)}, Amount: big.NewInt(1000000), Output: 1})
+		}
+		script, err := runestone.Encipher()
+		require.Nil(t, err)
+		assert.NotNil(t, CheckStandardScript(script))
+		assert.Equal(t, byte(txscript.OP_PUSHDATA2), script[2])
+		assert.Equal(t, []byte{0x08, 0x02}, script[3:5])
+	})
+}
@@ -0,0 +1,40 @@
+package runes
+
+import (
+	"errors"
+	"math/big"
+)
+
+// MaxVarintLength is the length of the LEB128 encoding of the largest u128.
+const MaxVarintLength = 19
+
+// MaxUint128 is the ceiling of runestone integers.
+var MaxUint128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+var bigMask7 = big.NewInt(0x7f)
+
+// EncodeVarint appends the LEB128 encoding of n, a u128, to buf.
+func EncodeVarint(buf []byte, n *big.Int) []byte {
+	n = new(big.Int).Set(n)
+	for n.Cmp(bigMask7) > 0 {
+		buf = append(buf, byte(new(big.Int).And(n, bigMask7).Uint64())|0x80)
+		n.Rsh(n, 7)
+	}
+	return append(buf, byte(n.Uint64()))
+}
+
+// EncodeUvarint is EncodeVarint for the integers that fit in a uint64.
+func EncodeUvarint(buf []byte, n uint64) []byte {
+	for n > 0x7f {
+		buf = append(buf, byte(n&0x7f)|0x80)
+		n >>= 7
+	}
+	return append(buf, byte(n))
+}
+
+func checkUint128(n *big.Int) error {
+	if n.Sign() < 0 || n.Cmp(MaxUint128) > 0 {
+		return errors.New("value out of the u128 range")
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package bitcoin
 
 import (
 	"encoding/hex"
-	"fmt"
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
@@ -13,45 +12,53 @@ import (
 
 func GetMessageHash(tx *wire.MsgTx, pubKeyBytes []byte, prevOutFetcher *txscript.MultiPrevOutFetcher) (map[int]string, error) {
 	var messageHashes = make(map[int]string)
-	for i, in := range tx.TxIn {
-		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+	for i := range tx.TxIn {
 		txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
-		if txscript.IsPayToTaproot(prevOut.PkScript) {
-			//witness, err := txscript.TaprootWitnessSignature(tx, txSigHashes, i, prevOut.Value, prevOut.PkScript, txscript.SigHashDefault, privKey)
-			sigHash, err := txscript.CalcTaprootSignatureHashRaw(
-				txSigHashes, txscript.SigHashDefault, tx, i,
-				txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value),
-			)
-			fmt.Printf("%x \n", prevOut.PkScript)
-			if err != nil {
-				return messageHashes, err
-			}
-			messageHashes[i] = hexutil.Encode(sigHash)
-		} else if txscript.IsPayToPubKeyHash(prevOut.PkScript) {
-			//sigScript, err := txscript.SignatureScript(tx, i, prevOut.PkScript, txscript.SigHashAll, privKey, true)
-			hash, err := txscript.CalcSignatureHash(prevOut.PkScript, txscript.SigHashAll, tx, i)
-			if err != nil {
-				return messageHashes, err
-			}
-			messageHashes[i] = hexutil.Encode(hash)
-		} else {
-			script, err := PayToPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
-			if err != nil {
-				return messageHashes, err
-			}
-			amount := prevOut.Value
-			//witness, err := txscript.WitnessSignature(tx, txSigHashes, i, amount, script, txscript.SigHashAll, privKey, true)
-			hash, err := txscript.CalcWitnessSignatureHashRaw(script, txSigHashes, txscript.SigHashAll, tx,
-				i, amount)
-			if err != nil {
-				return messageHashes, err
-			}
-			messageHashes[i] = hexutil.Encode(hash)
+		messageHash, err := calcInputMessageHash(tx, i, prevOutFetcher, txSigHashes, pubKeyBytes)
+		if err != nil {
+			return messageHashes, err
 		}
+		messageHashes[i] = messageHash
 	}
 	return messageHashes, nil
 }
 
+// calcInputMessageHash is the key path sighash of input i, pubKeyBytes is only
+// used for segwit v0 inputs.
+func calcInputMessageHash(tx *wire.MsgTx, i int, prevOutFetcher *txscript.MultiPrevOutFetcher, txSigHashes *txscript.TxSigHashes, pubKeyBytes []byte) (string, error) {
+	prevOut := prevOutFetcher.FetchPrevOutput(tx.TxIn[i].PreviousOutPoint)
+	if txscript.IsPayToTaproot(prevOut.PkScript) {
+		//witness, err := txscript.TaprootWitnessSignature(tx, txSigHashes, i, prevOut.Value, prevOut.PkScript, txscript.SigHashDefault, privKey)
+		sigHash, err := txscript.CalcTaprootSignatureHashRaw(
+			txSigHashes, txscript.SigHashDefault, tx, i,
+			txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value),
+		)
+		if err != nil {
+			return "", err
+		}
+		return hexutil.Encode(sigHash), nil
+	} else if txscript.IsPayToPubKeyHash(prevOut.PkScript) {
+		//sigScript, err := txscript.SignatureScript(tx, i, prevOut.PkScript, txscript.SigHashAll, privKey, true)
+		hash, err := txscript.CalcSignatureHash(prevOut.PkScript, txscript.SigHashAll, tx, i)
+		if err != nil {
+			return "", err
+		}
+		return hexutil.Encode(hash), nil
+	}
+	script, err := PayToPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
+	if err != nil {
+		return "", err
+	}
+	amount := prevOut.Value
+	//witness, err := txscript.WitnessSignature(tx, txSigHashes, i, amount, script, txscript.SigHashAll, privKey, true)
+	hash, err := txscript.CalcWitnessSignatureHashRaw(script, txSigHashes, txscript.SigHashAll, tx,
+		i, amount)
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(hash), nil
+}
+
 func BuildRawData(network *chaincfg.Params, txHex string, commitTxPrevOutputList []*PrevOutput, signatureMap map[int]string, pubKey string) (string, error) {
 	tool := &InscriptionBuilder{
 		Network: network,
@@ -81,47 +88,72 @@ func BuildRawData(network *chaincfg.Params, txHex string, commitTxPrevOutputList
 }
 
 func SignBySignature(tx *wire.MsgTx, prevOutFetcher *txscript.MultiPrevOutFetcher, signatureMap map[int]string, pubKey string) error {
-	for i, in := range tx.TxIn {
-		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+	for i := range tx.TxIn {
 		txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
-		if txscript.IsPayToTaproot(prevOut.PkScript) {
-			signatureBytes, err := hex.DecodeString(signatureMap[i])
-			if err != nil {
-				return err
-			}
-			in.Witness = wire.TxWitness{signatureBytes}
-			//return errors.New("not supper taproot address")
-		} else if txscript.IsPayToPubKeyHash(prevOut.PkScript) {
-			sigScript, err := txscript.SignatureScript2(tx, i, prevOut.PkScript, txscript.SigHashAll, signatureMap[i], pubKey, true)
-			if err != nil {
-				return err
-			}
-			in.SignatureScript = sigScript
-		} else {
-			pubKeyBytes, err := hex.DecodeString(pubKey)
-			script, err := PayToPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
-			if err != nil {
-				return err
-			}
-			amount := prevOut.Value
-			witness, err := txscript.WitnessSignature2(tx, txSigHashes, i, amount, script, txscript.SigHashAll, signatureMap[i], pubKeyBytes, true)
+		if err := signInputBySignature(tx, i, prevOutFetcher, txSigHashes, signatureMap[i], pubKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signInputBySignature sets the key path sigScript or witness of input i from
+// an externally produced signature.
+func signInputBySignature(tx *wire.MsgTx, i int, prevOutFetcher *txscript.MultiPrevOutFetcher, txSigHashes *txscript.TxSigHashes, signature string, pubKey string) error {
+	in := tx.TxIn[i]
+	prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+	if txscript.IsPayToTaproot(prevOut.PkScript) {
+		signatureBytes, err := hex.DecodeString(signature)
+		if err != nil {
+			return err
+		}
+		in.Witness = wire.TxWitness{signatureBytes}
+		//return errors.New("not supper taproot address")
+	} else if txscript.IsPayToPubKeyHash(prevOut.PkScript) {
+		sigScript, err := txscript.SignatureScript2(tx, i, prevOut.PkScript, txscript.SigHashAll, signature, pubKey, true)
+		if err != nil {
+			return err
+		}
+		in.SignatureScript = sigScript
+	} else {
+		pubKeyBytes, err := hex.DecodeString(pubKey)
+		script, err := PayToPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
+		if err != nil {
+			return err
+		}
+		amount := prevOut.Value
+		witness, err := txscript.WitnessSignature2(tx, txSigHashes, i, amount, script, txscript.SigHashAll, signature, pubKeyBytes, true)
+		if err != nil {
+			return err
+		}
+		in.Witness = witness
+
+		if txscript.IsPayToScriptHash(prevOut.PkScript) {
+			redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
 			if err != nil {
 				return err
 			}
-			in.Witness = witness
-
-			if txscript.IsPayToScriptHash(prevOut.PkScript) {
-				redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(pubKeyBytes))
-				if err != nil {
-					return err
-				}
-				in.SignatureScript = append([]byte{byte(len(redeemScript))}, redeemScript...)
-			}
+			in.SignatureScript = append([]byte{byte(len(redeemScript))}, redeemScript...)
 		}
 	}
 	return nil
 }
 
+// dummySignature returns a placeholder sigScript and witness of the size a key
+// path signature of pkScript takes, to estimate fees before signing.
+func dummySignature(pkScript []byte) ([]byte, wire.TxWitness) {
+	switch {
+	case txscript.IsPayToTaproot(pkScript):
+		return nil, wire.TxWitness{make([]byte, 64)}
+	case txscript.IsPayToPubKeyHash(pkScript):
+		return make([]byte, 1+72+1+33), nil
+	case txscript.IsPayToScriptHash(pkScript):
+		return make([]byte, 1+22), wire.TxWitness{make([]byte, 72), make([]byte, 33)}
+	default:
+		return nil, wire.TxWitness{make([]byte, 72), make([]byte, 33)}
+	}
+}
+
 func ParsePubKey(pubKeyStr string) (*btcec.PublicKey, error) {
 	serializedPubKey, err := hex.DecodeString(pubKeyStr)
 	pk, err := btcec.ParsePubKey(serializedPubKey)
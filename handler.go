@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
 	"net/http"
@@ -30,21 +33,30 @@ func errorRes(ctx echo.Context, msg string) error {
 	log.Error(msg)
 	return ctx.JSON(http.StatusInternalServerError, &ResultData{Code: http.StatusInternalServerError, Msg: msg})
 }
+func badRequestRes(ctx echo.Context, msg string) error {
+	log.Error(msg)
+	return ctx.JSON(http.StatusBadRequest, &ResultData{Code: http.StatusBadRequest, Msg: msg})
+}
 func errorResByCode(ctx echo.Context, msg string, code int) error {
 	log.Error(msg)
 	return ctx.JSON(http.StatusOK, &ResultData{Code: code, Msg: msg})
 }
 
 func buildBrc20CommitTx(ctx echo.Context) error {
-	network := ctx.Param("network")
-	netParams := getNetwork(network)
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	params := &BuildBrc20CommitTxRequest{}
-	err := ctx.Bind(params)
+	err = ctx.Bind(params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
 	d, _ := json.Marshal(params)
 	log.Infof("buildBrc20CommitTx request:%s", string(d))
+	if err = bitcoin.ValidateBrc20InscriptionDataList(params.InscriptionDataList); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	var commitTxPrivateKeyListWif = make([]string, len(params.CommitTxPrevOutputList))
 	for i, _ := range params.CommitTxPrevOutputList {
 		commitTxPrivateKeyListWif[i] = "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
@@ -53,7 +65,14 @@ func buildBrc20CommitTx(ctx echo.Context) error {
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
-	parseResult, unsignedCommitTxHex, commitTxFee, err := bitcoin.BuildBrc20CommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.RevealOutValue, params.MinChangeValue, params.CommitFeeRate, params.RevealFeeRate, params.ChangeAddress, serializedPubKey, commitTxPrivateKeyListWif)
+	var parseResult *bitcoin.Brc20InscriptionParseResult
+	var unsignedCommitTxHex string
+	var commitTxFee int64
+	if bitcoin.NeedsBatchTxCtxData(params.InscriptionDataList, params.BatchMode, params.Parent, params.RevealOptions) {
+		parseResult, unsignedCommitTxHex, commitTxFee, err = bitcoin.BuildBrc20BatchCommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.BatchMode, params.Parent, params.RevealOptions, params.RevealOutValue, params.MinChangeValue, params.CommitFeeRate, params.RevealFeeRate, params.ChangeAddress, serializedPubKey, commitTxPrivateKeyListWif)
+	} else {
+		parseResult, unsignedCommitTxHex, commitTxFee, err = bitcoin.BuildBrc20CommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.RevealOutValue, params.MinChangeValue, params.CommitFeeRate, params.RevealFeeRate, params.ChangeAddress, serializedPubKey, commitTxPrivateKeyListWif)
+	}
 
 	if err != nil {
 		return errorRes(ctx, err.Error())
@@ -76,19 +95,27 @@ func buildBrc20CommitTx(ctx echo.Context) error {
 		return errorRes(ctx, err.Error())
 	}
 
-	return successRes(ctx, &BuildBrc20CommitTxResponse{
+	result := &BuildBrc20CommitTxResponse{
 		ParseResult:    parseResult,
 		TxHex:          unsignedCommitTxHex,
 		MessageHashMap: messageHashMap,
 		CommitTxFee:    commitTxFee,
-	})
+	}
+	if params.WithPsbt {
+		if result.Psbt, err = bitcoin.Brc20CommitPSBT(netParams, unsignedCommitTxHex, params.CommitTxPrevOutputList, params.Bip32Derivation); err != nil {
+			return errorRes(ctx, err.Error())
+		}
+	}
+	return successRes(ctx, result)
 }
 
 func buildCommitTxRawData(ctx echo.Context) error {
-	network := ctx.Param("network")
-	netParams := getNetwork(network)
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	params := &BuildCommitTxRawDataRequest{}
-	err := ctx.Bind(params)
+	err = ctx.Bind(params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
@@ -104,79 +131,157 @@ func buildCommitTxRawData(ctx echo.Context) error {
 }
 
 func buildBrc20RevealTx(ctx echo.Context) error {
-	network := ctx.Param("network")
-	netParams := getNetwork(network)
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	params := &BuildBrc20RevealTxRequest{}
-	err := ctx.Bind(params)
+	err = ctx.Bind(params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
 	d, _ := json.Marshal(params)
 	log.Infof("buildBrc20RevealTx request:%s", string(d))
 
-	var witnessList [][]byte
 	commitTxHash, err := chainhash.NewHashFromStr(params.CommitTxHash)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
-	revealTxsHex, witnessList, revealTxFees, err := bitcoin.BuildBrc20RevealTx(netParams, *commitTxHash, params.CtxDataList, params.RevealAddrs, params.RevealFeeRate, params.RevealOutValue)
+	result, err := buildRevealTxs(netParams, *commitTxHash, params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
-	fmt.Println(*commitTxHash, params.CtxDataList[0], params.RevealAddrs, params.RevealFeeRate, params.RevealOutValue)
-	return successRes(ctx, &BuildBrc20RevealTxResponse{
-		RevealTxsHex: revealTxsHex[0],
-		WitnessList:  witnessList[0],
-		RevealTxFees: revealTxFees[0],
-		MessageHash:  hex.EncodeToString(witnessList[0]), //review交易只有一个input，所以只对应一个messageHash
-	})
+	return successRes(ctx, result)
+}
+
+// buildRevealTxs builds the reveals of buildBrc20RevealTx, through the child
+// reveal builder when the reveals also spend a parent or a funding output.
+func buildRevealTxs(netParams *chaincfg.Params, commitTxHash chainhash.Hash, params *BuildBrc20RevealTxRequest) (*BuildBrc20RevealTxResponse, error) {
+	var result *BuildBrc20RevealTxResponse
+	if isChildReveal(params.CtxDataList) {
+		parentPubKey, err := hex.DecodeString(params.ParentPubKey)
+		if err != nil {
+			return nil, err
+		}
+		fundingPubKey, err := hex.DecodeString(params.FundingPubKey)
+		if err != nil {
+			return nil, err
+		}
+		revealTxsHex, messageHashMaps, revealTxFees, err := bitcoin.BuildBrc20ChildRevealTx(netParams, commitTxHash, params.CtxDataList, params.RevealFeeRate, parentPubKey, fundingPubKey)
+		if err != nil {
+			return nil, err
+		}
+		result = &BuildBrc20RevealTxResponse{
+			RevealTxsHex:     revealTxsHex[0],
+			RevealTxFees:     revealTxFees[0],
+			RevealTxsHexList: revealTxsHex,
+			RevealTxFeesList: revealTxFees,
+			MessageHashMap:   messageHashMaps[0],
+			MessageHashMaps:  messageHashMaps,
+		}
+	} else {
+		revealTxsHex, witnessList, revealTxFees, err := bitcoin.BuildBrc20RevealTx(netParams, commitTxHash, params.CtxDataList, params.RevealAddrs, params.RevealFeeRate, params.RevealOutValue)
+		if err != nil {
+			return nil, err
+		}
+		result = newBuildBrc20RevealTxResponse(revealTxsHex, witnessList, revealTxFees)
+	}
+	if params.WithPsbt {
+		psbtList, err := bitcoin.Brc20RevealPSBTs(netParams, result.RevealTxsHexList, params.CtxDataList, params.Bip32Derivation)
+		if err != nil {
+			return nil, err
+		}
+		result.PsbtList = psbtList
+	}
+	return result, nil
+}
+
+// isChildReveal tells whether the reveals spend a parent or a funding output
+// next to the commit output.
+func isChildReveal(ctxDataList []*bitcoin.Brc20CtxData) bool {
+	return len(ctxDataList) > 0 && (ctxDataList[0].Parent != nil || ctxDataList[0].Funding != nil)
+}
+
+func newBuildBrc20RevealTxResponse(revealTxsHex []string, witnessList [][]byte, revealTxFees []int64) *BuildBrc20RevealTxResponse {
+	messageHashList := make([]string, len(witnessList))
+	for i, witness := range witnessList {
+		messageHashList[i] = hex.EncodeToString(witness)
+	}
+	return &BuildBrc20RevealTxResponse{
+		RevealTxsHex:     revealTxsHex[0],
+		WitnessList:      witnessList[0],
+		RevealTxFees:     revealTxFees[0],
+		MessageHash:      messageHashList[0], //review交易只有一个input，所以只对应一个messageHash
+		RevealTxsHexList: revealTxsHex,
+		RevealTxFeesList: revealTxFees,
+		MessageHashList:  messageHashList,
+	}
 }
 
 func buildReviewTxRawData(ctx echo.Context) error {
-	network := ctx.Param("network")
-	netParams := getNetwork(network)
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	params := &BuildRevealTxRawDataRequest{}
-	err := ctx.Bind(params)
+	err = ctx.Bind(params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
 	d, _ := json.Marshal(params)
 	log.Infof("buildReviewTxRawData request:%s", string(d))
-	signedRevealTxsHex, err := bitcoin.SignBrc20RevealTx2(netParams, params.RevealTxsHex, params.Signature, params.CtxDataList)
-	fmt.Println("SignBrc20RevealTx2 params", params.RevealTxsHex, params.Signature, params.CtxDataList[0])
+	result, err := signRevealTxs(netParams, params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
+	return successRes(ctx, result)
+}
 
-	return successRes(ctx, &BuildRevealTxRawDataResponse{
-		RevealTxHex: signedRevealTxsHex[0],
-	})
+// signRevealTxs adds the external signatures of buildReviewTxRawData, per
+// input with signature maps for the reveals built by the child reveal builder.
+func signRevealTxs(netParams *chaincfg.Params, params *BuildRevealTxRawDataRequest) (*BuildRevealTxRawDataResponse, error) {
+	signatureMaps := params.SignatureMaps
+	if len(params.SignatureMap) > 0 {
+		if len(signatureMaps) > 0 {
+			return nil, errors.New("signatureMap and signatureMaps are exclusive")
+		}
+		signatureMaps = []map[int]string{params.SignatureMap}
+	}
+	var signedRevealTxsHex []string
+	var err error
+	if len(signatureMaps) > 0 {
+		if len(signatureMaps) != len(params.RevealTxsHex) {
+			return nil, fmt.Errorf("%d signature maps for %d reveals", len(signatureMaps), len(params.RevealTxsHex))
+		}
+		signedRevealTxsHex, err = bitcoin.SignBrc20ChildRevealTx(netParams, params.RevealTxsHex, signatureMaps, params.CtxDataList, params.ParentPubKey, params.FundingPubKey)
+	} else {
+		signedRevealTxsHex, err = bitcoin.SignBrc20RevealTx2(netParams, params.RevealTxsHex, params.Signature, params.CtxDataList)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BuildRevealTxRawDataResponse{
+		RevealTxHex:     signedRevealTxsHex[0],
+		RevealTxHexList: signedRevealTxsHex,
+	}, nil
 }
 
 func buildNormalTx(ctx echo.Context) error {
-	network := ctx.Param("network")
-	netParams := getNetwork(network)
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	params := &BuildUnsignedTxRequest{}
-	err := ctx.Bind(params)
+	err = ctx.Bind(params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
 	d, _ := json.Marshal(params)
 	log.Infof("buildNormalTx request:%s", string(d))
 	params.Version = 2
-	txBuild := bitcoin.NewTxBuild(params.Version, netParams)
-	for i := 0; i < len(params.Inputs); i++ {
-		txBuild.AddInput2(params.Inputs[i].TxId, params.Inputs[i].VOut, "", params.Inputs[i].Address, params.Inputs[i].Amount)
-	}
-
-	for i := 0; i < len(params.Outputs); i++ {
-		txBuild.AddOutput(params.Outputs[i].Address, params.Outputs[i].Amount)
-	}
-
-	tx, _, err := txBuild.Build(false)
-
+	tx, err := buildUnsignedTx(netParams, params)
 	if err != nil {
-		return errorRes(ctx, err.Error())
+		return badRequestRes(ctx, err.Error())
 	}
 	txHex, err := bitcoin.GetTxHex(tx)
 	if err != nil {
@@ -199,11 +304,41 @@ func buildNormalTx(ctx echo.Context) error {
 	})
 }
 
+// buildUnsignedTx builds the tx of buildNormalTx and of the json-rpc
+// buildUnsignedTx from the inputs, the outputs and the runestone of params,
+// it fails when the tx would burn or merge inscriptions.
+func buildUnsignedTx(netParams *chaincfg.Params, params *BuildUnsignedTxRequest) (*wire.MsgTx, error) {
+	txBuild := bitcoin.NewTxBuild(params.Version, netParams)
+	for i := 0; i < len(params.Inputs); i++ {
+		txBuild.AddInput2(params.Inputs[i].TxId, params.Inputs[i].VOut, "", params.Inputs[i].Address, params.Inputs[i].Amount)
+	}
+
+	for i := 0; i < len(params.Outputs); i++ {
+		txBuild.AddOutput(params.Outputs[i].Address, params.Outputs[i].Amount)
+	}
+	if params.Runestone != nil {
+		if err := txBuild.AddRunestone(params.Runestone); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, _, err := txBuild.Build(false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = bitcoin.CheckSatFlow(tx, params.Inputs, params.AllowInscriptionMerge); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
 func buildNormalTx2(ctx echo.Context) error {
-	network := ctx.Param("network")
-	netParams := getNetwork(network)
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	params := &BuildUnsignedTxRequest{}
-	err := ctx.Bind(params)
+	err = ctx.Bind(params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
@@ -221,6 +356,11 @@ func buildNormalTx2(ctx echo.Context) error {
 		outputAmount += params.Outputs[i].Amount
 		txBuild.AddOutput(params.Outputs[i].Address, params.Outputs[i].Amount)
 	}
+	if params.Runestone != nil {
+		if err = txBuild.AddRunestone(params.Runestone); err != nil {
+			return badRequestRes(ctx, err.Error())
+		}
+	}
 	//先假设有找零，构造找零output
 	txBuild.AddOutput(params.Inputs[0].Address, 0)
 	tx, _, err := txBuild.Build(false)
@@ -236,7 +376,7 @@ func buildNormalTx2(ctx echo.Context) error {
 		return errorRes(ctx, err.Error())
 	}
 	var changeAmount int64
-	minChangeValue := int64(546)
+	minChangeValue := bitcoin.GetDustLimit(netParams)
 	if tx, changeAmount, err = CompleteTx(tx, btcutil.Amount(inputAmount), outputAmount, params.FeeRate, minChangeValue); err != nil {
 		maxVoutAmount := outputAmount + changeAmount - 6*params.FeeRate //out amount 不同，交易大小不通，这里给了一点误差
 		if maxVoutAmount < 0 {
@@ -246,7 +386,14 @@ func buildNormalTx2(ctx echo.Context) error {
 	}
 	if changeAmount >= minChangeValue {
 		outputAmount += changeAmount
-		params.Outputs = append(params.Outputs, RawOutput{params.Inputs[0].Address, changeAmount})
+	}
+	if params.Runestone != nil {
+		if err = params.Runestone.CheckOutputs(len(tx.TxOut)); err != nil {
+			return badRequestRes(ctx, err.Error())
+		}
+	}
+	if _, err = bitcoin.CheckSatFlow(tx, params.Inputs, params.AllowInscriptionMerge); err != nil {
+		return badRequestRes(ctx, err.Error())
 	}
 	fee := inputAmount - outputAmount
 	txHex, err := bitcoin.GetTxHex(tx)
@@ -263,11 +410,26 @@ func buildNormalTx2(ctx echo.Context) error {
 		Fee:         fee,
 		UnsignedTx:  txHex,
 		MessageHash: messageHashMap,
-		Outputs:     params.Outputs,
+		Outputs:     txRawOutputs(tx, netParams),
 		Inputs:      params.Inputs,
 	})
 }
 
+// txRawOutputs are the outputs of tx, change and runestone included.
+func txRawOutputs(tx *wire.MsgTx, netParams *chaincfg.Params) []RawOutput {
+	outputs := make([]RawOutput, len(tx.TxOut))
+	for i, out := range tx.TxOut {
+		outputs[i].Amount = out.Value
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.PkScript, netParams)
+		if err == nil && len(addrs) == 1 {
+			outputs[i].Address = addrs[0].EncodeAddress()
+		} else {
+			outputs[i].Script = hex.EncodeToString(out.PkScript)
+		}
+	}
+	return outputs
+}
+
 func CompleteTx(tx *wire.MsgTx, totalSenderAmount btcutil.Amount, outputAmount, commitFeeRate int64, minChangeValue int64) (*wire.MsgTx, int64, error) {
 	size := btcutil.Amount(bitcoin.GetTxVirtualSize(btcutil.NewTx(tx)))
 	log.Infof("tx size: %d", size)
@@ -306,10 +468,12 @@ func getPriKeys(inputs []*bitcoin.PrevOutput) []*btcec.PrivateKey {
 	return commitTxPrivateKeyList
 }
 func pubKey2Addr(ctx echo.Context) error {
-	network := ctx.Param("network")
-	netParams := getNetwork(network)
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
 	params := &PubKey2AddrRequest{}
-	err := ctx.Bind(params)
+	err = ctx.Bind(params)
 	if err != nil {
 		return errorRes(ctx, err.Error())
 	}
@@ -326,6 +490,699 @@ func pubKey2Addr(ctx echo.Context) error {
 	})
 }
 
+func decodeInscriptions(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &DecodeInscriptionsRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("decodeInscriptions request:%s", string(d))
+	inscriptions, err := bitcoin.DecodeInscriptions(params.TxHex, params.InputValues, params.InscriptionOffsets)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &DecodeInscriptionsResponse{
+		Inscriptions: inscriptions,
+	})
+}
+
+func decodeRunestone(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &DecodeRunestoneRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("decodeRunestone request:%s", string(d))
+	tx, err := bitcoin.NewTxFromHex(params.TxHex)
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	return successRes(ctx, &DecodeRunestoneResponse{
+		Artifact:   runes.Decipher(tx),
+		Allocation: runes.Allocate(tx, params.InputBalances, params.MintAmount),
+	})
+}
+
+func splitInscriptions(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.SplitInscriptionsRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("splitInscriptions request:%s", string(d))
+	splitTx, err := bitcoin.BuildSplitInscriptionsTx(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, splitTx)
+}
+
+func splitInscriptionsRawData(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &SplitInscriptionsRawDataRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("splitInscriptionsRawData request:%s", string(d))
+	if params.Request == nil {
+		return badRequestRes(ctx, "missing request")
+	}
+	txHex, err := bitcoin.BuildSplitInscriptionsRawData(netParams, params.TxHex, params.Request, params.SignatureMap)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &SendInscriptionRawDataResponse{
+		TxHex: txHex,
+	})
+}
+
+func sendInscription(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.SendInscriptionRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("sendInscription request:%s", string(d))
+	sendTx, err := bitcoin.BuildSendInscriptionTx(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, sendTx)
+}
+
+func sendInscriptionRawData(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &SendInscriptionRawDataRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("sendInscriptionRawData request:%s", string(d))
+	if params.Request == nil {
+		return badRequestRes(ctx, "missing request")
+	}
+	txHex, err := bitcoin.BuildSendInscriptionRawData(netParams, params.TxHex, params.Request, params.SignatureMap)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &SendInscriptionRawDataResponse{
+		TxHex: txHex,
+	})
+}
+
+func recoverCommit(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &RecoverCommitRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("recoverCommit request:%s", string(d))
+	recoverTx, err := bitcoin.BuildRecoverCommitTx(netParams, params.Outputs, params.RecoverAddress, params.FeeRate, params.ScriptPath)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, recoverTx)
+}
+
+func recoverCommitRawData(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &RecoverCommitRawDataRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("recoverCommitRawData request:%s", string(d))
+	txHex, err := bitcoin.BuildRecoverCommitRawData(netParams, params.TxHex, params.Outputs, params.SignatureMap, params.ScriptPath)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &RecoverCommitRawDataResponse{
+		TxHex: txHex,
+	})
+}
+
+func listInscriptions(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.ListingRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("listInscriptions request:%s", string(d))
+	listingPsbts, err := bitcoin.GenerateListingPSBTs(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, listingPsbts)
+}
+
+func listInscriptionsRawData(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &ListInscriptionsRawDataRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("listInscriptionsRawData request:%s", string(d))
+	listingPsbts, err := bitcoin.SignListingPSBTsBySignature(params.Psbts, params.SignatureMap, params.PubKeys)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, listingPsbts)
+}
+
+func checkListingPsbt(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &CheckListingPsbtRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("checkListingPsbt request:%s", string(d))
+	if params.Listing == nil {
+		return badRequestRes(ctx, "missing listing")
+	}
+	err = bitcoin.CheckListingPSBT(netParams, params.Psbt, params.Listing)
+	var failure *bitcoin.ListingCheckError
+	if errors.As(err, &failure) {
+		return successRes(ctx, &CheckListingPsbtResponse{Failure: failure})
+	}
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &CheckListingPsbtResponse{Valid: true})
+}
+
+func prepareDummyUtxos(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.PrepareDummyUtxosRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("prepareDummyUtxos request:%s", string(d))
+	dummyTx, err := bitcoin.BuildPrepareDummyUtxosTx(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, dummyTx)
+}
+
+func prepareDummyUtxosRawData(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &PrepareDummyUtxosRawDataRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("prepareDummyUtxosRawData request:%s", string(d))
+	if params.Request == nil {
+		return badRequestRes(ctx, "missing request")
+	}
+	txHex, err := bitcoin.BuildPrepareDummyUtxosRawData(netParams, params.TxHex, params.Request, params.SignatureMap)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &SendInscriptionRawDataResponse{
+		TxHex: txHex,
+	})
+}
+
+func buildBuyingPsbt(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &BuildBuyingPsbtRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("buildBuyingPsbt request:%s", string(d))
+	buyingPsbt, err := bitcoin.BuildBuyingPSBT(params.Inputs, params.Outputs, params.SellerPsbt, sellerIndex(params.SellerIndex), netParams)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	fee, err := bitcoin.CalcFee(params.Inputs, params.Outputs, params.SellerPsbt, sellerIndex(params.SellerIndex), params.FeeRate, netParams)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &BuildBuyingPsbtResponse{
+		BuyingPSBT: buyingPsbt,
+		Fee:        fee,
+	})
+}
+
+func buildBuyingPsbtRawData(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &BuildBuyingPsbtRawDataRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("buildBuyingPsbtRawData request:%s", string(d))
+	txHex, err := bitcoin.SignBuyingPSBTBySignature(params.Psbt, params.SignatureMap, params.PubKeys, sellerIndex(params.SellerIndex))
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &SendInscriptionRawDataResponse{
+		TxHex: txHex,
+	})
+}
+
+// sellerIndex is the seller index of a purchase request, the one of
+// bitcoin.ListingLayoutTwoDummies when not given.
+func sellerIndex(index *int) int {
+	if index == nil {
+		return bitcoin.SellerSignatureIndex
+	}
+	return *index
+}
+
+func createBid(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.BidRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("createBid request:%s", string(d))
+	bidPsbt, err := bitcoin.GenerateBidPSBT(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, bidPsbt)
+}
+
+func createBidRawData(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &CreateBidRawDataRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("createBidRawData request:%s", string(d))
+	bidPsbt, err := bitcoin.SignBidPSBTBySignature(params.Psbt, params.SignatureMap, params.PubKeys)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &bitcoin.BidPSBT{Psbt: bidPsbt})
+}
+
+func checkBidPsbt(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &CheckBidPsbtRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("checkBidPsbt request:%s", string(d))
+	if params.Bid == nil {
+		return badRequestRes(ctx, "missing bid")
+	}
+	err = bitcoin.CheckBidPSBT(netParams, params.Psbt, params.Bid)
+	var failure *bitcoin.ListingCheckError
+	if errors.As(err, &failure) {
+		return successRes(ctx, &CheckListingPsbtResponse{Failure: failure})
+	}
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &CheckListingPsbtResponse{Valid: true})
+}
+
+func acceptBid(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.AcceptBidRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("acceptBid request:%s", string(d))
+	accepted, err := bitcoin.AcceptBid(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, accepted)
+}
+
+func acceptBidRawData(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &AcceptBidRawDataRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("acceptBidRawData request:%s", string(d))
+	txHex, err := bitcoin.AcceptBidBySignature(params.Psbt, params.Signature, params.PubKey)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &SendInscriptionRawDataResponse{
+		TxHex: txHex,
+	})
+}
+
+func signMessage(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.SignMessageRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("signMessage request:%s", string(d))
+	signed, err := bitcoin.SignMessage(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, signed)
+}
+
+func verifyMessage(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &VerifyMessageRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("verifyMessage request:%s", string(d))
+	if _, err = bitcoin.AddrToPkScript(params.Address, netParams); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	if err = bitcoin.VerifyMessage(netParams, params.Address, params.Message, params.Signature); err != nil {
+		return successRes(ctx, &VerifyMessageResponse{Reason: err.Error()})
+	}
+	return successRes(ctx, &VerifyMessageResponse{Valid: true})
+}
+
+func recoverMessagePubKey(ctx echo.Context) error {
+	params := &RecoverMessagePubKeyRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("recoverMessagePubKey request:%s", string(d))
+	pubKey, err := bitcoin.RecoverMessagePubKey(params.Message, params.Signature)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &RecoverMessagePubKeyResponse{PublicKey: pubKey})
+}
+
+func createPsbt(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.CreatePSBTRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("createPsbt request:%s", string(d))
+	result, err := bitcoin.CreatePSBT(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func updatePsbt(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.UpdatePSBTRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("updatePsbt request:%s", string(d))
+	result, err := bitcoin.UpdatePSBT(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func signPsbt(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.SignPSBTRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("signPsbt request:%s", string(d))
+	result, err := bitcoin.SignPSBT(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func combinePsbts(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &CombinePsbtsRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("combinePsbts request:%s", string(d))
+	result, err := bitcoin.CombinePSBTs(params.Psbts)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func finalizePsbt(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &PsbtRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("finalizePsbt request:%s", string(d))
+	result, err := bitcoin.FinalizePSBT(params.Psbt)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func extractPsbt(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &PsbtRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("extractPsbt request:%s", string(d))
+	result, err := bitcoin.ExtractPSBT(params.Psbt)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &SendInscriptionRawDataResponse{
+		TxHex: result,
+	})
+}
+
+func constructPsbt(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.ConstructPSBTRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("constructPsbt request:%s", string(d))
+	result, err := bitcoin.ConstructPSBT(netParams, params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func convertPsbt(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.ConvertPSBTRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("convertPsbt request:%s", string(d))
+	result, err := bitcoin.ConvertPSBT(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func analyzePsbt(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &bitcoin.AnalyzePSBTRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("analyzePsbt request:%s", string(d))
+	result, err := bitcoin.AnalyzePSBT(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, result)
+}
+
+func importBrc20CommitPsbt(ctx echo.Context) error {
+	netParams, err := getNetwork(ctx.Param("network"))
+	if err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &PsbtRequest{}
+	err = ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("importBrc20CommitPsbt request:%s", string(d))
+	txHex, err := bitcoin.ImportBrc20CommitPSBT(netParams, params.Psbt)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &SignBrc20CommitTxResponse{
+		TxHex: txHex,
+	})
+}
+
+func importBrc20RevealPsbts(ctx echo.Context) error {
+	if _, err := getNetwork(ctx.Param("network")); err != nil {
+		return badRequestRes(ctx, err.Error())
+	}
+	params := &ImportBrc20RevealPsbtsRequest{}
+	err := ctx.Bind(params)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	d, _ := json.Marshal(params)
+	log.Infof("importBrc20RevealPsbts request:%s", string(d))
+	revealTxsHex, err := bitcoin.ImportBrc20RevealPSBTs(params.PsbtList)
+	if err != nil {
+		return errorRes(ctx, err.Error())
+	}
+	return successRes(ctx, &ImportBrc20RevealPsbtsResponse{
+		RevealTxsHexList: revealTxsHex,
+	})
+}
+
 func health(ctx echo.Context) error {
 	return successRes(ctx, "ok")
 }
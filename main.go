@@ -3,16 +3,20 @@ package main
 import (
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"io"
+	"math/big"
 	"net/http"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
 )
 
 type RequestData struct {
@@ -43,6 +47,11 @@ type BuildUnsignedTxRequest struct {
 	PubKey  string                `json:"pubKey"`
 	//ExtraInputs []*bitcoin.PrevOutput `json:"extraInputs"`
 	FeeRate int64 `json:"feeRate"`
+	// AllowInscriptionMerge lets one output take the inscriptions of
+	// several inputs, they are never burnt.
+	AllowInscriptionMerge bool `json:"allowInscriptionMerge"`
+	// Runestone is added as an OP_RETURN output right after Outputs.
+	Runestone *runes.Runestone `json:"runestone"`
 }
 
 type RawInput struct {
@@ -56,6 +65,9 @@ type RawInput struct {
 type RawOutput struct {
 	Address string `json:"address"`
 	Amount  int64  `json:"amount"`
+	// Script is the hex script of a returned output without address, such
+	// as the runestone
+	Script string `json:"script,omitempty"`
 }
 
 type BuildUnsignedTxResponse struct {
@@ -67,13 +79,16 @@ type BuildUnsignedTxResponse struct {
 }
 
 type PrepareBrc20CommitTxRequest struct {
-	CommitTxPrevOutputList []*bitcoin.PrevOutput     `json:"commitTxPrevOutputList"`
-	RevealFeeRate          int64                     `json:"revealFeeRate"`
-	InscriptionDataList    []bitcoin.InscriptionData `json:"inscriptionDataList"`
-	RevealOutValue         int64                     `json:"revealOutValue"`
-	ChangeAddress          string                    `json:"changeAddress"`
-	MinChangeValue         int64                     `json:"minChangeValue"`
-	PubKey                 []byte                    `json:"pubKey"`
+	CommitTxPrevOutputList []*bitcoin.PrevOutput      `json:"commitTxPrevOutputList"`
+	RevealFeeRate          int64                      `json:"revealFeeRate"`
+	InscriptionDataList    []bitcoin.InscriptionData  `json:"inscriptionDataList"`
+	RevealOutValue         int64                      `json:"revealOutValue"`
+	ChangeAddress          string                     `json:"changeAddress"`
+	MinChangeValue         int64                      `json:"minChangeValue"`
+	PubKey                 []byte                     `json:"pubKey"`
+	BatchMode              string                     `json:"batchMode"`
+	Parent                 *bitcoin.ParentInscription `json:"parent"`
+	RevealOptions          *bitcoin.RevealOptions     `json:"revealOptions"`
 }
 
 type PrepareBrc20CommitTxResponse struct {
@@ -99,22 +114,37 @@ type AdjustBrc20CommitTxRequest struct {
 	TotalRevealPrevOutputValue int64                 `json:"totalRevealPrevOutputValue"`
 	CommitFeeRate              int64                 `json:"commitFeeRate"`
 	MinChangeValue             int64                 `json:"minChangeValue"`
+	// WithPsbt also returns the adjusted tx as a psbt, with the utxos and
+	// the Bip32Derivation of the keys owning its inputs and outputs, for
+	// signers only taking psbts. The prepared tx has none, its change is
+	// only set here.
+	WithPsbt        bool                           `json:"withPsbt"`
+	Bip32Derivation []*bitcoin.PSBTBip32Derivation `json:"bip32Derivation"`
 }
 
 type AdjustBrc20CommitTxResponse struct {
-	TxHex       string `json:"txHex"`
-	CommitTxFee int64  `json:"commitTxFee"`
+	TxHex       string               `json:"txHex"`
+	CommitTxFee int64                `json:"commitTxFee"`
+	Psbt        *bitcoin.EncodedPSBT `json:"psbt,omitempty"`
 }
 
 type BuildBrc20CommitTxRequest struct {
-	CommitTxPrevOutputList []*bitcoin.PrevOutput     `json:"commitTxPrevOutputList"`
-	CommitFeeRate          int64                     `json:"commitFeeRate"`
-	RevealFeeRate          int64                     `json:"revealFeeRate"`
-	InscriptionDataList    []bitcoin.InscriptionData `json:"inscriptionDataList"`
-	RevealOutValue         int64                     `json:"revealOutValue"`
-	ChangeAddress          string                    `json:"changeAddress"`
-	MinChangeValue         int64                     `json:"minChangeValue"`
-	PubKey                 string                    `json:"pubKey"`
+	CommitTxPrevOutputList []*bitcoin.PrevOutput      `json:"commitTxPrevOutputList"`
+	CommitFeeRate          int64                      `json:"commitFeeRate"`
+	RevealFeeRate          int64                      `json:"revealFeeRate"`
+	InscriptionDataList    []bitcoin.InscriptionData  `json:"inscriptionDataList"`
+	RevealOutValue         int64                      `json:"revealOutValue"`
+	ChangeAddress          string                     `json:"changeAddress"`
+	MinChangeValue         int64                      `json:"minChangeValue"`
+	PubKey                 string                     `json:"pubKey"`
+	BatchMode              string                     `json:"batchMode"`
+	Parent                 *bitcoin.ParentInscription `json:"parent"`
+	RevealOptions          *bitcoin.RevealOptions     `json:"revealOptions"`
+	// WithPsbt also returns the tx as a psbt, with the utxos and the
+	// Bip32Derivation of the keys owning its inputs and outputs, for
+	// signers only taking psbts.
+	WithPsbt        bool                           `json:"withPsbt"`
+	Bip32Derivation []*bitcoin.PSBTBip32Derivation `json:"bip32Derivation"`
 }
 
 type BuildBrc20CommitTxResponse struct {
@@ -122,6 +152,7 @@ type BuildBrc20CommitTxResponse struct {
 	TxHex          string                               `json:"txHex"`
 	CommitTxFee    int64                                `json:"commitTxFee"`
 	MessageHashMap map[int]string                       `json:"messageHashMap"`
+	Psbt           *bitcoin.EncodedPSBT                 `json:"psbt,omitempty"`
 }
 
 type BuildBrc20RevealTxRequest struct {
@@ -130,6 +161,14 @@ type BuildBrc20RevealTxRequest struct {
 	RevealAddrs    []string                `json:"revealAddrs"`
 	RevealFeeRate  int64                   `json:"revealFeeRate"`
 	RevealOutValue int64                   `json:"revealOutValue"`
+	// ParentPubKey and FundingPubKey are needed for outputs held by segwit
+	// v0 addresses
+	ParentPubKey  string `json:"parentPubKey"`
+	FundingPubKey string `json:"fundingPubKey"`
+	// WithPsbt also returns the reveals as psbts carrying the inscription
+	// leaf and control block of the commit input.
+	WithPsbt        bool                           `json:"withPsbt"`
+	Bip32Derivation []*bitcoin.PSBTBip32Derivation `json:"bip32Derivation"`
 }
 
 type BuildBrc20RevealTxResponse struct {
@@ -137,16 +176,34 @@ type BuildBrc20RevealTxResponse struct {
 	WitnessList  []byte `json:"witnessList"`
 	RevealTxFees int64  `json:"revealTxFees"`
 	MessageHash  string `json:"messageHash"`
+	// a batch split over several reveals returns one entry per reveal
+	RevealTxsHexList []string `json:"revealTxsHexList"`
+	RevealTxFeesList []int64  `json:"revealTxFeesList"`
+	MessageHashList  []string `json:"messageHashList"`
+	// MessageHashMap is set for reveals spending a parent or a funding output,
+	// MessageHashMaps holds it for every reveal
+	MessageHashMap  map[int]string   `json:"messageHashMap,omitempty"`
+	MessageHashMaps []map[int]string `json:"messageHashMaps,omitempty"`
+	// PsbtList is set with WithPsbt, one psbt per reveal
+	PsbtList []*bitcoin.EncodedPSBT `json:"psbtList,omitempty"`
 }
 
 type BuildRevealTxRawDataRequest struct {
 	RevealTxsHex []string                `json:"revealTxsHex"`
 	CtxDataList  []*bitcoin.Brc20CtxData `json:"ctxDataList"`
 	Signature    string                  `json:"signature"`
+	// SignatureMap signs a single reveal returned with a MessageHashMap, keyed
+	// by input index. SignatureMaps signs every reveal of MessageHashMaps.
+	SignatureMap  map[int]string   `json:"signatureMap"`
+	SignatureMaps []map[int]string `json:"signatureMaps"`
+	ParentPubKey  string           `json:"parentPubKey"`
+	FundingPubKey string           `json:"fundingPubKey"`
 }
 
 type BuildRevealTxRawDataResponse struct {
 	RevealTxHex string `json:"revealTxsHex"`
+	// a batch split over several reveals returns every signed reveal
+	RevealTxHexList []string `json:"revealTxsHexList"`
 }
 
 type BuildCommitTxRawDataRequest struct {
@@ -167,23 +224,178 @@ type CheckBrc20RevealTxRequest struct {
 type CheckBrc20RevealTxResponse struct {
 }
 
-func getNetwork(network string) *chaincfg.Params {
-	var netParams *chaincfg.Params
-	if network == "mainnet" {
-		netParams = &chaincfg.MainNetParams
-	} else if network == "regtest" {
-		netParams = &chaincfg.RegressionNetParams
-	} else if network == "testnet3" {
-		netParams = &chaincfg.TestNet3Params
-	} else if network == "simnet" {
-		netParams = &chaincfg.SimNetParams
-	} else {
-		netParams = nil
-	}
-	return netParams
+type DecodeInscriptionsRequest struct {
+	TxHex       string  `json:"txHex"`
+	InputValues []int64 `json:"inputValues"`
+	// InscriptionOffsets are the inscribed sat offsets of each input, to
+	// tell reinscriptions
+	InscriptionOffsets [][]int64 `json:"inscriptionOffsets"`
+}
+
+type DecodeInscriptionsResponse struct {
+	Inscriptions []*bitcoin.ParsedInscription `json:"inscriptions"`
+}
+
+type DecodeRunestoneRequest struct {
+	TxHex string `json:"txHex"`
+	// InputBalances are the runes spent by the tx, MintAmount the amount
+	// the terms of the minted rune give when its mint is open.
+	InputBalances runes.Balances `json:"inputBalances"`
+	MintAmount    *big.Int       `json:"mintAmount"`
+}
+
+type DecodeRunestoneResponse struct {
+	Artifact   *runes.Artifact   `json:"artifact"`
+	Allocation *runes.Allocation `json:"allocation"`
+}
+
+type SplitInscriptionsRawDataRequest struct {
+	TxHex        string                            `json:"txHex"`
+	Request      *bitcoin.SplitInscriptionsRequest `json:"request"`
+	SignatureMap map[int]string                    `json:"signatureMap"`
+}
+
+type SendInscriptionRawDataRequest struct {
+	TxHex        string                          `json:"txHex"`
+	Request      *bitcoin.SendInscriptionRequest `json:"request"`
+	SignatureMap map[int]string                  `json:"signatureMap"`
+}
+
+type SendInscriptionRawDataResponse struct {
+	TxHex string `json:"txHex"`
+}
+
+type RecoverCommitRequest struct {
+	Outputs        []*bitcoin.RecoverCommitOutput `json:"outputs"`
+	RecoverAddress string                         `json:"recoverAddress"`
+	FeeRate        int64                          `json:"feeRate"`
+	ScriptPath     bool                           `json:"scriptPath"`
+}
+
+type RecoverCommitRawDataRequest struct {
+	TxHex        string                         `json:"txHex"`
+	Outputs      []*bitcoin.RecoverCommitOutput `json:"outputs"`
+	SignatureMap map[int]string                 `json:"signatureMap"`
+	ScriptPath   bool                           `json:"scriptPath"`
+}
+
+type RecoverCommitRawDataResponse struct {
+	TxHex string `json:"txHex"`
+}
+
+type CheckListingPsbtRequest struct {
+	Psbt    string                      `json:"psbt"`
+	Listing *bitcoin.ListingExpectation `json:"listing"`
+}
+
+type CheckListingPsbtResponse struct {
+	Valid   bool                       `json:"valid"`
+	Failure *bitcoin.ListingCheckError `json:"failure,omitempty"`
+}
+
+type ListInscriptionsRawDataRequest struct {
+	Psbts        []*bitcoin.ListingPSBT `json:"psbts"`
+	SignatureMap map[int]string         `json:"signatureMap"`
+	PubKeys      map[int]string         `json:"pubKeys"`
+}
+
+type PrepareDummyUtxosRawDataRequest struct {
+	TxHex        string                            `json:"txHex"`
+	Request      *bitcoin.PrepareDummyUtxosRequest `json:"request"`
+	SignatureMap map[int]string                    `json:"signatureMap"`
+}
+
+type BuildBuyingPsbtRequest struct {
+	Inputs     []*bitcoin.TxInput  `json:"inputs"`
+	Outputs    []*bitcoin.TxOutput `json:"outputs"`
+	SellerPsbt string              `json:"sellerPsbt"`
+	FeeRate    int64               `json:"feeRate"`
+	// SellerIndex is the sellerIndex of the listing, 2 when nil.
+	SellerIndex *int `json:"sellerIndex"`
+}
+
+type BuildBuyingPsbtResponse struct {
+	*bitcoin.BuyingPSBT
+	Fee int64 `json:"fee"`
+}
+
+type BuildBuyingPsbtRawDataRequest struct {
+	Psbt         string         `json:"psbt"`
+	SignatureMap map[int]string `json:"signatureMap"`
+	PubKeys      map[int]string `json:"pubKeys"`
+	// SellerIndex is the sellerIndex returned by buildBuyingPsbt.
+	SellerIndex *int `json:"sellerIndex"`
+}
+
+type CreateBidRawDataRequest struct {
+	Psbt         string         `json:"psbt"`
+	SignatureMap map[int]string `json:"signatureMap"`
+	PubKeys      map[int]string `json:"pubKeys"`
+}
+
+type CheckBidPsbtRequest struct {
+	Psbt string                  `json:"psbt"`
+	Bid  *bitcoin.BidExpectation `json:"bid"`
+}
+
+type AcceptBidRawDataRequest struct {
+	Psbt      string `json:"psbt"`
+	Signature string `json:"signature"`
+	PubKey    string `json:"pubKey"`
+}
+
+type VerifyMessageRequest struct {
+	Address   string `json:"address"`
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+type VerifyMessageResponse struct {
+	Valid bool `json:"valid"`
+	// Reason is why the signature is invalid.
+	Reason string `json:"reason,omitempty"`
+}
+
+type RecoverMessagePubKeyRequest struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+type RecoverMessagePubKeyResponse struct {
+	PublicKey string `json:"publicKey"`
+}
+
+type CombinePsbtsRequest struct {
+	Psbts []string `json:"psbts"`
+}
+
+type PsbtRequest struct {
+	Psbt string `json:"psbt"`
+}
+
+type ImportBrc20RevealPsbtsRequest struct {
+	PsbtList []string `json:"psbtList"`
+}
+
+type ImportBrc20RevealPsbtsResponse struct {
+	RevealTxsHexList []string `json:"revealTxsHexList"`
+}
+
+// getNetwork is the only place a network name from the request path is turned
+// into chain params, unknown names are rejected instead of falling back to mainnet.
+func getNetwork(network string) (*chaincfg.Params, error) {
+	return bitcoin.GetNetParams(network)
 }
 
 func main() {
+	networkConfig := flag.String("network-config", "", "path of a JSON file defining extra networks")
+	flag.Parse()
+	if *networkConfig != "" {
+		if err := bitcoin.LoadNetworkConfig(*networkConfig); err != nil {
+			log.Fatalf("invalid network config %s: %v", *networkConfig, err)
+		}
+	}
+
 	e := echo.New()
 	e.POST("/:network/buildBrc20CommitTx", buildBrc20CommitTx)
 	e.POST("/:network/buildCommitTxRawData", buildCommitTxRawData)
@@ -192,23 +404,47 @@ func main() {
 	e.POST("/:network/buildNormalTx", buildNormalTx)
 	e.POST("/:network/buildNormalTx2", buildNormalTx2)
 	e.POST("/:network/pubKey2Addr", pubKey2Addr)
+	e.POST("/:network/decodeInscriptions", decodeInscriptions)
+	e.POST("/:network/decodeRunestone", decodeRunestone)
+	e.POST("/:network/recoverCommit", recoverCommit)
+	e.POST("/:network/sendInscription", sendInscription)
+	e.POST("/:network/sendInscriptionRawData", sendInscriptionRawData)
+	e.POST("/:network/splitInscriptions", splitInscriptions)
+	e.POST("/:network/splitInscriptionsRawData", splitInscriptionsRawData)
+	e.POST("/:network/recoverCommitRawData", recoverCommitRawData)
+	e.POST("/:network/listInscriptions", listInscriptions)
+	e.POST("/:network/listInscriptionsRawData", listInscriptionsRawData)
+	e.POST("/:network/checkListingPsbt", checkListingPsbt)
+	e.POST("/:network/prepareDummyUtxos", prepareDummyUtxos)
+	e.POST("/:network/prepareDummyUtxosRawData", prepareDummyUtxosRawData)
+	e.POST("/:network/buildBuyingPsbt", buildBuyingPsbt)
+	e.POST("/:network/buildBuyingPsbtRawData", buildBuyingPsbtRawData)
+	e.POST("/:network/createBid", createBid)
+	e.POST("/:network/createBidRawData", createBidRawData)
+	e.POST("/:network/checkBidPsbt", checkBidPsbt)
+	e.POST("/:network/acceptBid", acceptBid)
+	e.POST("/:network/acceptBidRawData", acceptBidRawData)
+	e.POST("/:network/signMessage", signMessage)
+	e.POST("/:network/verifyMessage", verifyMessage)
+	e.POST("/recoverMessagePubKey", recoverMessagePubKey)
+	e.POST("/:network/createPsbt", createPsbt)
+	e.POST("/:network/updatePsbt", updatePsbt)
+	e.POST("/:network/signPsbt", signPsbt)
+	e.POST("/:network/combinePsbts", combinePsbts)
+	e.POST("/:network/finalizePsbt", finalizePsbt)
+	e.POST("/:network/extractPsbt", extractPsbt)
+	e.POST("/:network/constructPsbt", constructPsbt)
+	e.POST("/:network/convertPsbt", convertPsbt)
+	e.POST("/:network/analyzePsbt", analyzePsbt)
+	e.POST("/:network/importBrc20CommitPsbt", importBrc20CommitPsbt)
+	e.POST("/:network/importBrc20RevealPsbts", importBrc20RevealPsbts)
 	e.GET("/actuator/health", health)
 
 	e.POST("/:network", func(ctx echo.Context) error {
 
-		network := ctx.Param("network")
-		var netParams *chaincfg.Params
-
-		if network == "mainnet" {
-			netParams = &chaincfg.MainNetParams
-		} else if network == "regtest" {
-			netParams = &chaincfg.RegressionNetParams
-		} else if network == "testnet3" {
-			netParams = &chaincfg.TestNet3Params
-		} else if network == "simnet" {
-			netParams = &chaincfg.SimNetParams
-		} else {
-			return ctx.String(http.StatusNotFound, network)
+		netParams, err := getNetwork(ctx.Param("network"))
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
 		}
 
 		requestBody, err := io.ReadAll(ctx.Request().Body)
@@ -259,17 +495,7 @@ func main() {
 				return ctx.JSON(http.StatusOK, rsp)
 			}
 
-			txBuild := bitcoin.NewTxBuild(params.Version, netParams)
-			for i := 0; i < len(params.Inputs); i++ {
-				txBuild.AddInput2(params.Inputs[i].TxId, params.Inputs[i].VOut, "", params.Inputs[i].Address, params.Inputs[i].Amount)
-			}
-
-			for i := 0; i < len(params.Outputs); i++ {
-				txBuild.AddOutput(params.Outputs[i].Address, params.Outputs[i].Amount)
-			}
-
-			tx, _, err := txBuild.Build(false)
-
+			tx, err := buildUnsignedTx(netParams, params)
 			if err != nil {
 				rsp.Error = err.Error()
 				return ctx.JSON(http.StatusOK, rsp)
@@ -283,6 +509,7 @@ func main() {
 
 			rsp.Result = &BuildUnsignedTxResponse{
 				UnsignedTx: txHex,
+				Outputs:    txRawOutputs(tx, netParams),
 			}
 
 			return ctx.JSON(http.StatusOK, rsp)
@@ -294,8 +521,19 @@ func main() {
 				rsp.Error = err.Error()
 				return ctx.JSON(http.StatusOK, rsp)
 			}
+			if err = bitcoin.ValidateBrc20InscriptionDataList(params.InscriptionDataList); err != nil {
+				rsp.Error = err.Error()
+				return ctx.JSON(http.StatusOK, rsp)
+			}
 
-			parseResult, txPreparedHex, totalSenderAmount, err := bitcoin.PrepareBrc20CommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.RevealOutValue, params.MinChangeValue, params.RevealFeeRate, params.ChangeAddress, params.PubKey)
+			var parseResult *bitcoin.Brc20InscriptionParseResult
+			var txPreparedHex string
+			var totalSenderAmount btcutil.Amount
+			if bitcoin.NeedsBatchTxCtxData(params.InscriptionDataList, params.BatchMode, params.Parent, params.RevealOptions) {
+				parseResult, txPreparedHex, totalSenderAmount, err = bitcoin.PrepareBrc20BatchCommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.BatchMode, params.Parent, params.RevealOptions, params.RevealOutValue, params.MinChangeValue, params.RevealFeeRate, params.ChangeAddress, params.PubKey)
+			} else {
+				parseResult, txPreparedHex, totalSenderAmount, err = bitcoin.PrepareBrc20CommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.RevealOutValue, params.MinChangeValue, params.RevealFeeRate, params.ChangeAddress, params.PubKey)
+			}
 
 			if err != nil {
 				rsp.Error = err.Error()
@@ -350,10 +588,17 @@ func main() {
 				return ctx.JSON(http.StatusOK, rsp)
 			}
 
-			rsp.Result = &AdjustBrc20CommitTxResponse{
+			result := &AdjustBrc20CommitTxResponse{
 				TxHex:       txCheckedHex,
 				CommitTxFee: commitTxFee,
 			}
+			if params.WithPsbt {
+				if result.Psbt, err = bitcoin.Brc20CommitPSBT(netParams, txCheckedHex, params.CommitTxPrevOutputList, params.Bip32Derivation); err != nil {
+					rsp.Error = err.Error()
+					return ctx.JSON(http.StatusOK, rsp)
+				}
+			}
+			rsp.Result = result
 
 			return ctx.JSON(http.StatusOK, rsp)
 		} else if req.Method == "buildBrc20CommitTx" {
@@ -363,6 +608,10 @@ func main() {
 				rsp.Error = err.Error()
 				return ctx.JSON(http.StatusOK, rsp)
 			}
+			if err = bitcoin.ValidateBrc20InscriptionDataList(params.InscriptionDataList); err != nil {
+				rsp.Error = err.Error()
+				return ctx.JSON(http.StatusOK, rsp)
+			}
 
 			commitTxPrivateKeyListWif := []string{
 				"cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
@@ -375,7 +624,14 @@ func main() {
 				rsp.Error = err.Error()
 				return ctx.JSON(http.StatusOK, rsp)
 			}
-			parseResult, unsignedCommitTxHex, commitTxFee, err := bitcoin.BuildBrc20CommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.RevealOutValue, params.MinChangeValue, params.CommitFeeRate, params.RevealFeeRate, params.ChangeAddress, serializedPubKey, commitTxPrivateKeyListWif)
+			var parseResult *bitcoin.Brc20InscriptionParseResult
+			var unsignedCommitTxHex string
+			var commitTxFee int64
+			if bitcoin.NeedsBatchTxCtxData(params.InscriptionDataList, params.BatchMode, params.Parent, params.RevealOptions) {
+				parseResult, unsignedCommitTxHex, commitTxFee, err = bitcoin.BuildBrc20BatchCommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.BatchMode, params.Parent, params.RevealOptions, params.RevealOutValue, params.MinChangeValue, params.CommitFeeRate, params.RevealFeeRate, params.ChangeAddress, serializedPubKey, commitTxPrivateKeyListWif)
+			} else {
+				parseResult, unsignedCommitTxHex, commitTxFee, err = bitcoin.BuildBrc20CommitTx(netParams, params.InscriptionDataList, params.CommitTxPrevOutputList, params.RevealOutValue, params.MinChangeValue, params.CommitFeeRate, params.RevealFeeRate, params.ChangeAddress, serializedPubKey, commitTxPrivateKeyListWif)
+			}
 
 			if err != nil {
 				rsp.Error = err.Error()
@@ -399,12 +655,19 @@ func main() {
 			if err != nil {
 				return err
 			}
-			rsp.Result = &BuildBrc20CommitTxResponse{
+			result := &BuildBrc20CommitTxResponse{
 				ParseResult:    parseResult,
 				TxHex:          unsignedCommitTxHex,
 				MessageHashMap: messageHashMap,
 				CommitTxFee:    commitTxFee,
 			}
+			if params.WithPsbt {
+				if result.Psbt, err = bitcoin.Brc20CommitPSBT(netParams, unsignedCommitTxHex, params.CommitTxPrevOutputList, params.Bip32Derivation); err != nil {
+					rsp.Error = err.Error()
+					return ctx.JSON(http.StatusOK, rsp)
+				}
+			}
+			rsp.Result = result
 
 			return ctx.JSON(http.StatusOK, rsp)
 
@@ -416,7 +679,6 @@ func main() {
 				return ctx.JSON(http.StatusOK, rsp)
 			}
 
-			var witnessList [][]byte
 			commitTxHash := new(chainhash.Hash)
 			hashByte, err := hex.DecodeString(params.CommitTxHash)
 			if err != nil {
@@ -424,17 +686,12 @@ func main() {
 				return ctx.JSON(http.StatusOK, rsp)
 			}
 			commitTxHash.SetBytes(hashByte)
-			revealTxsHex, witnessList, revealTxFees, err := bitcoin.BuildBrc20RevealTx(netParams, *commitTxHash, params.CtxDataList, params.RevealAddrs, params.RevealFeeRate, params.RevealOutValue)
+			result, err := buildRevealTxs(netParams, *commitTxHash, params)
 			if err != nil {
 				rsp.Error = err.Error()
 				return ctx.JSON(http.StatusOK, rsp)
 			}
-			rsp.Result = &BuildBrc20RevealTxResponse{
-				RevealTxsHex: revealTxsHex[0],
-				WitnessList:  witnessList[0],
-				RevealTxFees: revealTxFees[0],
-				MessageHash:  hex.EncodeToString(witnessList[0]), //review交易只有一个input，所以只对应一个messageHash
-			}
+			rsp.Result = result
 			return ctx.JSON(http.StatusOK, rsp)
 		} else if req.Method == "buildReviewTxRawData" {
 			params := &BuildRevealTxRawDataRequest{}
@@ -444,16 +701,12 @@ func main() {
 				return ctx.JSON(http.StatusOK, rsp)
 			}
 
-			signedRevealTxsHex, err := bitcoin.SignBrc20RevealTx2(netParams, params.RevealTxsHex, params.Signature, params.CtxDataList)
-
+			result, err := signRevealTxs(netParams, params)
 			if err != nil {
 				rsp.Error = err.Error()
 				return ctx.JSON(http.StatusOK, rsp)
 			}
-
-			rsp.Result = &BuildRevealTxRawDataResponse{
-				RevealTxHex: signedRevealTxsHex[0],
-			}
+			rsp.Result = result
 
 			return ctx.JSON(http.StatusOK, rsp)
 		} else if req.Method == "buildCommitTxRawData" {
@@ -9,7 +9,9 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin"
+	"github.com/etherria/bitcoin-tx-builder/bitcoin/runes"
 	"github.com/etherria/bitcoin-tx-builder/bitcoin/txscript"
+	"math/big"
 	"testing"
 )
 
@@ -149,3 +151,45 @@ func TestTxSize(t *testing.T) {
 	t.Log(size)
 
 }
+
+func TestSignRevealTxsSignatureMaps(t *testing.T) {
+	params := &BuildRevealTxRawDataRequest{
+		RevealTxsHex:  []string{"00", "00"},
+		SignatureMaps: []map[int]string{{0: "00"}},
+	}
+	if _, err := signRevealTxs(&chaincfg.TestNet3Params, params); err == nil {
+		t.Error("one signature map signed two reveals")
+	}
+	params.SignatureMap = map[int]string{0: "00"}
+	if _, err := signRevealTxs(&chaincfg.TestNet3Params, params); err == nil {
+		t.Error("signatureMap and signatureMaps were both taken")
+	}
+}
+
+func TestBuildUnsignedTx(t *testing.T) {
+	params := &BuildUnsignedTxRequest{
+		Version: 2,
+		Inputs: []*bitcoin.PrevOutput{{
+			TxId:               "c44a7f98434e5e875a573339f77d36022c79c525771fa88c72fa53f3a55eeaf7",
+			VOut:               1,
+			Amount:             10000,
+			Address:            "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+			InscriptionOffsets: []int64{0},
+		}},
+		Outputs:   []RawOutput{{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 546}},
+		Runestone: &runes.Runestone{Edicts: []*runes.Edict{{Id: runes.RuneId{Block: 840000, Tx: 3}, Amount: big.NewInt(100), Output: 0}}},
+	}
+	tx, err := buildUnsignedTx(&chaincfg.TestNet3Params, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxOut) != 2 || hex.EncodeToString(tx.TxOut[1].PkScript) != "6a5d0700c0a233036400" {
+		t.Error("the runestone is not the last output")
+	}
+
+	// the inscription at offset 9500 would go to the fee
+	params.Inputs[0].InscriptionOffsets = []int64{9500}
+	if _, err = buildUnsignedTx(&chaincfg.TestNet3Params, params); err == nil {
+		t.Error("the inscription was burnt")
+	}
+}